@@ -0,0 +1,39 @@
+package main
+
+import (
+	"aegis/internal/domain/services"
+	"aegis/internal/infrastructure/config"
+	"aegis/internal/infrastructure/database"
+	"aegis/internal/infrastructure/repositories"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Rotates the access token signing key. Run it on a schedule (or set jwt.key_rotation_days): the new key is published
+// at once and signs a few minutes later, the previous key keeps verifying tokens until the last access token it signed
+// expires.
+func main() {
+	c, err := config.Read("config.json")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if c.JWT.KeyStorage != services.KeyStorageDatabase {
+		log.Fatal(`keys can only be rotated with "key_storage": "database"`)
+	}
+
+	db, err := database.Connect(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	keyService, err := services.NewKeyService(c, repositories.NewSigningKeyRepository(db, c.JWT.KeyEncryptionKey))
+	if err != nil {
+		log.Fatal(err)
+	}
+	key, err := keyService.Rotate()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Signing key rotated, new key id:", key.ID, "published now, signs from", key.SignsFrom.Format(time.RFC3339))
+}
@@ -7,17 +7,49 @@ import (
 
 func TestIntegration(t *testing.T) {
 	t.Run("API", func(r *testing.T) {
+		t.Run("calling /admin without a session returns 401", integration_test_cases.Admin_WithoutSessionReturns401)
+		t.Run("calling /admin without the platform_admin role returns 403", integration_test_cases.Admin_WithoutPlatformAdminRoleReturns403)
+		t.Run("calling GET /admin/users as a platform admin lists the users", integration_test_cases.Admin_PlatformAdminListsUsers)
+		t.Run("calling POST /admin/users/:id/block with an internal API key blocks the user and revokes their sessions", integration_test_cases.Admin_InternalAPIKeyBlocksUserAndRevokesSessions)
+		t.Run("calling GET /admin/users/:id with an unknown user returns 404", integration_test_cases.Admin_UnknownUserReturns404)
 		t.Run("calling GET /login returns 200 and shows login page when enabled", integration_test_cases.Login_NoATOrRT_Returns200AndShowsLoginPage)
 		t.Run("calling GET /login with a valid access_token gets redirected to /login-success", integration_test_cases.Login_ValidAT_RedirectsToSuccessPage)
 		t.Run("calling GET /login returns 404 when disabled", integration_test_cases.Login_DisabledReturns404)
 		t.Run("calling GET /login-error returns 200 when enabled", integration_test_cases.LoginError_EnabledReturns200AndShowsErrorPage)
 		t.Run("calling GET /login-error returns 404 when disabled", integration_test_cases.LoginError_DisabledReturns404)
 		t.Run("calling GET /health returns 200", integration_test_cases.Health_200)
+		t.Run("calling POST /email/login returns 403 when disabled", integration_test_cases.Email_DisabledReturns403)
+		t.Run("opening the link sent by POST /email/login logs in once", integration_test_cases.Email_MagicLinkLogsIn)
+		t.Run("calling POST /email/code/verify with the code sent by POST /email/code logs in", integration_test_cases.Email_LoginCodeLogsIn)
+		t.Run("calling POST /passkeys/login/begin returns 403 when disabled", integration_test_cases.Passkeys_DisabledReturns403)
+		t.Run("a passkey registered with POST /passkeys/register/finish logs in with POST /passkeys/login/finish", integration_test_cases.Passkeys_RegisterThenLogIn)
+		t.Run("calling POST /mfa/totp without a session returns 401", integration_test_cases.MFA_WithoutSessionReturns401)
+		t.Run("a login with a provider waits for the code of the authenticator app, entered with POST /mfa/verify", integration_test_cases.MFA_ProviderLoginWaitsForTheCode)
+		t.Run("a recovery code logs the user in with POST /recovery, to link a new identity", integration_test_cases.MFA_RecoveryCodeLogsInToLinkAnIdentity)
+		t.Run("calling GET /.well-known/openid-configuration returns 403 when the oauth server is disabled", integration_test_cases.OAuthServer_DisabledReturns403)
+		t.Run("GET /authorize resumes after the login and gives a code, exchanged with POST /token for an ID token", integration_test_cases.OAuthServer_CodeFlowResumesAfterTheLogin)
+		t.Run("a service client created with POST /admin/service-clients gets a token with POST /token", integration_test_cases.OAuthServer_ServiceClientGetsAToken)
+		t.Run("a device started with POST /device/code gets tokens from POST /token once the user approves it on GET /device", integration_test_cases.OAuthServer_DeviceFlowApprovedInTheBrowser)
+		t.Run("calling GET /.well-known/jwks.json returns no keys with HS256", integration_test_cases.JWKS_HS256ReturnsNoKeys)
+		t.Run("calling GET /.well-known/jwks.json returns the public key with an asymmetric algorithm", integration_test_cases.JWKS_AsymmetricReturnsThePublicKey)
 		t.Run("calling GET /logout sets zero cookies", integration_test_cases.Logout_SetsZeroCookies)
 		t.Run("calling GET /logout without a refresh_token does not break", integration_test_cases.Logout_WithoutRefreshTokenDoesNotBreak)
 		t.Run("calling GET /logout with a refresh_token deletes the refresh_token", integration_test_cases.Logout_DeletesRefreshToken)
 		t.Run("calling GET /me without a session returns 401", integration_test_cases.Me_WithoutSessionReturns401)
 		t.Run("calling GET /me with a session returns 200 and the session", integration_test_cases.Me_WithSessionReturns200)
+		t.Run("calling GET /sessions without a session returns 401", integration_test_cases.Sessions_WithoutSessionReturns401)
+		t.Run("calling GET /sessions lists the sessions of the user", integration_test_cases.Sessions_ListsTheSessionsOfTheUser)
+		t.Run("calling DELETE /sessions/others keeps only the current session", integration_test_cases.Sessions_RevokeOthersKeepsTheCurrentSession)
+		t.Run("calling DELETE /sessions/:id revokes the session", integration_test_cases.Sessions_RevokeOneSession)
+		t.Run("calling GET /identities without a session returns 401", integration_test_cases.Identities_WithoutSessionReturns401)
+		t.Run("calling DELETE /identities/:provider refuses to unlink the last identity", integration_test_cases.Identities_ListsAndKeepsTheLastIdentity)
+		t.Run("calling GET /provider/link without a session returns 401", integration_test_cases.Identities_LinkURLRequiresASession)
+		t.Run("calling GET /organizations without a session returns 401", integration_test_cases.Organizations_WithoutSessionReturns401)
+		t.Run("calling POST /organizations/switch puts the created organization in the access token", integration_test_cases.Organizations_CreateAndSwitch)
+		t.Run("calling POST /organizations/switch with an organization of someone else returns 404", integration_test_cases.Organizations_SwitchToAnotherOrganizationReturns404)
+		t.Run("calling POST /organizations/:id/invitations returns the link to the login page", integration_test_cases.Organizations_InviteReturnsTheLoginLink)
+		t.Run("logging in with an invitation adds the user to the organization, even for early adopters only", integration_test_cases.Organizations_InvitationIsAcceptedDuringLogin)
+		t.Run("calling GET /:provider with an invalid invitation returns 400", integration_test_cases.Organizations_LoginWithAnInvalidInvitationReturns400)
 		t.Run("calling GET /provider/callback returns 403 if the provider is not enabled", integration_test_cases.ProviderCallback_NotEnabledReturns403)
 		t.Run("calling GET /provider/callback redirects to error page if state is invalid", integration_test_cases.ProviderCallback_MustRedirectToErrorPage_InvalidState)
 		t.Run("calling GET /provider/callback redirects to error page if code is invalid", integration_test_cases.ProviderCallback_MustRedirectToErrorPage_InvalidCode)
@@ -39,6 +71,7 @@ func TestIntegration(t *testing.T) {
 		t.Run("calling GET /refresh (hard refresh) must not refresh the user (2) if no refresh_token", integration_test_cases.HardRefresh_MustNotRefresh_EmptyRT)
 		t.Run("calling GET /refresh (hard refresh) must not refresh the user (2) if expired refresh_token", integration_test_cases.HardRefresh_MustNotRefresh_ExpiredRT)
 		t.Run("calling GET /refresh (hard refresh) must not refresh the user (2) if malformed refresh_token", integration_test_cases.HardRefresh_MustNotRefresh_MalformedRT)
+		t.Run("calling GET /refresh (hard refresh) must not refresh the user and must revoke the family if the refresh_token was already rotated", integration_test_cases.HardRefresh_MustNotRefresh_ReusedRT)
 		t.Run("calling GET /refresh (hard refresh) must refresh the user if access_token and refresh_token are valid", integration_test_cases.HardRefresh_MustRefresh_ValidTokens)
 		t.Run("calling GET /refresh (hard refresh) must refresh the user if no access_token but refresh_token is valid", integration_test_cases.HardRefresh_MustRefresh_EmptyAT)
 		t.Run("calling GET /refresh (hard refresh) must refresh the user if expired access_token and refresh_token is valid", integration_test_cases.HardRefresh_MustRefresh_ExpiredAT)
@@ -50,6 +83,8 @@ func TestIntegration(t *testing.T) {
 		t.Run("calling POST /authorize-access-token (test roles) returns 401 if the user does not have the required role", integration_test_cases.Authorize_UserDoesNotHaveRequiredRoleReturns401)
 		t.Run("calling POST /authorize-access-token (test roles) returns 200 if the user has the required role", integration_test_cases.Authorize_UserHasRequiredRoleReturns200)
 		t.Run("calling POST /authorize-access-token (test roles) returns 200 if the user has any role", integration_test_cases.Authorize_UserHasAnyRoleReturns200)
+		t.Run("calling POST /authorize-access-token (test roles) matches roles exactly, with any_of or all_of", integration_test_cases.Authorize_RoleIsMatchedExactly)
+		t.Run("calling POST /authorize-access-token (test permissions) checks the permissions of the roles", integration_test_cases.Authorize_RequiredPermissions)
 	})
 	t.Run("Middlewares", func(t *testing.T) {
 		t.Run("soft refresh: must not refresh the user (1) if user does not exist", func(t *testing.T) { /*todo*/ })
@@ -0,0 +1,103 @@
+package integration_test_cases
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/pkg/cookies"
+	"aegis/pkg/jwtgen"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"aegis/integration/integration_testkit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func adminAccessCookie(t *testing.T, suite *integration_testkit.TestSuite, user entities.User) *http.Cookie {
+	cClaims, err := entities.NewCustomClaimsFromValues(user.ID, false, user.Roles, user.MetadataPublic)
+	require.NoError(t, err)
+	accessToken, atExp, err := jwtgen.Generate(cClaims.ToMap(), time.Now(), 10, "MyApp", suite.Config.JWT.Secret)
+	require.NoError(t, err)
+	atCookie := cookies.NewAccessCookie(accessToken, atExp, suite.Config)
+	return &atCookie
+}
+
+func Admin_WithoutSessionReturns401(t *testing.T) {
+	suite := integration_testkit.SetupTestSuite(t, integration_testkit.GetBaseConfig())
+	defer suite.Teardown()
+	resp, err := http.Get(suite.Server.URL + "/auth/admin/users")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func Admin_WithoutPlatformAdminRoleReturns403(t *testing.T) {
+	suite := integration_testkit.SetupTestSuite(t, integration_testkit.GetBaseConfig())
+	defer suite.Teardown()
+	user, err := entities.NewUser("cloude", "https://example.com/avatar.jpg", "cloude@example.com", "github")
+	require.NoError(t, err)
+	user = suite.CreateUser(t, user, []string{"user"})
+	req, err := http.NewRequest("GET", suite.Server.URL+"/auth/admin/users", nil)
+	require.NoError(t, err)
+	req.AddCookie(adminAccessCookie(t, suite, user))
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func Admin_PlatformAdminListsUsers(t *testing.T) {
+	suite := integration_testkit.SetupTestSuite(t, integration_testkit.GetBaseConfig())
+	defer suite.Teardown()
+	admin, err := entities.NewUser("admin", "https://example.com/avatar.jpg", "admin@example.com", "github")
+	require.NoError(t, err)
+	admin = suite.CreateUser(t, admin, []string{"user", "platform_admin"})
+	req, err := http.NewRequest("GET", suite.Server.URL+"/auth/admin/users?q=admin@", nil)
+	require.NoError(t, err)
+	req.AddCookie(adminAccessCookie(t, suite, admin))
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var page entities.UsersPage
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+	require.Len(t, page.Users, 1)
+	assert.Equal(t, admin.ID, page.Users[0].ID)
+	assert.ElementsMatch(t, []string{"user", "platform_admin"}, page.Users[0].Roles)
+}
+
+func Admin_InternalAPIKeyBlocksUserAndRevokesSessions(t *testing.T) {
+	suite := integration_testkit.SetupTestSuite(t, integration_testkit.GetBaseConfig())
+	defer suite.Teardown()
+	user, err := entities.NewUser("cloude", "https://example.com/avatar.jpg", "cloude@example.com", "github")
+	require.NoError(t, err)
+	user = suite.CreateUser(t, user, []string{"user"})
+	refreshToken, _, err := entities.NewRefreshToken(user, integration_testkit.TestDevice.Fingerprint(), suite.Config)
+	require.NoError(t, err)
+	suite.CreateRefreshToken(t, refreshToken)
+
+	req, err := http.NewRequest("POST", suite.Server.URL+"/auth/admin/users/"+user.ID+"/block", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Authorize", "test-api-key")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var details entities.UserDetails
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&details))
+	assert.NotNil(t, details.BlockedAt)
+
+	var count int64
+	require.NoError(t, suite.Db.Model(&entities.RefreshToken{}).Where("user_id = ?", user.ID).Count(&count).Error)
+	assert.Equal(t, int64(0), count)
+}
+
+func Admin_UnknownUserReturns404(t *testing.T) {
+	suite := integration_testkit.SetupTestSuite(t, integration_testkit.GetBaseConfig())
+	defer suite.Teardown()
+	req, err := http.NewRequest("GET", suite.Server.URL+"/auth/admin/users/00000000-0000-0000-0000-000000000000", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Authorize", "test-api-key")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
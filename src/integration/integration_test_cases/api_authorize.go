@@ -20,6 +20,8 @@ import (
 type AuthorizeRequest struct {
 	AccessToken string   `json:"access_token"`
 	Roles       []string `json:"authorized_roles"`
+	Match       string   `json:"match,omitempty"`
+	Permissions []string `json:"required_permissions,omitempty"`
 }
 
 func Authorize_EmptyTokenReturns401(t *testing.T) {
@@ -235,7 +237,7 @@ func Authorize_UserHasRequiredRoleReturns200(t *testing.T) {
 
 	ccMap := response["data"].(map[string]any)
 	require.Equal(t, user.ID, ccMap["user_id"])
-	require.Equal(t, "user", ccMap["roles"])
+	require.Equal(t, []any{"user"}, ccMap["roles"])
 	require.Equal(t, user.MetadataPublic, ccMap["metadata_public"])
 }
 
@@ -281,6 +283,80 @@ func Authorize_UserHasAnyRoleReturns200(t *testing.T) {
 
 	ccMap := response["data"].(map[string]any)
 	require.Equal(t, user.ID, ccMap["user_id"])
-	require.Equal(t, "user", ccMap["roles"])
+	require.Equal(t, []any{"user"}, ccMap["roles"])
 	require.Equal(t, user.MetadataPublic, ccMap["metadata_public"])
 }
+
+func Authorize_RoleIsMatchedExactly(t *testing.T) {
+	suite := integration_testkit.SetupTestSuite(t, integration_testkit.GetBaseConfig())
+	defer suite.Teardown()
+
+	user, err := entities.NewUser("testuser", "https://example.com/avatar.jpg", "test@example.com", "github")
+	require.NoError(t, err)
+	user = suite.CreateUser(t, user, []string{"user", "platform_admin"})
+
+	cClaims, err := entities.NewCustomClaimsFromValues(user.ID, false, user.Roles, user.MetadataPublic)
+	require.NoError(t, err)
+	validToken, _, err := jwtgen.Generate(cClaims.ToMap(), time.Now(), 15, "TestApp", suite.Config.JWT.Secret)
+	require.NoError(t, err)
+
+	authorize := func(reqBody AuthorizeRequest) int {
+		jsonBody, err := json.Marshal(reqBody)
+		require.NoError(t, err)
+		req, err := http.NewRequest("POST", suite.Server.URL+"/auth/authorize-access-token", bytes.NewBuffer(jsonBody))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Authorize", "Bearer test-api-key")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// "admin" is a substring of "platform_admin"
+	assert.Equal(t, http.StatusUnauthorized, authorize(AuthorizeRequest{AccessToken: validToken, Roles: []string{"admin"}}))
+	assert.Equal(t, http.StatusOK, authorize(AuthorizeRequest{AccessToken: validToken, Roles: []string{"user", "platform_admin"}, Match: "all_of"}))
+	assert.Equal(t, http.StatusUnauthorized, authorize(AuthorizeRequest{AccessToken: validToken, Roles: []string{"user", "payments"}, Match: "all_of"}))
+	assert.Equal(t, http.StatusBadRequest, authorize(AuthorizeRequest{AccessToken: validToken, Roles: []string{"user"}, Match: "some_of"}))
+}
+
+func Authorize_RequiredPermissions(t *testing.T) {
+	config := integration_testkit.GetBaseConfig()
+	config.User.Permissions = map[string]entities.RolePermissions{
+		"user":           {Permissions: []string{"billing:read"}},
+		"platform_admin": {Permissions: []string{"billing:write"}, Inherits: []string{"user"}},
+	}
+	suite := integration_testkit.SetupTestSuite(t, config)
+	defer suite.Teardown()
+
+	user, err := entities.NewUser("testuser", "https://example.com/avatar.jpg", "test@example.com", "github")
+	require.NoError(t, err)
+	user = suite.CreateUser(t, user, []string{"user"})
+
+	cClaims, err := entities.NewCustomClaimsFromValues(user.ID, false, user.Roles, user.MetadataPublic)
+	require.NoError(t, err)
+	validToken, _, err := jwtgen.Generate(cClaims.ToMap(), time.Now(), 15, "TestApp", suite.Config.JWT.Secret)
+	require.NoError(t, err)
+
+	authorize := func(reqBody AuthorizeRequest) (int, map[string]any) {
+		jsonBody, err := json.Marshal(reqBody)
+		require.NoError(t, err)
+		req, err := http.NewRequest("POST", suite.Server.URL+"/auth/authorize-access-token", bytes.NewBuffer(jsonBody))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Authorize", "Bearer test-api-key")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body := map[string]any{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return resp.StatusCode, body
+	}
+
+	status, body := authorize(AuthorizeRequest{AccessToken: validToken, Permissions: []string{"billing:read"}})
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []any{"billing:read"}, body["data"].(map[string]any)["permissions"])
+	status, body = authorize(AuthorizeRequest{AccessToken: validToken, Permissions: []string{"billing:write"}})
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Equal(t, apperrors.ErrMissingPermission.Error(), body["error"])
+}
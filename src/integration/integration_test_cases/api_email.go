@@ -0,0 +1,116 @@
+package integration_test_cases
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"aegis/integration/integration_testkit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Email_DisabledReturns403(t *testing.T) {
+	suite := integration_testkit.SetupTestSuite(t, integration_testkit.GetBaseConfig())
+	defer suite.Teardown()
+	resp, err := http.Post(suite.Server.URL+"/auth/email/login", "application/json", bytes.NewBufferString(`{"email": "someone@example.com"}`))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func Email_MagicLinkLogsIn(t *testing.T) {
+	config := integration_testkit.GetBaseConfig()
+	config.Auth.Providers.Email.Enabled = true
+	config.Mailer.Type = "log"
+	config.Mailer.LogFile = filepath.Join(t.TempDir(), "emails.log")
+	suite := integration_testkit.SetupTestSuite(t, config)
+	defer suite.Teardown()
+
+	req, err := http.NewRequest("POST", suite.Server.URL+"/auth/email/login", bytes.NewBufferString(`{"email": "someone@example.com"}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(suite.DeviceCookie())
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	emails, err := os.ReadFile(config.Mailer.LogFile)
+	require.NoError(t, err)
+	link := regexp.MustCompile(`http://localhost:8080/auth/email/verify\?token=\S+`).FindString(string(emails))
+	require.NotEmpty(t, link)
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	verify := func() *http.Response {
+		req, err := http.NewRequest("GET", suite.Server.URL+link[len("http://localhost:8080"):], nil)
+		require.NoError(t, err)
+		req.AddCookie(suite.DeviceCookie())
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+	resp = verify()
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, "http://localhost:8080/login-success", resp.Header.Get("Location"))
+	var accessToken string
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "access_token" {
+			accessToken = cookie.Value
+		}
+	}
+	assert.NotEmpty(t, accessToken)
+
+	resp = verify()
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, "http://localhost:8080/login-error?error=magic_link_invalid", resp.Header.Get("Location"))
+}
+
+func Email_LoginCodeLogsIn(t *testing.T) {
+	config := integration_testkit.GetBaseConfig()
+	config.Auth.Providers.Email.Enabled = true
+	config.Mailer.Type = "log"
+	config.Mailer.LogFile = filepath.Join(t.TempDir(), "emails.log")
+	suite := integration_testkit.SetupTestSuite(t, config)
+	defer suite.Teardown()
+
+	resp, err := http.Post(suite.Server.URL+"/auth/email/code", "application/json", bytes.NewBufferString(`{"email": "someone@example.com"}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	emails, err := os.ReadFile(config.Mailer.LogFile)
+	require.NoError(t, err)
+	match := regexp.MustCompile(`Subject: (\d{6}) is your`).FindStringSubmatch(string(emails))
+	require.NotNil(t, match)
+
+	verify := func(code string) *http.Response {
+		resp, err := http.Post(suite.Server.URL+"/auth/email/code/verify", "application/json", bytes.NewBufferString(`{"email": "someone@example.com", "code": "`+code+`"}`))
+		require.NoError(t, err)
+		return resp
+	}
+	wrongCode := "000000"
+	if match[1] == wrongCode {
+		wrongCode = "111111"
+	}
+	resp = verify(wrongCode)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = verify(match[1])
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var body map[string]string
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "http://localhost:8080/login-success", body["redirect_url"])
+	var accessToken string
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "access_token" {
+			accessToken = cookie.Value
+		}
+	}
+	assert.NotEmpty(t, accessToken)
+}
@@ -0,0 +1,60 @@
+package integration_test_cases
+
+import (
+	"aegis/internal/domain/entities"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"aegis/integration/integration_testkit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Identities_WithoutSessionReturns401(t *testing.T) {
+	suite := integration_testkit.SetupTestSuite(t, integration_testkit.GetBaseConfig())
+	defer suite.Teardown()
+	resp, err := http.Get(suite.Server.URL + "/auth/identities")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func Identities_ListsAndKeepsTheLastIdentity(t *testing.T) {
+	suite := integration_testkit.SetupTestSuite(t, integration_testkit.GetBaseConfig())
+	defer suite.Teardown()
+	user, sessionCookies := loginOnTwoDevices(t, suite)
+	require.NoError(t, suite.Db.Create(&[]entities.UserIdentity{entities.NewUserIdentity(user.ID, "github", "12345", user.Email)}).Error)
+
+	req, err := http.NewRequest("GET", suite.Server.URL+"/auth/identities", nil)
+	require.NoError(t, err)
+	for _, cookie := range sessionCookies {
+		req.AddCookie(cookie)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var body struct {
+		Identities []entities.UserIdentity `json:"identities"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.Len(t, body.Identities, 1)
+	assert.Equal(t, "github", body.Identities[0].Provider)
+
+	req, err = http.NewRequest("DELETE", suite.Server.URL+"/auth/identities/github", nil)
+	require.NoError(t, err)
+	for _, cookie := range sessionCookies {
+		req.AddCookie(cookie)
+	}
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+}
+
+func Identities_LinkURLRequiresASession(t *testing.T) {
+	suite := integration_testkit.SetupTestSuite(t, integration_testkit.GetBaseConfig())
+	defer suite.Teardown()
+	resp, err := http.Get(suite.Server.URL + "/auth/github/link")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
@@ -0,0 +1,45 @@
+package integration_test_cases
+
+import (
+	"aegis/integration/integration_testkit"
+	"aegis/pkg/jwks"
+	"aegis/pkg/jwtgen"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func JWKS_HS256ReturnsNoKeys(t *testing.T) {
+	suite := integration_testkit.SetupTestSuite(t, integration_testkit.GetBaseConfig())
+	defer suite.Teardown()
+	resp, err := http.Get(suite.Server.URL + "/auth/.well-known/jwks.json")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var set jwks.Set
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&set))
+	assert.Empty(t, set.Keys)
+}
+
+func JWKS_AsymmetricReturnsThePublicKey(t *testing.T) {
+	config := integration_testkit.GetBaseConfig()
+	key, pemData, err := jwtgen.GenerateKey("test-key", jwtgen.AlgorithmES256)
+	require.NoError(t, err)
+	config.JWT.Algorithm = jwtgen.AlgorithmES256
+	config.JWT.PrivateKey = pemData
+	config.JWT.KeyID = key.ID
+	suite := integration_testkit.SetupTestSuite(t, config)
+	defer suite.Teardown()
+	resp, err := http.Get(suite.Server.URL + "/auth/.well-known/jwks.json")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var set jwks.Set
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&set))
+	require.Len(t, set.Keys, 1)
+	expected, _ := key.PublicJWK()
+	assert.Equal(t, expected, set.Keys[0])
+}
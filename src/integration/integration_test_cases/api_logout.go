@@ -51,7 +51,7 @@ func Logout_DeletesRefreshToken(t *testing.T) {
 
 	// Verify the refresh token exists in the database
 	var count int64
-	err = suite.Db.Model(&entities.RefreshToken{}).Where("token = ?", refreshTokenEntity.Token).Count(&count).Error
+	err = suite.Db.Model(&entities.RefreshToken{}).Where("token = ?", suite.HashRefreshToken(refreshTokenEntity.Token)).Count(&count).Error
 	require.NoError(t, err)
 	assert.Equal(t, int64(1), count)
 
@@ -61,6 +61,7 @@ func Logout_DeletesRefreshToken(t *testing.T) {
 
 	refreshCookie := cookies.NewRefreshCookie(refreshTokenEntity.Token, refreshTokenEntity.ExpiresAt.Unix(), suite.Config)
 	req.AddCookie(&refreshCookie)
+	req.AddCookie(suite.DeviceCookie())
 
 	// Make the request
 	resp, err := http.DefaultClient.Do(req)
@@ -68,7 +69,7 @@ func Logout_DeletesRefreshToken(t *testing.T) {
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 
 	// Verify the refresh token was deleted from the database
-	err = suite.Db.Model(&entities.RefreshToken{}).Where("token = ?", refreshTokenEntity.Token).Count(&count).Error
+	err = suite.Db.Model(&entities.RefreshToken{}).Where("token = ?", suite.HashRefreshToken(refreshTokenEntity.Token)).Count(&count).Error
 	require.NoError(t, err)
 	assert.Equal(t, int64(0), count)
 }
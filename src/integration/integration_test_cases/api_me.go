@@ -48,7 +48,7 @@ func Me_WithSessionReturns200(t *testing.T) {
 	err = json.NewDecoder(bytes.NewReader(body)).Decode(&sessionResponse)
 	require.NoError(t, err)
 	assert.Equal(t, user.ID, sessionResponse.UserID)
-	assert.Equal(t, "user", sessionResponse.Roles)
+	assert.Equal(t, []string{"user"}, sessionResponse.Roles)
 	assert.Equal(t, "{}", sessionResponse.MetadataPublic)
 	assert.Equal(t, user.EarlyAdopter, sessionResponse.EarlyAdopter)
 }
@@ -0,0 +1,144 @@
+package integration_test_cases
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"aegis/integration/integration_testkit"
+	"aegis/internal/domain/entities"
+	"aegis/internal/infrastructure/repositories"
+	"aegis/pkg/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func MFA_WithoutSessionReturns401(t *testing.T) {
+	suite := integration_testkit.SetupTestSuite(t, integration_testkit.GetBaseConfig())
+	defer suite.Teardown()
+	resp, err := http.Post(suite.Server.URL+"/auth/mfa/totp", "application/json", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func MFA_ProviderLoginWaitsForTheCode(t *testing.T) {
+	suite := integration_testkit.SetupTestSuite(t, integration_testkit.GetBaseConfig())
+	defer suite.Teardown()
+
+	user, err := entities.NewUser("testuser", "https://example.com/avatar.jpg", "test@example.com", "github")
+	require.NoError(t, err)
+	user = suite.CreateUser(t, user, []string{"user"})
+	secret, err := totp.GenerateSecret()
+	require.NoError(t, err)
+	mfaRepository := repositories.NewMFARepository(suite.Db, suite.Config.PepperFor(entities.PepperPendingMFA), suite.Config.MFA.EncryptionKey)
+	require.NoError(t, mfaRepository.CreateTOTPFactor(entities.NewTOTPFactor(user.ID, secret)))
+	require.NoError(t, mfaRepository.ConfirmTOTPFactor(user.ID, totp.Step(time.Now())-1))
+	state := entities.State{
+		Value:     "valid_state",
+		ExpiresAt: time.Now().Add(10 * time.Minute),
+	}
+	require.NoError(t, suite.Db.Model(&entities.State{}).Create(&state).Error)
+
+	req, err := http.NewRequest("GET", suite.Server.URL+"/auth/github/callback?code=accepted_code&state=valid_state", nil)
+	require.NoError(t, err)
+	req.AddCookie(suite.DeviceCookie())
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, "http://localhost:8080/auth/login?mfa=required", resp.Header.Get("Location"))
+	var mfaCookie *http.Cookie
+	for _, cookie := range resp.Cookies() {
+		assert.NotEqual(t, "access_token", cookie.Name)
+		if cookie.Name == "mfa_token" {
+			mfaCookie = cookie
+		}
+	}
+	require.NotNil(t, mfaCookie)
+
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	require.NoError(t, err)
+	req, err = http.NewRequest("POST", suite.Server.URL+"/auth/mfa/verify", bytes.NewBufferString(`{"code": "`+code+`"}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(mfaCookie)
+	req.AddCookie(suite.DeviceCookie())
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var body map[string]string
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "http://localhost:8080/login-success", body["redirect_url"])
+	var accessToken string
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "access_token" {
+			accessToken = cookie.Value
+		}
+	}
+	require.NotEmpty(t, accessToken)
+
+	req, err = http.NewRequest("GET", suite.Server.URL+"/auth/me", nil)
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: accessToken})
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var session entities.Session
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&session))
+	assert.Equal(t, []string{entities.AMRFederated, entities.AMROTP, entities.AMRMultiFactor}, session.CustomClaims.AMR)
+}
+
+func MFA_RecoveryCodeLogsInToLinkAnIdentity(t *testing.T) {
+	suite := integration_testkit.SetupTestSuite(t, integration_testkit.GetBaseConfig())
+	defer suite.Teardown()
+
+	user, err := entities.NewUser("testuser", "https://example.com/avatar.jpg", "test@example.com", "github")
+	require.NoError(t, err)
+	user = suite.CreateUser(t, user, []string{"user"})
+	recoveryCodeRepository := repositories.NewRecoveryCodeRepository(suite.Db, suite.Config.PepperFor(entities.PepperRecoveryCode))
+	require.NoError(t, recoveryCodeRepository.ReplaceRecoveryCodes(user.ID, []string{"3f9a1-c07be"}))
+
+	req, err := http.NewRequest("POST", suite.Server.URL+"/auth/recovery", bytes.NewBufferString(`{"email": "test@example.com", "code": "3F9A1C07BE"}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(suite.DeviceCookie())
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var accessToken string
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "access_token" {
+			accessToken = cookie.Value
+		}
+	}
+	require.NotEmpty(t, accessToken)
+
+	req, err = http.NewRequest("GET", suite.Server.URL+"/auth/github/link", nil)
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: accessToken})
+	req.AddCookie(suite.DeviceCookie())
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	req, err = http.NewRequest("POST", suite.Server.URL+"/auth/recovery", bytes.NewBufferString(`{"email": "test@example.com", "code": "3f9a1-c07be"}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	var events []entities.SecurityEvent
+	require.NoError(t, suite.Db.Where("user_id = ?", user.ID).Order("created_at ASC").Find(&events).Error)
+	require.Len(t, events, 2)
+	assert.Equal(t, entities.SecurityEventRecoveryCodeUsed, events[0].Type)
+	assert.Equal(t, entities.SecurityEventRecoveryCodeFailed, events[1].Type)
+}
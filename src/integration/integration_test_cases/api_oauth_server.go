@@ -0,0 +1,372 @@
+package integration_test_cases
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"aegis/integration/integration_testkit"
+	"aegis/internal/domain/entities"
+	"aegis/pkg/jwtgen"
+	"aegis/pkg/pkce"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func OAuthServer_DisabledReturns403(t *testing.T) {
+	suite := integration_testkit.SetupTestSuite(t, integration_testkit.GetBaseConfig())
+	defer suite.Teardown()
+	resp, err := http.Get(suite.Server.URL + "/auth/.well-known/openid-configuration")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+// oauthServerConfig enables the authorization server, with the asymmetric key it requires
+func oauthServerConfig(t *testing.T) (entities.Config, jwtgen.Key) {
+	config := integration_testkit.GetBaseConfig()
+	key, pemData, err := jwtgen.GenerateKey("test-key", jwtgen.AlgorithmES256)
+	require.NoError(t, err)
+	config.JWT.Algorithm = jwtgen.AlgorithmES256
+	config.JWT.PrivateKey = pemData
+	config.JWT.KeyID = key.ID
+	config.OAuthServer.Enabled = true
+	return config, key
+}
+
+func OAuthServer_CodeFlowResumesAfterTheLogin(t *testing.T) {
+	config, key := oauthServerConfig(t)
+	config.OAuthServer.Clients = []entities.OAuthClientConfig{{
+		ClientID:     "billing",
+		ClientSecret: "billing-secret",
+		RedirectURIs: []string{"https://billing.example.com/callback"},
+	}}
+	suite := integration_testkit.SetupTestSuite(t, config)
+	defer suite.Teardown()
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := http.Get(suite.Server.URL + "/auth/.well-known/openid-configuration")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var configuration entities.OpenIDConfiguration
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&configuration))
+	assert.Equal(t, "http://localhost:8080/auth", configuration.Issuer)
+	assert.Equal(t, "http://localhost:8080/auth/token", configuration.TokenEndpoint)
+
+	// The user is not logged in, the request waits in a cookie while they log in
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"billing"},
+		"redirect_uri":          {"https://billing.example.com/callback"},
+		"scope":                 {"openid email"},
+		"state":                 {"some-state"},
+		"nonce":                 {"some-nonce"},
+		"code_challenge":        {pkce.Challenge(verifier)},
+		"code_challenge_method": {pkce.MethodS256},
+	}.Encode()
+	req, err := http.NewRequest("GET", suite.Server.URL+"/auth/authorize?"+query, nil)
+	require.NoError(t, err)
+	req.AddCookie(suite.DeviceCookie())
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, "http://localhost:8080/auth/login", resp.Header.Get("Location"))
+	var oauthRequestCookie *http.Cookie
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "oauth_request" {
+			oauthRequestCookie = cookie
+		}
+	}
+	require.NotNil(t, oauthRequestCookie)
+
+	user, err := entities.NewUser("testuser", "https://example.com/avatar.jpg", "test@example.com", "github")
+	require.NoError(t, err)
+	user = suite.CreateUser(t, user, []string{"user"})
+	state := entities.State{
+		Value:     "valid_state",
+		ExpiresAt: time.Now().Add(10 * time.Minute),
+	}
+	require.NoError(t, suite.Db.Model(&entities.State{}).Create(&state).Error)
+	req, err = http.NewRequest("GET", suite.Server.URL+"/auth/github/callback?code=accepted_code&state=valid_state", nil)
+	require.NoError(t, err)
+	req.AddCookie(suite.DeviceCookie())
+	req.AddCookie(oauthRequestCookie)
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, "http://localhost:8080/auth/authorize?"+query, resp.Header.Get("Location"))
+	var accessToken string
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "access_token" {
+			accessToken = cookie.Value
+		}
+	}
+	require.NotEmpty(t, accessToken)
+
+	// Back to the authorization endpoint, logged in
+	req, err = http.NewRequest("GET", suite.Server.URL+"/auth/authorize?"+query, nil)
+	require.NoError(t, err)
+	req.AddCookie(suite.DeviceCookie())
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: accessToken})
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	location, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "billing.example.com", location.Host)
+	assert.Equal(t, "some-state", location.Query().Get("state"))
+	code := location.Query().Get("code")
+	require.NotEmpty(t, code)
+
+	form := url.Values{
+		"grant_type":    {entities.GrantTypeAuthorizationCode},
+		"code":          {code},
+		"redirect_uri":  {"https://billing.example.com/callback"},
+		"code_verifier": {verifier},
+	}
+	req, err = http.NewRequest("POST", suite.Server.URL+"/auth/token", strings.NewReader(form.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("billing", "billing-secret")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "no-store", resp.Header.Get("Cache-Control"))
+	var tokens entities.OAuthTokenResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&tokens))
+	assert.Equal(t, "Bearer", tokens.TokenType)
+	idTokenClaims, err := jwtgen.ReadClaimsWithKeys(tokens.IDToken, []jwtgen.Key{key})
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, idTokenClaims["sub"])
+	assert.Equal(t, "billing", idTokenClaims["aud"])
+	assert.Equal(t, "some-nonce", idTokenClaims["nonce"])
+
+	// The code can not be exchanged twice
+	req, err = http.NewRequest("POST", suite.Server.URL+"/auth/token", strings.NewReader(form.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("billing", "billing-secret")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	req, err = http.NewRequest("GET", suite.Server.URL+"/auth/userinfo", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var userInfo entities.OIDCUserInfo
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&userInfo))
+	assert.Equal(t, user.ID, userInfo.Subject)
+	assert.Equal(t, "test@example.com", userInfo.Email)
+	assert.Empty(t, userInfo.Name)
+
+	// The access token of the client does not open the session of the user
+	req, err = http.NewRequest("GET", suite.Server.URL+"/auth/me", nil)
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: tokens.AccessToken})
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func OAuthServer_ServiceClientGetsAToken(t *testing.T) {
+	config, key := oauthServerConfig(t)
+	suite := integration_testkit.SetupTestSuite(t, config)
+	defer suite.Teardown()
+
+	req, err := http.NewRequest("POST", suite.Server.URL+"/auth/admin/service-clients", strings.NewReader(`{"name": "worker", "scopes": ["reports:read"]}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Authorize", "test-api-key")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var created map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	clientID, _ := created["client_id"].(string)
+	clientSecret, _ := created["client_secret"].(string)
+	require.NotEmpty(t, clientID)
+	require.NotEmpty(t, clientSecret)
+
+	form := url.Values{"grant_type": {entities.GrantTypeClientCredentials}}
+	req, err = http.NewRequest("POST", suite.Server.URL+"/auth/token", strings.NewReader(form.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(clientID, clientSecret)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var tokens entities.OAuthTokenResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&tokens))
+	assert.Equal(t, "reports:read", tokens.Scope)
+	assert.Empty(t, tokens.IDToken)
+	claims, err := jwtgen.ReadClaimsWithKeys(tokens.AccessToken, []jwtgen.Key{key})
+	require.NoError(t, err)
+	assert.Equal(t, clientID, claims["sub"])
+	assert.Equal(t, "reports:read", claims["scope"])
+
+	req, err = http.NewRequest("DELETE", suite.Server.URL+"/auth/admin/service-clients/"+clientID, nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Authorize", "test-api-key")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	req, err = http.NewRequest("POST", suite.Server.URL+"/auth/token", strings.NewReader(form.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(clientID, clientSecret)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func OAuthServer_DeviceFlowApprovedInTheBrowser(t *testing.T) {
+	config, key := oauthServerConfig(t)
+	config.OAuthServer.Clients = []entities.OAuthClientConfig{{
+		ClientID:   "cli",
+		Name:       "Internal CLI",
+		DeviceFlow: true,
+	}}
+	suite := integration_testkit.SetupTestSuite(t, config)
+	defer suite.Teardown()
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	postForm := func(path string, form url.Values) *http.Response {
+		req, err := http.NewRequest("POST", suite.Server.URL+path, strings.NewReader(form.Encode()))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := postForm("/auth/device/code", url.Values{"client_id": {"cli"}, "scope": {"openid email"}})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var started entities.DeviceAuthorizationResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&started))
+	assert.Equal(t, "http://localhost:8080/auth/device", started.VerificationURI)
+	require.NotEmpty(t, started.DeviceCode)
+	require.NotEmpty(t, started.UserCode)
+
+	poll := url.Values{"grant_type": {entities.GrantTypeDeviceCode}, "client_id": {"cli"}, "device_code": {started.DeviceCode}}
+	resp = postForm("/auth/token", poll)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	var pollError map[string]string
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&pollError))
+	assert.Equal(t, "authorization_pending", pollError["error"])
+
+	// The user opens the link of the device, and logs in first
+	query := url.Values{"user_code": {started.UserCode}}.Encode()
+	req, err := http.NewRequest("GET", suite.Server.URL+"/auth/device?"+query, nil)
+	require.NoError(t, err)
+	req.AddCookie(suite.DeviceCookie())
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, "http://localhost:8080/auth/login", resp.Header.Get("Location"))
+	var oauthRequestCookie *http.Cookie
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "oauth_request" {
+			oauthRequestCookie = cookie
+		}
+	}
+	require.NotNil(t, oauthRequestCookie)
+
+	user, err := entities.NewUser("testuser", "https://example.com/avatar.jpg", "test@example.com", "github")
+	require.NoError(t, err)
+	user = suite.CreateUser(t, user, []string{"user"})
+	state := entities.State{
+		Value:     "valid_state",
+		ExpiresAt: time.Now().Add(10 * time.Minute),
+	}
+	require.NoError(t, suite.Db.Model(&entities.State{}).Create(&state).Error)
+	req, err = http.NewRequest("GET", suite.Server.URL+"/auth/github/callback?code=accepted_code&state=valid_state", nil)
+	require.NoError(t, err)
+	req.AddCookie(suite.DeviceCookie())
+	req.AddCookie(oauthRequestCookie)
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, "http://localhost:8080/auth/device?"+query, resp.Header.Get("Location"))
+	var accessToken string
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "access_token" {
+			accessToken = cookie.Value
+		}
+	}
+	require.NotEmpty(t, accessToken)
+
+	// Back to the page of the device, logged in: the user sees which client they approve
+	req, err = http.NewRequest("GET", suite.Server.URL+"/auth/device?"+query, nil)
+	require.NoError(t, err)
+	req.AddCookie(suite.DeviceCookie())
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: accessToken})
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	page, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(page), "Internal CLI asks to access your account")
+
+	// A form of another site can not approve the device
+	body := `{"user_code": "` + started.UserCode + `", "approve": true}`
+	req, err = http.NewRequest("POST", suite.Server.URL+"/auth/device", strings.NewReader(url.Values{"user_code": {started.UserCode}, "approve": {"true"}}.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: accessToken})
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+
+	req, err = http.NewRequest("POST", suite.Server.URL+"/auth/device", strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: accessToken})
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	// The device waited its interval since its last poll
+	require.NoError(t, suite.Db.Model(&entities.DeviceAuthorization{}).Where("client_id = ?", "cli").Update("last_polled_at", nil).Error)
+	resp = postForm("/auth/token", poll)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var tokens entities.OAuthTokenResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&tokens))
+	assert.Equal(t, "openid email", tokens.Scope)
+	idTokenClaims, err := jwtgen.ReadClaimsWithKeys(tokens.IDToken, []jwtgen.Key{key})
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, idTokenClaims["sub"])
+	assert.Equal(t, "cli", idTokenClaims["aud"])
+
+	// The tokens are issued once
+	resp = postForm("/auth/token", poll)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,165 @@
+package integration_test_cases
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/infrastructure/repositories"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"aegis/integration/integration_testkit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Organizations_WithoutSessionReturns401(t *testing.T) {
+	suite := integration_testkit.SetupTestSuite(t, integration_testkit.GetBaseConfig())
+	defer suite.Teardown()
+	resp, err := http.Get(suite.Server.URL + "/auth/organizations")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func Organizations_CreateAndSwitch(t *testing.T) {
+	suite := integration_testkit.SetupTestSuite(t, integration_testkit.GetBaseConfig())
+	defer suite.Teardown()
+	_, sessionCookies := loginOnTwoDevices(t, suite)
+	send := func(method, path string, body any) *http.Response {
+		jsonBody, err := json.Marshal(body)
+		require.NoError(t, err)
+		req, err := http.NewRequest(method, suite.Server.URL+path, bytes.NewBuffer(jsonBody))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		for _, cookie := range sessionCookies {
+			req.AddCookie(cookie)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := send("POST", "/auth/organizations", map[string]string{"name": "Acme"})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var organization entities.Organization
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&organization))
+	assert.Equal(t, "Acme", organization.Name)
+
+	resp = send("POST", "/auth/organizations/switch", map[string]string{"organization_id": organization.ID})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var accessToken string
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "access_token" {
+			accessToken = cookie.Value
+		}
+	}
+	require.NotEmpty(t, accessToken)
+
+	req, err := http.NewRequest("GET", suite.Server.URL+"/auth/me", nil)
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: accessToken})
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var session entities.Session
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&session))
+	assert.Equal(t, organization.ID, session.OrganizationID)
+	assert.Equal(t, entities.OrganizationRoleOwner, session.OrganizationRole)
+}
+
+func Organizations_SwitchToAnotherOrganizationReturns404(t *testing.T) {
+	suite := integration_testkit.SetupTestSuite(t, integration_testkit.GetBaseConfig())
+	defer suite.Teardown()
+	_, sessionCookies := loginOnTwoDevices(t, suite)
+	req, err := http.NewRequest("POST", suite.Server.URL+"/auth/organizations/switch", bytes.NewBufferString(`{"organization_id": "9b2d3a4e-0000-4000-8000-000000000000"}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	for _, cookie := range sessionCookies {
+		req.AddCookie(cookie)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func Organizations_InviteReturnsTheLoginLink(t *testing.T) {
+	suite := integration_testkit.SetupTestSuite(t, integration_testkit.GetBaseConfig())
+	defer suite.Teardown()
+	user, sessionCookies := loginOnTwoDevices(t, suite)
+	organization := entities.NewOrganization("Acme")
+	require.NoError(t, repositories.NewOrganizationRepository(suite.Db).CreateOrganization(organization, entities.NewOrganizationMember(organization.ID, user.ID, entities.OrganizationRoleOwner)))
+
+	req, err := http.NewRequest("POST", suite.Server.URL+"/auth/organizations/"+organization.ID+"/invitations", bytes.NewBufferString(`{"email": "invited@example.com", "role": "member"}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	for _, cookie := range sessionCookies {
+		req.AddCookie(cookie)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var body struct {
+		Invitation entities.OrganizationInvitation `json:"invitation"`
+		Token      string                          `json:"token"`
+		URL        string                          `json:"url"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "invited@example.com", body.Invitation.Email)
+	require.NotEmpty(t, body.Token)
+	assert.Equal(t, "http://localhost:8080/auth/login?invitation="+body.Token, body.URL)
+
+	var stored entities.OrganizationInvitation
+	require.NoError(t, suite.Db.First(&stored, "id = ?", body.Invitation.ID).Error)
+	assert.NotEqual(t, body.Token, stored.Token)
+}
+
+func Organizations_InvitationIsAcceptedDuringLogin(t *testing.T) {
+	config := integration_testkit.GetBaseConfig()
+	config.App.EarlyAdoptersOnly = true
+	suite := integration_testkit.SetupTestSuite(t, config)
+	defer suite.Teardown()
+	owner, err := entities.NewUser("owner", "", "owner@example.com", "github")
+	require.NoError(t, err)
+	owner = suite.CreateUser(t, owner, []string{"user"})
+	organization := entities.NewOrganization("Acme")
+	require.NoError(t, repositories.NewOrganizationRepository(suite.Db).CreateOrganization(organization, entities.NewOrganizationMember(organization.ID, owner.ID, entities.OrganizationRoleOwner)))
+	// The fake provider logs in test@example.com
+	invitation, err := entities.NewOrganizationInvitation(organization.ID, "test@example.com", entities.OrganizationRoleAdmin, owner.ID, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, repositories.NewOrganizationInvitationRepository(suite.Db, suite.Config.PepperFor(entities.PepperInvitation)).CreateInvitation(invitation))
+	state := entities.State{
+		Value:        "valid_state",
+		ExpiresAt:    time.Now().Add(10 * time.Minute),
+		InvitationID: invitation.ID,
+	}
+	require.NoError(t, suite.Db.Create(&state).Error)
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(suite.Server.URL + "/auth/github/callback?code=accepted_code&state=valid_state")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, "http://localhost:8080/login-success", resp.Header.Get("Location"))
+
+	var user entities.User
+	require.NoError(t, suite.Db.First(&user, "email = ?", "test@example.com").Error)
+	assert.True(t, user.EarlyAdopter)
+	member, err := repositories.NewOrganizationRepository(suite.Db).GetOrganizationMember(organization.ID, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, entities.OrganizationRoleAdmin, member.Role)
+}
+
+func Organizations_LoginWithAnInvalidInvitationReturns400(t *testing.T) {
+	suite := integration_testkit.SetupTestSuite(t, integration_testkit.GetBaseConfig())
+	defer suite.Teardown()
+	resp, err := http.Get(suite.Server.URL + "/auth/github?invitation=invitation_unknown")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,95 @@
+package integration_test_cases
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"aegis/integration/integration_testkit"
+	"aegis/pkg/webauthn/webauthntest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Passkeys_DisabledReturns403(t *testing.T) {
+	suite := integration_testkit.SetupTestSuite(t, integration_testkit.GetBaseConfig())
+	defer suite.Teardown()
+	resp, err := http.Post(suite.Server.URL+"/auth/passkeys/login/begin", "application/json", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func Passkeys_RegisterThenLogIn(t *testing.T) {
+	config := integration_testkit.GetBaseConfig()
+	config.Auth.Providers.Passkeys.Enabled = true
+	suite := integration_testkit.SetupTestSuite(t, config)
+	defer suite.Teardown()
+	user, sessionCookies := loginOnTwoDevices(t, suite)
+	authenticator, err := webauthntest.NewAuthenticator("localhost", "http://localhost:8080")
+	require.NoError(t, err)
+
+	post := func(path string, body any, cookies []*http.Cookie) *http.Response {
+		payload, err := json.Marshal(body)
+		require.NoError(t, err)
+		req, err := http.NewRequest("POST", suite.Server.URL+path, bytes.NewReader(payload))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+	challenge := func(resp *http.Response) string {
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var body struct {
+			PublicKey struct {
+				Challenge string `json:"challenge"`
+			} `json:"publicKey"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return body.PublicKey.Challenge
+	}
+	encode := base64.RawURLEncoding.EncodeToString
+
+	clientDataJSON, attestationObject, err := authenticator.Create(challenge(post("/auth/passkeys/register/begin", map[string]any{}, sessionCookies)))
+	require.NoError(t, err)
+	resp := post("/auth/passkeys/register/finish", map[string]any{
+		"name":     "Laptop",
+		"response": map[string]string{"clientDataJSON": encode(clientDataJSON), "attestationObject": encode(attestationObject)},
+	}, sessionCookies)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	clientDataJSON, authenticatorData, signature, err := authenticator.Get(challenge(post("/auth/passkeys/login/begin", map[string]any{}, []*http.Cookie{suite.DeviceCookie()})))
+	require.NoError(t, err)
+	login := map[string]any{
+		"id": encode(authenticator.CredentialID),
+		"response": map[string]string{
+			"clientDataJSON":    encode(clientDataJSON),
+			"authenticatorData": encode(authenticatorData),
+			"signature":         encode(signature),
+			"userHandle":        encode([]byte(user.ID)),
+		},
+	}
+	resp = post("/auth/passkeys/login/finish", login, []*http.Cookie{suite.DeviceCookie()})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var body map[string]string
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "http://localhost:8080/login-success", body["redirect_url"])
+	var accessToken string
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "access_token" {
+			accessToken = cookie.Value
+		}
+	}
+	assert.NotEmpty(t, accessToken)
+
+	// The challenge was used
+	resp = post("/auth/passkeys/login/finish", login, []*http.Cookie{suite.DeviceCookie()})
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
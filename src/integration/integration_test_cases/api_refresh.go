@@ -32,7 +32,7 @@ func HardRefresh_MustRefresh_ValidTokens(t *testing.T) {
 	require.NoError(t, err)
 
 	// Create valid refresh token
-	refreshTokenEntity, _, err := entities.NewRefreshToken(user, "device-fingerprint", suite.Config)
+	refreshTokenEntity, _, err := entities.NewRefreshToken(user, integration_testkit.TestDevice.Fingerprint(), suite.Config)
 	require.NoError(t, err)
 	refreshTokenEntity = suite.CreateRefreshToken(t, refreshTokenEntity)
 
@@ -44,6 +44,7 @@ func HardRefresh_MustRefresh_ValidTokens(t *testing.T) {
 	refreshCookie := cookies.NewRefreshCookie(refreshTokenEntity.Token, refreshTokenEntity.ExpiresAt.Unix(), suite.Config)
 	req.AddCookie(&accessCookie)
 	req.AddCookie(&refreshCookie)
+	req.AddCookie(suite.DeviceCookie())
 
 	// Make the request
 	resp, err := http.DefaultClient.Do(req)
@@ -66,15 +67,15 @@ func HardRefresh_MustRefresh_ValidTokens(t *testing.T) {
 	assert.NotEqual(t, accessToken, newAccessToken)
 	assert.NotEqual(t, refreshTokenEntity.Token, newRefreshToken)
 
-	// Verify old refresh token is deleted
+	// Verify old refresh token is rotated
 	var count int64
-	err = suite.Db.Model(&entities.RefreshToken{}).Where("token = ?", refreshTokenEntity.Token).Count(&count).Error
+	err = suite.Db.Model(&entities.RefreshToken{}).Where("token = ? AND rotated_at IS NULL", suite.HashRefreshToken(refreshTokenEntity.Token)).Count(&count).Error
 	require.NoError(t, err)
 	assert.Equal(t, int64(0), count)
 
 	// verify new refresh token is created
 	var count2 int64
-	err = suite.Db.Model(&entities.RefreshToken{}).Where("token = ?", newRefreshToken).Count(&count2).Error
+	err = suite.Db.Model(&entities.RefreshToken{}).Where("token = ?", suite.HashRefreshToken(newRefreshToken)).Count(&count2).Error
 	require.NoError(t, err)
 	assert.Equal(t, int64(1), count2)
 }
@@ -89,7 +90,7 @@ func HardRefresh_MustRefresh_EmptyAT(t *testing.T) {
 	user = suite.CreateUser(t, user, []string{"user"})
 
 	// Create valid refresh token
-	refreshTokenEntity, _, err := entities.NewRefreshToken(user, "device-fingerprint", suite.Config)
+	refreshTokenEntity, _, err := entities.NewRefreshToken(user, integration_testkit.TestDevice.Fingerprint(), suite.Config)
 	require.NoError(t, err)
 	refreshTokenEntity = suite.CreateRefreshToken(t, refreshTokenEntity)
 
@@ -99,6 +100,7 @@ func HardRefresh_MustRefresh_EmptyAT(t *testing.T) {
 
 	refreshCookie := cookies.NewRefreshCookie(refreshTokenEntity.Token, refreshTokenEntity.ExpiresAt.Unix(), suite.Config)
 	req.AddCookie(&refreshCookie)
+	req.AddCookie(suite.DeviceCookie())
 
 	// Make the request
 	resp, err := http.DefaultClient.Do(req)
@@ -120,9 +122,9 @@ func HardRefresh_MustRefresh_EmptyAT(t *testing.T) {
 	assert.NotEmpty(t, newRefreshToken)
 	assert.NotEqual(t, refreshTokenEntity.Token, newRefreshToken)
 
-	// Verify old refresh token is deleted
+	// Verify old refresh token is rotated
 	var count int64
-	err = suite.Db.Model(&entities.RefreshToken{}).Where("token = ?", refreshTokenEntity.Token).Count(&count).Error
+	err = suite.Db.Model(&entities.RefreshToken{}).Where("token = ? AND rotated_at IS NULL", suite.HashRefreshToken(refreshTokenEntity.Token)).Count(&count).Error
 	require.NoError(t, err)
 	assert.Equal(t, int64(0), count)
 }
@@ -143,7 +145,7 @@ func HardRefresh_MustRefresh_ExpiredAT(t *testing.T) {
 	require.NoError(t, err)
 
 	// Create valid refresh token
-	refreshTokenEntity, _, err := entities.NewRefreshToken(user, "device-fingerprint", suite.Config)
+	refreshTokenEntity, _, err := entities.NewRefreshToken(user, integration_testkit.TestDevice.Fingerprint(), suite.Config)
 	require.NoError(t, err)
 	refreshTokenEntity = suite.CreateRefreshToken(t, refreshTokenEntity)
 
@@ -155,6 +157,7 @@ func HardRefresh_MustRefresh_ExpiredAT(t *testing.T) {
 	refreshCookie := cookies.NewRefreshCookie(refreshTokenEntity.Token, refreshTokenEntity.ExpiresAt.Unix(), suite.Config)
 	req.AddCookie(&accessCookie)
 	req.AddCookie(&refreshCookie)
+	req.AddCookie(suite.DeviceCookie())
 
 	// Make the request
 	resp, err := http.DefaultClient.Do(req)
@@ -177,9 +180,9 @@ func HardRefresh_MustRefresh_ExpiredAT(t *testing.T) {
 	assert.NotEqual(t, accessToken, newAccessToken)
 	assert.NotEqual(t, refreshTokenEntity.Token, newRefreshToken)
 
-	// Verify old refresh token is deleted
+	// Verify old refresh token is rotated
 	var count int64
-	err = suite.Db.Model(&entities.RefreshToken{}).Where("token = ?", refreshTokenEntity.Token).Count(&count).Error
+	err = suite.Db.Model(&entities.RefreshToken{}).Where("token = ? AND rotated_at IS NULL", suite.HashRefreshToken(refreshTokenEntity.Token)).Count(&count).Error
 	require.NoError(t, err)
 	assert.Equal(t, int64(0), count)
 }
@@ -197,7 +200,7 @@ func HardRefresh_MustRefresh_MalformedAT(t *testing.T) {
 	malformedAccessToken := "invalid.jwt.token"
 
 	// Create valid refresh token
-	refreshTokenEntity, _, err := entities.NewRefreshToken(user, "device-fingerprint", suite.Config)
+	refreshTokenEntity, _, err := entities.NewRefreshToken(user, integration_testkit.TestDevice.Fingerprint(), suite.Config)
 	require.NoError(t, err)
 	refreshTokenEntity = suite.CreateRefreshToken(t, refreshTokenEntity)
 
@@ -209,6 +212,7 @@ func HardRefresh_MustRefresh_MalformedAT(t *testing.T) {
 	refreshCookie := cookies.NewRefreshCookie(refreshTokenEntity.Token, refreshTokenEntity.ExpiresAt.Unix(), suite.Config)
 	req.AddCookie(&accessCookie)
 	req.AddCookie(&refreshCookie)
+	req.AddCookie(suite.DeviceCookie())
 
 	// Make the request
 	resp, err := http.DefaultClient.Do(req)
@@ -234,9 +238,9 @@ func HardRefresh_MustRefresh_MalformedAT(t *testing.T) {
 	assert.NotEqual(t, malformedAccessToken, newAccessToken)
 	assert.NotEqual(t, refreshTokenEntity.Token, newRefreshToken)
 
-	// Verify old refresh token is deleted
+	// Verify old refresh token is rotated
 	var count int64
-	err = suite.Db.Model(&entities.RefreshToken{}).Where("token = ?", refreshTokenEntity.Token).Count(&count).Error
+	err = suite.Db.Model(&entities.RefreshToken{}).Where("token = ? AND rotated_at IS NULL", suite.HashRefreshToken(refreshTokenEntity.Token)).Count(&count).Error
 	require.NoError(t, err)
 	assert.Equal(t, int64(0), count)
 }
@@ -289,6 +293,7 @@ func HardRefresh_MustNotRefresh_ExpiredRT(t *testing.T) {
 
 	refreshCookie := cookies.NewRefreshCookie(refreshTokenEntity.Token, refreshTokenEntity.ExpiresAt.Unix(), suite.Config)
 	req.AddCookie(&refreshCookie)
+	req.AddCookie(suite.DeviceCookie())
 
 	// Make the request
 	resp, err := http.DefaultClient.Do(req)
@@ -314,6 +319,7 @@ func HardRefresh_MustNotRefresh_MalformedRT(t *testing.T) {
 
 	refreshCookie := cookies.NewRefreshCookie("malformed_refresh_token", time.Now().Add(time.Hour).Unix(), suite.Config)
 	req.AddCookie(&refreshCookie)
+	req.AddCookie(suite.DeviceCookie())
 
 	// Make the request
 	resp, err := http.DefaultClient.Do(req)
@@ -329,3 +335,63 @@ func HardRefresh_MustNotRefresh_MalformedRT(t *testing.T) {
 	// todo: could consider another error
 	assert.Contains(t, errorResponse["error"], apperrors.ErrGeneric.Error())
 }
+
+func HardRefresh_MustNotRefresh_ReusedRT(t *testing.T) {
+	suite := integration_testkit.SetupTestSuite(t, integration_testkit.GetBaseConfig())
+	defer suite.Teardown()
+
+	// Create a user
+	user, err := entities.NewUser("testuser", "https://example.com/avatar.jpg", "test@example.com", "github")
+	require.NoError(t, err)
+	user = suite.CreateUser(t, user, []string{"user"})
+
+	// Create valid refresh token
+	refreshTokenEntity, _, err := entities.NewRefreshToken(user, integration_testkit.TestDevice.Fingerprint(), suite.Config)
+	require.NoError(t, err)
+	refreshTokenEntity = suite.CreateRefreshToken(t, refreshTokenEntity)
+
+	refresh := func(refreshToken string) *http.Response {
+		req, err := http.NewRequest("GET", suite.Server.URL+"/auth/refresh", nil)
+		require.NoError(t, err)
+		refreshCookie := cookies.NewRefreshCookie(refreshToken, refreshTokenEntity.ExpiresAt.Unix(), suite.Config)
+		req.AddCookie(&refreshCookie)
+		req.AddCookie(suite.DeviceCookie())
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	// First use rotates the token
+	resp := refresh(refreshTokenEntity.Token)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var newRefreshToken string
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "refresh_token" {
+			newRefreshToken = cookie.Value
+		}
+	}
+	require.NotEmpty(t, newRefreshToken)
+
+	// Second use is a reuse
+	resp = refresh(refreshTokenEntity.Token)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	var errorResponse map[string]interface{}
+	err = json.Unmarshal(body, &errorResponse)
+	require.NoError(t, err)
+	assert.Contains(t, errorResponse["error"], apperrors.ErrRefreshTokenReused.Error())
+
+	// Verify the whole family is revoked
+	var count int64
+	err = suite.Db.Model(&entities.RefreshToken{}).Where("family_id = ?", refreshTokenEntity.FamilyID).Count(&count).Error
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+	resp = refresh(newRefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// Verify the security event is recorded
+	err = suite.Db.Model(&entities.SecurityEvent{}).Where("user_id = ? AND type = ?", user.ID, entities.SecurityEventRefreshTokenReuse).Count(&count).Error
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
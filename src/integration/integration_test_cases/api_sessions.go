@@ -0,0 +1,122 @@
+package integration_test_cases
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/pkg/cookies"
+	"aegis/pkg/jwtgen"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"aegis/integration/integration_testkit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// loginOnTwoDevices creates a user with a session on TestDevice and one on another device, and returns the
+// cookies of the TestDevice session
+func loginOnTwoDevices(t *testing.T, suite *integration_testkit.TestSuite) (entities.User, []*http.Cookie) {
+	user, err := entities.NewUser("cloude", "https://example.com/avatar.jpg", "cloude@example.com", "github")
+	require.NoError(t, err)
+	user = suite.CreateUser(t, user, []string{"user"})
+	current, _, err := entities.NewRefreshToken(user, integration_testkit.TestDevice.Fingerprint(), suite.Config)
+	require.NoError(t, err)
+	suite.CreateRefreshToken(t, current)
+	other, _, err := entities.NewRefreshToken(user, entities.Device{ID: "device_other"}.Fingerprint(), suite.Config)
+	require.NoError(t, err)
+	suite.CreateRefreshToken(t, other)
+	cClaims, err := entities.NewCustomClaimsFromValues(user.ID, false, user.Roles, user.MetadataPublic)
+	require.NoError(t, err)
+	accessToken, atExp, err := jwtgen.Generate(cClaims.ToMap(), time.Now(), 10, "MyApp", suite.Config.JWT.Secret)
+	require.NoError(t, err)
+	atCookie := cookies.NewAccessCookie(accessToken, atExp, suite.Config)
+	rtCookie := cookies.NewRefreshCookie(current.Token, current.ExpiresAt.Unix(), suite.Config)
+	return user, []*http.Cookie{&atCookie, &rtCookie, suite.DeviceCookie()}
+}
+
+func listSessions(t *testing.T, suite *integration_testkit.TestSuite, sessionCookies []*http.Cookie) []entities.ActiveSession {
+	req, err := http.NewRequest("GET", suite.Server.URL+"/auth/sessions", nil)
+	require.NoError(t, err)
+	for _, cookie := range sessionCookies {
+		req.AddCookie(cookie)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var body struct {
+		Sessions []entities.ActiveSession `json:"sessions"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	return body.Sessions
+}
+
+func Sessions_WithoutSessionReturns401(t *testing.T) {
+	suite := integration_testkit.SetupTestSuite(t, integration_testkit.GetBaseConfig())
+	defer suite.Teardown()
+	resp, err := http.Get(suite.Server.URL + "/auth/sessions")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func Sessions_ListsTheSessionsOfTheUser(t *testing.T) {
+	suite := integration_testkit.SetupTestSuite(t, integration_testkit.GetBaseConfig())
+	defer suite.Teardown()
+	_, sessionCookies := loginOnTwoDevices(t, suite)
+	sessions := listSessions(t, suite, sessionCookies)
+	require.Len(t, sessions, 2)
+	current := 0
+	for _, session := range sessions {
+		assert.NotEmpty(t, session.ID)
+		if session.Current {
+			current++
+		}
+	}
+	assert.Equal(t, 1, current)
+}
+
+func Sessions_RevokeOthersKeepsTheCurrentSession(t *testing.T) {
+	suite := integration_testkit.SetupTestSuite(t, integration_testkit.GetBaseConfig())
+	defer suite.Teardown()
+	_, sessionCookies := loginOnTwoDevices(t, suite)
+	req, err := http.NewRequest("DELETE", suite.Server.URL+"/auth/sessions/others", nil)
+	require.NoError(t, err)
+	for _, cookie := range sessionCookies {
+		req.AddCookie(cookie)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	sessions := listSessions(t, suite, sessionCookies)
+	require.Len(t, sessions, 1)
+	assert.True(t, sessions[0].Current)
+}
+
+func Sessions_RevokeOneSession(t *testing.T) {
+	suite := integration_testkit.SetupTestSuite(t, integration_testkit.GetBaseConfig())
+	defer suite.Teardown()
+	_, sessionCookies := loginOnTwoDevices(t, suite)
+	var other entities.ActiveSession
+	for _, session := range listSessions(t, suite, sessionCookies) {
+		if !session.Current {
+			other = session
+		}
+	}
+	require.NotEmpty(t, other.ID)
+	req, err := http.NewRequest("DELETE", suite.Server.URL+"/auth/sessions/"+other.ID, nil)
+	require.NoError(t, err)
+	for _, cookie := range sessionCookies {
+		req.AddCookie(cookie)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	sessions := listSessions(t, suite, sessionCookies)
+	require.Len(t, sessions, 1)
+	assert.True(t, sessions[0].Current)
+
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -32,7 +32,7 @@ func SoftRefresh_MustNotRefresh_ValidAT(t *testing.T) {
 	require.NoError(t, err)
 
 	// Create valid refresh token
-	refreshTokenEntity, _, err := entities.NewRefreshToken(user, "device-fingerprint", suite.Config)
+	refreshTokenEntity, _, err := entities.NewRefreshToken(user, integration_testkit.TestDevice.Fingerprint(), suite.Config)
 	require.NoError(t, err)
 	refreshTokenEntity = suite.CreateRefreshToken(t, refreshTokenEntity)
 
@@ -44,6 +44,7 @@ func SoftRefresh_MustNotRefresh_ValidAT(t *testing.T) {
 	refreshCookie := cookies.NewRefreshCookie(refreshTokenEntity.Token, refreshTokenEntity.ExpiresAt.Unix(), suite.Config)
 	req.AddCookie(&accessCookie)
 	req.AddCookie(&refreshCookie)
+	req.AddCookie(suite.DeviceCookie())
 
 	// Make the request
 	resp, err := http.DefaultClient.Do(req)
@@ -69,7 +70,7 @@ func SoftRefresh_MustRefresh_EmptyAT(t *testing.T) {
 	atExp := time.Now().Add(-2 * time.Minute).Unix()
 
 	// Create empty refresh token
-	refreshTokenEntity, _, err := entities.NewRefreshToken(user, "device-fingerprint", suite.Config)
+	refreshTokenEntity, _, err := entities.NewRefreshToken(user, integration_testkit.TestDevice.Fingerprint(), suite.Config)
 	require.NoError(t, err)
 	refreshTokenEntity = suite.CreateRefreshToken(t, refreshTokenEntity)
 
@@ -81,6 +82,7 @@ func SoftRefresh_MustRefresh_EmptyAT(t *testing.T) {
 	refreshCookie := cookies.NewRefreshCookie(refreshTokenEntity.Token, refreshTokenEntity.ExpiresAt.Unix(), suite.Config)
 	req.AddCookie(&accessCookie)
 	req.AddCookie(&refreshCookie)
+	req.AddCookie(suite.DeviceCookie())
 
 	// Make the request
 	resp, err := http.DefaultClient.Do(req)
@@ -104,15 +106,15 @@ func SoftRefresh_MustRefresh_EmptyAT(t *testing.T) {
 	assert.NotEmpty(t, newRefreshToken)
 	assert.NotEqual(t, refreshTokenEntity.Token, newRefreshToken)
 
-	// verify old refresh token is not there
+	// verify old refresh token is rotated
 	var count int64
-	err = suite.Db.Model(&entities.RefreshToken{}).Where("token = ?", refreshTokenEntity.Token).Count(&count).Error
+	err = suite.Db.Model(&entities.RefreshToken{}).Where("token = ? AND rotated_at IS NULL", suite.HashRefreshToken(refreshTokenEntity.Token)).Count(&count).Error
 	require.NoError(t, err)
 	assert.Equal(t, int64(0), count)
 
 	// verify new refresh token is there
 	var count2 int64
-	err = suite.Db.Model(&entities.RefreshToken{}).Where("token = ? AND user_id = ?", newRefreshToken, user.ID).Count(&count2).Error
+	err = suite.Db.Model(&entities.RefreshToken{}).Where("token = ? AND user_id = ?", suite.HashRefreshToken(newRefreshToken), user.ID).Count(&count2).Error
 	require.NoError(t, err)
 	assert.Equal(t, int64(1), count2)
 }
@@ -133,7 +135,7 @@ func SoftRefresh_MustRefresh_ExpiredAT(t *testing.T) {
 	require.NoError(t, err)
 
 	// Create valid refresh token
-	refreshTokenEntity, _, err := entities.NewRefreshToken(user, "device-fingerprint", suite.Config)
+	refreshTokenEntity, _, err := entities.NewRefreshToken(user, integration_testkit.TestDevice.Fingerprint(), suite.Config)
 	require.NoError(t, err)
 	refreshTokenEntity = suite.CreateRefreshToken(t, refreshTokenEntity)
 
@@ -145,6 +147,7 @@ func SoftRefresh_MustRefresh_ExpiredAT(t *testing.T) {
 	refreshCookie := cookies.NewRefreshCookie(refreshTokenEntity.Token, refreshTokenEntity.ExpiresAt.Unix(), suite.Config)
 	req.AddCookie(&accessCookie)
 	req.AddCookie(&refreshCookie)
+	req.AddCookie(suite.DeviceCookie())
 
 	// Make the request
 	resp, err := http.DefaultClient.Do(req)
@@ -168,15 +171,15 @@ func SoftRefresh_MustRefresh_ExpiredAT(t *testing.T) {
 	assert.NotEmpty(t, newRefreshToken)
 	assert.NotEqual(t, refreshTokenEntity.Token, newRefreshToken)
 
-	// verify old refresh token is not there
+	// verify old refresh token is rotated
 	var count int64
-	err = suite.Db.Model(&entities.RefreshToken{}).Where("token = ?", refreshTokenEntity.Token).Count(&count).Error
+	err = suite.Db.Model(&entities.RefreshToken{}).Where("token = ? AND rotated_at IS NULL", suite.HashRefreshToken(refreshTokenEntity.Token)).Count(&count).Error
 	require.NoError(t, err)
 	assert.Equal(t, int64(0), count)
 
 	// verify new refresh token is there
 	var count2 int64
-	err = suite.Db.Model(&entities.RefreshToken{}).Where("token = ? AND user_id = ?", newRefreshToken, user.ID).Count(&count2).Error
+	err = suite.Db.Model(&entities.RefreshToken{}).Where("token = ? AND user_id = ?", suite.HashRefreshToken(newRefreshToken), user.ID).Count(&count2).Error
 	require.NoError(t, err)
 	assert.Equal(t, int64(1), count2)
 }
@@ -195,7 +198,7 @@ func SoftRefresh_MustRefresh_MalformedAT(t *testing.T) {
 	atExp := time.Now().Add(time.Hour).Unix()
 
 	// Create valid refresh token
-	refreshTokenEntity, _, err := entities.NewRefreshToken(user, "device-fingerprint", suite.Config)
+	refreshTokenEntity, _, err := entities.NewRefreshToken(user, integration_testkit.TestDevice.Fingerprint(), suite.Config)
 	require.NoError(t, err)
 	refreshTokenEntity = suite.CreateRefreshToken(t, refreshTokenEntity)
 
@@ -207,6 +210,7 @@ func SoftRefresh_MustRefresh_MalformedAT(t *testing.T) {
 	refreshCookie := cookies.NewRefreshCookie(refreshTokenEntity.Token, refreshTokenEntity.ExpiresAt.Unix(), suite.Config)
 	req.AddCookie(&accessCookie)
 	req.AddCookie(&refreshCookie)
+	req.AddCookie(suite.DeviceCookie())
 
 	// Make the request
 	resp, err := http.DefaultClient.Do(req)
@@ -230,15 +234,15 @@ func SoftRefresh_MustRefresh_MalformedAT(t *testing.T) {
 	assert.NotEmpty(t, newRefreshToken)
 	assert.NotEqual(t, refreshTokenEntity.Token, newRefreshToken)
 
-	// verify old refresh token is not there
+	// verify old refresh token is rotated
 	var count int64
-	err = suite.Db.Model(&entities.RefreshToken{}).Where("token = ?", refreshTokenEntity.Token).Count(&count).Error
+	err = suite.Db.Model(&entities.RefreshToken{}).Where("token = ? AND rotated_at IS NULL", suite.HashRefreshToken(refreshTokenEntity.Token)).Count(&count).Error
 	require.NoError(t, err)
 	assert.Equal(t, int64(0), count)
 
 	// verify new refresh token is there
 	var count2 int64
-	err = suite.Db.Model(&entities.RefreshToken{}).Where("token = ? AND user_id = ?", newRefreshToken, user.ID).Count(&count2).Error
+	err = suite.Db.Model(&entities.RefreshToken{}).Where("token = ? AND user_id = ?", suite.HashRefreshToken(newRefreshToken), user.ID).Count(&count2).Error
 	require.NoError(t, err)
 	assert.Equal(t, int64(1), count2)
 }
@@ -294,6 +298,7 @@ func SoftRefresh_MustNotRefresh_ExpiredRT(t *testing.T) {
 
 	refreshCookie := cookies.NewRefreshCookie(refreshTokenEntity.Token, refreshTokenEntity.ExpiresAt.Unix(), suite.Config)
 	req.AddCookie(&refreshCookie)
+	req.AddCookie(suite.DeviceCookie())
 
 	// Make the request
 	resp, err := http.DefaultClient.Do(req)
@@ -323,6 +328,7 @@ func SoftRefresh_MustNotRefresh_MalformedRT(t *testing.T) {
 
 	refreshCookie := cookies.NewRefreshCookie("malformed_refresh_token", time.Now().Add(time.Hour).Unix(), suite.Config)
 	req.AddCookie(&refreshCookie)
+	req.AddCookie(suite.DeviceCookie())
 
 	// Make the request
 	resp, err := http.DefaultClient.Do(req)
@@ -51,9 +51,11 @@ func (p *FakeOAuthProvider) ExchangeCodeForUserInfos(code, state string) (*provi
 	case "accepted_code":
 		// Return valid user info
 		return &providers.UserInfos{
-			Name:   "testuser",
-			Email:  "test@example.com",
-			Avatar: "https://example.com/avatar.jpg",
+			SubjectID:     "12345",
+			Name:          "testuser",
+			Email:         "test@example.com",
+			EmailVerified: true,
+			Avatar:        "https://example.com/avatar.jpg",
 		}, nil
 	case "rejected_code":
 		return nil, fmt.Errorf("invalid_grant: The authorization code is invalid or has expired")
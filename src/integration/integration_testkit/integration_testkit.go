@@ -3,14 +3,17 @@ package integration_testkit
 import (
 	usecases "aegis/internal/application/use_cases"
 	"aegis/internal/domain/entities"
+	"aegis/internal/domain/services"
 	"aegis/internal/infrastructure/database"
 	"aegis/internal/infrastructure/handlers"
 	"aegis/internal/infrastructure/middlewares"
 	"aegis/internal/infrastructure/repositories"
 	"aegis/internal/registry"
+	"aegis/pkg/cookies"
 	"aegis/pkg/urlbuilder"
 	"context"
 	"fmt"
+	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
@@ -102,6 +105,12 @@ func GetBaseConfig() entities.Config {
 	config.JWT.AccessTokenExpirationMin = 15
 	config.JWT.RefreshTokenExpirationDays = 7
 
+	// Sessions configuration
+	config.Sessions.RefreshTokenPepper = "test-refresh-token-pepper"
+
+	// MFA configuration
+	config.MFA.EncryptionKey = "test-mfa-encryption-key"
+
 	// Login page configuration
 	config.LoginPage.Enabled = true
 	config.LoginPage.FullPath = "/auth/login"
@@ -142,7 +151,7 @@ func (s *TestSuite) setupTestServer() {
 	e.HideBanner = true
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
-	group := e.Group("/auth")
+	group := e.Group("/auth", r.Middlewares.IdentifyDevice)
 	group.GET("/me", r.Handlers.GetSession, r.Middlewares.CheckAndRefreshToken)
 	group.GET("/refresh", r.Handlers.DoNothing, r.Middlewares.CheckAndForceRefreshToken)
 	group.GET("/logout", r.Handlers.Logout)
@@ -154,21 +163,110 @@ func (s *TestSuite) setupTestServer() {
 		group.GET("/login-error", r.Handlers.ServeErrorPage)
 	}
 	group.POST("/authorize-access-token", r.Handlers.Authorize, r.Middlewares.CheckInternalAPICall)
+	group.GET("/.well-known/jwks.json", r.Handlers.GetJWKS)
+	group.GET("/sessions", r.Handlers.ListSessions, r.Middlewares.CheckAndRefreshToken)
+	group.GET("/sessions/evicted", r.Handlers.ListEvictedSessions, r.Middlewares.CheckAndRefreshToken)
+	group.DELETE("/sessions/others", r.Handlers.RevokeOtherSessions, r.Middlewares.CheckAndRefreshToken)
+	group.DELETE("/sessions/:id", r.Handlers.RevokeSession, r.Middlewares.CheckAndRefreshToken)
+	group.GET("/identities", r.IdentityHandlers.ListIdentities, r.Middlewares.CheckAndRefreshToken)
+	group.DELETE("/identities/:provider", r.IdentityHandlers.UnlinkIdentity, r.Middlewares.CheckAndRefreshToken)
+	group.GET("/organizations", r.OrganizationHandlers.ListOrganizations, r.Middlewares.CheckAndRefreshToken)
+	group.POST("/organizations", r.OrganizationHandlers.CreateOrganization, r.Middlewares.CheckAndRefreshToken)
+	group.POST("/organizations/switch", r.OrganizationHandlers.SwitchOrganization, r.Middlewares.CheckAndRefreshToken)
+	group.GET("/organizations/:id/members", r.OrganizationHandlers.ListMembers, r.Middlewares.CheckAndRefreshToken)
+	group.PUT("/organizations/:id/members/:user_id", r.OrganizationHandlers.SetMemberRole, r.Middlewares.CheckAndRefreshToken)
+	group.DELETE("/organizations/:id/members/:user_id", r.OrganizationHandlers.RemoveMember, r.Middlewares.CheckAndRefreshToken)
+	group.GET("/organizations/:id/invitations", r.OrganizationHandlers.ListInvitations, r.Middlewares.CheckAndRefreshToken)
+	group.POST("/organizations/:id/invitations", r.OrganizationHandlers.CreateInvitation, r.Middlewares.CheckAndRefreshToken)
+	group.DELETE("/organizations/:id/invitations/:invitation_id", r.OrganizationHandlers.RevokeInvitation, r.Middlewares.CheckAndRefreshToken)
+	group.POST("/invitations/accept", r.OrganizationHandlers.AcceptInvitation, r.Middlewares.CheckAndRefreshToken)
+	group.POST("/email/login", r.EmailHandlers.SendMagicLink, r.EmailMiddlewares.CheckAuthEnabled)
+	group.GET("/email/verify", r.EmailHandlers.VerifyMagicLink, r.EmailMiddlewares.CheckAuthEnabled)
+	group.POST("/email/code", r.EmailHandlers.SendLoginCode, r.EmailMiddlewares.CheckAuthEnabled)
+	group.POST("/email/code/verify", r.EmailHandlers.VerifyLoginCode, r.EmailMiddlewares.CheckAuthEnabled)
+	group.POST("/passkeys/login/begin", r.PasskeyHandlers.BeginLogin, r.PasskeyMiddlewares.CheckAuthEnabled)
+	group.POST("/passkeys/login/finish", r.PasskeyHandlers.FinishLogin, r.PasskeyMiddlewares.CheckAuthEnabled)
+	group.POST("/passkeys/register/begin", r.PasskeyHandlers.BeginRegistration, r.PasskeyMiddlewares.CheckAuthEnabled, r.Middlewares.CheckAndRefreshToken)
+	group.POST("/passkeys/register/finish", r.PasskeyHandlers.FinishRegistration, r.PasskeyMiddlewares.CheckAuthEnabled, r.Middlewares.CheckAndRefreshToken)
+	group.GET("/passkeys", r.PasskeyHandlers.ListPasskeys, r.PasskeyMiddlewares.CheckAuthEnabled, r.Middlewares.CheckAndRefreshToken)
+	group.DELETE("/passkeys/:id", r.PasskeyHandlers.DeletePasskey, r.PasskeyMiddlewares.CheckAuthEnabled, r.Middlewares.CheckAndRefreshToken)
+	group.GET("/mfa", r.MFAHandlers.GetMFAStatus, r.Middlewares.CheckAndRefreshToken)
+	group.POST("/mfa/totp", r.MFAHandlers.EnrollTOTP, r.Middlewares.CheckAndRefreshToken)
+	group.POST("/mfa/totp/confirm", r.MFAHandlers.ConfirmTOTP, r.Middlewares.CheckAndRefreshToken)
+	group.POST("/mfa/totp/disable", r.MFAHandlers.DisableTOTP, r.Middlewares.CheckAndRefreshToken)
+	group.POST("/mfa/recovery-codes", r.MFAHandlers.GenerateRecoveryCodes, r.Middlewares.CheckAndRefreshToken)
+	group.POST("/mfa/verify", r.MFAHandlers.VerifyMFA)
+	group.POST("/recovery", r.MFAHandlers.LogInWithRecoveryCode)
+	group.GET("/.well-known/openid-configuration", r.OAuthServerHandlers.GetOpenIDConfiguration, r.OAuthServerMiddlewares.CheckAuthEnabled)
+	group.GET("/authorize", r.OAuthServerHandlers.Authorize, r.OAuthServerMiddlewares.CheckAuthEnabled, r.Middlewares.RefreshTokenIfLoggedIn)
+	group.POST("/token", r.OAuthServerHandlers.IssueToken, r.OAuthServerMiddlewares.CheckAuthEnabled)
+	group.GET("/userinfo", r.OAuthServerHandlers.GetUserInfo, r.OAuthServerMiddlewares.CheckAuthEnabled)
+	group.POST("/userinfo", r.OAuthServerHandlers.GetUserInfo, r.OAuthServerMiddlewares.CheckAuthEnabled)
+	group.POST("/device/code", r.OAuthServerHandlers.StartDeviceAuthorization, r.OAuthServerMiddlewares.CheckAuthEnabled)
+	group.GET("/device", r.OAuthServerHandlers.ServeDevicePage, r.OAuthServerMiddlewares.CheckAuthEnabled, r.Middlewares.RefreshTokenIfLoggedIn)
+	group.POST("/device", r.OAuthServerHandlers.DecideDeviceAuthorization, r.OAuthServerMiddlewares.CheckAuthEnabled, r.Middlewares.RefreshTokenIfLoggedIn)
+
+	admin := group.Group("/admin", r.Middlewares.CheckPlatformAdmin)
+	admin.GET("/users", r.AdminHandlers.ListUsers)
+	admin.GET("/users/:id", r.AdminHandlers.GetUser)
+	admin.POST("/users/:id/block", r.AdminHandlers.BlockUser)
+	admin.POST("/users/:id/unblock", r.AdminHandlers.UnblockUser)
+	admin.POST("/users/:id/delete", r.AdminHandlers.DeleteUser)
+	admin.POST("/users/:id/restore", r.AdminHandlers.RestoreUser)
+	admin.PUT("/users/:id/early-adopter", r.AdminHandlers.SetEarlyAdopter)
+	admin.PUT("/users/:id/roles/:role", r.AdminHandlers.GrantRole)
+	admin.DELETE("/users/:id/roles/:role", r.AdminHandlers.RevokeRole)
+	admin.GET("/service-clients", r.AdminHandlers.ListServiceClients)
+	admin.POST("/service-clients", r.AdminHandlers.CreateServiceClient)
+	admin.DELETE("/service-clients/:client_id", r.AdminHandlers.DeleteServiceClient)
 	for _, provider := range r.Providers {
 		group.GET(fmt.Sprintf("/%s", provider.Name), provider.Handlers.GetAuthURL, provider.Middlewares.CheckAuthEnabled)
 		group.GET(fmt.Sprintf("/%s/callback", provider.Name), provider.Handlers.ExchangeCode, provider.Middlewares.CheckAuthEnabled)
+		group.GET(fmt.Sprintf("/%s/link", provider.Name), provider.Handlers.GetLinkURL, provider.Middlewares.CheckAuthEnabled, r.Middlewares.CheckAndRefreshToken)
 	}
 	s.Server = httptest.NewServer(e)
 }
 
 func (s *TestSuite) createTestRegistry() (registry.Registry, error) {
 	userRepository := repositories.NewUserRepository(s.Db)
-	refreshTokenRepository := repositories.NewRefreshTokenRepository(s.Db)
+	refreshTokenRepository := repositories.NewRefreshTokenRepository(s.Db, s.Config.PepperFor(entities.PepperRefreshToken))
 	stateRepository := repositories.NewStateRepository(s.Db)
+	userIdentityRepository := repositories.NewUserIdentityRepository(s.Db)
+	organizationRepository := repositories.NewOrganizationRepository(s.Db)
+	invitationRepository := repositories.NewOrganizationInvitationRepository(s.Db, s.Config.PepperFor(entities.PepperInvitation))
+	magicLinkRepository := repositories.NewMagicLinkRepository(s.Db, s.Config.PepperFor(entities.PepperMagicLink))
+	emailCodeRepository := repositories.NewEmailCodeRepository(s.Db, s.Config.PepperFor(entities.PepperEmailCode))
+	passkeyRepository := repositories.NewPasskeyRepository(s.Db)
+	mfaRepository := repositories.NewMFARepository(s.Db, s.Config.PepperFor(entities.PepperPendingMFA), s.Config.MFA.EncryptionKey)
+	recoveryCodeRepository := repositories.NewRecoveryCodeRepository(s.Db, s.Config.PepperFor(entities.PepperRecoveryCode))
+	securityEventRepository := repositories.NewSecurityEventRepository(s.Db)
+	authorizationCodeRepository := repositories.NewAuthorizationCodeRepository(s.Db, s.Config.PepperFor(entities.PepperAuthorizationCode))
+	serviceClientRepository := repositories.NewServiceClientRepository(s.Db, s.Config.PepperFor(entities.PepperServiceClient))
+	deviceAuthorizationRepository := repositories.NewDeviceAuthorizationRepository(s.Db, s.Config.PepperFor(entities.PepperDeviceAuthorization))
 
-	authService := usecases.NewService(s.Config, refreshTokenRepository, userRepository)
+	keyService, err := services.NewKeyService(s.Config, repositories.NewSigningKeyRepository(s.Db, s.Config.JWT.KeyEncryptionKey))
+	if err != nil {
+		return registry.Registry{}, err
+	}
+
+	mailer, err := registry.NewMailer(s.Config)
+	if err != nil {
+		return registry.Registry{}, err
+	}
+
+	tokenService := services.NewTokenService(refreshTokenRepository, securityEventRepository, organizationRepository, keyService, s.Config)
+	mfaService := services.NewMFAService(mfaRepository, tokenService)
+
+	authService := usecases.NewService(s.Config, refreshTokenRepository, userRepository, keyService, tokenService)
 	authHandlers := handlers.NewHandlers(s.Config, authService)
 	authMiddlewares := middlewares.NewAuthMiddleware(s.Config, authService)
+	adminHandlers := handlers.NewAdminHandlers(s.Config, usecases.NewAdminUseCases(s.Config, userRepository, tokenService, serviceClientRepository))
+	identityHandlers := handlers.NewIdentityHandlers(s.Config, usecases.NewIdentityUseCases(s.Config, userIdentityRepository, tokenService))
+	organizationHandlers := handlers.NewOrganizationHandlers(s.Config, usecases.NewOrganizationUseCases(s.Config, organizationRepository, invitationRepository, userRepository, refreshTokenRepository, tokenService))
+	emailService := usecases.NewEmailUseCases(s.Config, mailer, tokenService, mfaService, userRepository, userIdentityRepository, magicLinkRepository, emailCodeRepository)
+	passkeyService := usecases.NewPasskeyUseCases(s.Config, tokenService, mfaService, userRepository, userIdentityRepository, passkeyRepository, securityEventRepository)
+	mfaUseCases := usecases.NewMFAUseCases(s.Config, tokenService, mfaService, userRepository, userIdentityRepository, mfaRepository, recoveryCodeRepository, securityEventRepository)
+	oauthServerUseCases := usecases.NewOAuthServerUseCases(s.Config, tokenService, keyService, userRepository, userIdentityRepository, authorizationCodeRepository, serviceClientRepository, deviceAuthorizationRepository)
 
 	redirectURLBase, err := urlbuilder.Build(s.Config.App.URL, "/auth/%s/callback", map[string]string{})
 	if err != nil {
@@ -184,9 +282,13 @@ func (s *TestSuite) createTestRegistry() (registry.Registry, error) {
 				s.Config.Auth.Providers.GitHub.ClientID,
 				s.Config.Auth.Providers.GitHub.ClientSecret,
 				fmt.Sprintf(redirectURLBase, "github")),
+			tokenService,
+			mfaService,
 			userRepository,
 			refreshTokenRepository,
-			stateRepository),
+			stateRepository,
+			userIdentityRepository,
+			invitationRepository),
 		registry.NewProvider(
 			s.Config, NewFakeOAuthProvider(
 				"discord",
@@ -194,15 +296,29 @@ func (s *TestSuite) createTestRegistry() (registry.Registry, error) {
 				s.Config.Auth.Providers.Discord.ClientID,
 				s.Config.Auth.Providers.Discord.ClientSecret,
 				fmt.Sprintf(redirectURLBase, "discord")),
+			tokenService,
+			mfaService,
 			userRepository,
 			refreshTokenRepository,
-			stateRepository),
+			stateRepository,
+			userIdentityRepository,
+			invitationRepository),
 	}
 
 	return registry.Registry{
-		Handlers:    authHandlers,
-		Middlewares: authMiddlewares,
-		Providers:   providers,
+		Handlers:               authHandlers,
+		AdminHandlers:          adminHandlers,
+		IdentityHandlers:       identityHandlers,
+		OrganizationHandlers:   organizationHandlers,
+		EmailHandlers:          handlers.NewEmailHandlers(s.Config, emailService),
+		PasskeyHandlers:        handlers.NewPasskeyHandlers(s.Config, passkeyService),
+		MFAHandlers:            handlers.NewMFAHandlers(s.Config, mfaUseCases),
+		OAuthServerHandlers:    handlers.NewOAuthServerHandlers(s.Config, oauthServerUseCases),
+		Middlewares:            authMiddlewares,
+		EmailMiddlewares:       middlewares.NewOAuthMiddlewares(s.Config, emailService),
+		PasskeyMiddlewares:     middlewares.NewOAuthMiddlewares(s.Config, passkeyService),
+		OAuthServerMiddlewares: middlewares.NewOAuthMiddlewares(s.Config, oauthServerUseCases),
+		Providers:              providers,
 	}, nil
 }
 
@@ -237,7 +353,21 @@ func (s *TestSuite) CreateUser(t *testing.T, user entities.User, roles []string)
 }
 
 func (s *TestSuite) CreateRefreshToken(t *testing.T, refreshToken entities.RefreshToken) entities.RefreshToken {
-	err := s.Db.Model(&entities.RefreshToken{}).Create(&refreshToken).Error
+	err := repositories.NewRefreshTokenRepository(s.Db, s.Config.PepperFor(entities.PepperRefreshToken)).CreateRefreshToken(refreshToken)
 	require.NoError(t, err)
 	return refreshToken
 }
+
+// TestDevice is the device of the requests that carry DeviceCookie
+var TestDevice = entities.Device{ID: "device_test"}
+
+// DeviceCookie identifies the request as coming from TestDevice
+func (s *TestSuite) DeviceCookie() *http.Cookie {
+	cookie := cookies.NewDeviceCookie(cookies.SignDeviceID(TestDevice.ID, s.Config.DeviceCookieSecret()), s.Config)
+	return &cookie
+}
+
+// HashRefreshToken returns the value under which a refresh token is stored, to query the database
+func (s *TestSuite) HashRefreshToken(token string) string {
+	return repositories.NewRefreshTokenRepository(s.Db, s.Config.PepperFor(entities.PepperRefreshToken)).Hash(token)
+}
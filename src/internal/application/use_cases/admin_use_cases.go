@@ -0,0 +1,151 @@
+package usecases
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/ports/primary"
+	"aegis/internal/domain/ports/secondary"
+	"aegis/internal/domain/services"
+	"aegis/pkg/apperrors"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	defaultUsersPerPage = 20
+	maxUsersPerPage     = 100
+)
+
+// AdminUseCases manage the users and the service clients, for the platform admins
+type AdminUseCases struct {
+	Config                  entities.Config
+	UserRepository          secondary.UserRepository
+	ServiceClientRepository secondary.ServiceClientRepository
+	TokenService            *services.TokenService
+}
+
+var _ primary.AdminUseCasesInterface = (*AdminUseCases)(nil)
+
+func NewAdminUseCases(c entities.Config, u secondary.UserRepository, t *services.TokenService, sc secondary.ServiceClientRepository) *AdminUseCases {
+	return &AdminUseCases{
+		Config:                  c,
+		UserRepository:          u,
+		ServiceClientRepository: sc,
+		TokenService:            t,
+	}
+}
+
+func (s AdminUseCases) ListUsers(search string, page, perPage int) (entities.UsersPage, error) {
+	if page < 1 {
+		page = 1
+	}
+	if perPage < 1 {
+		perPage = defaultUsersPerPage
+	}
+	perPage = min(perPage, maxUsersPerPage)
+	users, total, err := s.UserRepository.ListUsers(search, (page-1)*perPage, perPage)
+	if err != nil {
+		return entities.UsersPage{}, err
+	}
+	usersDetails := make([]entities.UserDetails, 0, len(users))
+	for _, user := range users {
+		usersDetails = append(usersDetails, entities.NewUserDetails(user))
+	}
+	return entities.UsersPage{
+		Users:   usersDetails,
+		Total:   total,
+		Page:    page,
+		PerPage: perPage,
+	}, nil
+}
+
+func (s AdminUseCases) GetUser(userID string) (entities.UserDetails, error) {
+	user, err := s.UserRepository.GetUserByID(userID)
+	if err != nil {
+		return entities.UserDetails{}, err
+	}
+	return entities.NewUserDetails(user), nil
+}
+
+// BlockUser prevents the user from logging in, and logs them out of all their devices
+func (s AdminUseCases) BlockUser(userID string) error {
+	now := time.Now()
+	if err := s.UserRepository.SetUserBlockedAt(userID, &now); err != nil {
+		return err
+	}
+	return s.TokenService.RevokeUserSessions(userID)
+}
+
+func (s AdminUseCases) UnblockUser(userID string) error {
+	return s.UserRepository.SetUserBlockedAt(userID, nil)
+}
+
+// DeleteUser soft deletes the user (they can be restored), and logs them out of all their devices
+func (s AdminUseCases) DeleteUser(userID string) error {
+	now := time.Now()
+	if err := s.UserRepository.SetUserDeletedAt(userID, &now); err != nil {
+		return err
+	}
+	return s.TokenService.RevokeUserSessions(userID)
+}
+
+func (s AdminUseCases) RestoreUser(userID string) error {
+	return s.UserRepository.SetUserDeletedAt(userID, nil)
+}
+
+func (s AdminUseCases) SetEarlyAdopter(userID string, earlyAdopter bool) error {
+	return s.UserRepository.SetUserEarlyAdopter(userID, earlyAdopter)
+}
+
+// GrantRole gives one of the roles of the config to the user. It is in their access token from their next refresh.
+func (s AdminUseCases) GrantRole(userID, role string) error {
+	if !slices.Contains(s.Config.User.Roles, role) {
+		return apperrors.ErrUnknownRole
+	}
+	return s.UserRepository.AddRole(entities.NewRole(userID, role))
+}
+
+func (s AdminUseCases) RevokeRole(userID, role string) error {
+	if !slices.Contains(s.Config.User.Roles, role) {
+		return apperrors.ErrUnknownRole
+	}
+	user, err := s.UserRepository.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	// Access tokens cannot be issued to a user without roles
+	if len(user.Roles) == 1 && user.Roles[0].Value == role {
+		return apperrors.ErrNoRoles
+	}
+	return s.UserRepository.RemoveRole(userID, role)
+}
+
+func (s AdminUseCases) ListServiceClients() ([]entities.ServiceClient, error) {
+	return s.ServiceClientRepository.ListServiceClients()
+}
+
+// CreateServiceClient returns the new client and its secret, which can not be read again
+func (s AdminUseCases) CreateServiceClient(name string, scopes []string) (entities.ServiceClient, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return entities.ServiceClient{}, "", apperrors.ErrServiceClientNameRequired
+	}
+	for _, scope := range scopes {
+		if !entities.IsValidScope(scope) {
+			return entities.ServiceClient{}, "", apperrors.ErrOAuthInvalidScope
+		}
+	}
+	client, secret, err := entities.NewServiceClient(name, scopes)
+	if err != nil {
+		return entities.ServiceClient{}, "", err
+	}
+	if err := s.ServiceClientRepository.CreateServiceClient(client); err != nil {
+		return entities.ServiceClient{}, "", err
+	}
+	return client, secret, nil
+}
+
+// DeleteServiceClient stops the client from getting new tokens, the ones it has work until they expire
+func (s AdminUseCases) DeleteServiceClient(clientID string) error {
+	return s.ServiceClientRepository.DeleteServiceClient(clientID)
+}
@@ -0,0 +1,192 @@
+package usecases
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/services"
+	"aegis/internal/infrastructure/repositories"
+	"aegis/pkg/apperrors"
+	"errors"
+	"fmt"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestAdminUseCases(t *testing.T) {
+	baseConfig := entities.Config{
+		JWT: entities.JWTConfig{
+			Secret:                     "some-secret",
+			AccessTokenExpirationMin:   1,
+			RefreshTokenExpirationDays: 1,
+		},
+	}
+	baseConfig.User.Roles = []string{"user", "platform_admin", "support"}
+	prepare := func(t *testing.T) (*AdminUseCases, *services.TokenService, *gorm.DB, entities.User) {
+		db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.AutoMigrate(&entities.User{}, &entities.RefreshToken{}, &entities.Role{}, &entities.SecurityEvent{}, &entities.ServiceClient{})
+		refreshTokenRepository := repositories.NewRefreshTokenRepository(db, "some-pepper")
+		userRepository := repositories.NewUserRepository(db)
+		keyService, err := services.NewKeyService(baseConfig, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokenService := services.NewTokenService(refreshTokenRepository, repositories.NewSecurityEventRepository(db), repositories.NewOrganizationRepository(db), keyService, baseConfig)
+		newUser, err := entities.NewUser("some-name", "some-avatar", "some-email@example.com", "github")
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if err := userRepository.CreateUser(newUser, []entities.Role{entities.NewRole(newUser.ID, "user")}); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		newUser.Roles = []entities.Role{entities.NewRole(newUser.ID, "user")}
+		return NewAdminUseCases(baseConfig, userRepository, tokenService, repositories.NewServiceClientRepository(db, "some-pepper")), tokenService, db, newUser
+	}
+	t.Run("lists, searches and paginates the users", func(t *testing.T) {
+		adminUseCases, _, _, _ := prepare(t)
+		for i := 0; i < 4; i++ {
+			user, _ := entities.NewUser(fmt.Sprintf("other-%d", i), "", fmt.Sprintf("other-%d@example.com", i), "github")
+			adminUseCases.UserRepository.CreateUser(user, []entities.Role{entities.NewRole(user.ID, "user")})
+		}
+		page, err := adminUseCases.ListUsers("", 2, 2)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if page.Total != 5 || len(page.Users) != 2 || page.Page != 2 {
+			t.Fatal("expected the second page of 5 users", page)
+		}
+		page, err = adminUseCases.ListUsers("SOME-EMAIL", 0, 0)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if page.Total != 1 || page.Users[0].Name != "some-name" || page.PerPage != 20 {
+			t.Fatal("expected the matching user", page)
+		}
+		if len(page.Users[0].Roles) != 1 || page.Users[0].Roles[0] != "user" {
+			t.Fatal("expected the roles of the user", page.Users[0].Roles)
+		}
+	})
+	t.Run("blocking a user revokes their sessions", func(t *testing.T) {
+		adminUseCases, tokenService, db, user := prepare(t)
+		if _, _, _, _, err := tokenService.GenerateTokensForUser(user, testDevice, nil); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if err := adminUseCases.BlockUser(user.ID); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		details, _ := adminUseCases.GetUser(user.ID)
+		if details.BlockedAt == nil {
+			t.Fatal("expected the user to be blocked")
+		}
+		var count int64
+		db.Model(&entities.RefreshToken{}).Where("user_id = ?", user.ID).Count(&count)
+		if count != 0 {
+			t.Fatal("expected the refresh tokens to be revoked", count)
+		}
+		if err := adminUseCases.UnblockUser(user.ID); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		details, _ = adminUseCases.GetUser(user.ID)
+		if details.BlockedAt != nil {
+			t.Fatal("expected the user to be unblocked")
+		}
+	})
+	t.Run("deleting a user revokes their sessions and can be undone", func(t *testing.T) {
+		adminUseCases, tokenService, db, user := prepare(t)
+		if _, _, _, _, err := tokenService.GenerateTokensForUser(user, testDevice, nil); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if err := adminUseCases.DeleteUser(user.ID); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		details, _ := adminUseCases.GetUser(user.ID)
+		if details.DeletedAt == nil {
+			t.Fatal("expected the user to be deleted")
+		}
+		var count int64
+		db.Model(&entities.RefreshToken{}).Where("user_id = ?", user.ID).Count(&count)
+		if count != 0 {
+			t.Fatal("expected the refresh tokens to be revoked", count)
+		}
+		if err := adminUseCases.RestoreUser(user.ID); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		details, _ = adminUseCases.GetUser(user.ID)
+		if details.DeletedAt != nil {
+			t.Fatal("expected the user to be restored")
+		}
+	})
+	t.Run("toggles early adopter", func(t *testing.T) {
+		adminUseCases, _, _, user := prepare(t)
+		if err := adminUseCases.SetEarlyAdopter(user.ID, true); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		details, _ := adminUseCases.GetUser(user.ID)
+		if !details.EarlyAdopter {
+			t.Fatal("expected the user to be an early adopter")
+		}
+	})
+	t.Run("grants and revokes the roles of the config", func(t *testing.T) {
+		adminUseCases, _, _, user := prepare(t)
+		if err := adminUseCases.GrantRole(user.ID, "support"); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if err := adminUseCases.GrantRole(user.ID, "support"); err != nil {
+			t.Fatal("expected granting a role twice to do nothing", err)
+		}
+		details, _ := adminUseCases.GetUser(user.ID)
+		if len(details.Roles) != 2 {
+			t.Fatal("expected 2 roles", details.Roles)
+		}
+		if err := adminUseCases.GrantRole(user.ID, "superuser"); !errors.Is(err, apperrors.ErrUnknownRole) {
+			t.Fatal("expected error ErrUnknownRole", err)
+		}
+		if err := adminUseCases.RevokeRole(user.ID, "support"); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if err := adminUseCases.RevokeRole(user.ID, "user"); !errors.Is(err, apperrors.ErrNoRoles) {
+			t.Fatal("expected error ErrNoRoles", err)
+		}
+	})
+	t.Run("unknown user", func(t *testing.T) {
+		adminUseCases, _, _, _ := prepare(t)
+		if err := adminUseCases.BlockUser("00000000-0000-0000-0000-000000000000"); !errors.Is(err, apperrors.ErrNoUser) {
+			t.Fatal("expected error ErrNoUser", err)
+		}
+		if err := adminUseCases.GrantRole("00000000-0000-0000-0000-000000000000", "user"); !errors.Is(err, apperrors.ErrNoUser) {
+			t.Fatal("expected error ErrNoUser", err)
+		}
+	})
+	t.Run("creates, lists and deletes service clients", func(t *testing.T) {
+		adminUseCases, _, _, _ := prepare(t)
+		client, secret, err := adminUseCases.CreateServiceClient(" worker ", []string{"reports:read"})
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if client.Name != "worker" || secret == "" {
+			t.Fatal("expected the client and its secret", client)
+		}
+		if _, err := adminUseCases.ServiceClientRepository.AuthenticateServiceClient(client.ClientID, secret); err != nil {
+			t.Fatal("expected the secret to authenticate the client", err)
+		}
+		clients, err := adminUseCases.ListServiceClients()
+		if err != nil || len(clients) != 1 || clients[0].Secret == secret {
+			t.Fatal("expected the client, with its hashed secret", clients, err)
+		}
+		if _, _, err := adminUseCases.CreateServiceClient("", nil); !errors.Is(err, apperrors.ErrServiceClientNameRequired) {
+			t.Fatal("expected error ErrServiceClientNameRequired", err)
+		}
+		if _, _, err := adminUseCases.CreateServiceClient("worker", []string{"reports read"}); !errors.Is(err, apperrors.ErrOAuthInvalidScope) {
+			t.Fatal("expected error ErrOAuthInvalidScope", err)
+		}
+		if err := adminUseCases.DeleteServiceClient(client.ClientID); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if err := adminUseCases.DeleteServiceClient(client.ClientID); !errors.Is(err, apperrors.ErrServiceClientNotFound) {
+			t.Fatal("expected error ErrServiceClientNotFound", err)
+		}
+	})
+}
@@ -0,0 +1,176 @@
+package usecases
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/ports/primary"
+	"aegis/internal/domain/ports/secondary"
+	"aegis/internal/domain/services"
+	"aegis/pkg/apperrors"
+	"aegis/pkg/plugins/mailers"
+	"aegis/pkg/plugins/providers"
+	"aegis/pkg/urlbuilder"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// EmailAuthMethod is the auth method, and the provider of the identities, of the users who log in by email
+const EmailAuthMethod = "email"
+
+// EmailUseCases log users in with a link or a code sent to their email
+type EmailUseCases struct {
+	Config              entities.Config
+	Mailer              mailers.MailerInterface
+	MagicLinkRepository secondary.MagicLinkRepository
+	EmailCodeRepository secondary.EmailCodeRepository
+	UserService         *services.UserService
+	TokenService        *services.TokenService
+	MFAService          *services.MFAService
+}
+
+var _ primary.EmailUseCasesInterface = (*EmailUseCases)(nil)
+
+func NewEmailUseCases(
+	c entities.Config,
+	mailer mailers.MailerInterface,
+	tokenService *services.TokenService,
+	mfaService *services.MFAService,
+	userRepository secondary.UserRepository,
+	userIdentityRepository secondary.UserIdentityRepository,
+	magicLinkRepository secondary.MagicLinkRepository,
+	emailCodeRepository secondary.EmailCodeRepository,
+) *EmailUseCases {
+	return &EmailUseCases{
+		Config:              c,
+		Mailer:              mailer,
+		MagicLinkRepository: magicLinkRepository,
+		EmailCodeRepository: emailCodeRepository,
+		UserService:         services.NewUserService(userRepository, userIdentityRepository, c),
+		TokenService:        tokenService,
+		MFAService:          mfaService,
+	}
+}
+
+func (s EmailUseCases) CheckAuthEnabled() bool {
+	return s.Config.Auth.Providers.Email.Enabled
+}
+
+func (s EmailUseCases) SendMagicLink(email string, device entities.Device) error {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return err
+	}
+	links, err := s.MagicLinkRepository.CountMagicLinksSince(email, time.Now().Add(-entities.MagicLinkWindow))
+	if err != nil {
+		return err
+	}
+	if links >= entities.MaxMagicLinksPerEmail {
+		return apperrors.ErrTooManyMagicLinks
+	}
+	magicLink, err := entities.NewMagicLink(email, device.ID, s.Config.MagicLinkLifetime())
+	if err != nil {
+		return err
+	}
+	if err := s.MagicLinkRepository.CreateMagicLink(magicLink); err != nil {
+		return err
+	}
+	link, err := urlbuilder.Build(s.Config.App.URL, "/auth/email/verify", map[string]string{"token": magicLink.Token})
+	if err != nil {
+		return err
+	}
+	return s.Mailer.Send(mailers.Message{
+		To:      email,
+		Subject: fmt.Sprintf("Log in to %s", s.Config.App.Name),
+		Body: fmt.Sprintf("Open this link to log in to %s:\n\n%s\n\nIt expires in %d minutes and only works once, in the browser where you asked for it.\nIf you did not ask for it, you can ignore this email.\n",
+			s.Config.App.Name, link, int(s.Config.MagicLinkLifetime().Minutes())),
+	})
+}
+
+// VerifyMagicLink logs in the owner of the email of the link
+func (s EmailUseCases) VerifyMagicLink(token string, device entities.Device) (*entities.TokenPair, error) {
+	magicLink, err := s.MagicLinkRepository.GetAndDeleteMagicLink(token)
+	if err != nil {
+		return nil, err
+	}
+	// A link opened on another device: someone may be trying to log the user into their account
+	if magicLink.IsExpired() || (magicLink.DeviceID != "" && magicLink.DeviceID != device.ID) {
+		return nil, apperrors.ErrMagicLinkInvalid
+	}
+	return s.logIn(magicLink.Email, device)
+}
+
+// SendLoginCode emails a code of six digits, which replaces the previous code of the email
+func (s EmailUseCases) SendLoginCode(email string) error {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return err
+	}
+	codes, attempts, err := s.EmailCodeRepository.CountEmailCodesSince(email, time.Now().Add(-entities.EmailCodeWindow))
+	if err != nil {
+		return err
+	}
+	if codes >= entities.MaxEmailCodesPerEmail {
+		return apperrors.ErrTooManyEmailCodes
+	}
+	if attempts >= entities.MaxEmailCodeAttemptsPerEmail {
+		return apperrors.ErrTooManyEmailCodeAttempts
+	}
+	emailCode, err := entities.NewEmailCode(email, s.Config.EmailCodeLifetime())
+	if err != nil {
+		return err
+	}
+	if err := s.EmailCodeRepository.CreateEmailCode(emailCode); err != nil {
+		return err
+	}
+	return s.Mailer.Send(mailers.Message{
+		To:      email,
+		Subject: fmt.Sprintf("%s is your %s login code", emailCode.Code, s.Config.App.Name),
+		Body: fmt.Sprintf("Enter this code to log in to %s:\n\n%s\n\nIt expires in %d minutes.\nIf you did not ask for it, you can ignore this email.\n",
+			s.Config.App.Name, emailCode.Code, int(s.Config.EmailCodeLifetime().Minutes())),
+	})
+}
+
+// VerifyLoginCode logs in the owner of the email with the latest code sent to them
+func (s EmailUseCases) VerifyLoginCode(email, code string, device entities.Device) (*entities.TokenPair, error) {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	_, attempts, err := s.EmailCodeRepository.CountEmailCodesSince(email, time.Now().Add(-entities.EmailCodeWindow))
+	if err != nil {
+		return nil, err
+	}
+	if attempts >= entities.MaxEmailCodeAttemptsPerEmail {
+		return nil, apperrors.ErrTooManyEmailCodeAttempts
+	}
+	if err := s.EmailCodeRepository.ConsumeEmailCode(email, strings.TrimSpace(code)); err != nil {
+		return nil, err
+	}
+	return s.logIn(email, device)
+}
+
+// logIn logs in the owner of the email, like a login with a provider that verified the email
+func (s EmailUseCases) logIn(email string, device entities.Device) (*entities.TokenPair, error) {
+	userInfos := &providers.UserInfos{
+		SubjectID:     email,
+		Name:          strings.Split(email, "@")[0],
+		Email:         email,
+		EmailVerified: true,
+	}
+	user, err := s.UserService.GetOrCreateUserIfAllowed(userInfos, EmailAuthMethod, false)
+	if err != nil {
+		return nil, err
+	}
+	return s.MFAService.LogIn(user, device, []string{entities.AMREmail})
+}
+
+// normalizeEmail accepts a bare address only, lowercased so that an email has a single identity
+func normalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || len(email) > 100 {
+		return "", apperrors.ErrInvalidEmail
+	}
+	return email, nil
+}
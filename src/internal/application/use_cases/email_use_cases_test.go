@@ -0,0 +1,195 @@
+package usecases
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/services"
+	"aegis/internal/infrastructure/repositories"
+	"aegis/pkg/apperrors"
+	"aegis/pkg/plugins/mailers"
+	"errors"
+	"fmt"
+	"regexp"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type fakeMailer struct {
+	messages []mailers.Message
+}
+
+func (m *fakeMailer) Send(message mailers.Message) error {
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+var magicLinkTokenRegexp = regexp.MustCompile(`token=(magic_[0-9a-f]+)`)
+
+func TestEmailUseCases(t *testing.T) {
+	baseConfig := entities.Config{
+		JWT: entities.JWTConfig{
+			Secret:                     "some-secret",
+			AccessTokenExpirationMin:   1,
+			RefreshTokenExpirationDays: 1,
+		},
+	}
+	baseConfig.App.Name = "Aegis"
+	baseConfig.App.URL = "https://aegis.example.com"
+	baseConfig.Auth.Providers.Email.Enabled = true
+	prepare := func(t *testing.T) (*EmailUseCases, *fakeMailer, *repositories.UserRepository) {
+		db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.AutoMigrate(&entities.User{}, &entities.RefreshToken{}, &entities.Role{}, &entities.SecurityEvent{}, &entities.UserIdentity{}, &entities.MagicLink{}, &entities.EmailCode{}, &entities.TOTPFactor{})
+		refreshTokenRepository := repositories.NewRefreshTokenRepository(db, "some-pepper")
+		keyService, err := services.NewKeyService(baseConfig, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokenService := services.NewTokenService(refreshTokenRepository, repositories.NewSecurityEventRepository(db), repositories.NewOrganizationRepository(db), keyService, baseConfig)
+		mailer := &fakeMailer{}
+		userRepository := repositories.NewUserRepository(db)
+		mfaService := services.NewMFAService(repositories.NewMFARepository(db, "some-pepper", "some-key"), tokenService)
+		return NewEmailUseCases(baseConfig, mailer, tokenService, mfaService, userRepository, repositories.NewUserIdentityRepository(db), repositories.NewMagicLinkRepository(db, "some-pepper"), repositories.NewEmailCodeRepository(db, "some-pepper")), mailer, userRepository
+	}
+	// sentToken returns the token of the last link sent
+	sentToken := func(t *testing.T, mailer *fakeMailer) string {
+		if len(mailer.messages) == 0 {
+			t.Fatal("expected an email to be sent")
+		}
+		match := magicLinkTokenRegexp.FindStringSubmatch(mailer.messages[len(mailer.messages)-1].Body)
+		if match == nil {
+			t.Fatal("expected a link in the email", mailer.messages)
+		}
+		return match[1]
+	}
+	t.Run("the link logs the owner of the email in once", func(t *testing.T) {
+		emailUseCases, mailer, userRepository := prepare(t)
+		if err := emailUseCases.SendMagicLink(" Someone@Example.com ", testDevice); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if mailer.messages[0].To != "someone@example.com" {
+			t.Fatal("expected the email to be sent to the normalized address", mailer.messages[0].To)
+		}
+		token := sentToken(t, mailer)
+		tokensPair, err := emailUseCases.VerifyMagicLink(token, testDevice)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		cc, err := emailUseCases.TokenService.ReadAccessTokenClaims(tokensPair.AccessToken)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		user, err := userRepository.GetUserByEmail("someone@example.com")
+		if err != nil || user.ID != cc.UserID || user.AuthMethod != EmailAuthMethod {
+			t.Fatal("expected the user to be created with the email auth method", user, err)
+		}
+		if _, err := emailUseCases.VerifyMagicLink(token, testDevice); !errors.Is(err, apperrors.ErrMagicLinkInvalid) {
+			t.Fatal("expected error ErrMagicLinkInvalid", err)
+		}
+	})
+	t.Run("the link only works on the device that asked for it", func(t *testing.T) {
+		emailUseCases, mailer, _ := prepare(t)
+		emailUseCases.SendMagicLink("someone@example.com", testDevice)
+		if _, err := emailUseCases.VerifyMagicLink(sentToken(t, mailer), entities.Device{ID: "other-device-id"}); !errors.Is(err, apperrors.ErrMagicLinkInvalid) {
+			t.Fatal("expected error ErrMagicLinkInvalid", err)
+		}
+	})
+	t.Run("an email receives a limited number of links", func(t *testing.T) {
+		emailUseCases, mailer, _ := prepare(t)
+		for range entities.MaxMagicLinksPerEmail {
+			if err := emailUseCases.SendMagicLink("someone@example.com", testDevice); err != nil {
+				t.Fatal("expected no error", err)
+			}
+		}
+		if err := emailUseCases.SendMagicLink("Someone@Example.com", testDevice); !errors.Is(err, apperrors.ErrTooManyMagicLinks) {
+			t.Fatal("expected error ErrTooManyMagicLinks", err)
+		}
+		if len(mailer.messages) != entities.MaxMagicLinksPerEmail {
+			t.Fatal("expected no more email to be sent", len(mailer.messages))
+		}
+		if err := emailUseCases.SendMagicLink("someone-else@example.com", testDevice); err != nil {
+			t.Fatal("expected the other emails to not be limited", err)
+		}
+	})
+	t.Run("rejects invalid emails", func(t *testing.T) {
+		emailUseCases, mailer, _ := prepare(t)
+		for _, email := range []string{"", "someone", "Someone <someone@example.com>"} {
+			if err := emailUseCases.SendMagicLink(email, testDevice); !errors.Is(err, apperrors.ErrInvalidEmail) {
+				t.Fatal("expected error ErrInvalidEmail", email, err)
+			}
+		}
+		if len(mailer.messages) != 0 {
+			t.Fatal("expected no email to be sent", mailer.messages)
+		}
+	})
+	// sentCode returns the code of the last email sent
+	sentCode := func(t *testing.T, mailer *fakeMailer) string {
+		if len(mailer.messages) == 0 {
+			t.Fatal("expected an email to be sent")
+		}
+		return mailer.messages[len(mailer.messages)-1].Subject[:6]
+	}
+	t.Run("the code logs the owner of the email in once", func(t *testing.T) {
+		emailUseCases, mailer, _ := prepare(t)
+		if err := emailUseCases.SendLoginCode("someone@example.com"); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		code := sentCode(t, mailer)
+		wrongCode := "000000"
+		if code == wrongCode {
+			wrongCode = "111111"
+		}
+		if _, err := emailUseCases.VerifyLoginCode("someone@example.com", wrongCode, testDevice); !errors.Is(err, apperrors.ErrEmailCodeInvalid) {
+			t.Fatal("expected error ErrEmailCodeInvalid", err)
+		}
+		if _, err := emailUseCases.VerifyLoginCode("Someone@example.com", code, testDevice); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if _, err := emailUseCases.VerifyLoginCode("someone@example.com", code, testDevice); !errors.Is(err, apperrors.ErrEmailCodeInvalid) {
+			t.Fatal("expected error ErrEmailCodeInvalid", err)
+		}
+	})
+	t.Run("limits the attempts per code and per email", func(t *testing.T) {
+		emailUseCases, mailer, _ := prepare(t)
+		tryWrongCodes := func(code string) {
+			for i := 0; i < entities.MaxEmailCodeAttempts; i++ {
+				wrongCode := fmt.Sprintf("%06d", i)
+				if wrongCode == code {
+					wrongCode = "999999"
+				}
+				if _, err := emailUseCases.VerifyLoginCode("someone@example.com", wrongCode, testDevice); !errors.Is(err, apperrors.ErrEmailCodeInvalid) {
+					t.Fatal("expected error ErrEmailCodeInvalid", err)
+				}
+			}
+		}
+		emailUseCases.SendLoginCode("someone@example.com")
+		code := sentCode(t, mailer)
+		tryWrongCodes(code)
+		if _, err := emailUseCases.VerifyLoginCode("someone@example.com", code, testDevice); !errors.Is(err, apperrors.ErrEmailCodeInvalid) {
+			t.Fatal("expected the code to be invalid after too many attempts", err)
+		}
+		emailUseCases.SendLoginCode("someone@example.com")
+		code = sentCode(t, mailer)
+		tryWrongCodes(code)
+		if _, err := emailUseCases.VerifyLoginCode("someone@example.com", code, testDevice); !errors.Is(err, apperrors.ErrTooManyEmailCodeAttempts) {
+			t.Fatal("expected error ErrTooManyEmailCodeAttempts", err)
+		}
+		if err := emailUseCases.SendLoginCode("someone@example.com"); !errors.Is(err, apperrors.ErrTooManyEmailCodeAttempts) {
+			t.Fatal("expected error ErrTooManyEmailCodeAttempts", err)
+		}
+	})
+	t.Run("limits the codes per email", func(t *testing.T) {
+		emailUseCases, _, _ := prepare(t)
+		for i := 0; i < entities.MaxEmailCodesPerEmail; i++ {
+			if err := emailUseCases.SendLoginCode("someone@example.com"); err != nil {
+				t.Fatal("expected no error", err)
+			}
+		}
+		if err := emailUseCases.SendLoginCode("someone@example.com"); !errors.Is(err, apperrors.ErrTooManyEmailCodes) {
+			t.Fatal("expected error ErrTooManyEmailCodes", err)
+		}
+	})
+}
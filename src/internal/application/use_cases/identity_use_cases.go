@@ -0,0 +1,51 @@
+package usecases
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/ports/primary"
+	"aegis/internal/domain/ports/secondary"
+	"aegis/internal/domain/services"
+	"aegis/pkg/apperrors"
+)
+
+// IdentityUseCases manage the accounts at providers the logged in user can log in with.
+// Accounts are linked through the provider routes, see OAuthUseCases.GetLinkURL.
+type IdentityUseCases struct {
+	Config                 entities.Config
+	UserIdentityRepository secondary.UserIdentityRepository
+	TokenService           *services.TokenService
+}
+
+var _ primary.IdentityUseCasesInterface = (*IdentityUseCases)(nil)
+
+func NewIdentityUseCases(c entities.Config, i secondary.UserIdentityRepository, t *services.TokenService) *IdentityUseCases {
+	return &IdentityUseCases{
+		Config:                 c,
+		UserIdentityRepository: i,
+		TokenService:           t,
+	}
+}
+
+func (s IdentityUseCases) ListIdentities(accessToken string) ([]entities.UserIdentity, error) {
+	cc, err := s.TokenService.ReadAccessTokenClaims(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	return s.UserIdentityRepository.GetUserIdentitiesForUser(cc.UserID)
+}
+
+// UnlinkIdentity removes an account from the identities of the user, who must keep at least one to log in
+func (s IdentityUseCases) UnlinkIdentity(accessToken, provider string) error {
+	cc, err := s.TokenService.ReadAccessTokenClaims(accessToken)
+	if err != nil {
+		return err
+	}
+	identities, err := s.UserIdentityRepository.GetUserIdentitiesForUser(cc.UserID)
+	if err != nil {
+		return err
+	}
+	if len(identities) == 1 && identities[0].Provider == provider {
+		return apperrors.ErrLastIdentity
+	}
+	return s.UserIdentityRepository.DeleteUserIdentity(cc.UserID, provider)
+}
@@ -0,0 +1,258 @@
+package usecases
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/ports/primary"
+	"aegis/internal/domain/ports/secondary"
+	"aegis/internal/domain/services"
+	"aegis/pkg/apperrors"
+	"aegis/pkg/totp"
+	"errors"
+	"strings"
+	"time"
+)
+
+// MFAUseCases enroll the authenticator apps of the logged in users, complete the logins that wait for them,
+// and recover the accounts with recovery codes
+type MFAUseCases struct {
+	Config                  entities.Config
+	MFARepository           secondary.MFARepository
+	RecoveryCodeRepository  secondary.RecoveryCodeRepository
+	SecurityEventRepository secondary.SecurityEventRepository
+	UserRepository          secondary.UserRepository
+	UserService             *services.UserService
+	TokenService            *services.TokenService
+	MFAService              *services.MFAService
+	RecoveryCodeService     *services.RecoveryCodeService
+}
+
+var _ primary.MFAUseCasesInterface = (*MFAUseCases)(nil)
+
+func NewMFAUseCases(
+	c entities.Config,
+	tokenService *services.TokenService,
+	mfaService *services.MFAService,
+	userRepository secondary.UserRepository,
+	userIdentityRepository secondary.UserIdentityRepository,
+	mfaRepository secondary.MFARepository,
+	recoveryCodeRepository secondary.RecoveryCodeRepository,
+	securityEventRepository secondary.SecurityEventRepository,
+) *MFAUseCases {
+	return &MFAUseCases{
+		Config:                  c,
+		MFARepository:           mfaRepository,
+		RecoveryCodeRepository:  recoveryCodeRepository,
+		SecurityEventRepository: securityEventRepository,
+		UserRepository:          userRepository,
+		UserService:             services.NewUserService(userRepository, userIdentityRepository, c),
+		TokenService:            tokenService,
+		MFAService:              mfaService,
+		RecoveryCodeService:     services.NewRecoveryCodeService(recoveryCodeRepository, securityEventRepository),
+	}
+}
+
+func (s MFAUseCases) GetMFAStatus(accessToken string) (entities.MFAStatus, error) {
+	cc, err := s.TokenService.ReadAccessTokenClaims(accessToken)
+	if err != nil {
+		return entities.MFAStatus{}, err
+	}
+	status := entities.MFAStatus{}
+	factor, err := s.MFARepository.GetTOTPFactor(cc.UserID)
+	if err != nil && !errors.Is(err, apperrors.ErrTOTPNotEnabled) {
+		return entities.MFAStatus{}, err
+	}
+	status.TOTPEnabled = err == nil && factor.IsConfirmed()
+	status.RecoveryCodesLeft, err = s.RecoveryCodeRepository.CountUnusedRecoveryCodes(cc.UserID)
+	if err != nil {
+		return entities.MFAStatus{}, err
+	}
+	return status, nil
+}
+
+func (s MFAUseCases) EnrollTOTP(accessToken string) (entities.TOTPEnrollment, error) {
+	cc, err := s.TokenService.ReadAccessTokenClaims(accessToken)
+	if err != nil {
+		return entities.TOTPEnrollment{}, err
+	}
+	user, err := s.UserRepository.GetUserByID(cc.UserID)
+	if err != nil {
+		return entities.TOTPEnrollment{}, err
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return entities.TOTPEnrollment{}, err
+	}
+	if err := s.MFARepository.CreateTOTPFactor(entities.NewTOTPFactor(user.ID, secret)); err != nil {
+		return entities.TOTPEnrollment{}, err
+	}
+	return entities.TOTPEnrollment{
+		Secret: secret,
+		URI:    totp.URI(s.Config.App.Name, user.Email, secret),
+	}, nil
+}
+
+func (s MFAUseCases) ConfirmTOTP(accessToken, code string, device entities.Device) ([]string, error) {
+	cc, err := s.TokenService.ReadAccessTokenClaims(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	factor, err := s.MFARepository.GetTOTPFactor(cc.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if factor.IsConfirmed() {
+		return nil, apperrors.ErrTOTPAlreadyEnabled
+	}
+	step, ok := totp.Validate(factor.Secret, normalizeCode(code), time.Now())
+	if !ok {
+		return nil, apperrors.ErrMFACodeInvalid
+	}
+	if err := s.MFARepository.ConfirmTOTPFactor(cc.UserID, step); err != nil {
+		return nil, err
+	}
+	return s.RecoveryCodeService.GenerateRecoveryCodes(cc.UserID, device)
+}
+
+// DisableTOTP keeps the recovery codes, they still recover the account
+func (s MFAUseCases) DisableTOTP(accessToken, code string, device entities.Device) error {
+	cc, err := s.TokenService.ReadAccessTokenClaims(accessToken)
+	if err != nil {
+		return err
+	}
+	if err := s.checkCode(cc.UserID, code, device, services.RecoveryCodeForDisableTOTP); err != nil {
+		return err
+	}
+	return s.MFARepository.DeleteTOTPFactor(cc.UserID)
+}
+
+// GenerateRecoveryCodes requires a code from the users with an authenticator app: a recovery code logs in without
+// the app, a stolen session must not be enough to get new ones
+func (s MFAUseCases) GenerateRecoveryCodes(accessToken, code string, device entities.Device) ([]string, error) {
+	cc, err := s.TokenService.ReadAccessTokenClaims(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	factor, err := s.MFARepository.GetTOTPFactor(cc.UserID)
+	if err != nil && !errors.Is(err, apperrors.ErrTOTPNotEnabled) {
+		return nil, err
+	}
+	if err == nil && factor.IsConfirmed() {
+		if err := s.checkCode(cc.UserID, code, device, services.RecoveryCodeForNewCodes); err != nil {
+			return nil, err
+		}
+	}
+	return s.RecoveryCodeService.GenerateRecoveryCodes(cc.UserID, device)
+}
+
+// LogInWithRecoveryCode logs in the owner of the email with one of their recovery codes, without their other methods.
+// The failures are limited per email and IP, and recorded in the security events of the user. An unknown email, or a
+// user who can not log in, gets the error of a wrong code: the answers do not tell who has an account.
+func (s MFAUseCases) LogInWithRecoveryCode(email, code string, device entities.Device) (*entities.TokenPair, error) {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	failures, err := s.RecoveryCodeRepository.CountFailedRecoveryAttemptsSince(email, device.IP, time.Now().Add(-entities.RecoveryAttemptsWindow))
+	if err != nil {
+		return nil, err
+	}
+	if failures >= entities.MaxRecoveryAttempts {
+		return nil, apperrors.ErrTooManyMFAAttempts
+	}
+	user, err := s.UserRepository.GetUserByEmail(email)
+	if err != nil && !errors.Is(err, apperrors.ErrNoUser) {
+		return nil, err
+	}
+	// The user is checked before their code is used, a blocked user keeps their codes
+	if err == nil && s.UserService.CheckUserAllowed(user) == nil {
+		err = s.RecoveryCodeService.UseRecoveryCode(user.ID, code, device, services.RecoveryCodeForLogin)
+		if err == nil {
+			return s.MFAService.IssueTokens(user, device, []string{entities.AMRRecoveryCode})
+		}
+		if !errors.Is(err, apperrors.ErrMFACodeInvalid) {
+			return nil, err
+		}
+		if err := s.recordFailure(user.ID, entities.SecurityEventRecoveryCodeFailed, map[string]string{"device_id": device.ID, "ip": device.IP}); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.RecoveryCodeRepository.RecordFailedRecoveryAttempt(email, device.IP); err != nil {
+		return nil, err
+	}
+	return nil, apperrors.ErrMFACodeInvalid
+}
+
+func (s MFAUseCases) VerifyMFA(pendingToken, code string, device entities.Device) (*entities.TokenPair, error) {
+	pending, err := s.MFARepository.GetPendingMFA(pendingToken)
+	if err != nil {
+		return nil, err
+	}
+	// A login started on another device: someone is trying to log the user into their account
+	if pending.DeviceID != "" && pending.DeviceID != device.ID {
+		return nil, apperrors.ErrMFATokenInvalid
+	}
+	if err := s.MFARepository.CountPendingMFAAttempt(pendingToken); err != nil {
+		return nil, err
+	}
+	if err := s.checkCode(pending.UserID, code, device, services.RecoveryCodeForMFA); err != nil {
+		return nil, err
+	}
+	// The login completes once, a concurrent request with another valid code fails here
+	if err := s.MFARepository.DeletePendingMFA(pendingToken); err != nil {
+		return nil, err
+	}
+	user, err := s.UserRepository.GetUserByID(pending.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.UserService.CheckUserAllowed(user); err != nil {
+		return nil, err
+	}
+	return s.MFAService.IssueTokens(user, device, append(pending.Methods(), entities.AMROTP, entities.AMRMultiFactor))
+}
+
+// checkCode uses a code of the authenticator app of the user, or one of their recovery codes.
+// The wrong codes are recorded, and limited per user whatever the login or the session they are entered in.
+func (s MFAUseCases) checkCode(userID, code string, device entities.Device, purpose string) error {
+	factor, err := s.MFARepository.GetTOTPFactor(userID)
+	if err != nil {
+		return err
+	}
+	if !factor.IsConfirmed() {
+		return apperrors.ErrTOTPNotEnabled
+	}
+	failures, err := s.SecurityEventRepository.CountSecurityEventsForUserSince(userID, entities.SecurityEventMFACodeFailed, time.Now().Add(-entities.MFACodeAttemptsWindow))
+	if err != nil {
+		return err
+	}
+	if failures >= entities.MaxMFACodeAttempts {
+		return apperrors.ErrTooManyMFAAttempts
+	}
+	code = normalizeCode(code)
+	if step, ok := totp.Validate(factor.Secret, code, time.Now()); ok {
+		return s.MFARepository.UseTOTPStep(userID, step)
+	}
+	err = apperrors.ErrMFACodeInvalid
+	if len(code) != totp.Digits {
+		err = s.RecoveryCodeService.UseRecoveryCode(userID, code, device, purpose)
+	}
+	if errors.Is(err, apperrors.ErrMFACodeInvalid) {
+		if eventErr := s.recordFailure(userID, entities.SecurityEventMFACodeFailed, map[string]string{"device_id": device.ID, "purpose": purpose}); eventErr != nil {
+			return eventErr
+		}
+	}
+	return err
+}
+
+func (s MFAUseCases) recordFailure(userID, eventType string, details map[string]string) error {
+	event, err := entities.NewSecurityEvent(userID, eventType, details)
+	if err != nil {
+		return err
+	}
+	return s.SecurityEventRepository.CreateSecurityEvent(event)
+}
+
+// normalizeCode accepts a code typed with spaces (ex: "123 456")
+func normalizeCode(code string) string {
+	return strings.Join(strings.Fields(code), "")
+}
@@ -0,0 +1,339 @@
+package usecases
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/services"
+	"aegis/internal/infrastructure/repositories"
+	"aegis/pkg/apperrors"
+	"aegis/pkg/totp"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestMFAUseCases(t *testing.T) {
+	baseConfig := entities.Config{
+		JWT: entities.JWTConfig{
+			Secret:                     "some-secret",
+			AccessTokenExpirationMin:   1,
+			RefreshTokenExpirationDays: 1,
+		},
+	}
+	baseConfig.App.Name = "Aegis"
+	baseConfig.MFA.RequiredForRoles = []string{entities.RolePlatformAdmin}
+	type fixture struct {
+		mfaUseCases             *MFAUseCases
+		userRepository          *repositories.UserRepository
+		securityEventRepository *repositories.SecurityEventRepository
+		recoveryCodeRepository  *repositories.RecoveryCodeRepository
+		user                    entities.User
+		accessToken             string
+	}
+	prepare := func(t *testing.T) fixture {
+		db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.AutoMigrate(&entities.User{}, &entities.RefreshToken{}, &entities.Role{}, &entities.SecurityEvent{}, &entities.UserIdentity{}, &entities.TOTPFactor{}, &entities.RecoveryCode{}, &entities.RecoveryAttempt{}, &entities.PendingMFA{})
+		keyService, err := services.NewKeyService(baseConfig, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		securityEventRepository := repositories.NewSecurityEventRepository(db)
+		tokenService := services.NewTokenService(repositories.NewRefreshTokenRepository(db, "some-pepper"), securityEventRepository, repositories.NewOrganizationRepository(db), keyService, baseConfig)
+		mfaService := services.NewMFAService(repositories.NewMFARepository(db, "some-pepper", "some-key"), tokenService)
+		userRepository := repositories.NewUserRepository(db)
+		user, err := entities.NewUser("someone", "", "someone@example.com", "github")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := userRepository.CreateUser(user, []entities.Role{entities.NewRole(user.ID, entities.RoleUser), entities.NewRole(user.ID, entities.RolePlatformAdmin)}); err != nil {
+			t.Fatal(err)
+		}
+		user, err = userRepository.GetUserByID(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		accessToken, _, _, _, err := tokenService.GenerateTokensForUser(user, testDevice, []string{entities.AMRFederated})
+		if err != nil {
+			t.Fatal(err)
+		}
+		recoveryCodeRepository := repositories.NewRecoveryCodeRepository(db, "some-pepper")
+		return fixture{
+			mfaUseCases:             NewMFAUseCases(baseConfig, tokenService, mfaService, userRepository, repositories.NewUserIdentityRepository(db), repositories.NewMFARepository(db, "some-pepper", "some-key"), recoveryCodeRepository, securityEventRepository),
+			userRepository:          userRepository,
+			securityEventRepository: securityEventRepository,
+			recoveryCodeRepository:  recoveryCodeRepository,
+			user:                    user,
+			accessToken:             accessToken,
+		}
+	}
+	// enroll enables an authenticator app with a code of the previous period, so that the next code is unused
+	enroll := func(t *testing.T, f fixture) (string, []string) {
+		enrollment, err := f.mfaUseCases.EnrollTOTP(f.accessToken)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		code, err := totp.Code(enrollment.Secret, totp.Step(time.Now())-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recoveryCodes, err := f.mfaUseCases.ConfirmTOTP(f.accessToken, code, testDevice)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		return enrollment.Secret, recoveryCodes
+	}
+	currentCode := func(t *testing.T, secret string) string {
+		code, err := totp.Code(secret, totp.Step(time.Now()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+	logIn := func(t *testing.T, f fixture) string {
+		tokensPair, err := f.mfaUseCases.MFAService.LogIn(f.user, testDevice, []string{entities.AMRFederated})
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if !tokensPair.IsPendingMFA() || tokensPair.AccessToken != "" {
+			t.Fatal("expected the login to wait for the second factor", tokensPair)
+		}
+		return tokensPair.PendingMFAToken
+	}
+	t.Run("enrollment returns a provisioning uri and recovery codes", func(t *testing.T) {
+		f := prepare(t)
+		enrollment, err := f.mfaUseCases.EnrollTOTP(f.accessToken)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if !strings.HasPrefix(enrollment.URI, "otpauth://totp/Aegis:someone@example.com?") || !strings.Contains(enrollment.URI, enrollment.Secret) {
+			t.Fatal("expected the uri of the secret", enrollment.URI)
+		}
+		oldCode, _ := totp.Code(enrollment.Secret, totp.Step(time.Now())-10)
+		if _, err := f.mfaUseCases.ConfirmTOTP(f.accessToken, oldCode, testDevice); !errors.Is(err, apperrors.ErrMFACodeInvalid) {
+			t.Fatal("expected error ErrMFACodeInvalid", err)
+		}
+		recoveryCodes, err := f.mfaUseCases.ConfirmTOTP(f.accessToken, currentCode(t, enrollment.Secret), testDevice)
+		if err != nil || len(recoveryCodes) != entities.RecoveryCodesCount {
+			t.Fatal("expected the recovery codes", recoveryCodes, err)
+		}
+		status, err := f.mfaUseCases.GetMFAStatus(f.accessToken)
+		if err != nil || !status.TOTPEnabled || status.RecoveryCodesLeft != entities.RecoveryCodesCount {
+			t.Fatal("expected the authenticator app to be enabled", status, err)
+		}
+		if _, err := f.mfaUseCases.EnrollTOTP(f.accessToken); !errors.Is(err, apperrors.ErrTOTPAlreadyEnabled) {
+			t.Fatal("expected error ErrTOTPAlreadyEnabled", err)
+		}
+	})
+	t.Run("a user without an authenticator app gets the tokens without the roles that require it", func(t *testing.T) {
+		f := prepare(t)
+		tokensPair, err := f.mfaUseCases.MFAService.LogIn(f.user, testDevice, []string{entities.AMRFederated})
+		if err != nil || tokensPair.IsPendingMFA() {
+			t.Fatal("expected the tokens", tokensPair, err)
+		}
+		cc, err := f.mfaUseCases.TokenService.ReadAccessTokenClaims(tokensPair.AccessToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(cc.AMR, []string{entities.AMRFederated}) || !slices.Equal(cc.Roles, []string{entities.RoleUser}) {
+			t.Fatal("expected the amr of the login and no platform_admin role", cc.AMR, cc.Roles)
+		}
+	})
+	t.Run("a login waits for a code of the authenticator app", func(t *testing.T) {
+		f := prepare(t)
+		secret, _ := enroll(t, f)
+		pendingToken := logIn(t, f)
+		tokensPair, err := f.mfaUseCases.VerifyMFA(pendingToken, currentCode(t, secret), testDevice)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		cc, err := f.mfaUseCases.TokenService.ReadAccessTokenClaims(tokensPair.AccessToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(cc.AMR, []string{entities.AMRFederated, entities.AMROTP, entities.AMRMultiFactor}) || !slices.Contains(cc.Roles, entities.RolePlatformAdmin) {
+			t.Fatal("expected the second factor in the amr and the platform_admin role", cc.AMR, cc.Roles)
+		}
+		if _, err := f.mfaUseCases.VerifyMFA(pendingToken, currentCode(t, secret), testDevice); !errors.Is(err, apperrors.ErrMFATokenInvalid) {
+			t.Fatal("expected a completed login to be used once", err)
+		}
+	})
+	t.Run("a code can not be used twice", func(t *testing.T) {
+		f := prepare(t)
+		secret, _ := enroll(t, f)
+		code := currentCode(t, secret)
+		if _, err := f.mfaUseCases.VerifyMFA(logIn(t, f), code, testDevice); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if _, err := f.mfaUseCases.VerifyMFA(logIn(t, f), code, testDevice); !errors.Is(err, apperrors.ErrMFACodeInvalid) {
+			t.Fatal("expected error ErrMFACodeInvalid", err)
+		}
+	})
+	t.Run("a recovery code can be used once instead of a code", func(t *testing.T) {
+		f := prepare(t)
+		_, recoveryCodes := enroll(t, f)
+		if _, err := f.mfaUseCases.VerifyMFA(logIn(t, f), strings.ToUpper(recoveryCodes[0]), testDevice); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if _, err := f.mfaUseCases.VerifyMFA(logIn(t, f), recoveryCodes[0], testDevice); !errors.Is(err, apperrors.ErrMFACodeInvalid) {
+			t.Fatal("expected error ErrMFACodeInvalid", err)
+		}
+		status, _ := f.mfaUseCases.GetMFAStatus(f.accessToken)
+		if status.RecoveryCodesLeft != entities.RecoveryCodesCount-1 {
+			t.Fatal("expected a recovery code to be used", status)
+		}
+		events, err := f.securityEventRepository.GetSecurityEventsForUser(f.user.ID, entities.SecurityEventRecoveryCodeUsed, 10)
+		if err != nil || len(events) != 1 || !strings.Contains(events[0].Details, `"purpose":"mfa"`) {
+			t.Fatal("expected the use to be recorded", events, err)
+		}
+	})
+	t.Run("a login is limited in attempts and to its device", func(t *testing.T) {
+		f := prepare(t)
+		secret, _ := enroll(t, f)
+		pendingToken := logIn(t, f)
+		if _, err := f.mfaUseCases.VerifyMFA(pendingToken, currentCode(t, secret), entities.Device{ID: "other-device-id"}); !errors.Is(err, apperrors.ErrMFATokenInvalid) {
+			t.Fatal("expected error ErrMFATokenInvalid on another device", err)
+		}
+		for range entities.MaxPendingMFAAttempts {
+			f.mfaUseCases.VerifyMFA(pendingToken, "not-a-code", testDevice)
+		}
+		if _, err := f.mfaUseCases.VerifyMFA(pendingToken, currentCode(t, secret), testDevice); !errors.Is(err, apperrors.ErrTooManyMFAAttempts) {
+			t.Fatal("expected error ErrTooManyMFAAttempts", err)
+		}
+	})
+	t.Run("the wrong codes are limited per user, across logins and sessions", func(t *testing.T) {
+		f := prepare(t)
+		secret, _ := enroll(t, f)
+		for i := range entities.MaxMFACodeAttempts {
+			var err error
+			if i%2 == 0 {
+				err = f.mfaUseCases.DisableTOTP(f.accessToken, "not-a-code", testDevice)
+			} else {
+				_, err = f.mfaUseCases.VerifyMFA(logIn(t, f), "not-a-code", testDevice)
+			}
+			if !errors.Is(err, apperrors.ErrMFACodeInvalid) {
+				t.Fatal("expected error ErrMFACodeInvalid", err)
+			}
+		}
+		if _, err := f.mfaUseCases.VerifyMFA(logIn(t, f), currentCode(t, secret), testDevice); !errors.Is(err, apperrors.ErrTooManyMFAAttempts) {
+			t.Fatal("expected error ErrTooManyMFAAttempts", err)
+		}
+		if _, err := f.mfaUseCases.GenerateRecoveryCodes(f.accessToken, currentCode(t, secret), testDevice); !errors.Is(err, apperrors.ErrTooManyMFAAttempts) {
+			t.Fatal("expected error ErrTooManyMFAAttempts", err)
+		}
+		events, err := f.securityEventRepository.GetSecurityEventsForUser(f.user.ID, entities.SecurityEventMFACodeFailed, 20)
+		if err != nil || len(events) != entities.MaxMFACodeAttempts {
+			t.Fatal("expected the failures to be recorded", events, err)
+		}
+	})
+	t.Run("disabling requires a code", func(t *testing.T) {
+		f := prepare(t)
+		secret, _ := enroll(t, f)
+		if err := f.mfaUseCases.DisableTOTP(f.accessToken, "not-a-code", testDevice); !errors.Is(err, apperrors.ErrMFACodeInvalid) {
+			t.Fatal("expected error ErrMFACodeInvalid", err)
+		}
+		if err := f.mfaUseCases.DisableTOTP(f.accessToken, currentCode(t, secret), testDevice); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		status, _ := f.mfaUseCases.GetMFAStatus(f.accessToken)
+		if status.TOTPEnabled || status.RecoveryCodesLeft != entities.RecoveryCodesCount {
+			t.Fatal("expected the authenticator app to be removed and the recovery codes to be kept", status)
+		}
+		tokensPair, err := f.mfaUseCases.MFAService.LogIn(f.user, testDevice, []string{entities.AMRFederated})
+		if err != nil || tokensPair.IsPendingMFA() {
+			t.Fatal("expected the tokens", tokensPair, err)
+		}
+	})
+	t.Run("a recovery code logs the user in without their other methods", func(t *testing.T) {
+		f := prepare(t)
+		recoveryCodes, err := f.mfaUseCases.GenerateRecoveryCodes(f.accessToken, "", testDevice)
+		if err != nil || len(recoveryCodes) != entities.RecoveryCodesCount {
+			t.Fatal("expected the recovery codes", recoveryCodes, err)
+		}
+		tokensPair, err := f.mfaUseCases.LogInWithRecoveryCode("Someone@example.com", recoveryCodes[0], testDevice)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		cc, err := f.mfaUseCases.TokenService.ReadAccessTokenClaims(tokensPair.AccessToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cc.UserID != f.user.ID || !slices.Equal(cc.AMR, []string{entities.AMRRecoveryCode}) || slices.Contains(cc.Roles, entities.RolePlatformAdmin) {
+			t.Fatal("expected a session of the user, without the roles that require a second factor", cc)
+		}
+		if _, err := f.mfaUseCases.LogInWithRecoveryCode("someone@example.com", recoveryCodes[0], testDevice); !errors.Is(err, apperrors.ErrMFACodeInvalid) {
+			t.Fatal("expected a recovery code to be used once", err)
+		}
+		events, err := f.securityEventRepository.GetSecurityEventsForUser(f.user.ID, entities.SecurityEventRecoveryCodeUsed, 10)
+		if err != nil || len(events) != 1 || !strings.Contains(events[0].Details, `"purpose":"login"`) {
+			t.Fatal("expected the use to be recorded", events, err)
+		}
+	})
+	t.Run("new recovery codes replace the previous ones", func(t *testing.T) {
+		f := prepare(t)
+		secret, previousCodes := enroll(t, f)
+		if _, err := f.mfaUseCases.GenerateRecoveryCodes(f.accessToken, "", testDevice); !errors.Is(err, apperrors.ErrMFACodeInvalid) {
+			t.Fatal("expected a code of the app to be required", err)
+		}
+		if _, err := f.mfaUseCases.GenerateRecoveryCodes(f.accessToken, currentCode(t, secret), testDevice); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if _, err := f.mfaUseCases.LogInWithRecoveryCode("someone@example.com", previousCodes[0], testDevice); !errors.Is(err, apperrors.ErrMFACodeInvalid) {
+			t.Fatal("expected error ErrMFACodeInvalid", err)
+		}
+		events, err := f.securityEventRepository.GetSecurityEventsForUser(f.user.ID, entities.SecurityEventRecoveryCodesGenerated, 10)
+		if err != nil || len(events) != 2 {
+			t.Fatal("expected the generations to be recorded", events, err)
+		}
+	})
+	t.Run("a login with a recovery code is limited in failures per email and IP", func(t *testing.T) {
+		f := prepare(t)
+		recoveryCodes, _ := f.mfaUseCases.GenerateRecoveryCodes(f.accessToken, "", testDevice)
+		attacker := entities.Device{ID: "attacker-device-id", IP: "203.0.113.7"}
+		for range entities.MaxRecoveryAttempts {
+			if _, err := f.mfaUseCases.LogInWithRecoveryCode("someone@example.com", "00000-00000", attacker); !errors.Is(err, apperrors.ErrMFACodeInvalid) {
+				t.Fatal("expected error ErrMFACodeInvalid", err)
+			}
+		}
+		if _, err := f.mfaUseCases.LogInWithRecoveryCode("someone@example.com", recoveryCodes[0], attacker); !errors.Is(err, apperrors.ErrTooManyMFAAttempts) {
+			t.Fatal("expected error ErrTooManyMFAAttempts", err)
+		}
+		events, err := f.securityEventRepository.GetSecurityEventsForUser(f.user.ID, entities.SecurityEventRecoveryCodeFailed, 10)
+		if err != nil || len(events) != entities.MaxRecoveryAttempts {
+			t.Fatal("expected the failures to be recorded", events, err)
+		}
+		if _, err := f.mfaUseCases.LogInWithRecoveryCode("someone@example.com", recoveryCodes[0], testDevice); err != nil {
+			t.Fatal("expected the user to still recover their account from elsewhere", err)
+		}
+	})
+	t.Run("an unknown email gets the answers of a known one", func(t *testing.T) {
+		f := prepare(t)
+		for range entities.MaxRecoveryAttempts {
+			if _, err := f.mfaUseCases.LogInWithRecoveryCode("nobody@example.com", "00000-00000", testDevice); !errors.Is(err, apperrors.ErrMFACodeInvalid) {
+				t.Fatal("expected an unknown email to get the error of a wrong code", err)
+			}
+		}
+		if _, err := f.mfaUseCases.LogInWithRecoveryCode("nobody@example.com", "00000-00000", testDevice); !errors.Is(err, apperrors.ErrTooManyMFAAttempts) {
+			t.Fatal("expected an unknown email to be limited too", err)
+		}
+	})
+	t.Run("a blocked user can not recover their account, and keeps their codes", func(t *testing.T) {
+		f := prepare(t)
+		recoveryCodes, _ := f.mfaUseCases.GenerateRecoveryCodes(f.accessToken, "", testDevice)
+		now := time.Now()
+		f.userRepository.SetUserBlockedAt(f.user.ID, &now)
+		if _, err := f.mfaUseCases.LogInWithRecoveryCode("someone@example.com", recoveryCodes[0], testDevice); !errors.Is(err, apperrors.ErrMFACodeInvalid) {
+			t.Fatal("expected error ErrMFACodeInvalid", err)
+		}
+		left, err := f.recoveryCodeRepository.CountUnusedRecoveryCodes(f.user.ID)
+		if err != nil || left != entities.RecoveryCodesCount {
+			t.Fatal("expected the code to not be used", left, err)
+		}
+	})
+}
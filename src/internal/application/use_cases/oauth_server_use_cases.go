@@ -0,0 +1,421 @@
+package usecases
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/ports/primary"
+	"aegis/internal/domain/ports/secondary"
+	"aegis/internal/domain/services"
+	"aegis/pkg/apperrors"
+	"aegis/pkg/pkce"
+	"aegis/pkg/urlbuilder"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Seconds added to the poll interval of a device that polls too soon (RFC 8628, section 3.5)
+const devicePollBackoff = 5
+
+// OAuthServerUseCases let the registered apps log their users in with OpenID Connect, Aegis being their provider
+type OAuthServerUseCases struct {
+	Config                        entities.Config
+	TokenService                  *services.TokenService
+	KeyService                    *services.KeyService
+	UserRepository                secondary.UserRepository
+	UserService                   *services.UserService
+	AuthorizationCodeRepository   secondary.AuthorizationCodeRepository
+	ServiceClientRepository       secondary.ServiceClientRepository
+	DeviceAuthorizationRepository secondary.DeviceAuthorizationRepository
+}
+
+var _ primary.OAuthServerUseCasesInterface = (*OAuthServerUseCases)(nil)
+
+func NewOAuthServerUseCases(
+	c entities.Config,
+	tokenService *services.TokenService,
+	keyService *services.KeyService,
+	userRepository secondary.UserRepository,
+	userIdentityRepository secondary.UserIdentityRepository,
+	authorizationCodeRepository secondary.AuthorizationCodeRepository,
+	serviceClientRepository secondary.ServiceClientRepository,
+	deviceAuthorizationRepository secondary.DeviceAuthorizationRepository,
+) *OAuthServerUseCases {
+	return &OAuthServerUseCases{
+		Config:                        c,
+		TokenService:                  tokenService,
+		KeyService:                    keyService,
+		UserRepository:                userRepository,
+		UserService:                   services.NewUserService(userRepository, userIdentityRepository, c),
+		AuthorizationCodeRepository:   authorizationCodeRepository,
+		ServiceClientRepository:       serviceClientRepository,
+		DeviceAuthorizationRepository: deviceAuthorizationRepository,
+	}
+}
+
+func (s OAuthServerUseCases) CheckAuthEnabled() bool {
+	return s.Config.OAuthServer.Enabled
+}
+
+func (s OAuthServerUseCases) GetOpenIDConfiguration() entities.OpenIDConfiguration {
+	issuer := s.Config.OAuthIssuer()
+	return entities.OpenIDConfiguration{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/authorize",
+		TokenEndpoint:                     issuer + "/token",
+		UserInfoEndpoint:                  issuer + "/userinfo",
+		DeviceAuthorizationEndpoint:       issuer + "/device/code",
+		JWKSURI:                           issuer + "/.well-known/jwks.json",
+		ScopesSupported:                   entities.SupportedScopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{entities.GrantTypeAuthorizationCode, entities.GrantTypeClientCredentials, entities.GrantTypeDeviceCode},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{s.Config.JWT.Algorithm},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{pkce.MethodS256},
+		ClaimsSupported:                   []string{"sub", "name", "picture", "email", "email_verified", "amr", "nonce"},
+	}
+}
+
+// Authorize checks the client and its redirect URI first: the errors about them can not be sent to the client.
+// The other errors are sent back to the redirect URI by the handler.
+func (s OAuthServerUseCases) Authorize(request entities.AuthorizationRequest, accessToken string) (string, error) {
+	client, ok := s.Config.OAuthClient(request.ClientID)
+	if !ok {
+		return "", apperrors.ErrOAuthInvalidClient
+	}
+	if !slices.Contains(client.RedirectURIs, request.RedirectURI) {
+		return "", apperrors.ErrOAuthInvalidRedirectURI
+	}
+	if request.ResponseType != "code" {
+		return "", apperrors.ErrOAuthUnsupportedResponseType
+	}
+	if request.CodeChallengeMethod != pkce.MethodS256 || !pkce.IsValidChallenge(request.CodeChallenge) {
+		return "", apperrors.ErrOAuthInvalidRequest
+	}
+	user, cc, err := s.loggedInUser(accessToken)
+	if err != nil {
+		return "", err
+	}
+	code, err := entities.NewAuthorizationCode(request, user.ID, cc.AMR)
+	if err != nil {
+		return "", err
+	}
+	if err := s.AuthorizationCodeRepository.CreateAuthorizationCode(code); err != nil {
+		return "", err
+	}
+	queryParams := map[string]string{"code": code.Code}
+	if request.State != "" {
+		queryParams["state"] = request.State
+	}
+	return urlbuilder.AddQuery(request.RedirectURI, queryParams)
+}
+
+func (s OAuthServerUseCases) IssueToken(request entities.TokenRequest) (entities.OAuthTokenResponse, error) {
+	switch request.GrantType {
+	case entities.GrantTypeAuthorizationCode:
+		return s.exchangeCode(request)
+	case entities.GrantTypeClientCredentials:
+		return s.grantClientCredentials(request)
+	case entities.GrantTypeDeviceCode:
+		return s.pollDeviceAuthorization(request)
+	}
+	return entities.OAuthTokenResponse{}, apperrors.ErrOAuthUnsupportedGrantType
+}
+
+// loggedInUser returns the user of the session, ErrOAuthLoginRequired if there is none, and ErrOAuthAccessDenied if
+// they are not allowed to log in anymore
+func (s OAuthServerUseCases) loggedInUser(accessToken string) (entities.User, *entities.CustomClaims, error) {
+	if accessToken == "" {
+		return entities.User{}, nil, apperrors.ErrOAuthLoginRequired
+	}
+	cc, err := s.TokenService.ReadAccessTokenClaims(accessToken)
+	if err != nil {
+		return entities.User{}, nil, apperrors.ErrOAuthLoginRequired
+	}
+	user, err := s.UserRepository.GetUserByID(cc.UserID)
+	if err != nil {
+		if errors.Is(err, apperrors.ErrNoUser) {
+			return entities.User{}, nil, apperrors.ErrOAuthLoginRequired
+		}
+		return entities.User{}, nil, err
+	}
+	if err := s.UserService.CheckUserAllowed(user); err != nil {
+		return entities.User{}, nil, apperrors.ErrOAuthAccessDenied
+	}
+	return user, cc, nil
+}
+
+// exchangeCode returns the tokens of the user who authorized the client.
+// No refresh token is issued: the client authorizes again with prompt=none when the access token expires.
+func (s OAuthServerUseCases) exchangeCode(request entities.TokenRequest) (entities.OAuthTokenResponse, error) {
+	client, ok := s.Config.OAuthClient(request.ClientID)
+	if !ok || !client.Authenticate(request.ClientSecret) {
+		return entities.OAuthTokenResponse{}, apperrors.ErrOAuthInvalidClient
+	}
+	if request.Code == "" || request.CodeVerifier == "" {
+		return entities.OAuthTokenResponse{}, apperrors.ErrOAuthInvalidRequest
+	}
+	code, err := s.AuthorizationCodeRepository.GetAndDeleteAuthorizationCode(request.Code)
+	if err != nil {
+		return entities.OAuthTokenResponse{}, err
+	}
+	if code.IsExpired() || code.ClientID != client.ClientID || code.RedirectURI != request.RedirectURI {
+		return entities.OAuthTokenResponse{}, apperrors.ErrOAuthInvalidGrant
+	}
+	if !pkce.Verify(request.CodeVerifier, code.CodeChallenge) {
+		return entities.OAuthTokenResponse{}, apperrors.ErrOAuthInvalidGrant
+	}
+	return s.issueUserTokens(client, code.UserID, code.Methods(), code.Scope, code.Nonce)
+}
+
+// issueUserTokens returns an access token of the user for the client, and an ID token if it was granted the "openid"
+// scope
+func (s OAuthServerUseCases) issueUserTokens(client entities.OAuthClientConfig, userID string, amr []string, scope, nonce string) (entities.OAuthTokenResponse, error) {
+	user, err := s.UserRepository.GetUserByID(userID)
+	if err != nil {
+		if errors.Is(err, apperrors.ErrNoUser) {
+			return entities.OAuthTokenResponse{}, apperrors.ErrOAuthInvalidGrant
+		}
+		return entities.OAuthTokenResponse{}, err
+	}
+	// The user may have been blocked since they authorized the client
+	if err := s.UserService.CheckUserAllowed(user); err != nil {
+		return entities.OAuthTokenResponse{}, apperrors.ErrOAuthInvalidGrant
+	}
+	// The token is meant for the client: it carries the user and the scopes only, not the roles and permissions
+	// the user has in the sessions of Aegis
+	now := time.Now()
+	accessToken, expiresAt, err := s.KeyService.Sign(map[string]any{
+		"iss":       s.Config.OAuthIssuer(),
+		"aud":       client.ClientID,
+		"sub":       user.ID,
+		"client_id": client.ClientID,
+		"scope":     scope,
+	}, now)
+	if err != nil {
+		return entities.OAuthTokenResponse{}, err
+	}
+	response := entities.OAuthTokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   expiresAt - now.Unix(),
+		Scope:       scope,
+	}
+	if slices.Contains(strings.Fields(scope), entities.ScopeOpenID) {
+		idTokenClaims := userInfoFor(user, scope).ToMap()
+		idTokenClaims["iss"] = s.Config.OAuthIssuer()
+		idTokenClaims["aud"] = client.ClientID
+		// Marks the token as an ID token, the key service does not accept it as an access token
+		idTokenClaims["azp"] = client.ClientID
+		idTokenClaims["iat"] = now.Unix()
+		if len(amr) > 0 {
+			idTokenClaims["amr"] = amr
+		}
+		if nonce != "" {
+			idTokenClaims["nonce"] = nonce
+		}
+		response.IDToken, _, err = s.KeyService.Sign(idTokenClaims, now)
+		if err != nil {
+			return entities.OAuthTokenResponse{}, err
+		}
+	}
+	return response, nil
+}
+
+// grantClientCredentials returns an access token of the service client itself, with the scopes it requested.
+// Its subject is the client ID, it carries no user and no roles.
+func (s OAuthServerUseCases) grantClientCredentials(request entities.TokenRequest) (entities.OAuthTokenResponse, error) {
+	if request.ClientID == "" || request.ClientSecret == "" {
+		return entities.OAuthTokenResponse{}, apperrors.ErrOAuthInvalidClient
+	}
+	client, err := s.ServiceClientRepository.AuthenticateServiceClient(request.ClientID, request.ClientSecret)
+	if err != nil {
+		return entities.OAuthTokenResponse{}, err
+	}
+	scope, ok := client.GrantScopes(request.Scope)
+	if !ok {
+		return entities.OAuthTokenResponse{}, apperrors.ErrOAuthInvalidScope
+	}
+	now := time.Now()
+	accessToken, expiresAt, err := s.KeyService.Sign(map[string]any{
+		"sub":       client.ClientID,
+		"client_id": client.ClientID,
+		"scope":     scope,
+	}, now)
+	if err != nil {
+		return entities.OAuthTokenResponse{}, err
+	}
+	return entities.OAuthTokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   expiresAt - now.Unix(),
+		Scope:       scope,
+	}, nil
+}
+
+// StartDeviceAuthorization returns the codes of a device login, the device shows the user code to its user and polls
+// the token endpoint with the device code
+func (s OAuthServerUseCases) StartDeviceAuthorization(request entities.DeviceAuthorizationRequest) (entities.DeviceAuthorizationResponse, error) {
+	client, ok := s.Config.OAuthClient(request.ClientID)
+	if !ok || !client.Authenticate(request.ClientSecret) {
+		return entities.DeviceAuthorizationResponse{}, apperrors.ErrOAuthInvalidClient
+	}
+	if !client.DeviceFlow {
+		return entities.DeviceAuthorizationResponse{}, apperrors.ErrOAuthUnauthorizedClient
+	}
+	authorization, err := entities.NewDeviceAuthorization(client.ClientID, request.Scope)
+	if err != nil {
+		return entities.DeviceAuthorizationResponse{}, err
+	}
+	if err := s.DeviceAuthorizationRepository.CreateDeviceAuthorization(authorization); err != nil {
+		return entities.DeviceAuthorizationResponse{}, err
+	}
+	userCode := entities.FormatUserCode(authorization.UserCode)
+	verificationURI := s.Config.OAuthIssuer() + "/device"
+	verificationURIComplete, err := urlbuilder.AddQuery(verificationURI, map[string]string{"user_code": userCode})
+	if err != nil {
+		return entities.DeviceAuthorizationResponse{}, err
+	}
+	return entities.DeviceAuthorizationResponse{
+		DeviceCode:              authorization.DeviceCode,
+		UserCode:                userCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURIComplete,
+		ExpiresIn:               int64(time.Until(authorization.ExpiresAt).Round(time.Second).Seconds()),
+		Interval:                authorization.PollInterval,
+	}, nil
+}
+
+// GetDeviceAuthorization returns the pending authorization of the user code, to show the user which client they
+// approve. The user must be logged in, even without a code.
+func (s OAuthServerUseCases) GetDeviceAuthorization(userCode, accessToken string) (entities.DeviceAuthorization, error) {
+	if _, _, err := s.loggedInUser(accessToken); err != nil {
+		return entities.DeviceAuthorization{}, err
+	}
+	if userCode == "" {
+		return entities.DeviceAuthorization{}, nil
+	}
+	return s.pendingDeviceAuthorization(userCode)
+}
+
+// DecideDeviceAuthorization lets the logged in user approve or deny the device showing the user code. An approved
+// device gets the tokens of the user at its next poll.
+func (s OAuthServerUseCases) DecideDeviceAuthorization(userCode, accessToken string, approve bool) error {
+	user, cc, err := s.loggedInUser(accessToken)
+	if err != nil {
+		return err
+	}
+	if _, err := s.pendingDeviceAuthorization(userCode); err != nil {
+		return err
+	}
+	decision := entities.DeviceAuthorization{Status: entities.DeviceAuthorizationDenied}
+	if approve {
+		decision = entities.DeviceAuthorization{
+			Status: entities.DeviceAuthorizationApproved,
+			UserID: user.ID,
+			AMR:    strings.Join(cc.AMR, ","),
+		}
+	}
+	return s.DeviceAuthorizationRepository.DecideDeviceAuthorization(userCode, decision)
+}
+
+func (s OAuthServerUseCases) pendingDeviceAuthorization(userCode string) (entities.DeviceAuthorization, error) {
+	authorization, err := s.DeviceAuthorizationRepository.GetDeviceAuthorizationByUserCode(userCode)
+	if err != nil {
+		return entities.DeviceAuthorization{}, err
+	}
+	if authorization.IsExpired() || authorization.Status != entities.DeviceAuthorizationPending {
+		return entities.DeviceAuthorization{}, apperrors.ErrUserCodeInvalid
+	}
+	return authorization, nil
+}
+
+// pollDeviceAuthorization returns the tokens of the user once they approved the device, once. Until then it fails
+// with authorization_pending, or slow_down when the device does not wait the interval between its polls
+// (RFC 8628, section 3.5).
+func (s OAuthServerUseCases) pollDeviceAuthorization(request entities.TokenRequest) (entities.OAuthTokenResponse, error) {
+	client, ok := s.Config.OAuthClient(request.ClientID)
+	if !ok || !client.Authenticate(request.ClientSecret) {
+		return entities.OAuthTokenResponse{}, apperrors.ErrOAuthInvalidClient
+	}
+	if !client.DeviceFlow {
+		return entities.OAuthTokenResponse{}, apperrors.ErrOAuthUnauthorizedClient
+	}
+	if request.DeviceCode == "" {
+		return entities.OAuthTokenResponse{}, apperrors.ErrOAuthInvalidRequest
+	}
+	authorization, err := s.DeviceAuthorizationRepository.GetDeviceAuthorization(request.DeviceCode)
+	if err != nil {
+		return entities.OAuthTokenResponse{}, err
+	}
+	if authorization.ClientID != client.ClientID {
+		return entities.OAuthTokenResponse{}, apperrors.ErrOAuthInvalidGrant
+	}
+	if authorization.IsExpired() {
+		return entities.OAuthTokenResponse{}, apperrors.ErrOAuthExpiredToken
+	}
+	switch authorization.Status {
+	case entities.DeviceAuthorizationApproved:
+		if err := s.DeviceAuthorizationRepository.DeleteDeviceAuthorization(request.DeviceCode); err != nil {
+			return entities.OAuthTokenResponse{}, err
+		}
+		return s.issueUserTokens(client, authorization.UserID, authorization.Methods(), authorization.Scope, "")
+	case entities.DeviceAuthorizationDenied:
+		if err := s.DeviceAuthorizationRepository.DeleteDeviceAuthorization(request.DeviceCode); err != nil {
+			return entities.OAuthTokenResponse{}, err
+		}
+		return entities.OAuthTokenResponse{}, apperrors.ErrOAuthAccessDenied
+	}
+	now := time.Now()
+	interval := authorization.PollInterval
+	if authorization.IsPolledTooSoon(now) {
+		interval += devicePollBackoff
+	}
+	if err := s.DeviceAuthorizationRepository.UpdateDeviceAuthorizationPoll(request.DeviceCode, now, interval); err != nil {
+		return entities.OAuthTokenResponse{}, err
+	}
+	if interval != authorization.PollInterval {
+		return entities.OAuthTokenResponse{}, apperrors.ErrOAuthSlowDown
+	}
+	return entities.OAuthTokenResponse{}, apperrors.ErrOAuthAuthorizationPending
+}
+
+// GetUserInfo accepts the access tokens of the sessions too, they are granted all the scopes
+func (s OAuthServerUseCases) GetUserInfo(accessToken string) (entities.OIDCUserInfo, error) {
+	cc, err := s.TokenService.ReadClientAccessTokenClaims(accessToken)
+	if err != nil {
+		return entities.OIDCUserInfo{}, err
+	}
+	userID := cc.UserID
+	if cc.ClientID != "" {
+		userID = cc.Subject
+	}
+	user, err := s.UserRepository.GetUserByID(userID)
+	if err != nil {
+		return entities.OIDCUserInfo{}, err
+	}
+	if err := s.UserService.CheckUserAllowed(user); err != nil {
+		return entities.OIDCUserInfo{}, apperrors.ErrAccessTokenInvalid
+	}
+	scope := strings.Join(entities.SupportedScopes, " ")
+	if cc.ClientID != "" {
+		scope = cc.Scope
+	}
+	return userInfoFor(user, scope), nil
+}
+
+func userInfoFor(user entities.User, scope string) entities.OIDCUserInfo {
+	scopes := strings.Fields(scope)
+	userInfo := entities.OIDCUserInfo{Subject: user.ID}
+	if slices.Contains(scopes, entities.ScopeProfile) {
+		userInfo.Name = user.Name
+		userInfo.Picture = user.AvatarURL
+	}
+	if slices.Contains(scopes, entities.ScopeEmail) {
+		// The providers and the email logins only give verified emails
+		userInfo.Email = user.Email
+		userInfo.EmailVerified = true
+	}
+	return userInfo
+}
@@ -0,0 +1,429 @@
+package usecases
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/services"
+	"aegis/internal/infrastructure/repositories"
+	"aegis/pkg/apperrors"
+	"aegis/pkg/jwtgen"
+	"aegis/pkg/pkce"
+	"errors"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestOAuthServerUseCases(t *testing.T) {
+	_, privateKey, err := jwtgen.GenerateKey("test-key", jwtgen.AlgorithmES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseConfig := entities.Config{
+		JWT: entities.JWTConfig{
+			Algorithm:                  jwtgen.AlgorithmES256,
+			KeyID:                      "test-key",
+			PrivateKey:                 privateKey,
+			AccessTokenExpirationMin:   5,
+			RefreshTokenExpirationDays: 1,
+		},
+	}
+	baseConfig.App.Name = "Aegis"
+	baseConfig.App.URL = "https://aegis.example.com"
+	baseConfig.OAuthServer.Enabled = true
+	baseConfig.OAuthServer.Clients = []entities.OAuthClientConfig{
+		{ClientID: "billing", ClientSecret: "billing-secret", RedirectURIs: []string{"https://billing.example.com/callback"}},
+		{ClientID: "cli", RedirectURIs: []string{"http://127.0.0.1:8400/callback"}},
+		{ClientID: "terminal", Name: "Terminal", DeviceFlow: true},
+	}
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	type fixture struct {
+		oauthServerUseCases *OAuthServerUseCases
+		userRepository      *repositories.UserRepository
+		serviceClients      *repositories.ServiceClientRepository
+		devices             *repositories.DeviceAuthorizationRepository
+		user                entities.User
+		accessToken         string
+	}
+	prepare := func(t *testing.T) fixture {
+		db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.AutoMigrate(&entities.User{}, &entities.RefreshToken{}, &entities.Role{}, &entities.SecurityEvent{}, &entities.UserIdentity{}, &entities.AuthorizationCode{}, &entities.ServiceClient{}, &entities.DeviceAuthorization{})
+		keyService, err := services.NewKeyService(baseConfig, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokenService := services.NewTokenService(repositories.NewRefreshTokenRepository(db, "some-pepper"), repositories.NewSecurityEventRepository(db), repositories.NewOrganizationRepository(db), keyService, baseConfig)
+		userRepository := repositories.NewUserRepository(db)
+		user, err := entities.NewUser("someone", "https://example.com/avatar.png", "someone@example.com", "github")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := userRepository.CreateUser(user, []entities.Role{entities.NewRole(user.ID, entities.RoleUser)}); err != nil {
+			t.Fatal(err)
+		}
+		user, err = userRepository.GetUserByID(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		accessToken, _, _, _, err := tokenService.GenerateTokensForUser(user, testDevice, []string{entities.AMRFederated})
+		if err != nil {
+			t.Fatal(err)
+		}
+		serviceClientRepository := repositories.NewServiceClientRepository(db, "some-pepper")
+		deviceAuthorizationRepository := repositories.NewDeviceAuthorizationRepository(db, "some-pepper")
+		return fixture{
+			oauthServerUseCases: NewOAuthServerUseCases(baseConfig, tokenService, keyService, userRepository, repositories.NewUserIdentityRepository(db), repositories.NewAuthorizationCodeRepository(db, "some-pepper"), serviceClientRepository, deviceAuthorizationRepository),
+			userRepository:      userRepository,
+			serviceClients:      serviceClientRepository,
+			devices:             deviceAuthorizationRepository,
+			user:                user,
+			accessToken:         accessToken,
+		}
+	}
+	authorizationRequest := func(clientID, redirectURI, scope string) entities.AuthorizationRequest {
+		return entities.AuthorizationRequest{
+			ResponseType:        "code",
+			ClientID:            clientID,
+			RedirectURI:         redirectURI,
+			Scope:               scope,
+			State:               "some-state",
+			Nonce:               "some-nonce",
+			CodeChallenge:       pkce.Challenge(verifier),
+			CodeChallengeMethod: pkce.MethodS256,
+		}
+	}
+	// authorize returns the code given to the client
+	authorize := func(t *testing.T, f fixture, request entities.AuthorizationRequest) string {
+		redirectURL, err := f.oauthServerUseCases.Authorize(request, f.accessToken)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		parsedURL, err := url.Parse(redirectURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsedURL.Query().Get("state") != request.State || parsedURL.Query().Get("code") == "" {
+			t.Fatal("expected the code and the state", redirectURL)
+		}
+		return parsedURL.Query().Get("code")
+	}
+	t.Run("the code flow issues an ID token for the client", func(t *testing.T) {
+		f := prepare(t)
+		code := authorize(t, f, authorizationRequest("billing", "https://billing.example.com/callback", "openid email unknown"))
+		response, err := f.oauthServerUseCases.IssueToken(entities.TokenRequest{
+			GrantType:    entities.GrantTypeAuthorizationCode,
+			Code:         code,
+			RedirectURI:  "https://billing.example.com/callback",
+			ClientID:     "billing",
+			ClientSecret: "billing-secret",
+			CodeVerifier: verifier,
+		})
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if response.TokenType != "Bearer" || response.Scope != "openid email" || response.ExpiresIn <= 0 {
+			t.Fatal("expected a bearer token for the supported scopes", response)
+		}
+		idTokenClaims, err := jwtgen.ReadClaimsWithKeys(response.IDToken, []jwtgen.Key{mustParseKey(t, baseConfig)})
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if idTokenClaims["iss"] != "https://aegis.example.com/auth" || idTokenClaims["aud"] != "billing" || idTokenClaims["sub"] != f.user.ID {
+			t.Fatal("expected the ID token of the user for the client", idTokenClaims)
+		}
+		if idTokenClaims["nonce"] != "some-nonce" || idTokenClaims["email"] != "someone@example.com" || idTokenClaims["name"] != nil {
+			t.Fatal("expected the nonce and the claims of the granted scopes", idTokenClaims)
+		}
+		accessTokenClaims, err := jwtgen.ReadClaimsWithKeys(response.AccessToken, []jwtgen.Key{mustParseKey(t, baseConfig)})
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if accessTokenClaims["iss"] != "https://aegis.example.com/auth" || accessTokenClaims["aud"] != "billing" || accessTokenClaims["sub"] != f.user.ID || accessTokenClaims["scope"] != "openid email" {
+			t.Fatal("expected the access token of the user for the client", accessTokenClaims)
+		}
+		if accessTokenClaims["user_id"] != nil || accessTokenClaims["roles"] != nil || accessTokenClaims["permissions"] != nil {
+			t.Fatal("expected the access token of the client to carry no roles", accessTokenClaims)
+		}
+		if _, err := f.oauthServerUseCases.TokenService.ReadAccessTokenClaims(response.IDToken); !errors.Is(err, apperrors.ErrAccessTokenInvalid) {
+			t.Fatal("expected the ID token to be rejected as an access token", err)
+		}
+		if _, err := f.oauthServerUseCases.TokenService.ReadAccessTokenClaims(response.AccessToken); !errors.Is(err, apperrors.ErrAccessTokenInvalid) {
+			t.Fatal("expected the access token of the client to be rejected for the sessions", err)
+		}
+		userInfo, err := f.oauthServerUseCases.GetUserInfo(response.AccessToken)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if userInfo.Subject != f.user.ID || userInfo.Email != "someone@example.com" || !userInfo.EmailVerified || userInfo.Name != "" {
+			t.Fatal("expected the claims of the granted scopes", userInfo)
+		}
+		if _, err := f.oauthServerUseCases.GetUserInfo(response.IDToken); !errors.Is(err, apperrors.ErrAccessTokenInvalid) {
+			t.Fatal("expected the ID token to be rejected by userinfo", err)
+		}
+	})
+	t.Run("a code can be exchanged once", func(t *testing.T) {
+		f := prepare(t)
+		request := entities.TokenRequest{
+			GrantType:    entities.GrantTypeAuthorizationCode,
+			Code:         authorize(t, f, authorizationRequest("cli", "http://127.0.0.1:8400/callback", "openid")),
+			RedirectURI:  "http://127.0.0.1:8400/callback",
+			ClientID:     "cli",
+			CodeVerifier: verifier,
+		}
+		if _, err := f.oauthServerUseCases.IssueToken(request); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if _, err := f.oauthServerUseCases.IssueToken(request); !errors.Is(err, apperrors.ErrOAuthInvalidGrant) {
+			t.Fatal("expected error ErrOAuthInvalidGrant", err)
+		}
+	})
+	t.Run("the exchange checks the verifier, the client and the redirect uri", func(t *testing.T) {
+		f := prepare(t)
+		valid := entities.TokenRequest{
+			GrantType:    entities.GrantTypeAuthorizationCode,
+			RedirectURI:  "https://billing.example.com/callback",
+			ClientID:     "billing",
+			ClientSecret: "billing-secret",
+			CodeVerifier: verifier,
+		}
+		wrongVerifier := valid
+		wrongVerifier.CodeVerifier = "some-other-verifier-some-other-verifier-some-other"
+		wrongRedirectURI := valid
+		wrongRedirectURI.RedirectURI = "https://billing.example.com/other"
+		wrongClient := valid
+		wrongClient.ClientID = "cli"
+		wrongClient.ClientSecret = ""
+		for _, request := range []entities.TokenRequest{wrongVerifier, wrongRedirectURI, wrongClient} {
+			request.Code = authorize(t, f, authorizationRequest("billing", "https://billing.example.com/callback", "openid"))
+			if _, err := f.oauthServerUseCases.IssueToken(request); !errors.Is(err, apperrors.ErrOAuthInvalidGrant) {
+				t.Fatal("expected error ErrOAuthInvalidGrant", err)
+			}
+		}
+		wrongSecret := valid
+		wrongSecret.ClientSecret = "wrong"
+		wrongSecret.Code = authorize(t, f, authorizationRequest("billing", "https://billing.example.com/callback", "openid"))
+		if _, err := f.oauthServerUseCases.IssueToken(wrongSecret); !errors.Is(err, apperrors.ErrOAuthInvalidClient) {
+			t.Fatal("expected error ErrOAuthInvalidClient", err)
+		}
+		unsupported := valid
+		unsupported.GrantType = "password"
+		if _, err := f.oauthServerUseCases.IssueToken(unsupported); !errors.Is(err, apperrors.ErrOAuthUnsupportedGrantType) {
+			t.Fatal("expected error ErrOAuthUnsupportedGrantType", err)
+		}
+	})
+	t.Run("authorize rejects unknown clients, redirect uris and requests without pkce", func(t *testing.T) {
+		f := prepare(t)
+		if _, err := f.oauthServerUseCases.Authorize(authorizationRequest("unknown", "https://billing.example.com/callback", "openid"), f.accessToken); !errors.Is(err, apperrors.ErrOAuthInvalidClient) {
+			t.Fatal("expected error ErrOAuthInvalidClient", err)
+		}
+		if _, err := f.oauthServerUseCases.Authorize(authorizationRequest("billing", "https://evil.example.com/callback", "openid"), f.accessToken); !errors.Is(err, apperrors.ErrOAuthInvalidRedirectURI) {
+			t.Fatal("expected error ErrOAuthInvalidRedirectURI", err)
+		}
+		withoutPKCE := authorizationRequest("billing", "https://billing.example.com/callback", "openid")
+		withoutPKCE.CodeChallenge = ""
+		if _, err := f.oauthServerUseCases.Authorize(withoutPKCE, f.accessToken); !errors.Is(err, apperrors.ErrOAuthInvalidRequest) {
+			t.Fatal("expected error ErrOAuthInvalidRequest", err)
+		}
+		plain := authorizationRequest("billing", "https://billing.example.com/callback", "openid")
+		plain.CodeChallengeMethod = "plain"
+		if _, err := f.oauthServerUseCases.Authorize(plain, f.accessToken); !errors.Is(err, apperrors.ErrOAuthInvalidRequest) {
+			t.Fatal("expected error ErrOAuthInvalidRequest", err)
+		}
+		token := authorizationRequest("billing", "https://billing.example.com/callback", "openid")
+		token.ResponseType = "token"
+		if _, err := f.oauthServerUseCases.Authorize(token, f.accessToken); !errors.Is(err, apperrors.ErrOAuthUnsupportedResponseType) {
+			t.Fatal("expected error ErrOAuthUnsupportedResponseType", err)
+		}
+	})
+	t.Run("authorize requires a logged in user who is allowed", func(t *testing.T) {
+		f := prepare(t)
+		request := authorizationRequest("billing", "https://billing.example.com/callback", "openid")
+		if _, err := f.oauthServerUseCases.Authorize(request, ""); !errors.Is(err, apperrors.ErrOAuthLoginRequired) {
+			t.Fatal("expected error ErrOAuthLoginRequired", err)
+		}
+		if _, err := f.oauthServerUseCases.Authorize(request, "invalid"); !errors.Is(err, apperrors.ErrOAuthLoginRequired) {
+			t.Fatal("expected error ErrOAuthLoginRequired", err)
+		}
+		blockedAt := time.Now()
+		if err := f.userRepository.SetUserBlockedAt(f.user.ID, &blockedAt); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.oauthServerUseCases.Authorize(request, f.accessToken); !errors.Is(err, apperrors.ErrOAuthAccessDenied) {
+			t.Fatal("expected error ErrOAuthAccessDenied", err)
+		}
+	})
+	t.Run("a service client gets a token of its own, with the scopes it requested", func(t *testing.T) {
+		f := prepare(t)
+		client, secret, err := entities.NewServiceClient("worker", []string{"reports:read", "reports:write"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.serviceClients.CreateServiceClient(client); err != nil {
+			t.Fatal(err)
+		}
+		request := entities.TokenRequest{
+			GrantType:    entities.GrantTypeClientCredentials,
+			ClientID:     client.ClientID,
+			ClientSecret: secret,
+			Scope:        "reports:read",
+		}
+		response, err := f.oauthServerUseCases.IssueToken(request)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if response.Scope != "reports:read" || response.IDToken != "" {
+			t.Fatal("expected an access token with the requested scope", response)
+		}
+		claims, err := jwtgen.ReadClaimsWithKeys(response.AccessToken, []jwtgen.Key{mustParseKey(t, baseConfig)})
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if claims["sub"] != client.ClientID || claims["client_id"] != client.ClientID || claims["user_id"] != nil {
+			t.Fatal("expected a token of the client, without a user", claims)
+		}
+		if _, err := f.oauthServerUseCases.TokenService.ReadAccessTokenClaims(response.AccessToken); !errors.Is(err, apperrors.ErrAccessTokenInvalid) {
+			t.Fatal("expected the token to be rejected for the sessions", err)
+		}
+		request.Scope = ""
+		if response, err := f.oauthServerUseCases.IssueToken(request); err != nil || response.Scope != "reports:read reports:write" {
+			t.Fatal("expected all the scopes of the client", response, err)
+		}
+		request.Scope = "reports:read admin"
+		if _, err := f.oauthServerUseCases.IssueToken(request); !errors.Is(err, apperrors.ErrOAuthInvalidScope) {
+			t.Fatal("expected error ErrOAuthInvalidScope", err)
+		}
+		request.Scope = ""
+		request.ClientSecret = "wrong"
+		if _, err := f.oauthServerUseCases.IssueToken(request); !errors.Is(err, apperrors.ErrOAuthInvalidClient) {
+			t.Fatal("expected error ErrOAuthInvalidClient", err)
+		}
+	})
+	t.Run("a device gets the tokens of the user once they approved it", func(t *testing.T) {
+		f := prepare(t)
+		started, err := f.oauthServerUseCases.StartDeviceAuthorization(entities.DeviceAuthorizationRequest{ClientID: "terminal", Scope: "openid profile"})
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if started.VerificationURI != "https://aegis.example.com/auth/device" || started.Interval != 5 || started.ExpiresIn <= 0 {
+			t.Fatal("expected the verification page and the interval", started)
+		}
+		if len(started.UserCode) != 9 || started.VerificationURIComplete != started.VerificationURI+"?user_code="+started.UserCode {
+			t.Fatal("expected a formatted user code, filled in the complete uri", started)
+		}
+		poll := entities.TokenRequest{GrantType: entities.GrantTypeDeviceCode, ClientID: "terminal", DeviceCode: started.DeviceCode}
+		if _, err := f.oauthServerUseCases.IssueToken(poll); !errors.Is(err, apperrors.ErrOAuthAuthorizationPending) {
+			t.Fatal("expected error ErrOAuthAuthorizationPending", err)
+		}
+		if _, err := f.oauthServerUseCases.IssueToken(poll); !errors.Is(err, apperrors.ErrOAuthSlowDown) {
+			t.Fatal("expected error ErrOAuthSlowDown", err)
+		}
+
+		// The user types the code in lowercase, without the dash
+		typed := strings.ToLower(strings.ReplaceAll(started.UserCode, "-", ""))
+		authorization, err := f.oauthServerUseCases.GetDeviceAuthorization(typed, f.accessToken)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if authorization.ClientID != "terminal" || authorization.PollInterval != 10 {
+			t.Fatal("expected the pending authorization of the client, with a longer interval", authorization)
+		}
+		if err := f.oauthServerUseCases.DecideDeviceAuthorization(typed, f.accessToken, true); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if err := f.devices.UpdateDeviceAuthorizationPoll(started.DeviceCode, time.Now().Add(-time.Minute), 10); err != nil {
+			t.Fatal(err)
+		}
+		response, err := f.oauthServerUseCases.IssueToken(poll)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if response.Scope != "openid profile" || response.IDToken == "" {
+			t.Fatal("expected an ID token for the granted scopes", response)
+		}
+		cc, err := f.oauthServerUseCases.TokenService.ReadClientAccessTokenClaims(response.AccessToken)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if cc.Subject != f.user.ID || cc.ClientID != "terminal" || len(cc.Roles) != 0 {
+			t.Fatal("expected a token of the user for the client, without their roles", cc)
+		}
+		idTokenClaims, err := jwtgen.ReadClaimsWithKeys(response.IDToken, []jwtgen.Key{mustParseKey(t, baseConfig)})
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if amr, _ := idTokenClaims["amr"].([]any); len(amr) != 1 || amr[0] != entities.AMRFederated {
+			t.Fatal("expected the ID token to carry the methods of their session", idTokenClaims)
+		}
+		if _, err := f.oauthServerUseCases.IssueToken(poll); !errors.Is(err, apperrors.ErrOAuthInvalidGrant) {
+			t.Fatal("expected error ErrOAuthInvalidGrant", err)
+		}
+	})
+	t.Run("a denied device gets access_denied, and a code is decided once", func(t *testing.T) {
+		f := prepare(t)
+		started, err := f.oauthServerUseCases.StartDeviceAuthorization(entities.DeviceAuthorizationRequest{ClientID: "terminal"})
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if err := f.oauthServerUseCases.DecideDeviceAuthorization(started.UserCode, f.accessToken, false); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if err := f.oauthServerUseCases.DecideDeviceAuthorization(started.UserCode, f.accessToken, true); !errors.Is(err, apperrors.ErrUserCodeInvalid) {
+			t.Fatal("expected error ErrUserCodeInvalid", err)
+		}
+		poll := entities.TokenRequest{GrantType: entities.GrantTypeDeviceCode, ClientID: "terminal", DeviceCode: started.DeviceCode}
+		if _, err := f.oauthServerUseCases.IssueToken(poll); !errors.Is(err, apperrors.ErrOAuthAccessDenied) {
+			t.Fatal("expected error ErrOAuthAccessDenied", err)
+		}
+	})
+	t.Run("the device flow requires a client allowed to use it and a logged in user", func(t *testing.T) {
+		f := prepare(t)
+		if _, err := f.oauthServerUseCases.StartDeviceAuthorization(entities.DeviceAuthorizationRequest{ClientID: "billing", ClientSecret: "billing-secret"}); !errors.Is(err, apperrors.ErrOAuthUnauthorizedClient) {
+			t.Fatal("expected error ErrOAuthUnauthorizedClient", err)
+		}
+		if _, err := f.oauthServerUseCases.StartDeviceAuthorization(entities.DeviceAuthorizationRequest{ClientID: "unknown"}); !errors.Is(err, apperrors.ErrOAuthInvalidClient) {
+			t.Fatal("expected error ErrOAuthInvalidClient", err)
+		}
+		started, err := f.oauthServerUseCases.StartDeviceAuthorization(entities.DeviceAuthorizationRequest{ClientID: "terminal"})
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if _, err := f.oauthServerUseCases.GetDeviceAuthorization(started.UserCode, ""); !errors.Is(err, apperrors.ErrOAuthLoginRequired) {
+			t.Fatal("expected error ErrOAuthLoginRequired", err)
+		}
+		if err := f.oauthServerUseCases.DecideDeviceAuthorization(started.UserCode, "invalid", true); !errors.Is(err, apperrors.ErrOAuthLoginRequired) {
+			t.Fatal("expected error ErrOAuthLoginRequired", err)
+		}
+		if _, err := f.oauthServerUseCases.GetDeviceAuthorization("BCDF-GHJK", f.accessToken); !errors.Is(err, apperrors.ErrUserCodeInvalid) {
+			t.Fatal("expected error ErrUserCodeInvalid", err)
+		}
+		poll := entities.TokenRequest{GrantType: entities.GrantTypeDeviceCode, ClientID: "billing", ClientSecret: "billing-secret", DeviceCode: started.DeviceCode}
+		if _, err := f.oauthServerUseCases.IssueToken(poll); !errors.Is(err, apperrors.ErrOAuthUnauthorizedClient) {
+			t.Fatal("expected error ErrOAuthUnauthorizedClient", err)
+		}
+	})
+	t.Run("the discovery document lists the endpoints under the issuer", func(t *testing.T) {
+		f := prepare(t)
+		configuration := f.oauthServerUseCases.GetOpenIDConfiguration()
+		if configuration.Issuer != "https://aegis.example.com/auth" || configuration.TokenEndpoint != "https://aegis.example.com/auth/token" {
+			t.Fatal("expected the endpoints under the issuer", configuration)
+		}
+		if !slices.Equal(configuration.IDTokenSigningAlgValuesSupported, []string{jwtgen.AlgorithmES256}) || !slices.Equal(configuration.CodeChallengeMethodsSupported, []string{"S256"}) {
+			t.Fatal("expected the signing algorithm and S256", configuration)
+		}
+	})
+}
+
+func mustParseKey(t *testing.T, c entities.Config) jwtgen.Key {
+	key, err := jwtgen.ParsePrivateKey(c.JWT.KeyID, c.JWT.Algorithm, c.JWT.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
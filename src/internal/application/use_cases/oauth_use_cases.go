@@ -8,7 +8,6 @@ import (
 	"aegis/pkg/apperrors"
 	"aegis/pkg/plugins/providers"
 	"aegis/pkg/tokengen"
-	"time"
 )
 
 type OAuthUseCases struct {
@@ -17,8 +16,10 @@ type OAuthUseCases struct {
 	UserRepository         secondary.UserRepository
 	RefreshTokenRepository secondary.RefreshTokenRepository
 	StateRepository        secondary.StateRepository
+	InvitationRepository   secondary.OrganizationInvitationRepository
 	UserService            *services.UserService
 	TokenService           *services.TokenService
+	MFAService             *services.MFAService
 }
 
 var _ primary.OAuthUseCasesInterface = (*OAuthUseCases)(nil)
@@ -26,20 +27,25 @@ var _ primary.OAuthUseCasesInterface = (*OAuthUseCases)(nil)
 func NewOAuthUseCases(
 	c entities.Config,
 	p providers.OAuthProviderInterface,
+	tokenService *services.TokenService,
+	mfaService *services.MFAService,
 	userRepository secondary.UserRepository,
 	refreshTokenRepository secondary.RefreshTokenRepository,
 	stateRepository secondary.StateRepository,
+	userIdentityRepository secondary.UserIdentityRepository,
+	invitationRepository secondary.OrganizationInvitationRepository,
 ) *OAuthUseCases {
-	userService := services.NewUserService(userRepository, c)
-	tokenService := services.NewTokenService(refreshTokenRepository, c)
+	userService := services.NewUserService(userRepository, userIdentityRepository, c)
 	return &OAuthUseCases{
 		Config:                 c,
 		Provider:               p,
 		UserRepository:         userRepository,
 		RefreshTokenRepository: refreshTokenRepository,
 		StateRepository:        stateRepository,
+		InvitationRepository:   invitationRepository,
 		UserService:            userService,
 		TokenService:           tokenService,
+		MFAService:             mfaService,
 	}
 }
 
@@ -47,19 +53,48 @@ func (s OAuthUseCases) CheckAuthEnabled() bool {
 	return s.Provider.IsEnabled()
 }
 
-func (s *OAuthUseCases) GetAuthURL(redirectUri string) (string, error) {
+// GetAuthURL starts a login, which accepts the invitation of invitationToken if it is not empty
+func (s *OAuthUseCases) GetAuthURL(redirectUri, invitationToken string, device entities.Device) (string, error) {
 	state, err := tokengen.Generate("state_", 13)
 	if err != nil {
 		return "", err
 	}
-	if err := s.StateRepository.CreateState(entities.NewState(state)); err != nil {
+	serverState := entities.NewState(state, device.ID)
+	if invitationToken != "" {
+		invitation, err := s.InvitationRepository.GetPendingInvitationByToken(invitationToken)
+		if err != nil {
+			return "", err
+		}
+		serverState.InvitationID = invitation.ID
+	}
+	return s.redirectURL(serverState)
+}
+
+// GetLinkURL starts the link of an account at the provider to the logged in user
+func (s *OAuthUseCases) GetLinkURL(accessToken, redirectUri string, device entities.Device) (string, error) {
+	cc, err := s.TokenService.ReadAccessTokenClaims(accessToken)
+	if err != nil {
 		return "", err
 	}
-	redirectURL := s.Provider.GetOauthRedirectURL(state)
+	state, err := tokengen.Generate("state_", 13)
+	if err != nil {
+		return "", err
+	}
+	return s.redirectURL(entities.NewLinkState(state, device.ID, cc.UserID))
+}
+
+func (s *OAuthUseCases) redirectURL(state entities.State) (string, error) {
+	if err := s.StateRepository.CreateState(state); err != nil {
+		return "", err
+	}
+	redirectURL := s.Provider.GetOauthRedirectURL(state.Value)
+	if redirectURL == "" {
+		return "", apperrors.ErrProviderUnavailable
+	}
 	return redirectURL, nil
 }
 
-func (s OAuthUseCases) ExchangeCode(code, state string) (*entities.TokenPair, error) {
+func (s OAuthUseCases) ExchangeCode(code, state string, device entities.Device) (*entities.TokenPair, error) {
 	serverState, err := s.StateRepository.GetAndDeleteState(state)
 	if err != nil {
 		return nil, apperrors.ErrInvalidState
@@ -67,33 +102,44 @@ func (s OAuthUseCases) ExchangeCode(code, state string) (*entities.TokenPair, er
 	if serverState.IsExpired() {
 		return nil, apperrors.ErrInvalidState
 	}
+	// A login started on another device: someone is trying to log the user into their account
+	if serverState.DeviceID != "" && serverState.DeviceID != device.ID {
+		return nil, apperrors.ErrInvalidState
+	}
 
 	userInfos, err := s.Provider.ExchangeCodeForUserInfos(code, state)
 	if err != nil {
 		return nil, err
 	}
 
-	user, err := s.UserService.GetOrCreateUserIfAllowed(userInfos, s.Provider.GetName())
-	if err != nil {
-		return nil, err
+	if serverState.LinkUserID != "" {
+		return nil, s.UserService.LinkIdentity(serverState.LinkUserID, userInfos, s.Provider.GetName())
 	}
 
-	if user.AuthMethod != s.Provider.GetName() {
-		return nil, apperrors.ErrWrongAuthMethod
+	var invitation *entities.OrganizationInvitation
+	if serverState.InvitationID != "" {
+		pending, err := s.InvitationRepository.GetPendingInvitation(serverState.InvitationID)
+		if err != nil {
+			return nil, err
+		}
+		// Anyone can follow the link, the invitation is for the owner of the email
+		if !userInfos.EmailVerified || !pending.IsFor(userInfos.Email) {
+			return nil, apperrors.ErrInvitationEmailMismatch
+		}
+		invitation = &pending
 	}
 
-	// todo device-id: pass one, since one session per device is allowed
-	accessToken, atExpiresAt, newRefreshToken, rtExpiresAt, err := s.TokenService.GenerateTokensForUser(user, "device-id")
+	user, err := s.UserService.GetOrCreateUserIfAllowed(userInfos, s.Provider.GetName(), invitation != nil)
 	if err != nil {
 		return nil, err
 	}
 
-	result := &entities.TokenPair{
-		AccessToken:           accessToken,
-		AccessTokenExpiresAt:  time.Unix(atExpiresAt, 0),
-		RefreshToken:          newRefreshToken,
-		RefreshTokenExpiresAt: time.Unix(rtExpiresAt, 0),
+	if invitation != nil {
+		member := entities.NewOrganizationMember(invitation.OrganizationID, user.ID, invitation.Role)
+		if err := s.InvitationRepository.AcceptInvitation(invitation.ID, member); err != nil {
+			return nil, err
+		}
 	}
 
-	return result, nil
+	return s.MFAService.LogIn(user, device, []string{entities.AMRFederated})
 }
@@ -0,0 +1,265 @@
+package usecases
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/ports/primary"
+	"aegis/internal/domain/ports/secondary"
+	"aegis/internal/domain/services"
+	"aegis/pkg/apperrors"
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
+)
+
+// OrganizationUseCases manage the organizations of the logged in user and their members
+type OrganizationUseCases struct {
+	Config                 entities.Config
+	OrganizationRepository secondary.OrganizationRepository
+	InvitationRepository   secondary.OrganizationInvitationRepository
+	UserRepository         secondary.UserRepository
+	RefreshTokenRepository secondary.RefreshTokenRepository
+	TokenService           *services.TokenService
+}
+
+var _ primary.OrganizationUseCasesInterface = (*OrganizationUseCases)(nil)
+
+func NewOrganizationUseCases(c entities.Config, o secondary.OrganizationRepository, i secondary.OrganizationInvitationRepository, u secondary.UserRepository, r secondary.RefreshTokenRepository, t *services.TokenService) *OrganizationUseCases {
+	return &OrganizationUseCases{
+		Config:                 c,
+		OrganizationRepository: o,
+		InvitationRepository:   i,
+		UserRepository:         u,
+		RefreshTokenRepository: r,
+		TokenService:           t,
+	}
+}
+
+// CreateOrganization creates an organization owned by the user
+func (s OrganizationUseCases) CreateOrganization(accessToken, name string) (entities.Organization, error) {
+	cc, err := s.TokenService.ReadAccessTokenClaims(accessToken)
+	if err != nil {
+		return entities.Organization{}, err
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 100 {
+		return entities.Organization{}, apperrors.ErrNoOrganizationName
+	}
+	organization := entities.NewOrganization(name)
+	owner := entities.NewOrganizationMember(organization.ID, cc.UserID, entities.OrganizationRoleOwner)
+	if err := s.OrganizationRepository.CreateOrganization(organization, owner); err != nil {
+		return entities.Organization{}, err
+	}
+	return organization, nil
+}
+
+func (s OrganizationUseCases) ListOrganizations(accessToken string) ([]entities.OrganizationMembership, error) {
+	cc, err := s.TokenService.ReadAccessTokenClaims(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	members, err := s.OrganizationRepository.GetOrganizationsForUser(cc.UserID)
+	if err != nil {
+		return nil, err
+	}
+	memberships := make([]entities.OrganizationMembership, 0, len(members))
+	for _, member := range members {
+		memberships = append(memberships, entities.NewOrganizationMembership(member, cc.OrganizationID))
+	}
+	return memberships, nil
+}
+
+// SwitchOrganization reissues the tokens of the session for an organization of the user,
+// or outside of the organizations with an empty organizationID
+func (s OrganizationUseCases) SwitchOrganization(accessToken, refreshToken, organizationID string, device entities.Device) (*entities.TokenPair, error) {
+	cc, err := s.TokenService.ReadAccessTokenClaims(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	refreshTokenObject, err := s.RefreshTokenRepository.GetRefreshTokenByToken(refreshToken)
+	if err != nil || refreshTokenObject.UserID != cc.UserID || refreshTokenObject.IsExpired() {
+		return nil, apperrors.ErrRefreshTokenInvalid
+	}
+	if err := s.TokenService.CheckDevice(refreshTokenObject, device); err != nil {
+		return nil, err
+	}
+	user, err := s.UserRepository.GetUserByID(cc.UserID)
+	if err != nil {
+		return nil, err
+	}
+	newAccessToken, atExpiresAt, newRefreshToken, rtExpiresAt, err := s.TokenService.SwitchOrganization(user, refreshTokenObject, organizationID, device)
+	if err != nil {
+		return nil, err
+	}
+	return &entities.TokenPair{
+		AccessToken:           newAccessToken,
+		AccessTokenExpiresAt:  time.Unix(atExpiresAt, 0),
+		RefreshToken:          newRefreshToken,
+		RefreshTokenExpiresAt: time.Unix(rtExpiresAt, 0),
+	}, nil
+}
+
+// ListMembers returns the members of an organization of the user
+func (s OrganizationUseCases) ListMembers(accessToken, organizationID string) ([]entities.OrganizationMemberDetails, error) {
+	if _, err := s.currentMember(accessToken, organizationID); err != nil {
+		return nil, err
+	}
+	members, err := s.OrganizationRepository.GetOrganizationMembers(organizationID)
+	if err != nil {
+		return nil, err
+	}
+	membersDetails := make([]entities.OrganizationMemberDetails, 0, len(members))
+	for _, member := range members {
+		membersDetails = append(membersDetails, entities.NewOrganizationMemberDetails(member))
+	}
+	return membersDetails, nil
+}
+
+// SetMemberRole changes the role of a member, for the owners and the admins. Users join with an invitation only.
+// Only the owners can make or unmake owners, and the organization keeps at least one owner.
+func (s OrganizationUseCases) SetMemberRole(accessToken, organizationID, userID, role string) error {
+	actor, err := s.currentMember(accessToken, organizationID)
+	if err != nil {
+		return err
+	}
+	if !actor.CanManageMembers() {
+		return apperrors.ErrOrganizationForbidden
+	}
+	if !slices.Contains(s.Config.OrganizationRoles(), role) {
+		return apperrors.ErrUnknownOrganizationRole
+	}
+	member, err := s.OrganizationRepository.GetOrganizationMember(organizationID, userID)
+	if err != nil {
+		return err
+	}
+	wasOwner := member.Role == entities.OrganizationRoleOwner
+	if (wasOwner || role == entities.OrganizationRoleOwner) && actor.Role != entities.OrganizationRoleOwner {
+		return apperrors.ErrOrganizationForbidden
+	}
+	if wasOwner && role != entities.OrganizationRoleOwner {
+		if err := s.keepAnOwner(organizationID); err != nil {
+			return err
+		}
+	}
+	return s.OrganizationRepository.UpdateOrganizationMemberRole(organizationID, userID, role)
+}
+
+// RemoveMember removes a user from the organization, for the owners and the admins. Any member can leave.
+func (s OrganizationUseCases) RemoveMember(accessToken, organizationID, userID string) error {
+	actor, err := s.currentMember(accessToken, organizationID)
+	if err != nil {
+		return err
+	}
+	member, err := s.OrganizationRepository.GetOrganizationMember(organizationID, userID)
+	if err != nil {
+		return err
+	}
+	if actor.UserID != member.UserID {
+		if !actor.CanManageMembers() || (member.Role == entities.OrganizationRoleOwner && actor.Role != entities.OrganizationRoleOwner) {
+			return apperrors.ErrOrganizationForbidden
+		}
+	}
+	if member.Role == entities.OrganizationRoleOwner {
+		if err := s.keepAnOwner(organizationID); err != nil {
+			return err
+		}
+	}
+	return s.OrganizationRepository.DeleteOrganizationMember(organizationID, userID)
+}
+
+// CreateInvitation invites the owner of an email to join the organization, for the owners and the admins.
+// Only the owners can invite owners. The returned invitation holds its plain token, which is not stored.
+func (s OrganizationUseCases) CreateInvitation(accessToken, organizationID, email, role string) (entities.OrganizationInvitation, error) {
+	actor, err := s.currentMember(accessToken, organizationID)
+	if err != nil {
+		return entities.OrganizationInvitation{}, err
+	}
+	if !actor.CanManageMembers() || (role == entities.OrganizationRoleOwner && actor.Role != entities.OrganizationRoleOwner) {
+		return entities.OrganizationInvitation{}, apperrors.ErrOrganizationForbidden
+	}
+	if !slices.Contains(s.Config.OrganizationRoles(), role) {
+		return entities.OrganizationInvitation{}, apperrors.ErrUnknownOrganizationRole
+	}
+	email = strings.TrimSpace(email)
+	if _, err := mail.ParseAddress(email); err != nil || len(email) > 100 {
+		return entities.OrganizationInvitation{}, apperrors.ErrInvalidInvitationEmail
+	}
+	invitation, err := entities.NewOrganizationInvitation(organizationID, email, role, actor.UserID, s.Config.InvitationLifetime())
+	if err != nil {
+		return entities.OrganizationInvitation{}, err
+	}
+	if err := s.InvitationRepository.CreateInvitation(invitation); err != nil {
+		return entities.OrganizationInvitation{}, err
+	}
+	return invitation, nil
+}
+
+// ListInvitations returns the invitations of the organization that can still be accepted, for the owners and the admins
+func (s OrganizationUseCases) ListInvitations(accessToken, organizationID string) ([]entities.OrganizationInvitation, error) {
+	actor, err := s.currentMember(accessToken, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	if !actor.CanManageMembers() {
+		return nil, apperrors.ErrOrganizationForbidden
+	}
+	return s.InvitationRepository.GetPendingInvitations(organizationID)
+}
+
+// RevokeInvitation deletes an invitation of the organization, for the owners and the admins
+func (s OrganizationUseCases) RevokeInvitation(accessToken, organizationID, invitationID string) error {
+	actor, err := s.currentMember(accessToken, organizationID)
+	if err != nil {
+		return err
+	}
+	if !actor.CanManageMembers() {
+		return apperrors.ErrOrganizationForbidden
+	}
+	return s.InvitationRepository.DeleteInvitation(organizationID, invitationID)
+}
+
+// AcceptInvitation adds the logged in user to the organization of the invitation, which must have been sent to their email.
+// A user who is already a member keeps their role.
+func (s OrganizationUseCases) AcceptInvitation(accessToken, invitationToken string) (entities.Organization, error) {
+	cc, err := s.TokenService.ReadAccessTokenClaims(accessToken)
+	if err != nil {
+		return entities.Organization{}, err
+	}
+	user, err := s.UserRepository.GetUserByID(cc.UserID)
+	if err != nil {
+		return entities.Organization{}, err
+	}
+	invitation, err := s.InvitationRepository.GetPendingInvitationByToken(invitationToken)
+	if err != nil {
+		return entities.Organization{}, err
+	}
+	if !invitation.IsFor(user.Email) {
+		return entities.Organization{}, apperrors.ErrInvitationEmailMismatch
+	}
+	member := entities.NewOrganizationMember(invitation.OrganizationID, user.ID, invitation.Role)
+	if err := s.InvitationRepository.AcceptInvitation(invitation.ID, member); err != nil {
+		return entities.Organization{}, err
+	}
+	return invitation.Organization, nil
+}
+
+// currentMember returns the membership of the user of the access token, who must be a member of the organization
+func (s OrganizationUseCases) currentMember(accessToken, organizationID string) (entities.OrganizationMember, error) {
+	cc, err := s.TokenService.ReadAccessTokenClaims(accessToken)
+	if err != nil {
+		return entities.OrganizationMember{}, err
+	}
+	return s.OrganizationRepository.GetOrganizationMember(organizationID, cc.UserID)
+}
+
+// keepAnOwner fails with ErrLastOrganizationOwner if the organization has a single owner left
+func (s OrganizationUseCases) keepAnOwner(organizationID string) error {
+	owners, err := s.OrganizationRepository.CountOrganizationOwners(organizationID)
+	if err != nil {
+		return err
+	}
+	if owners <= 1 {
+		return apperrors.ErrLastOrganizationOwner
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package usecases
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/services"
+	"aegis/internal/infrastructure/repositories"
+	"aegis/pkg/apperrors"
+	"errors"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestOrganizationUseCases(t *testing.T) {
+	baseConfig := entities.Config{
+		JWT: entities.JWTConfig{
+			Secret:                     "some-secret",
+			AccessTokenExpirationMin:   1,
+			RefreshTokenExpirationDays: 1,
+		},
+	}
+	baseConfig.Organizations.Roles = []string{"billing"}
+	prepare := func(t *testing.T) (*OrganizationUseCases, *UseCases, *services.TokenService) {
+		db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.AutoMigrate(&entities.User{}, &entities.RefreshToken{}, &entities.Role{}, &entities.SecurityEvent{}, &entities.Organization{}, &entities.OrganizationMember{}, &entities.OrganizationInvitation{})
+		refreshTokenRepository := repositories.NewRefreshTokenRepository(db, "some-pepper")
+		userRepository := repositories.NewUserRepository(db)
+		organizationRepository := repositories.NewOrganizationRepository(db)
+		keyService, err := services.NewKeyService(baseConfig, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokenService := services.NewTokenService(refreshTokenRepository, repositories.NewSecurityEventRepository(db), organizationRepository, keyService, baseConfig)
+		authService := NewService(baseConfig, refreshTokenRepository, userRepository, keyService, tokenService)
+		return NewOrganizationUseCases(baseConfig, organizationRepository, repositories.NewOrganizationInvitationRepository(db, "some-pepper"), userRepository, refreshTokenRepository, tokenService), authService, tokenService
+	}
+	// login creates a user and returns the tokens of their session
+	login := func(t *testing.T, organizationUseCases *OrganizationUseCases, name string) (entities.User, string, string) {
+		user, err := entities.NewUser(name, "", name+"@example.com", "github")
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if err := organizationUseCases.UserRepository.CreateUser(user, []entities.Role{entities.NewRole(user.ID, "user")}); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		user, _ = organizationUseCases.UserRepository.GetUserByID(user.ID)
+		accessToken, _, refreshToken, _, err := organizationUseCases.TokenService.GenerateTokensForUser(user, testDevice, nil)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		return user, accessToken, refreshToken
+	}
+	// join makes the user accept an invitation to the organization with the role
+	join := func(t *testing.T, organizationUseCases *OrganizationUseCases, ownerAccessToken, organizationID string, user entities.User, accessToken, role string) {
+		invitation, err := organizationUseCases.CreateInvitation(ownerAccessToken, organizationID, user.Email, role)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if _, err := organizationUseCases.AcceptInvitation(accessToken, invitation.Token); err != nil {
+			t.Fatal("expected no error", err)
+		}
+	}
+	t.Run("the creator owns the organization", func(t *testing.T) {
+		organizationUseCases, _, _ := prepare(t)
+		_, accessToken, _ := login(t, organizationUseCases, "owner")
+		organization, err := organizationUseCases.CreateOrganization(accessToken, " Acme ")
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if organization.Name != "Acme" {
+			t.Fatal("expected the name to be trimmed", organization.Name)
+		}
+		organizations, err := organizationUseCases.ListOrganizations(accessToken)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if len(organizations) != 1 || organizations[0].Role != entities.OrganizationRoleOwner || organizations[0].Active {
+			t.Fatal("expected the user to own the organization", organizations)
+		}
+		if _, err := organizationUseCases.CreateOrganization(accessToken, " "); !errors.Is(err, apperrors.ErrNoOrganizationName) {
+			t.Fatal("expected error ErrNoOrganizationName", err)
+		}
+	})
+	t.Run("switching puts the organization in the access tokens of the session", func(t *testing.T) {
+		organizationUseCases, authService, tokenService := prepare(t)
+		_, accessToken, refreshToken := login(t, organizationUseCases, "owner")
+		organization, _ := organizationUseCases.CreateOrganization(accessToken, "Acme")
+		tokensPair, err := organizationUseCases.SwitchOrganization(accessToken, refreshToken, organization.ID, testDevice)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		cc, _ := tokenService.ReadAccessTokenClaims(tokensPair.AccessToken)
+		if cc.OrganizationID != organization.ID || cc.OrganizationRole != entities.OrganizationRoleOwner {
+			t.Fatal("expected the organization in the claims", cc)
+		}
+		refreshed, err := authService.CheckAndRefreshToken(tokensPair.AccessToken, tokensPair.RefreshToken, true, testDevice)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		cc, _ = tokenService.ReadAccessTokenClaims(refreshed.AccessToken)
+		if cc.OrganizationID != organization.ID {
+			t.Fatal("expected the organization to survive the refresh", cc)
+		}
+		tokensPair, err = organizationUseCases.SwitchOrganization(refreshed.AccessToken, refreshed.RefreshToken, "", testDevice)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		cc, _ = tokenService.ReadAccessTokenClaims(tokensPair.AccessToken)
+		if cc.OrganizationID != "" || cc.OrganizationRole != "" {
+			t.Fatal("expected no organization in the claims", cc)
+		}
+	})
+	t.Run("a user can not switch to an organization they are not a member of", func(t *testing.T) {
+		organizationUseCases, _, _ := prepare(t)
+		_, ownerAccessToken, _ := login(t, organizationUseCases, "owner")
+		organization, _ := organizationUseCases.CreateOrganization(ownerAccessToken, "Acme")
+		_, accessToken, refreshToken := login(t, organizationUseCases, "stranger")
+		_, err := organizationUseCases.SwitchOrganization(accessToken, refreshToken, organization.ID, testDevice)
+		if !errors.Is(err, apperrors.ErrNotOrganizationMember) {
+			t.Fatal("expected error ErrNotOrganizationMember", err)
+		}
+		if _, err := organizationUseCases.ListMembers(accessToken, organization.ID); !errors.Is(err, apperrors.ErrNotOrganizationMember) {
+			t.Fatal("expected error ErrNotOrganizationMember", err)
+		}
+	})
+	t.Run("a removed member loses the organization on their next refresh", func(t *testing.T) {
+		organizationUseCases, authService, tokenService := prepare(t)
+		_, ownerAccessToken, _ := login(t, organizationUseCases, "owner")
+		organization, _ := organizationUseCases.CreateOrganization(ownerAccessToken, "Acme")
+		member, accessToken, refreshToken := login(t, organizationUseCases, "member")
+		join(t, organizationUseCases, ownerAccessToken, organization.ID, member, accessToken, "billing")
+		tokensPair, err := organizationUseCases.SwitchOrganization(accessToken, refreshToken, organization.ID, testDevice)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if err := organizationUseCases.RemoveMember(ownerAccessToken, organization.ID, member.ID); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		refreshed, err := authService.CheckAndRefreshToken(tokensPair.AccessToken, tokensPair.RefreshToken, true, testDevice)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		cc, _ := tokenService.ReadAccessTokenClaims(refreshed.AccessToken)
+		if cc.OrganizationID != "" {
+			t.Fatal("expected the organization to be dropped", cc)
+		}
+	})
+	t.Run("only the owners manage the owners", func(t *testing.T) {
+		organizationUseCases, _, _ := prepare(t)
+		owner, ownerAccessToken, _ := login(t, organizationUseCases, "owner")
+		organization, _ := organizationUseCases.CreateOrganization(ownerAccessToken, "Acme")
+		admin, adminAccessToken, _ := login(t, organizationUseCases, "admin")
+		join(t, organizationUseCases, ownerAccessToken, organization.ID, admin, adminAccessToken, entities.OrganizationRoleAdmin)
+		other, otherAccessToken, _ := login(t, organizationUseCases, "other")
+		if err := organizationUseCases.SetMemberRole(adminAccessToken, organization.ID, other.ID, entities.OrganizationRoleMember); !errors.Is(err, apperrors.ErrNotOrganizationMember) {
+			t.Fatal("expected error ErrNotOrganizationMember, users join with an invitation", err)
+		}
+		join(t, organizationUseCases, ownerAccessToken, organization.ID, other, otherAccessToken, entities.OrganizationRoleMember)
+		if err := organizationUseCases.SetMemberRole(adminAccessToken, organization.ID, other.ID, "billing"); err != nil {
+			t.Fatal("expected admins to change the roles of the members", err)
+		}
+		if err := organizationUseCases.SetMemberRole(adminAccessToken, organization.ID, other.ID, entities.OrganizationRoleOwner); !errors.Is(err, apperrors.ErrOrganizationForbidden) {
+			t.Fatal("expected error ErrOrganizationForbidden", err)
+		}
+		if err := organizationUseCases.RemoveMember(adminAccessToken, organization.ID, owner.ID); !errors.Is(err, apperrors.ErrOrganizationForbidden) {
+			t.Fatal("expected error ErrOrganizationForbidden", err)
+		}
+		if err := organizationUseCases.SetMemberRole(adminAccessToken, organization.ID, other.ID, "intern"); !errors.Is(err, apperrors.ErrUnknownOrganizationRole) {
+			t.Fatal("expected error ErrUnknownOrganizationRole", err)
+		}
+		members, _ := organizationUseCases.ListMembers(adminAccessToken, organization.ID)
+		if len(members) != 3 || members[0].UserID != owner.ID || members[0].Name != "owner" {
+			t.Fatal("expected the 3 members, owner first", members)
+		}
+	})
+	t.Run("the last owner can not leave", func(t *testing.T) {
+		organizationUseCases, _, _ := prepare(t)
+		owner, ownerAccessToken, _ := login(t, organizationUseCases, "owner")
+		organization, _ := organizationUseCases.CreateOrganization(ownerAccessToken, "Acme")
+		if err := organizationUseCases.RemoveMember(ownerAccessToken, organization.ID, owner.ID); !errors.Is(err, apperrors.ErrLastOrganizationOwner) {
+			t.Fatal("expected error ErrLastOrganizationOwner", err)
+		}
+		if err := organizationUseCases.SetMemberRole(ownerAccessToken, organization.ID, owner.ID, entities.OrganizationRoleMember); !errors.Is(err, apperrors.ErrLastOrganizationOwner) {
+			t.Fatal("expected error ErrLastOrganizationOwner", err)
+		}
+		other, otherAccessToken, _ := login(t, organizationUseCases, "other")
+		join(t, organizationUseCases, ownerAccessToken, organization.ID, other, otherAccessToken, entities.OrganizationRoleMember)
+		if err := organizationUseCases.RemoveMember(otherAccessToken, organization.ID, other.ID); err != nil {
+			t.Fatal("expected a member to leave", err)
+		}
+	})
+	t.Run("an invitation is accepted once by the owner of the email", func(t *testing.T) {
+		organizationUseCases, _, _ := prepare(t)
+		_, ownerAccessToken, _ := login(t, organizationUseCases, "owner")
+		organization, _ := organizationUseCases.CreateOrganization(ownerAccessToken, "Acme")
+		invitation, err := organizationUseCases.CreateInvitation(ownerAccessToken, organization.ID, "Invited@example.com", "billing")
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		_, otherAccessToken, _ := login(t, organizationUseCases, "other")
+		if _, err := organizationUseCases.AcceptInvitation(otherAccessToken, invitation.Token); !errors.Is(err, apperrors.ErrInvitationEmailMismatch) {
+			t.Fatal("expected error ErrInvitationEmailMismatch", err)
+		}
+		invited, invitedAccessToken, _ := login(t, organizationUseCases, "invited")
+		accepted, err := organizationUseCases.AcceptInvitation(invitedAccessToken, invitation.Token)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if accepted.ID != organization.ID {
+			t.Fatal("expected the organization of the invitation", accepted)
+		}
+		member, _ := organizationUseCases.OrganizationRepository.GetOrganizationMember(organization.ID, invited.ID)
+		if member.Role != "billing" {
+			t.Fatal("expected the role of the invitation", member.Role)
+		}
+		if _, err := organizationUseCases.AcceptInvitation(invitedAccessToken, invitation.Token); !errors.Is(err, apperrors.ErrInvitationInvalid) {
+			t.Fatal("expected error ErrInvitationInvalid", err)
+		}
+		invitations, _ := organizationUseCases.ListInvitations(ownerAccessToken, organization.ID)
+		if len(invitations) != 0 {
+			t.Fatal("expected no pending invitation", invitations)
+		}
+	})
+	t.Run("only the owners and the admins invite", func(t *testing.T) {
+		organizationUseCases, _, _ := prepare(t)
+		_, ownerAccessToken, _ := login(t, organizationUseCases, "owner")
+		organization, _ := organizationUseCases.CreateOrganization(ownerAccessToken, "Acme")
+		member, memberAccessToken, _ := login(t, organizationUseCases, "member")
+		join(t, organizationUseCases, ownerAccessToken, organization.ID, member, memberAccessToken, entities.OrganizationRoleMember)
+		if _, err := organizationUseCases.CreateInvitation(memberAccessToken, organization.ID, "invited@example.com", entities.OrganizationRoleMember); !errors.Is(err, apperrors.ErrOrganizationForbidden) {
+			t.Fatal("expected error ErrOrganizationForbidden", err)
+		}
+		if _, err := organizationUseCases.CreateInvitation(ownerAccessToken, organization.ID, "not-an-email", entities.OrganizationRoleMember); !errors.Is(err, apperrors.ErrInvalidInvitationEmail) {
+			t.Fatal("expected error ErrInvalidInvitationEmail", err)
+		}
+		invitation, _ := organizationUseCases.CreateInvitation(ownerAccessToken, organization.ID, "invited@example.com", entities.OrganizationRoleMember)
+		if err := organizationUseCases.RevokeInvitation(memberAccessToken, organization.ID, invitation.ID); !errors.Is(err, apperrors.ErrOrganizationForbidden) {
+			t.Fatal("expected error ErrOrganizationForbidden", err)
+		}
+		if err := organizationUseCases.RevokeInvitation(ownerAccessToken, organization.ID, invitation.ID); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		_, invitedAccessToken, _ := login(t, organizationUseCases, "invited")
+		if _, err := organizationUseCases.AcceptInvitation(invitedAccessToken, invitation.Token); !errors.Is(err, apperrors.ErrInvitationInvalid) {
+			t.Fatal("expected a revoked invitation to be invalid", err)
+		}
+	})
+}
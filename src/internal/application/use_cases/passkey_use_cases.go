@@ -0,0 +1,215 @@
+package usecases
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/ports/primary"
+	"aegis/internal/domain/ports/secondary"
+	"aegis/internal/domain/services"
+	"aegis/pkg/apperrors"
+	"aegis/pkg/webauthn"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// PasskeyUseCases register the passkeys of the logged in users, and log users in with them
+type PasskeyUseCases struct {
+	Config                  entities.Config
+	RelyingParty            webauthn.RelyingParty
+	PasskeyRepository       secondary.PasskeyRepository
+	UserRepository          secondary.UserRepository
+	SecurityEventRepository secondary.SecurityEventRepository
+	UserService             *services.UserService
+	TokenService            *services.TokenService
+	MFAService              *services.MFAService
+}
+
+var _ primary.PasskeyUseCasesInterface = (*PasskeyUseCases)(nil)
+
+func NewPasskeyUseCases(
+	c entities.Config,
+	tokenService *services.TokenService,
+	mfaService *services.MFAService,
+	userRepository secondary.UserRepository,
+	userIdentityRepository secondary.UserIdentityRepository,
+	passkeyRepository secondary.PasskeyRepository,
+	securityEventRepository secondary.SecurityEventRepository,
+) *PasskeyUseCases {
+	return &PasskeyUseCases{
+		Config: c,
+		RelyingParty: webauthn.RelyingParty{
+			ID:                      c.PasskeyRPID(),
+			Origins:                 c.PasskeyOrigins(),
+			RequireUserVerification: c.Auth.Providers.Passkeys.RequireUserVerification,
+		},
+		PasskeyRepository:       passkeyRepository,
+		UserRepository:          userRepository,
+		SecurityEventRepository: securityEventRepository,
+		UserService:             services.NewUserService(userRepository, userIdentityRepository, c),
+		TokenService:            tokenService,
+		MFAService:              mfaService,
+	}
+}
+
+func (s PasskeyUseCases) CheckAuthEnabled() bool {
+	return s.Config.Auth.Providers.Passkeys.Enabled
+}
+
+func (s PasskeyUseCases) BeginRegistration(accessToken string, device entities.Device) (webauthn.CreationOptions, error) {
+	cc, err := s.TokenService.ReadAccessTokenClaims(accessToken)
+	if err != nil {
+		return webauthn.CreationOptions{}, err
+	}
+	user, err := s.UserRepository.GetUserByID(cc.UserID)
+	if err != nil {
+		return webauthn.CreationOptions{}, err
+	}
+	passkeys, err := s.PasskeyRepository.GetPasskeysForUser(user.ID)
+	if err != nil {
+		return webauthn.CreationOptions{}, err
+	}
+	exclude := make([]string, 0, len(passkeys))
+	for _, passkey := range passkeys {
+		exclude = append(exclude, passkey.CredentialID)
+	}
+	challenge, err := entities.NewPasskeyChallenge(device.ID, user.ID)
+	if err != nil {
+		return webauthn.CreationOptions{}, err
+	}
+	if err := s.PasskeyRepository.CreatePasskeyChallenge(challenge); err != nil {
+		return webauthn.CreationOptions{}, err
+	}
+	return s.RelyingParty.CreationOptions(s.Config.App.Name, challenge.Challenge, user.ID, user.Email, user.Name, exclude), nil
+}
+
+func (s PasskeyUseCases) FinishRegistration(accessToken, name string, clientDataJSON, attestationObject []byte, device entities.Device) (entities.Passkey, error) {
+	cc, err := s.TokenService.ReadAccessTokenClaims(accessToken)
+	if err != nil {
+		return entities.Passkey{}, err
+	}
+	challenge, err := s.getChallenge(clientDataJSON, device)
+	if err != nil {
+		return entities.Passkey{}, err
+	}
+	if challenge.UserID != cc.UserID {
+		return entities.Passkey{}, apperrors.ErrPasskeyInvalid
+	}
+	credential, err := s.RelyingParty.VerifyRegistration(challenge.Challenge, clientDataJSON, attestationObject)
+	if err != nil {
+		return entities.Passkey{}, apperrors.ErrPasskeyInvalid
+	}
+	name = strings.TrimSpace(name)
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	passkey := entities.NewPasskey(
+		cc.UserID,
+		base64.RawURLEncoding.EncodeToString(credential.ID),
+		base64.RawURLEncoding.EncodeToString(credential.PublicKey),
+		credential.SignCount,
+		name)
+	if err := s.PasskeyRepository.CreatePasskey(passkey); err != nil {
+		return entities.Passkey{}, err
+	}
+	return passkey, nil
+}
+
+func (s PasskeyUseCases) BeginLogin(device entities.Device) (webauthn.RequestOptions, error) {
+	challenge, err := entities.NewPasskeyChallenge(device.ID, "")
+	if err != nil {
+		return webauthn.RequestOptions{}, err
+	}
+	if err := s.PasskeyRepository.CreatePasskeyChallenge(challenge); err != nil {
+		return webauthn.RequestOptions{}, err
+	}
+	return s.RelyingParty.RequestOptions(challenge.Challenge), nil
+}
+
+// FinishLogin rejects a passkey whose signature counter went backwards, and records it for the admins:
+// the user logs in another way and deletes it
+func (s PasskeyUseCases) FinishLogin(credentialID string, clientDataJSON, authenticatorData, signature, userHandle []byte, device entities.Device) (*entities.TokenPair, error) {
+	challenge, err := s.getChallenge(clientDataJSON, device)
+	if err != nil {
+		return nil, err
+	}
+	if challenge.UserID != "" {
+		return nil, apperrors.ErrPasskeyInvalid
+	}
+	passkey, err := s.PasskeyRepository.GetPasskeyByCredentialID(credentialID)
+	if errors.Is(err, apperrors.ErrPasskeyNotFound) {
+		return nil, apperrors.ErrPasskeyInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(userHandle) > 0 && string(userHandle) != passkey.UserID {
+		return nil, apperrors.ErrPasskeyInvalid
+	}
+	publicKey, err := base64.RawURLEncoding.DecodeString(passkey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	signCount, err := s.RelyingParty.VerifyAssertion(challenge.Challenge, webauthn.Credential{
+		PublicKey: publicKey,
+		SignCount: passkey.SignCount,
+	}, clientDataJSON, authenticatorData, signature)
+	if errors.Is(err, webauthn.ErrSignCountMismatch) {
+		event, err := entities.NewSecurityEvent(passkey.UserID, entities.SecurityEventPasskeySignCountMismatch, map[string]string{
+			"passkey_id": passkey.ID,
+			"device_id":  device.ID,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := s.SecurityEventRepository.CreateSecurityEvent(event); err != nil {
+			return nil, err
+		}
+		return nil, apperrors.ErrPasskeyInvalid
+	}
+	if err != nil {
+		return nil, apperrors.ErrPasskeyInvalid
+	}
+	if err := s.PasskeyRepository.UsePasskey(passkey.ID, passkey.SignCount, signCount); err != nil {
+		return nil, err
+	}
+	user, err := s.UserRepository.GetUserByID(passkey.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.UserService.CheckUserAllowed(user); err != nil {
+		return nil, err
+	}
+	return s.MFAService.LogIn(user, device, []string{entities.AMRHardwareKey})
+}
+
+func (s PasskeyUseCases) ListPasskeys(accessToken string) ([]entities.Passkey, error) {
+	cc, err := s.TokenService.ReadAccessTokenClaims(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	return s.PasskeyRepository.GetPasskeysForUser(cc.UserID)
+}
+
+func (s PasskeyUseCases) DeletePasskey(accessToken, passkeyID string) error {
+	cc, err := s.TokenService.ReadAccessTokenClaims(accessToken)
+	if err != nil {
+		return err
+	}
+	return s.PasskeyRepository.DeletePasskey(cc.UserID, passkeyID)
+}
+
+// getChallenge consumes the challenge signed by the authenticator, which must have been asked for by this device
+func (s PasskeyUseCases) getChallenge(clientDataJSON []byte, device entities.Device) (entities.PasskeyChallenge, error) {
+	value, err := webauthn.ParseChallenge(clientDataJSON)
+	if err != nil {
+		return entities.PasskeyChallenge{}, apperrors.ErrPasskeyInvalid
+	}
+	challenge, err := s.PasskeyRepository.GetAndDeletePasskeyChallenge(value)
+	if err != nil {
+		return entities.PasskeyChallenge{}, err
+	}
+	if challenge.IsExpired() || (challenge.DeviceID != "" && challenge.DeviceID != device.ID) {
+		return entities.PasskeyChallenge{}, apperrors.ErrPasskeyInvalid
+	}
+	return challenge, nil
+}
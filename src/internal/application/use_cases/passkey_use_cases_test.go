@@ -0,0 +1,203 @@
+package usecases
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/services"
+	"aegis/internal/infrastructure/repositories"
+	"aegis/pkg/apperrors"
+	"aegis/pkg/webauthn"
+	"aegis/pkg/webauthn/webauthntest"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestPasskeyUseCases(t *testing.T) {
+	baseConfig := entities.Config{
+		JWT: entities.JWTConfig{
+			Secret:                     "some-secret",
+			AccessTokenExpirationMin:   1,
+			RefreshTokenExpirationDays: 1,
+		},
+	}
+	baseConfig.App.Name = "Aegis"
+	baseConfig.App.URL = "https://aegis.example.com"
+	baseConfig.Auth.Providers.Passkeys.Enabled = true
+	type fixture struct {
+		passkeyUseCases         *PasskeyUseCases
+		userRepository          *repositories.UserRepository
+		securityEventRepository *repositories.SecurityEventRepository
+		user                    entities.User
+		accessToken             string
+	}
+	prepare := func(t *testing.T) fixture {
+		db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.AutoMigrate(&entities.User{}, &entities.RefreshToken{}, &entities.Role{}, &entities.SecurityEvent{}, &entities.UserIdentity{}, &entities.Passkey{}, &entities.PasskeyChallenge{}, &entities.TOTPFactor{})
+		keyService, err := services.NewKeyService(baseConfig, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		securityEventRepository := repositories.NewSecurityEventRepository(db)
+		tokenService := services.NewTokenService(repositories.NewRefreshTokenRepository(db, "some-pepper"), securityEventRepository, repositories.NewOrganizationRepository(db), keyService, baseConfig)
+		userRepository := repositories.NewUserRepository(db)
+		user, err := entities.NewUser("someone", "", "someone@example.com", "github")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := userRepository.CreateUser(user, []entities.Role{entities.NewRole(user.ID, entities.RoleUser)}); err != nil {
+			t.Fatal(err)
+		}
+		user, err = userRepository.GetUserByID(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		accessToken, _, _, _, err := tokenService.GenerateTokensForUser(user, testDevice, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fixture{
+			passkeyUseCases:         NewPasskeyUseCases(baseConfig, tokenService, services.NewMFAService(repositories.NewMFARepository(db, "some-pepper", "some-key"), tokenService), userRepository, repositories.NewUserIdentityRepository(db), repositories.NewPasskeyRepository(db), securityEventRepository),
+			userRepository:          userRepository,
+			securityEventRepository: securityEventRepository,
+			user:                    user,
+			accessToken:             accessToken,
+		}
+	}
+	register := func(t *testing.T, f fixture) *webauthntest.Authenticator {
+		authenticator, err := webauthntest.NewAuthenticator("aegis.example.com", "https://aegis.example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		options, err := f.passkeyUseCases.BeginRegistration(f.accessToken, testDevice)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		clientDataJSON, attestationObject, err := authenticator.Create(options.Challenge)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.passkeyUseCases.FinishRegistration(f.accessToken, "Laptop", clientDataJSON, attestationObject, testDevice); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		return authenticator
+	}
+	logIn := func(t *testing.T, f fixture, authenticator *webauthntest.Authenticator) (*entities.TokenPair, error) {
+		options, err := f.passkeyUseCases.BeginLogin(testDevice)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		clientDataJSON, authenticatorData, signature, err := authenticator.Get(options.Challenge)
+		if err != nil {
+			t.Fatal(err)
+		}
+		credentialID := base64.RawURLEncoding.EncodeToString(authenticator.CredentialID)
+		return f.passkeyUseCases.FinishLogin(credentialID, clientDataJSON, authenticatorData, signature, []byte(f.user.ID), testDevice)
+	}
+	t.Run("a registered passkey logs the user in", func(t *testing.T) {
+		f := prepare(t)
+		authenticator := register(t, f)
+		passkeys, err := f.passkeyUseCases.ListPasskeys(f.accessToken)
+		if err != nil || len(passkeys) != 1 || passkeys[0].Name != "Laptop" {
+			t.Fatal("expected the passkey to be listed", passkeys, err)
+		}
+		tokensPair, err := logIn(t, f, authenticator)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		cc, err := f.passkeyUseCases.TokenService.ReadAccessTokenClaims(tokensPair.AccessToken)
+		if err != nil || cc.UserID != f.user.ID {
+			t.Fatal("expected the owner of the passkey to be logged in", cc, err)
+		}
+		passkeys, _ = f.passkeyUseCases.ListPasskeys(f.accessToken)
+		if passkeys[0].SignCount != 1 || passkeys[0].LastUsedAt == nil {
+			t.Fatal("expected the sign count and the last use to be stored", passkeys[0])
+		}
+	})
+	t.Run("excludes the passkeys already registered", func(t *testing.T) {
+		f := prepare(t)
+		authenticator := register(t, f)
+		options, err := f.passkeyUseCases.BeginRegistration(f.accessToken, testDevice)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if len(options.ExcludeCredentials) != 1 || options.ExcludeCredentials[0].ID != base64.RawURLEncoding.EncodeToString(authenticator.CredentialID) {
+			t.Fatal("expected the passkey to be excluded", options.ExcludeCredentials)
+		}
+		clientDataJSON, attestationObject, _ := authenticator.Create(options.Challenge)
+		if _, err := f.passkeyUseCases.FinishRegistration(f.accessToken, "", clientDataJSON, attestationObject, testDevice); !errors.Is(err, apperrors.ErrPasskeyAlreadyRegistered) {
+			t.Fatal("expected error ErrPasskeyAlreadyRegistered", err)
+		}
+	})
+	t.Run("a challenge only works once, for its ceremony and on its device", func(t *testing.T) {
+		f := prepare(t)
+		authenticator := register(t, f)
+		loginOptions, _ := f.passkeyUseCases.BeginLogin(testDevice)
+		clientDataJSON, attestationObject, _ := authenticator.Create(loginOptions.Challenge)
+		if _, err := f.passkeyUseCases.FinishRegistration(f.accessToken, "", clientDataJSON, attestationObject, testDevice); !errors.Is(err, apperrors.ErrPasskeyInvalid) {
+			t.Fatal("expected a login challenge to be rejected for a registration", err)
+		}
+		options, _ := f.passkeyUseCases.BeginLogin(testDevice)
+		clientDataJSON, authenticatorData, signature, _ := authenticator.Get(options.Challenge)
+		credentialID := base64.RawURLEncoding.EncodeToString(authenticator.CredentialID)
+		if _, err := f.passkeyUseCases.FinishLogin(credentialID, clientDataJSON, authenticatorData, signature, nil, entities.Device{ID: "other-device-id"}); !errors.Is(err, apperrors.ErrPasskeyInvalid) {
+			t.Fatal("expected error ErrPasskeyInvalid on another device", err)
+		}
+		if _, err := f.passkeyUseCases.FinishLogin(credentialID, clientDataJSON, authenticatorData, signature, nil, testDevice); !errors.Is(err, apperrors.ErrPasskeyInvalid) {
+			t.Fatal("expected error ErrPasskeyInvalid for a used challenge", err)
+		}
+	})
+	t.Run("rejects a passkey whose sign count went backwards", func(t *testing.T) {
+		f := prepare(t)
+		authenticator := register(t, f)
+		if _, err := logIn(t, f, authenticator); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		authenticator.SignCount = 0
+		if _, err := logIn(t, f, authenticator); !errors.Is(err, apperrors.ErrPasskeyInvalid) {
+			t.Fatal("expected error ErrPasskeyInvalid", err)
+		}
+		events, err := f.securityEventRepository.GetSecurityEventsForUser(f.user.ID, entities.SecurityEventPasskeySignCountMismatch, 10)
+		if err != nil || len(events) != 1 {
+			t.Fatal("expected a security event", events, err)
+		}
+	})
+	t.Run("rejects a blocked user", func(t *testing.T) {
+		f := prepare(t)
+		authenticator := register(t, f)
+		now := time.Now()
+		f.userRepository.SetUserBlockedAt(f.user.ID, &now)
+		if _, err := logIn(t, f, authenticator); !errors.Is(err, apperrors.ErrUserBlocked) {
+			t.Fatal("expected error ErrUserBlocked", err)
+		}
+	})
+	t.Run("rejects an unknown passkey", func(t *testing.T) {
+		f := prepare(t)
+		authenticator, err := webauthntest.NewAuthenticator("aegis.example.com", "https://aegis.example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := logIn(t, f, authenticator); !errors.Is(err, apperrors.ErrPasskeyInvalid) {
+			t.Fatal("expected error ErrPasskeyInvalid", err)
+		}
+	})
+	t.Run("the options scope the passkeys to the app", func(t *testing.T) {
+		f := prepare(t)
+		options, err := f.passkeyUseCases.BeginRegistration(f.accessToken, testDevice)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if options.RP.ID != "aegis.example.com" || options.User.ID != base64.RawURLEncoding.EncodeToString([]byte(f.user.ID)) {
+			t.Fatal("expected the rp id of the app and the user id as handle", options.RP, options.User)
+		}
+		if options.AuthenticatorSelection.UserVerification != "preferred" || options.PubKeyCredParams[0].Alg != webauthn.AlgorithmES256 {
+			t.Fatal("expected user verification to be preferred and ES256 first", options)
+		}
+	})
+}
@@ -6,7 +6,7 @@ import (
 	"aegis/internal/domain/ports/secondary"
 	"aegis/internal/domain/services"
 	"aegis/pkg/apperrors"
-	"aegis/pkg/jwtgen"
+	"aegis/pkg/jwks"
 	"slices"
 	"strings"
 	"time"
@@ -16,23 +16,24 @@ type UseCases struct {
 	Config                 entities.Config
 	RefreshTokenRepository secondary.RefreshTokenRepository
 	UserRepository         secondary.UserRepository
+	KeyService             *services.KeyService
 	TokenService           *services.TokenService
 }
 
 var _ primary.UseCasesInterface = (*UseCases)(nil)
 
-func NewService(c entities.Config, r secondary.RefreshTokenRepository, u secondary.UserRepository) *UseCases {
-	tokenService := services.NewTokenService(r, c)
+func NewService(c entities.Config, r secondary.RefreshTokenRepository, u secondary.UserRepository, k *services.KeyService, t *services.TokenService) *UseCases {
 	return &UseCases{
 		Config:                 c,
 		RefreshTokenRepository: r,
 		UserRepository:         u,
-		TokenService:           tokenService,
+		KeyService:             k,
+		TokenService:           t,
 	}
 }
 
 func (s UseCases) GetSession(accessToken string) (entities.Session, error) {
-	ccMap, err := jwtgen.ReadClaims(accessToken, s.Config.JWT.Secret)
+	ccMap, err := s.KeyService.ReadClaims(accessToken)
 	if err != nil {
 		return entities.Session{}, err
 	}
@@ -56,14 +57,16 @@ func (s UseCases) eraseTokens(err error) (*entities.TokenPair, error) {
 
 func (s UseCases) Logout(refreshToken string) (*entities.TokenPair, error) {
 	if refreshToken != "" {
-		_ = s.RefreshTokenRepository.DeleteRefreshToken(refreshToken)
+		if refreshTokenObject, err := s.RefreshTokenRepository.GetRefreshTokenByToken(refreshToken); err == nil {
+			_ = s.TokenService.RevokeRefreshTokenFamily(refreshTokenObject)
+		}
 	}
 	return s.eraseTokens(nil)
 }
 
-func (s UseCases) CheckAndRefreshToken(accessToken, refreshToken string, forceRefresh bool) (*entities.TokenPair, error) {
+func (s UseCases) CheckAndRefreshToken(accessToken, refreshToken string, forceRefresh bool, device entities.Device) (*entities.TokenPair, error) {
 	if accessToken != "" && !forceRefresh {
-		_, err := jwtgen.ReadClaims(accessToken, s.Config.JWT.Secret)
+		_, err := s.KeyService.ReadClaims(accessToken)
 		if err == nil {
 			return nil, nil
 		}
@@ -81,10 +84,15 @@ func (s UseCases) CheckAndRefreshToken(accessToken, refreshToken string, forceRe
 		}
 		return nil, err
 	}
+	if refreshTokenObject.IsRotated() && !s.TokenService.IsInRefreshGracePeriod(refreshTokenObject) {
+		return nil, s.TokenService.HandleRefreshTokenReuse(refreshTokenObject)
+	}
 	if refreshTokenObject.IsExpired() {
 		return nil, apperrors.ErrRefreshTokenExpired
 	}
-	// todo: check device id
+	if err := s.TokenService.CheckDevice(refreshTokenObject, device); err != nil {
+		return nil, err
+	}
 	user, err := s.UserRepository.GetUserByID(refreshTokenObject.UserID)
 	if err != nil {
 		return nil, err
@@ -98,13 +106,11 @@ func (s UseCases) CheckAndRefreshToken(accessToken, refreshToken string, forceRe
 	if s.Config.App.EarlyAdoptersOnly && !user.IsEarlyAdopter() {
 		return nil, apperrors.ErrEarlyAdoptersOnly
 	}
-
-	err = s.RefreshTokenRepository.DeleteRefreshToken(refreshToken)
-	if err != nil {
+	if err := s.TokenService.CheckSessionLifetime(refreshTokenObject, user); err != nil {
 		return nil, err
 	}
-	// todo device-id: pass one, since one session per device is allowed
-	newAccessToken, atExpiresAt, newRefreshToken, rtExpiresAt, err := s.TokenService.GenerateTokensForUser(user, "device-id")
+
+	newAccessToken, atExpiresAt, newRefreshToken, rtExpiresAt, err := s.TokenService.RotateTokensForUser(user, refreshTokenObject, device)
 	if err != nil {
 		return nil, err
 	}
@@ -116,34 +122,120 @@ func (s UseCases) CheckAndRefreshToken(accessToken, refreshToken string, forceRe
 	}, nil
 }
 
-func (s UseCases) Authorize(accessToken string, authorizedRoles []string) (*entities.CustomClaims, error) {
-	if len(authorizedRoles) == 0 {
+// Authorize checks that the access token holds one of the authorized roles, or all of them with RoleMatchAllOf,
+// and all the required permissions. Roles are matched exactly, "any" lets any valid token through.
+// Permissions are resolved from the roles with the current config, not read from the token.
+func (s UseCases) Authorize(accessToken string, authorizedRoles []string, match string, requiredPermissions []string) (*entities.CustomClaims, error) {
+	if len(authorizedRoles) == 0 && len(requiredPermissions) == 0 {
 		return nil, apperrors.ErrNoRoles
 	}
-	ccMap, err := jwtgen.ReadClaims(accessToken, s.Config.JWT.Secret)
+	if match == "" {
+		match = entities.RoleMatchAnyOf
+	}
+	if match != entities.RoleMatchAnyOf && match != entities.RoleMatchAllOf {
+		return nil, apperrors.ErrInvalidRoleMatch
+	}
+	ccMap, err := s.KeyService.ReadClaims(accessToken)
 	if err != nil {
 		return nil, err
 	}
 	cc, err := entities.NewCusomClaimsFromMap(ccMap)
 	if err != nil {
-		return nil, err
+		return nil, apperrors.ErrAccessTokenInvalid
 	}
-	if slices.Contains(authorizedRoles, "any") {
-		return cc, nil
+	if cc.LegacyRoles && !s.Config.JWT.AcceptLegacyRolesClaim {
+		return nil, apperrors.ErrAccessTokenInvalid
 	}
-	authorized := false
-	for _, authorizedRole := range authorizedRoles {
-		if ccMap["roles"] != nil && strings.Contains(ccMap["roles"].(string), authorizedRole) {
-			authorized = true
-			break
-		}
-	}
-	if !authorized {
+	if len(authorizedRoles) > 0 && !slices.Contains(authorizedRoles, "any") && !cc.HasRoles(authorizedRoles, match) {
 		return nil, apperrors.ErrUnauthorizedRole
 	}
+	cc.Permissions = s.Config.PermissionsFor(cc.Roles)
+	for _, permission := range requiredPermissions {
+		if !slices.Contains(cc.Permissions, permission) {
+			return nil, apperrors.ErrMissingPermission
+		}
+	}
 	return cc, nil
 }
 
+func (s UseCases) GetJWKS() jwks.Set {
+	return s.KeyService.GetJWKS()
+}
+
+// currentSession returns the user of the access token, and the session of the refresh token if it belongs to them
+func (s UseCases) currentSession(accessToken, refreshToken string) (userID, familyID string, err error) {
+	ccMap, err := s.KeyService.ReadClaims(accessToken)
+	if err != nil {
+		return "", "", err
+	}
+	cc, err := entities.NewCusomClaimsFromMap(ccMap)
+	if err != nil {
+		return "", "", err
+	}
+	if refreshToken != "" {
+		// A token rotated by the middleware during this request is still in the family
+		if refreshTokenObject, err := s.RefreshTokenRepository.GetRefreshTokenByToken(refreshToken); err == nil && refreshTokenObject.UserID == cc.UserID {
+			familyID = refreshTokenObject.FamilyID
+		}
+	}
+	return cc.UserID, familyID, nil
+}
+
+func (s UseCases) ListSessions(accessToken, refreshToken string) ([]entities.ActiveSession, error) {
+	userID, familyID, err := s.currentSession(accessToken, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	refreshTokens, err := s.RefreshTokenRepository.GetActiveRefreshTokensForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]entities.ActiveSession, 0, len(refreshTokens))
+	for _, refreshToken := range refreshTokens {
+		sessions = append(sessions, entities.NewActiveSession(refreshToken, familyID))
+	}
+	return sessions, nil
+}
+
+// RevokeSession logs the user out of one of their sessions. Revoking the current session also erases the cookies.
+func (s UseCases) RevokeSession(accessToken, refreshToken, sessionID string) (*entities.TokenPair, error) {
+	userID, familyID, err := s.currentSession(accessToken, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	if sessionID == "" {
+		return nil, apperrors.ErrSessionNotFound
+	}
+	if err := s.RefreshTokenRepository.DeleteRefreshTokenFamilyForUser(userID, sessionID); err != nil {
+		return nil, err
+	}
+	if sessionID == familyID {
+		return s.eraseTokens(nil)
+	}
+	return nil, nil
+}
+
+// RevokeOtherSessions logs the user out of every device but the one of the request
+func (s UseCases) RevokeOtherSessions(accessToken, refreshToken string) error {
+	userID, familyID, err := s.currentSession(accessToken, refreshToken)
+	if err != nil {
+		return err
+	}
+	if familyID == "" {
+		return apperrors.ErrRefreshTokenInvalid
+	}
+	return s.RefreshTokenRepository.DeleteOtherRefreshTokenFamilies(userID, familyID)
+}
+
+// ListEvictedSessions returns the sessions of the user that were revoked because they logged in on one device too many
+func (s UseCases) ListEvictedSessions(accessToken string) ([]entities.EvictedSession, error) {
+	userID, _, err := s.currentSession(accessToken, "")
+	if err != nil {
+		return nil, err
+	}
+	return s.TokenService.GetEvictedSessions(userID)
+}
+
 func (s UseCases) AuthorizeInternalAPICall(key string) error {
 	key = strings.TrimPrefix(key, "Bearer ")
 	for _, k := range s.Config.App.InternalAPIKeys {
@@ -3,9 +3,12 @@ package usecases
 import (
 	"aegis/internal/domain/entities"
 	"aegis/internal/domain/ports/secondary"
+	"aegis/internal/domain/services"
 	"aegis/internal/infrastructure/repositories"
 	"aegis/pkg/apperrors"
 	"aegis/pkg/jwtgen"
+	"aegis/pkg/tokengen"
+	"errors"
 	"testing"
 	"time"
 
@@ -26,15 +29,20 @@ func TestCheckAndRefreshToken(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		db.AutoMigrate(&entities.User{}, &entities.RefreshToken{}, &entities.Role{})
-		refreshTokenRepository := repositories.NewRefreshTokenRepository(db)
+		db.AutoMigrate(&entities.User{}, &entities.RefreshToken{}, &entities.Role{}, &entities.SecurityEvent{})
+		refreshTokenRepository := repositories.NewRefreshTokenRepository(db, "some-pepper")
 		userRepository := repositories.NewUserRepository(db)
-		authService := NewService(baseConfig, refreshTokenRepository, userRepository)
+		keyService, err := services.NewKeyService(baseConfig, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokenService := services.NewTokenService(refreshTokenRepository, repositories.NewSecurityEventRepository(db), repositories.NewOrganizationRepository(db), keyService, baseConfig)
+		authService := NewService(baseConfig, refreshTokenRepository, userRepository, keyService, tokenService)
 		return authService, userRepository, refreshTokenRepository, db
 	}
 	t.Run("invalid access token gets rejected", func(t *testing.T) {
 		authService, _, _, _ := prepare(t)
-		_, err := authService.CheckAndRefreshToken("invalid", "invalid", false)
+		_, err := authService.CheckAndRefreshToken("invalid", "invalid", false, testDevice)
 		if err.Error() != apperrors.ErrRefreshTokenInvalid.Error() {
 			t.Fatal("expected error ErrRefreshTokenInvalid", err)
 		}
@@ -47,12 +55,12 @@ func TestCheckAndRefreshToken(t *testing.T) {
 		}
 		db.Create(&newUser)
 		db.Save(&newUser)
-		refreshToken, _, err := entities.NewRefreshToken(newUser, "some-device-id", baseConfig)
+		refreshToken, _, err := entities.NewRefreshToken(newUser, testDevice.Fingerprint(), baseConfig)
 		if err != nil {
 			t.Fatal("expected no error", err)
 		}
 		refreshToken.ExpiresAt = time.Now().Add(-time.Hour * 24)
-		db.Save(&refreshToken)
+		authService.RefreshTokenRepository.CreateRefreshToken(refreshToken)
 		newUser.Roles = []entities.Role{
 			{UserID: newUser.ID, Value: "user"},
 		}
@@ -65,7 +73,7 @@ func TestCheckAndRefreshToken(t *testing.T) {
 		if err != nil {
 			t.Fatal("expected no error", err)
 		}
-		_, err = authService.CheckAndRefreshToken(accessToken, refreshToken.Token, false)
+		_, err = authService.CheckAndRefreshToken(accessToken, refreshToken.Token, false, testDevice)
 		if err.Error() != apperrors.ErrRefreshTokenExpired.Error() {
 			t.Fatal("expected error ErrRefreshTokenExpired", err)
 		}
@@ -77,11 +85,11 @@ func TestCheckAndRefreshToken(t *testing.T) {
 			t.Fatal("expected no error", err)
 		}
 		db.Create(&newUser)
-		refreshToken, _, err := entities.NewRefreshToken(newUser, "some-device-id", baseConfig)
+		refreshToken, _, err := entities.NewRefreshToken(newUser, testDevice.Fingerprint(), baseConfig)
 		if err != nil {
 			t.Fatal("expected no error", err)
 		}
-		db.Save(&refreshToken)
+		authService.RefreshTokenRepository.CreateRefreshToken(refreshToken)
 		newUser.Roles = []entities.Role{
 			{UserID: newUser.ID, Value: "user"},
 		}
@@ -94,13 +102,13 @@ func TestCheckAndRefreshToken(t *testing.T) {
 		if err != nil {
 			t.Fatal("expected no error", err)
 		}
-		at, err := authService.CheckAndRefreshToken(accessToken, refreshToken.Token, false)
+		at, err := authService.CheckAndRefreshToken(accessToken, refreshToken.Token, false, testDevice)
 		if err != nil {
 			t.Fatal("expected no error", err)
 		}
 		var retrievedRefreshToken entities.RefreshToken
-		db.First(&retrievedRefreshToken, "user_id = ?", newUser.ID)
-		if retrievedRefreshToken.Token == refreshToken.Token {
+		db.First(&retrievedRefreshToken, "user_id = ? AND rotated_at IS NULL", newUser.ID)
+		if retrievedRefreshToken.Token == hashRefreshToken(refreshToken.Token) {
 			t.Fatal("expected refresh token to be different")
 		}
 		if at.AccessToken == accessToken {
@@ -114,11 +122,11 @@ func TestCheckAndRefreshToken(t *testing.T) {
 			t.Fatal("expected no error", err)
 		}
 		db.Create(&newUser)
-		refreshToken, _, err := entities.NewRefreshToken(newUser, "some-device-id", baseConfig)
+		refreshToken, _, err := entities.NewRefreshToken(newUser, testDevice.Fingerprint(), baseConfig)
 		if err != nil {
 			t.Fatal("expected no error", err)
 		}
-		db.Save(&refreshToken)
+		authService.RefreshTokenRepository.CreateRefreshToken(refreshToken)
 		newUser.Roles = []entities.Role{
 			{UserID: newUser.ID, Value: "user"},
 		}
@@ -131,13 +139,13 @@ func TestCheckAndRefreshToken(t *testing.T) {
 		if err != nil {
 			t.Fatal("expected no error", err)
 		}
-		_, err = authService.CheckAndRefreshToken(accessToken, refreshToken.Token, false)
+		_, err = authService.CheckAndRefreshToken(accessToken, refreshToken.Token, false, testDevice)
 		if err != nil {
 			t.Fatal("expected no error", err)
 		}
 		var retrievedRefreshToken entities.RefreshToken
 		db.First(&retrievedRefreshToken, "user_id = ?", newUser.ID)
-		if retrievedRefreshToken.Token != refreshToken.Token {
+		if retrievedRefreshToken.Token != hashRefreshToken(refreshToken.Token) {
 			t.Fatal("expected refresh token to be the same")
 		}
 	})
@@ -149,11 +157,11 @@ func TestCheckAndRefreshToken(t *testing.T) {
 			t.Fatal("expected no error", err)
 		}
 		db.Create(&newUser)
-		refreshToken, _, err := entities.NewRefreshToken(newUser, "some-device-id", baseConfig)
+		refreshToken, _, err := entities.NewRefreshToken(newUser, testDevice.Fingerprint(), baseConfig)
 		if err != nil {
 			t.Fatal("expected no error", err)
 		}
-		db.Save(&refreshToken)
+		authService.RefreshTokenRepository.CreateRefreshToken(refreshToken)
 		newUser.Roles = []entities.Role{
 			{UserID: newUser.ID, Value: "user"},
 		}
@@ -166,16 +174,474 @@ func TestCheckAndRefreshToken(t *testing.T) {
 		if err != nil {
 			t.Fatal("expected no error", err)
 		}
-		_, err = authService.CheckAndRefreshToken(accessToken, refreshToken.Token, true)
+		_, err = authService.CheckAndRefreshToken(accessToken, refreshToken.Token, true, testDevice)
 		if err != nil {
 			t.Fatal("expected no error", err)
 		}
 		var retrievedRefreshToken entities.RefreshToken
-		db.First(&retrievedRefreshToken, "user_id = ?", newUser.ID)
-		if retrievedRefreshToken.Token == refreshToken.Token {
+		db.First(&retrievedRefreshToken, "user_id = ? AND rotated_at IS NULL", newUser.ID)
+		if retrievedRefreshToken.Token == hashRefreshToken(refreshToken.Token) {
 			t.Fatal("expected refresh token to be different")
 		}
 	})
+	t.Run("the rotated refresh token joins the family of its parent", func(t *testing.T) {
+		authService, _, _, db := prepare(t)
+		newUser, err := entities.NewUser("some-name", "some-avatar", "some-email", "github")
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		db.Create(&newUser)
+		newUser.Roles = []entities.Role{
+			{UserID: newUser.ID, Value: "user"},
+		}
+		db.Save(&newUser)
+		refreshToken, _, err := entities.NewRefreshToken(newUser, testDevice.Fingerprint(), baseConfig)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		authService.RefreshTokenRepository.CreateRefreshToken(refreshToken)
+		tokensPair, err := authService.CheckAndRefreshToken("", refreshToken.Token, true, testDevice)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		var child entities.RefreshToken
+		db.First(&child, "token = ?", hashRefreshToken(tokensPair.RefreshToken))
+		if child.FamilyID != refreshToken.FamilyID || child.ParentToken != hashRefreshToken(refreshToken.Token) {
+			t.Fatal("expected the new token to be a child of the old one", child)
+		}
+		var parent entities.RefreshToken
+		db.First(&parent, "token = ?", hashRefreshToken(refreshToken.Token))
+		if !parent.IsRotated() {
+			t.Fatal("expected the old token to be marked as rotated")
+		}
+	})
+	t.Run("reusing a rotated refresh token revokes the family and records a security event", func(t *testing.T) {
+		authService, _, _, db := prepare(t)
+		newUser, err := entities.NewUser("some-name", "some-avatar", "some-email", "github")
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		db.Create(&newUser)
+		newUser.Roles = []entities.Role{
+			{UserID: newUser.ID, Value: "user"},
+		}
+		db.Save(&newUser)
+		refreshToken, _, err := entities.NewRefreshToken(newUser, testDevice.Fingerprint(), baseConfig)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		authService.RefreshTokenRepository.CreateRefreshToken(refreshToken)
+		tokensPair, err := authService.CheckAndRefreshToken("", refreshToken.Token, true, testDevice)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		_, err = authService.CheckAndRefreshToken("", refreshToken.Token, true, testDevice)
+		if err == nil || err.Error() != apperrors.ErrRefreshTokenReused.Error() {
+			t.Fatal("expected error ErrRefreshTokenReused", err)
+		}
+		_, err = authService.CheckAndRefreshToken("", tokensPair.RefreshToken, true, testDevice)
+		if err == nil || err.Error() != apperrors.ErrRefreshTokenInvalid.Error() {
+			t.Fatal("expected the child token to be revoked", err)
+		}
+		var events []entities.SecurityEvent
+		db.Find(&events, "user_id = ?", newUser.ID)
+		if len(events) != 1 || events[0].Type != entities.SecurityEventRefreshTokenReuse {
+			t.Fatal("expected a refresh_token_reuse security event", events)
+		}
+	})
+	t.Run("reusing a token of another family does not revoke the current one", func(t *testing.T) {
+		authService, _, _, db := prepare(t)
+		newUser, err := entities.NewUser("some-name", "some-avatar", "some-email", "github")
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		db.Create(&newUser)
+		newUser.Roles = []entities.Role{
+			{UserID: newUser.ID, Value: "user"},
+		}
+		db.Save(&newUser)
+		laptopDevice := entities.Device{ID: "laptop"}
+		phoneDevice := entities.Device{ID: "phone"}
+		laptop, _, _ := entities.NewRefreshToken(newUser, laptopDevice.Fingerprint(), baseConfig)
+		phone, _, _ := entities.NewRefreshToken(newUser, phoneDevice.Fingerprint(), baseConfig)
+		authService.RefreshTokenRepository.CreateRefreshToken(laptop)
+		authService.RefreshTokenRepository.CreateRefreshToken(phone)
+		if _, err := authService.CheckAndRefreshToken("", laptop.Token, true, laptopDevice); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if _, err := authService.CheckAndRefreshToken("", laptop.Token, true, laptopDevice); err == nil {
+			t.Fatal("expected an error")
+		}
+		if _, err := authService.CheckAndRefreshToken("", phone.Token, true, phoneDevice); err != nil {
+			t.Fatal("expected the phone session to survive", err)
+		}
+	})
+	t.Run("a rotated refresh token returns the same new token during the grace period", func(t *testing.T) {
+		authService, _, _, db := prepare(t)
+		authService.TokenService = services.NewTokenService(authService.RefreshTokenRepository, repositories.NewSecurityEventRepository(db), repositories.NewOrganizationRepository(db), authService.KeyService, withGracePeriod(baseConfig, 10))
+		newUser, err := entities.NewUser("some-name", "some-avatar", "some-email", "github")
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		db.Create(&newUser)
+		newUser.Roles = []entities.Role{
+			{UserID: newUser.ID, Value: "user"},
+		}
+		db.Save(&newUser)
+		refreshToken, _, err := entities.NewRefreshToken(newUser, testDevice.Fingerprint(), baseConfig)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		authService.RefreshTokenRepository.CreateRefreshToken(refreshToken)
+		first, err := authService.CheckAndRefreshToken("", refreshToken.Token, true, testDevice)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		second, err := authService.CheckAndRefreshToken("", refreshToken.Token, true, testDevice)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if first.RefreshToken != second.RefreshToken {
+			t.Fatal("expected the same new refresh token", first.RefreshToken, second.RefreshToken)
+		}
+		var count int64
+		db.Model(&entities.RefreshToken{}).Where("family_id = ?", refreshToken.FamilyID).Count(&count)
+		if count != 2 {
+			t.Fatal("expected the family to be kept", count)
+		}
+	})
+	t.Run("a rotated refresh token is a reuse after the grace period", func(t *testing.T) {
+		authService, _, _, db := prepare(t)
+		authService.TokenService = services.NewTokenService(authService.RefreshTokenRepository, repositories.NewSecurityEventRepository(db), repositories.NewOrganizationRepository(db), authService.KeyService, withGracePeriod(baseConfig, 10))
+		newUser, err := entities.NewUser("some-name", "some-avatar", "some-email", "github")
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		db.Create(&newUser)
+		newUser.Roles = []entities.Role{
+			{UserID: newUser.ID, Value: "user"},
+		}
+		db.Save(&newUser)
+		refreshToken, _, err := entities.NewRefreshToken(newUser, testDevice.Fingerprint(), baseConfig)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		authService.RefreshTokenRepository.CreateRefreshToken(refreshToken)
+		if _, err := authService.CheckAndRefreshToken("", refreshToken.Token, true, testDevice); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		db.Model(&entities.RefreshToken{}).Where("token = ?", hashRefreshToken(refreshToken.Token)).Update("rotated_at", time.Now().Add(-11*time.Second))
+		_, err = authService.CheckAndRefreshToken("", refreshToken.Token, true, testDevice)
+		if err == nil || err.Error() != apperrors.ErrRefreshTokenReused.Error() {
+			t.Fatal("expected error ErrRefreshTokenReused", err)
+		}
+	})
+	t.Run("a refresh token presented by another device gets rejected", func(t *testing.T) {
+		authService, _, _, db := prepare(t)
+		newUser, err := entities.NewUser("some-name", "some-avatar", "some-email", "github")
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		db.Create(&newUser)
+		newUser.Roles = []entities.Role{
+			{UserID: newUser.ID, Value: "user"},
+		}
+		db.Save(&newUser)
+		refreshToken, _, err := entities.NewRefreshToken(newUser, testDevice.Fingerprint(), baseConfig)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		authService.RefreshTokenRepository.CreateRefreshToken(refreshToken)
+		_, err = authService.CheckAndRefreshToken("", refreshToken.Token, true, entities.Device{ID: "other-device-id"})
+		if err == nil || err.Error() != apperrors.ErrRefreshTokenInvalid.Error() {
+			t.Fatal("expected error ErrRefreshTokenInvalid", err)
+		}
+		var events int64
+		db.Model(&entities.SecurityEvent{}).Where("type = ?", entities.SecurityEventRefreshTokenDeviceMismatch).Count(&events)
+		if events != 1 {
+			t.Fatal("expected a security event, got", events)
+		}
+		if _, err := authService.CheckAndRefreshToken("", refreshToken.Token, true, testDevice); err != nil {
+			t.Fatal("expected the session to survive on its own device", err)
+		}
+	})
+}
+
+func TestSessions(t *testing.T) {
+	baseConfig := entities.Config{
+		JWT: entities.JWTConfig{
+			Secret:                     "some-secret",
+			AccessTokenExpirationMin:   1,
+			RefreshTokenExpirationDays: 1,
+		},
+	}
+	laptop := entities.Device{ID: "laptop", UserAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0", IP: "10.0.0.1"}
+	phone := entities.Device{ID: "phone", UserAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1", IP: "10.0.0.2"}
+	// prepare logs the same user in on the laptop and the phone, and returns the laptop tokens
+	prepare := func(t *testing.T) (*UseCases, entities.User, string, string) {
+		db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.AutoMigrate(&entities.User{}, &entities.RefreshToken{}, &entities.Role{}, &entities.SecurityEvent{})
+		refreshTokenRepository := repositories.NewRefreshTokenRepository(db, "some-pepper")
+		userRepository := repositories.NewUserRepository(db)
+		keyService, err := services.NewKeyService(baseConfig, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokenService := services.NewTokenService(refreshTokenRepository, repositories.NewSecurityEventRepository(db), repositories.NewOrganizationRepository(db), keyService, baseConfig)
+		authService := NewService(baseConfig, refreshTokenRepository, userRepository, keyService, tokenService)
+		newUser, err := entities.NewUser("some-name", "some-avatar", "some-email", "github")
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		newUser.Roles = []entities.Role{
+			{UserID: newUser.ID, Value: "user"},
+		}
+		db.Create(&newUser)
+		accessToken, _, refreshToken, _, err := tokenService.GenerateTokensForUser(newUser, laptop, nil)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if _, _, _, _, err := tokenService.GenerateTokensForUser(newUser, phone, nil); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		return authService, newUser, accessToken, refreshToken
+	}
+	t.Run("lists the sessions of the user", func(t *testing.T) {
+		authService, _, accessToken, refreshToken := prepare(t)
+		sessions, err := authService.ListSessions(accessToken, refreshToken)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if len(sessions) != 2 {
+			t.Fatal("expected 2 sessions", len(sessions))
+		}
+		labels := map[string]bool{}
+		current := 0
+		for _, session := range sessions {
+			labels[session.DeviceLabel] = session.Current
+			if session.Current {
+				current++
+			}
+		}
+		if current != 1 || !labels["Firefox on Linux"] {
+			t.Fatal("expected the laptop session to be the current one", sessions)
+		}
+		if _, ok := labels["Safari on iOS"]; !ok {
+			t.Fatal("expected the phone session to be listed", sessions)
+		}
+	})
+	t.Run("a session keeps its id and creation date when it is refreshed", func(t *testing.T) {
+		authService, _, accessToken, refreshToken := prepare(t)
+		before, _ := authService.ListSessions(accessToken, refreshToken)
+		tokensPair, err := authService.CheckAndRefreshToken("", refreshToken, true, laptop)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		after, err := authService.ListSessions(tokensPair.AccessToken, tokensPair.RefreshToken)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if len(after) != 2 {
+			t.Fatal("expected 2 sessions", len(after))
+		}
+		for _, session := range after {
+			if !session.Current {
+				continue
+			}
+			for _, previous := range before {
+				if previous.Current && (previous.ID != session.ID || !previous.CreatedAt.Equal(session.CreatedAt)) {
+					t.Fatal("expected the same session", previous, session)
+				}
+			}
+		}
+	})
+	t.Run("revokes the other sessions", func(t *testing.T) {
+		authService, _, accessToken, refreshToken := prepare(t)
+		if err := authService.RevokeOtherSessions(accessToken, refreshToken); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		sessions, _ := authService.ListSessions(accessToken, refreshToken)
+		if len(sessions) != 1 || !sessions[0].Current {
+			t.Fatal("expected only the current session to remain", sessions)
+		}
+	})
+	t.Run("revokes one session", func(t *testing.T) {
+		authService, _, accessToken, refreshToken := prepare(t)
+		sessions, _ := authService.ListSessions(accessToken, refreshToken)
+		for _, session := range sessions {
+			if session.Current {
+				continue
+			}
+			tokensPair, err := authService.RevokeSession(accessToken, refreshToken, session.ID)
+			if err != nil {
+				t.Fatal("expected no error", err)
+			}
+			if tokensPair != nil {
+				t.Fatal("expected the cookies to be kept", tokensPair)
+			}
+		}
+		sessions, _ = authService.ListSessions(accessToken, refreshToken)
+		if len(sessions) != 1 || !sessions[0].Current {
+			t.Fatal("expected only the current session to remain", sessions)
+		}
+	})
+	t.Run("revoking the current session erases the cookies", func(t *testing.T) {
+		authService, _, accessToken, refreshToken := prepare(t)
+		sessions, _ := authService.ListSessions(accessToken, refreshToken)
+		for _, session := range sessions {
+			if !session.Current {
+				continue
+			}
+			tokensPair, err := authService.RevokeSession(accessToken, refreshToken, session.ID)
+			if err != nil {
+				t.Fatal("expected no error", err)
+			}
+			if tokensPair == nil || tokensPair.RefreshToken != "" {
+				t.Fatal("expected the cookies to be erased", tokensPair)
+			}
+		}
+		if _, err := authService.CheckAndRefreshToken("", refreshToken, true, laptop); err == nil {
+			t.Fatal("expected the refresh token to be revoked")
+		}
+	})
+	t.Run("cannot revoke the session of another user", func(t *testing.T) {
+		authService, _, accessToken, refreshToken := prepare(t)
+		otherUser, err := entities.NewUser("other-name", "other-avatar", "other-email", "github")
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		otherUser.Roles = []entities.Role{
+			{UserID: otherUser.ID, Value: "user"},
+		}
+		otherAccessToken, _, otherRefreshToken, _, err := authService.TokenService.GenerateTokensForUser(otherUser, phone, nil)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		otherSessions, _ := authService.ListSessions(otherAccessToken, otherRefreshToken)
+		if len(otherSessions) != 1 {
+			t.Fatal("expected 1 session", otherSessions)
+		}
+		_, err = authService.RevokeSession(accessToken, refreshToken, otherSessions[0].ID)
+		if err == nil || err.Error() != apperrors.ErrSessionNotFound.Error() {
+			t.Fatal("expected error ErrSessionNotFound", err)
+		}
+	})
+	t.Run("requires a valid access token", func(t *testing.T) {
+		authService, _, _, refreshToken := prepare(t)
+		if _, err := authService.ListSessions("invalid", refreshToken); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
+
+func TestSessionLifetime(t *testing.T) {
+	baseConfig := entities.Config{
+		JWT: entities.JWTConfig{
+			Secret:                     "some-secret",
+			AccessTokenExpirationMin:   1,
+			RefreshTokenExpirationDays: 30,
+		},
+	}
+	prepare := func(t *testing.T, config entities.Config, roles ...string) (*UseCases, *gorm.DB, string) {
+		db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.AutoMigrate(&entities.User{}, &entities.RefreshToken{}, &entities.Role{}, &entities.SecurityEvent{})
+		refreshTokenRepository := repositories.NewRefreshTokenRepository(db, "some-pepper")
+		userRepository := repositories.NewUserRepository(db)
+		keyService, err := services.NewKeyService(config, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokenService := services.NewTokenService(refreshTokenRepository, repositories.NewSecurityEventRepository(db), repositories.NewOrganizationRepository(db), keyService, config)
+		authService := NewService(config, refreshTokenRepository, userRepository, keyService, tokenService)
+		newUser, err := entities.NewUser("some-name", "some-avatar", "some-email", "github")
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		for _, role := range append([]string{"user"}, roles...) {
+			newUser.Roles = append(newUser.Roles, entities.Role{UserID: newUser.ID, Value: role})
+		}
+		db.Create(&newUser)
+		_, _, refreshToken, _, err := tokenService.GenerateTokensForUser(newUser, testDevice, nil)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		return authService, db, refreshToken
+	}
+	t.Run("a session that was not refreshed for longer than the idle timeout dies", func(t *testing.T) {
+		config := baseConfig
+		config.Sessions.IdleTimeoutHours = 2
+		authService, db, refreshToken := prepare(t, config)
+		// The token still expires later, ex: it was issued before the idle timeout was configured
+		db.Model(&entities.RefreshToken{}).Where("token = ?", hashRefreshToken(refreshToken)).Updates(map[string]any{
+			"last_used_at": time.Now().Add(-3 * time.Hour),
+			"expires_at":   time.Now().Add(time.Hour),
+		})
+		_, err := authService.CheckAndRefreshToken("", refreshToken, true, testDevice)
+		if err == nil || err.Error() != apperrors.ErrRefreshTokenExpired.Error() {
+			t.Fatal("expected error ErrRefreshTokenExpired", err)
+		}
+	})
+	t.Run("refreshing extends the session up to the absolute lifetime", func(t *testing.T) {
+		config := baseConfig
+		config.Sessions.IdleTimeoutHours = 2
+		config.Sessions.AbsoluteLifetimeDays = 1
+		authService, db, refreshToken := prepare(t, config)
+		db.Model(&entities.RefreshToken{}).Where("token = ?", hashRefreshToken(refreshToken)).Update("created_at", time.Now().Add(-23*time.Hour))
+		tokensPair, err := authService.CheckAndRefreshToken("", refreshToken, true, testDevice)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if until := time.Until(tokensPair.RefreshTokenExpiresAt); until > time.Hour+time.Minute || until < 59*time.Minute {
+			t.Fatal("expected the refresh token to expire at the end of the session", tokensPair.RefreshTokenExpiresAt)
+		}
+		db.Model(&entities.RefreshToken{}).Where("token = ?", hashRefreshToken(tokensPair.RefreshToken)).Updates(map[string]any{
+			"created_at": time.Now().Add(-25 * time.Hour),
+			"expires_at": time.Now().Add(time.Hour),
+		})
+		_, err = authService.CheckAndRefreshToken("", tokensPair.RefreshToken, true, testDevice)
+		if err == nil || err.Error() != apperrors.ErrRefreshTokenExpired.Error() {
+			t.Fatal("expected error ErrRefreshTokenExpired", err)
+		}
+	})
+	t.Run("refreshing slides the idle timeout", func(t *testing.T) {
+		config := baseConfig
+		config.Sessions.IdleTimeoutHours = 2
+		authService, _, refreshToken := prepare(t, config)
+		tokensPair, err := authService.CheckAndRefreshToken("", refreshToken, true, testDevice)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if until := time.Until(tokensPair.RefreshTokenExpiresAt); until > 2*time.Hour+time.Minute || until < 119*time.Minute {
+			t.Fatal("expected the refresh token to expire after the idle timeout", tokensPair.RefreshTokenExpiresAt)
+		}
+	})
+	t.Run("the most restrictive lifetime among the roles of the user applies", func(t *testing.T) {
+		config := baseConfig
+		config.Sessions.IdleTimeoutHours = 24
+		config.Sessions.LifetimePerRole = map[string]entities.SessionLifetime{
+			"admin":   {IdleTimeoutHours: 1},
+			"support": {IdleTimeoutHours: 8, AbsoluteLifetimeDays: 7},
+		}
+		lifetime := config.SessionLifetimeFor([]entities.Role{{Value: "user"}, {Value: "admin"}, {Value: "support"}})
+		if lifetime.IdleTimeoutHours != 1 || lifetime.AbsoluteLifetimeDays != 7 {
+			t.Fatal("expected the most restrictive lifetime", lifetime)
+		}
+		authService, db, refreshToken := prepare(t, config, "admin")
+		db.Model(&entities.RefreshToken{}).Where("token = ?", hashRefreshToken(refreshToken)).Updates(map[string]any{
+			"last_used_at": time.Now().Add(-2 * time.Hour),
+			"expires_at":   time.Now().Add(time.Hour),
+		})
+		_, err := authService.CheckAndRefreshToken("", refreshToken, true, testDevice)
+		if err == nil || err.Error() != apperrors.ErrRefreshTokenExpired.Error() {
+			t.Fatal("expected error ErrRefreshTokenExpired", err)
+		}
+	})
 }
 
 func TestAuthorize(t *testing.T) {
@@ -191,10 +657,15 @@ func TestAuthorize(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		db.AutoMigrate(&entities.User{}, &entities.RefreshToken{}, &entities.Role{})
-		refreshTokenRepository := repositories.NewRefreshTokenRepository(db)
+		db.AutoMigrate(&entities.User{}, &entities.RefreshToken{}, &entities.Role{}, &entities.SecurityEvent{})
+		refreshTokenRepository := repositories.NewRefreshTokenRepository(db, "some-pepper")
 		userRepository := repositories.NewUserRepository(db)
-		authService := NewService(baseConfig, refreshTokenRepository, userRepository)
+		keyService, err := services.NewKeyService(baseConfig, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokenService := services.NewTokenService(refreshTokenRepository, repositories.NewSecurityEventRepository(db), repositories.NewOrganizationRepository(db), keyService, baseConfig)
+		authService := NewService(baseConfig, refreshTokenRepository, userRepository, keyService, tokenService)
 		return authService, userRepository, refreshTokenRepository, db
 	}
 
@@ -202,7 +673,7 @@ func TestAuthorize(t *testing.T) {
 		authService, _, _, _ := prepare(t)
 
 		t.Run("no token returns an error", func(t *testing.T) {
-			_, err := authService.Authorize("", []string{"user"})
+			_, err := authService.Authorize("", []string{"user"}, "", nil)
 			if err == nil {
 				t.Fatal("expected error for empty token")
 			}
@@ -229,7 +700,7 @@ func TestAuthorize(t *testing.T) {
 			if err != nil {
 				t.Fatal("expected no error", err)
 			}
-			_, err = authService.Authorize(expiredToken, []string{"user"})
+			_, err = authService.Authorize(expiredToken, []string{"user"}, "", nil)
 			if err == nil {
 				t.Fatal("expected error for expired token")
 			}
@@ -239,7 +710,7 @@ func TestAuthorize(t *testing.T) {
 		})
 
 		t.Run("malformed token returns an error", func(t *testing.T) {
-			_, err := authService.Authorize("invalid.token.here", []string{"user"})
+			_, err := authService.Authorize("invalid.token.here", []string{"user"}, "", nil)
 			if err == nil {
 				t.Fatal("expected error for malformed token")
 			}
@@ -268,7 +739,7 @@ func TestAuthorize(t *testing.T) {
 			if err != nil {
 				t.Fatal("expected no error", err)
 			}
-			_, err = authService.Authorize(validToken, []string{})
+			_, err = authService.Authorize(validToken, []string{}, "", nil)
 			if err.Error() != apperrors.ErrNoRoles.Error() {
 				t.Fatal("expected error ErrNoRoles", err)
 			}
@@ -290,7 +761,7 @@ func TestAuthorize(t *testing.T) {
 			if err != nil {
 				t.Fatal("expected no error", err)
 			}
-			_, err = authService.Authorize(validToken, []string{"any"})
+			_, err = authService.Authorize(validToken, []string{"any"}, "", nil)
 			if err != nil {
 				t.Fatal("expected no error for 'any' role", err)
 			}
@@ -314,13 +785,118 @@ func TestAuthorize(t *testing.T) {
 		if err != nil {
 			t.Fatal("expected no error", err)
 		}
-		_, err = authService.Authorize(validToken, []string{"admin", "user", "payments"})
+		_, err = authService.Authorize(validToken, []string{"admin", "user", "payments"}, "", nil)
 		if err != nil {
 			t.Fatal("expected no error for authorized role", err)
 		}
-		_, err = authService.Authorize(validToken, []string{"user"})
+		_, err = authService.Authorize(validToken, []string{"user"}, "", nil)
 		if err.Error() != apperrors.ErrUnauthorizedRole.Error() {
 			t.Fatal("expected error ErrUnauthorizedRole", err)
 		}
 	})
+
+	generateToken := func(t *testing.T, claims map[string]any) string {
+		token, _, err := jwtgen.Generate(claims, time.Now(), baseConfig.JWT.AccessTokenExpirationMin, baseConfig.App.Name, baseConfig.JWT.Secret)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		return token
+	}
+
+	t.Run("roles are matched exactly", func(t *testing.T) {
+		authService, _, _, _ := prepare(t)
+		cc, err := entities.NewCustomClaimsFromValues("some-user-id", false, []entities.Role{{Value: "platform_admin"}}, "{}")
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		_, err = authService.Authorize(generateToken(t, cc.ToMap()), []string{"admin"}, "", nil)
+		if !errors.Is(err, apperrors.ErrUnauthorizedRole) {
+			t.Fatal("expected error ErrUnauthorizedRole", err)
+		}
+	})
+
+	t.Run("all_of requires every role", func(t *testing.T) {
+		authService, _, _, _ := prepare(t)
+		cc, err := entities.NewCustomClaimsFromValues("some-user-id", false, []entities.Role{{Value: "user"}, {Value: "payments"}}, "{}")
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		token := generateToken(t, cc.ToMap())
+		if _, err := authService.Authorize(token, []string{"user", "payments"}, entities.RoleMatchAllOf, nil); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		_, err = authService.Authorize(token, []string{"user", "support"}, entities.RoleMatchAllOf, nil)
+		if !errors.Is(err, apperrors.ErrUnauthorizedRole) {
+			t.Fatal("expected error ErrUnauthorizedRole", err)
+		}
+		_, err = authService.Authorize(token, []string{"user"}, "some_of", nil)
+		if !errors.Is(err, apperrors.ErrInvalidRoleMatch) {
+			t.Fatal("expected error ErrInvalidRoleMatch", err)
+		}
+	})
+
+	t.Run("legacy roles claims are only accepted in compatibility mode", func(t *testing.T) {
+		authService, _, _, _ := prepare(t)
+		token := generateToken(t, map[string]any{
+			"user_id":         "some-user-id",
+			"early_adopter":   false,
+			"roles":           "user,payments",
+			"metadata_public": "{}",
+		})
+		_, err := authService.Authorize(token, []string{"payments"}, "", nil)
+		if !errors.Is(err, apperrors.ErrAccessTokenInvalid) {
+			t.Fatal("expected error ErrAccessTokenInvalid", err)
+		}
+		authService.Config.JWT.AcceptLegacyRolesClaim = true
+		cc, err := authService.Authorize(token, []string{"payments"}, "", nil)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if len(cc.Roles) != 2 || cc.Roles[1] != "payments" {
+			t.Fatal("expected the roles to be split", cc.Roles)
+		}
+		_, err = authService.Authorize(token, []string{"pay"}, "", nil)
+		if !errors.Is(err, apperrors.ErrUnauthorizedRole) {
+			t.Fatal("expected error ErrUnauthorizedRole", err)
+		}
+	})
+
+	t.Run("required permissions are resolved from the roles", func(t *testing.T) {
+		authService, _, _, _ := prepare(t)
+		authService.Config.User.Permissions = map[string]entities.RolePermissions{
+			"user":    {Permissions: []string{"billing:read"}},
+			"support": {Permissions: []string{"users:read"}, Inherits: []string{"user"}},
+		}
+		cc, err := entities.NewCustomClaimsFromValues("some-user-id", false, []entities.Role{{Value: "support"}}, "{}")
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		token := generateToken(t, cc.ToMap())
+		authorized, err := authService.Authorize(token, nil, "", []string{"users:read", "billing:read"})
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if len(authorized.Permissions) != 2 {
+			t.Fatal("expected the permissions of the user", authorized.Permissions)
+		}
+		_, err = authService.Authorize(token, nil, "", []string{"billing:write"})
+		if !errors.Is(err, apperrors.ErrMissingPermission) {
+			t.Fatal("expected error ErrMissingPermission", err)
+		}
+		_, err = authService.Authorize(token, []string{"user"}, "", []string{"users:read"})
+		if !errors.Is(err, apperrors.ErrUnauthorizedRole) {
+			t.Fatal("expected roles to be checked too", err)
+		}
+	})
+}
+
+var testDevice = entities.Device{ID: "some-device-id"}
+
+func withGracePeriod(config entities.Config, seconds int) entities.Config {
+	config.Sessions.RefreshGracePeriodSeconds = seconds
+	return config
+}
+
+func hashRefreshToken(token string) string {
+	return tokengen.Hash(token, "some-pepper")
 }
@@ -1,5 +1,14 @@
 package entities
 
+import (
+	"aegis/pkg/tokengen"
+	"crypto/subtle"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
 type Config struct {
 	App struct {
 		// Name of the application (ex: "Aegis")
@@ -56,9 +65,66 @@ type Config struct {
 				ClientID     string `json:"client_id"`
 				ClientSecret string `json:"client_secret"`
 			} `json:"discord"`
+			// Any OpenID Connect issuer (ex: Keycloak, Authentik, Zitadel)
+			OIDC []OIDCProviderConfig `json:"oidc"`
+			// Passwordless login with a link sent by email through the mailer
+			Email struct {
+				Enabled bool `json:"enabled"`
+				// Number of minutes a login link can be used, 15 if not set
+				LinkExpirationMinutes int `json:"link_expiration_minutes"`
+				// Number of minutes a login code can be used, 10 if not set
+				CodeExpirationMinutes int `json:"code_expiration_minutes"`
+			} `json:"email"`
+			// Passwordless login with a passkey (WebAuthn), registered by a logged in user
+			Passkeys struct {
+				Enabled bool `json:"enabled"`
+				// Domain the passkeys are scoped to, the host of app.url if not set (ex: "example.com")
+				RPID string `json:"rp_id"`
+				// Origins the login page is served from, the origin of app.url if not set (ex: ["https://auth.example.com"])
+				Origins []string `json:"origins"`
+				// Rejects the authenticators that do not verify the user with a PIN or biometrics
+				RequireUserVerification bool `json:"require_user_verification"`
+			} `json:"passkeys"`
 		} `json:"providers"`
 	} `json:"auth"`
 
+	MFA struct {
+		// Roles only granted to the sessions that logged in with a second factor, the users who have them must
+		// enroll an authenticator app to use them (ex: ["platform_admin"])
+		RequiredForRoles []string `json:"required_for_roles"`
+		// Secret key used to encrypt the secrets of the authenticator apps, required (at least 16 characters, distinct from refresh_token_pepper) (ex: "${env:AEGIS_MFA_ENCRYPTION_KEY}")
+		EncryptionKey string `json:"encryption_key"`
+	} `json:"mfa"`
+
+	OAuthServer struct {
+		// Lets the apps on other domains log their users in with OpenID Connect, Aegis being their provider.
+		// Requires an asymmetric jwt algorithm, the ID tokens are verified with the JWKS.
+		Enabled bool `json:"enabled"`
+		// Apps allowed to log their users in
+		Clients []OAuthClientConfig `json:"clients"`
+	} `json:"oauth_server"`
+
+	Sessions struct {
+		// After a refresh token is rotated, presenting it again during this many seconds returns the same new token
+		// instead of being treated as a reuse, so that several tabs can refresh at the same time (ex: 10, 0 disables it)
+		RefreshGracePeriodSeconds int `json:"refresh_grace_period_seconds"`
+		// Secret key the keys used to hash the tokens before storing them are derived from (see PepperFor), required
+		// (at least 16 characters), changing it logs out every user (ex: "${env:AEGIS_REFRESH_TOKEN_PEPPER}")
+		RefreshTokenPepper string `json:"refresh_token_pepper"`
+		// Secret key used to sign the device cookie, defaults to a key derived from refresh_token_pepper (ex: "${env:AEGIS_DEVICE_COOKIE_SECRET}")
+		DeviceCookieSecret string `json:"device_cookie_secret"`
+		// Maximum number of sessions (devices) of a user, 5 if not set
+		MaxPerUser int `json:"max_per_user"`
+		// Overrides max_per_user for the users with these roles, the smallest limit among the roles of the user applies (ex: {"admin": 1})
+		MaxPerRole map[string]int `json:"max_per_role"`
+		// What to do when a user logs in on one device too many: "reject" (default), "evict_oldest" or "evict_lru" (least recently used)
+		LimitPolicy string `json:"limit_policy"`
+		SessionLifetime
+		// Overrides the idle timeout and the absolute lifetime for the users with these roles, the most restrictive
+		// value among the roles of the user applies (ex: {"admin": {"idle_timeout_hours": 1, "absolute_lifetime_days": 1}})
+		LifetimePerRole map[string]SessionLifetime `json:"lifetime_per_role"`
+	} `json:"sessions"`
+
 	Cookies struct {
 		Domain   string `json:"domain"`
 		Secure   bool   `json:"secure"`
@@ -71,14 +137,315 @@ type Config struct {
 	User struct {
 		// Roles for a user. Mandatory roles are: "user" and "platform_admin"
 		Roles []string `json:"roles"`
+		// Permissions granted by each role (ex: {"support": {"permissions": ["users:read"], "inherits": ["user"]}})
+		Permissions map[string]RolePermissions `json:"permissions"`
 	} `json:"user"`
+
+	Organizations struct {
+		// Roles of the members of an organization, on top of "owner", "admin" and "member" (ex: ["billing"])
+		Roles []string `json:"roles"`
+		// Number of days an invitation to an organization can be accepted, 7 if not set
+		InvitationExpirationDays int `json:"invitation_expiration_days"`
+	} `json:"organizations"`
+
+	Mailer struct {
+		// "smtp", or "log" to write the emails to log_file (or to the logs if not set) instead of sending them
+		Type string `json:"type"`
+		// Sender of the emails (ex: "Aegis <no-reply@example.com>")
+		From string `json:"from"`
+		SMTP struct {
+			Host     string `json:"host"`
+			Port     int    `json:"port"`
+			Username string `json:"username"`
+			Password string `json:"password"`
+		} `json:"smtp"`
+		LogFile string `json:"log_file"`
+	} `json:"mailer"`
+}
+
+// RolePermissions are the permissions of a role, on top of the ones of the roles it inherits from
+type RolePermissions struct {
+	// Permissions granted by the role (ex: ["billing:read", "billing:write"])
+	Permissions []string `json:"permissions"`
+	// Roles whose permissions are granted too (ex: ["user"])
+	Inherits []string `json:"inherits"`
+}
+
+type OIDCProviderConfig struct {
+	// Name of the provider, used in the routes /auth/{name} and /auth/{name}/callback (ex: "keycloak")
+	Name string `json:"name"`
+	// Label of the login button (ex: "Keycloak")
+	DisplayName string `json:"display_name"`
+	Enabled     bool   `json:"enabled"`
+	// Issuer URL, must serve /.well-known/openid-configuration (ex: "https://sso.example.com/realms/main")
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	// Scopes to request, "openid" is always requested (ex: ["profile", "email"])
+	Scopes []string `json:"scopes"`
+}
+
+// OAuthClientConfig is an app that logs its users in with Aegis as their OpenID Connect provider
+type OAuthClientConfig struct {
+	// Sent by the app in its requests (ex: "billing")
+	ClientID string `json:"client_id"`
+	// Authenticates the app when it exchanges a code, empty for the apps that can not keep a secret (ex: single page apps, CLIs)
+	ClientSecret string `json:"client_secret"`
+	// Name of the app (ex: "Billing")
+	Name string `json:"name"`
+	// URLs the users are sent back to with a code, compared exactly (ex: ["https://billing.example.com/callback"])
+	RedirectURIs []string `json:"redirect_uris"`
+	// Lets the app log its users in with the device authorization grant, for the apps without a browser (ex: CLIs).
+	// A client that only uses this grant needs no redirect_uris.
+	DeviceFlow bool `json:"device_flow"`
+}
+
+// Authenticate checks the secret sent by the client, the clients without a secret authenticate with their ID only
+func (c OAuthClientConfig) Authenticate(clientSecret string) bool {
+	if c.ClientSecret == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(c.ClientSecret), []byte(clientSecret)) == 1
+}
+
+// SessionLifetime limits how long a session lives. Each refresh extends the refresh token by
+// jwt.refresh_token_expiration_days, within these limits (0 disables a limit).
+type SessionLifetime struct {
+	// A session that was not refreshed for this many hours dies (ex: 24)
+	IdleTimeoutHours int `json:"idle_timeout_hours"`
+	// A session dies this many days after the login, however often it is refreshed (ex: 30)
+	AbsoluteLifetimeDays int `json:"absolute_lifetime_days"`
+}
+
+func (l SessionLifetime) IdleTimeout() time.Duration {
+	return time.Duration(l.IdleTimeoutHours) * time.Hour
+}
+
+func (l SessionLifetime) AbsoluteLifetime() time.Duration {
+	return time.Duration(l.AbsoluteLifetimeDays) * 24 * time.Hour
 }
 
 type JWTConfig struct {
-	// Secret key for the JWT
+	// Signing algorithm: "HS256" (default, signs with secret), "RS256", "ES256" or "EdDSA" (sign with private_key)
+	Algorithm string `json:"algorithm"`
+	// Secret key for the JWT (HS256 only)
 	Secret string `json:"secret"`
+	// PEM encoded private key for asymmetric algorithms (PKCS#8, PKCS#1 or SEC1)
+	PrivateKey string `json:"private_key"`
+	// Path to a PEM encoded private key, read into private_key when the config is loaded
+	PrivateKeyFile string `json:"private_key_file"`
+	// Key ID set in the "kid" header and published in the JWKS, defaults to the key thumbprint
+	KeyID string `json:"key_id"`
+	// Where the signing keys live: "config" (default, the private_key above) or "database" (a key ring shared by all instances, rotated with the rotatekeys command)
+	KeyStorage string `json:"key_storage"`
+	// Secret key used to encrypt the signing keys stored in the database, required with the "database" key storage (ex: "${env:AEGIS_JWT_KEY_ENCRYPTION_KEY}")
+	KeyEncryptionKey string `json:"key_encryption_key"`
+	// With the "database" key storage, rotate the signing key automatically when it is older than this (0 disables automatic rotation)
+	KeyRotationDays int `json:"key_rotation_days"`
 	// Access token expiration time in minutes
 	AccessTokenExpirationMin int `json:"access_token_expiration_minutes"`
-	// Refresh token expiration time in days
+	// Refresh token expiration time in days, counted from the last refresh (see sessions.idle_timeout_hours and sessions.absolute_lifetime_days)
 	RefreshTokenExpirationDays int `json:"refresh_token_expiration_days"`
+	// Accept in authorizations the access tokens issued before roles became an array, while they have not expired
+	AcceptLegacyRolesClaim bool `json:"accept_legacy_roles_claim"`
+}
+
+const (
+	SessionLimitPolicyReject      = "reject"
+	SessionLimitPolicyEvictOldest = "evict_oldest"
+	SessionLimitPolicyEvictLRU    = "evict_lru"
+)
+
+const defaultMaxSessionsPerUser = 5
+
+// MaxSessions is the number of sessions a user with these roles can have at the same time
+func (c Config) MaxSessions(roles []Role) int {
+	maxSessions := c.Sessions.MaxPerUser
+	if maxSessions <= 0 {
+		maxSessions = defaultMaxSessionsPerUser
+	}
+	roleLimit := 0
+	for _, role := range roles {
+		if limit, ok := c.Sessions.MaxPerRole[role.Value]; ok && limit > 0 && (roleLimit == 0 || limit < roleLimit) {
+			roleLimit = limit
+		}
+	}
+	if roleLimit > 0 {
+		return roleLimit
+	}
+	return maxSessions
+}
+
+// SessionLifetimeFor returns the most restrictive lifetime among the global one and the ones of these roles
+func (c Config) SessionLifetimeFor(roles []Role) SessionLifetime {
+	lifetime := c.Sessions.SessionLifetime
+	for _, role := range roles {
+		roleLifetime, ok := c.Sessions.LifetimePerRole[role.Value]
+		if !ok {
+			continue
+		}
+		lifetime.IdleTimeoutHours = smallestLimit(lifetime.IdleTimeoutHours, roleLifetime.IdleTimeoutHours)
+		lifetime.AbsoluteLifetimeDays = smallestLimit(lifetime.AbsoluteLifetimeDays, roleLifetime.AbsoluteLifetimeDays)
+	}
+	return lifetime
+}
+
+// smallestLimit returns the smallest of two limits, 0 meaning no limit
+func smallestLimit(a, b int) int {
+	if a <= 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// PermissionsFor returns the permissions granted by these roles and the roles they inherit from, sorted
+func (c Config) PermissionsFor(roles []string) []string {
+	visited := map[string]bool{}
+	granted := map[string]bool{}
+	var visit func(role string)
+	visit = func(role string) {
+		if visited[role] {
+			return
+		}
+		visited[role] = true
+		rolePermissions := c.User.Permissions[role]
+		for _, permission := range rolePermissions.Permissions {
+			granted[permission] = true
+		}
+		for _, inherited := range rolePermissions.Inherits {
+			visit(inherited)
+		}
+	}
+	for _, role := range roles {
+		visit(role)
+	}
+	permissions := make([]string, 0, len(granted))
+	for permission := range granted {
+		permissions = append(permissions, permission)
+	}
+	slices.Sort(permissions)
+	return permissions
+}
+
+// OrganizationRoles are the roles a member of an organization can have
+func (c Config) OrganizationRoles() []string {
+	roles := []string{OrganizationRoleOwner, OrganizationRoleAdmin, OrganizationRoleMember}
+	for _, role := range c.Organizations.Roles {
+		if !slices.Contains(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+const (
+	MailerTypeSMTP = "smtp"
+	MailerTypeLog  = "log"
+)
+
+const defaultInvitationExpirationDays = 7
+
+// InvitationLifetime is how long an invitation to an organization can be accepted
+func (c Config) InvitationLifetime() time.Duration {
+	days := c.Organizations.InvitationExpirationDays
+	if days <= 0 {
+		days = defaultInvitationExpirationDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+const defaultMagicLinkExpirationMinutes = 15
+
+// MagicLinkLifetime is how long a login link sent by email can be used
+func (c Config) MagicLinkLifetime() time.Duration {
+	minutes := c.Auth.Providers.Email.LinkExpirationMinutes
+	if minutes <= 0 {
+		minutes = defaultMagicLinkExpirationMinutes
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+const defaultEmailCodeExpirationMinutes = 10
+
+// EmailCodeLifetime is how long a login code sent by email can be used
+func (c Config) EmailCodeLifetime() time.Duration {
+	minutes := c.Auth.Providers.Email.CodeExpirationMinutes
+	if minutes <= 0 {
+		minutes = defaultEmailCodeExpirationMinutes
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// PasskeyRPID is the domain the passkeys are scoped to
+func (c Config) PasskeyRPID() string {
+	if c.Auth.Providers.Passkeys.RPID != "" {
+		return c.Auth.Providers.Passkeys.RPID
+	}
+	appURL, err := url.Parse(c.App.URL)
+	if err != nil {
+		return ""
+	}
+	return appURL.Hostname()
+}
+
+// PasskeyOrigins are the origins the browser can use the passkeys from
+func (c Config) PasskeyOrigins() []string {
+	if len(c.Auth.Providers.Passkeys.Origins) > 0 {
+		return c.Auth.Providers.Passkeys.Origins
+	}
+	appURL, err := url.Parse(c.App.URL)
+	if err != nil {
+		return nil
+	}
+	return []string{appURL.Scheme + "://" + appURL.Host}
+}
+
+// Purposes of the keys derived from refresh_token_pepper, one per kind of token
+const (
+	PepperRefreshToken        = "refresh_token"
+	PepperInvitation          = "invitation"
+	PepperMagicLink           = "magic_link"
+	PepperEmailCode           = "email_code"
+	PepperPendingMFA          = "pending_mfa"
+	PepperRecoveryCode        = "recovery_code"
+	PepperAuthorizationCode   = "authorization_code"
+	PepperServiceClient       = "service_client"
+	PepperDeviceAuthorization = "device_authorization"
+	PepperDeviceCookie        = "device_cookie"
+)
+
+// PepperFor derives from refresh_token_pepper the key of one purpose (ex: PepperRefreshToken), so that a key is never
+// used for two kinds of tokens
+func (c Config) PepperFor(purpose string) string {
+	return tokengen.Hash(purpose, c.Sessions.RefreshTokenPepper)
+}
+
+// DeviceCookieSecret is the key the device cookie is signed with
+func (c Config) DeviceCookieSecret() string {
+	if c.Sessions.DeviceCookieSecret != "" {
+		return c.Sessions.DeviceCookieSecret
+	}
+	return c.PepperFor(PepperDeviceCookie)
+}
+
+// RolesWithoutMFA removes from the roles the ones that require a second factor
+func (c Config) RolesWithoutMFA(roles []string) []string {
+	return slices.DeleteFunc(slices.Clone(roles), func(role string) bool {
+		return slices.Contains(c.MFA.RequiredForRoles, role)
+	})
+}
+
+// OAuthIssuer identifies Aegis in the ID tokens, its discovery document is served under it
+func (c Config) OAuthIssuer() string {
+	return strings.TrimSuffix(c.App.URL, "/") + "/auth"
+}
+
+// OAuthClient returns the registered client with this ID
+func (c Config) OAuthClient(clientID string) (OAuthClientConfig, bool) {
+	for _, client := range c.OAuthServer.Clients {
+		if client.ClientID != "" && client.ClientID == clientID {
+			return client, true
+		}
+	}
+	return OAuthClientConfig{}, false
 }
@@ -0,0 +1,99 @@
+package entities
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestConfig_PermissionsFor(t *testing.T) {
+	config := Config{}
+	config.User.Permissions = map[string]RolePermissions{
+		"user":           {Permissions: []string{"billing:read"}},
+		"support":        {Permissions: []string{"users:read", "billing:read"}, Inherits: []string{"user"}},
+		"platform_admin": {Permissions: []string{"users:write"}, Inherits: []string{"support"}},
+	}
+	t.Run("inherits the permissions of the parent roles", func(t *testing.T) {
+		permissions := config.PermissionsFor([]string{"platform_admin"})
+		if !slices.Equal(permissions, []string{"billing:read", "users:read", "users:write"}) {
+			t.Fatal("expected the permissions of platform_admin, support and user", permissions)
+		}
+	})
+	t.Run("a role without permissions grants none", func(t *testing.T) {
+		permissions := config.PermissionsFor([]string{"payments"})
+		if len(permissions) != 0 {
+			t.Fatal("expected no permissions", permissions)
+		}
+	})
+	t.Run("survives inheritance cycles", func(t *testing.T) {
+		cyclic := Config{}
+		cyclic.User.Permissions = map[string]RolePermissions{
+			"a": {Permissions: []string{"a:read"}, Inherits: []string{"b"}},
+			"b": {Permissions: []string{"b:read"}, Inherits: []string{"a"}},
+		}
+		permissions := cyclic.PermissionsFor([]string{"a"})
+		if !slices.Equal(permissions, []string{"a:read", "b:read"}) {
+			t.Fatal("expected the permissions of a and b", permissions)
+		}
+	})
+}
+
+func TestConfig_Passkeys(t *testing.T) {
+	t.Run("defaults to the host and origin of the app", func(t *testing.T) {
+		config := Config{}
+		config.App.URL = "https://auth.example.com:8443/app"
+		if config.PasskeyRPID() != "auth.example.com" {
+			t.Fatal("expected the rp id to be auth.example.com", config.PasskeyRPID())
+		}
+		if !slices.Equal(config.PasskeyOrigins(), []string{"https://auth.example.com:8443"}) {
+			t.Fatal("expected the origin of the app", config.PasskeyOrigins())
+		}
+	})
+	t.Run("uses the configured values", func(t *testing.T) {
+		config := Config{}
+		config.App.URL = "https://auth.example.com"
+		config.Auth.Providers.Passkeys.RPID = "example.com"
+		config.Auth.Providers.Passkeys.Origins = []string{"https://example.com"}
+		if config.PasskeyRPID() != "example.com" {
+			t.Fatal("expected the rp id to be example.com", config.PasskeyRPID())
+		}
+		if !slices.Equal(config.PasskeyOrigins(), []string{"https://example.com"}) {
+			t.Fatal("expected the configured origins", config.PasskeyOrigins())
+		}
+	})
+}
+
+func TestConfig_RolesWithoutMFA(t *testing.T) {
+	t.Run("removes the roles that require a second factor", func(t *testing.T) {
+		config := Config{}
+		config.MFA.RequiredForRoles = []string{"platform_admin"}
+		roles := []string{"user", "platform_admin"}
+		if !slices.Equal(config.RolesWithoutMFA(roles), []string{"user"}) {
+			t.Fatal("expected only the user role", config.RolesWithoutMFA(roles))
+		}
+		if !slices.Equal(roles, []string{"user", "platform_admin"}) {
+			t.Fatal("expected the roles to be left untouched", roles)
+		}
+	})
+}
+
+func TestConfig_PepperFor(t *testing.T) {
+	config := Config{}
+	config.Sessions.RefreshTokenPepper = "some-refresh-token-pepper"
+	t.Run("derives a distinct key per purpose", func(t *testing.T) {
+		if config.PepperFor(PepperRefreshToken) == config.PepperFor(PepperMagicLink) {
+			t.Fatal("expected two purposes to get two keys")
+		}
+		if config.PepperFor(PepperRefreshToken) == config.Sessions.RefreshTokenPepper {
+			t.Fatal("expected the pepper itself to not be used")
+		}
+	})
+	t.Run("signs the device cookie with a derived key by default", func(t *testing.T) {
+		if config.DeviceCookieSecret() != config.PepperFor(PepperDeviceCookie) {
+			t.Fatal("expected the key derived for the device cookie", config.DeviceCookieSecret())
+		}
+		config.Sessions.DeviceCookieSecret = "some-device-cookie-secret"
+		if config.DeviceCookieSecret() != "some-device-cookie-secret" {
+			t.Fatal("expected the configured secret", config.DeviceCookieSecret())
+		}
+	})
+}
@@ -2,14 +2,29 @@ package entities
 
 import (
 	"errors"
+	"slices"
 	"strings"
 )
 
 type CustomClaims struct {
-	UserID         string `json:"user_id"`
-	EarlyAdopter   bool   `json:"early_adopter"`
-	Roles          string `json:"roles"` // coma separated list
-	MetadataPublic string `json:"metadata_public"`
+	UserID       string   `json:"user_id"`
+	EarlyAdopter bool     `json:"early_adopter"`
+	Roles        []string `json:"roles"`
+	// Permissions granted by the roles when the token was issued
+	Permissions    []string `json:"permissions,omitempty"`
+	MetadataPublic string   `json:"metadata_public"`
+	// The organization the user acts in, and their role in it. Empty outside of an organization.
+	OrganizationID   string `json:"org_id,omitempty"`
+	OrganizationRole string `json:"org_role,omitempty"`
+	// Authentication methods of the login, "mfa" when it used a second factor (ex: ["fed", "otp", "mfa"])
+	AMR []string `json:"amr,omitempty"`
+	// Set for the tokens issued to an OAuth client: its ID, the scopes it was granted, and the user (or the client
+	// itself for the client credentials grant)
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	Subject  string `json:"sub,omitempty"`
+	// Set for the tokens issued before roles became an array, that carry them as a coma separated list
+	LegacyRoles bool `json:"-"`
 }
 
 func NewCustomClaimsFromValues(userID string, earlyAdopter bool, roles []Role, metadataPublic string) (*CustomClaims, error) {
@@ -22,17 +37,14 @@ func NewCustomClaimsFromValues(userID string, earlyAdopter bool, roles []Role, m
 	if metadataPublic == "" {
 		return nil, errors.New("custom_claims: metadataPublic is required to create custom claims")
 	}
-	rolesString := ""
+	rolesValues := make([]string, len(roles))
 	for i, role := range roles {
-		rolesString += role.Value
-		if i < len(roles)-1 {
-			rolesString += ","
-		}
+		rolesValues[i] = role.Value
 	}
 	return &CustomClaims{
 		UserID:         userID,
 		EarlyAdopter:   earlyAdopter,
-		Roles:          rolesString,
+		Roles:          rolesValues,
 		MetadataPublic: metadataPublic,
 	}, nil
 }
@@ -45,29 +57,106 @@ func NewCusomClaimsFromMap(ccMap map[string]any) (*CustomClaims, error) {
 	if ccMap["early_adopter"] != nil {
 		cClaims.EarlyAdopter = ccMap["early_adopter"].(bool)
 	}
-	if ccMap["roles"] != nil {
-		cClaims.Roles = ccMap["roles"].(string)
+	switch roles := ccMap["roles"].(type) {
+	case []any:
+		for _, role := range roles {
+			value, ok := role.(string)
+			if !ok {
+				return nil, errors.New("custom_claims: roles must be strings")
+			}
+			cClaims.Roles = append(cClaims.Roles, value)
+		}
+	case string:
+		for _, role := range strings.Split(roles, ",") {
+			cClaims.Roles = append(cClaims.Roles, strings.TrimSpace(role))
+		}
+		cClaims.LegacyRoles = true
+	}
+	if permissions, ok := ccMap["permissions"].([]any); ok {
+		for _, permission := range permissions {
+			value, ok := permission.(string)
+			if !ok {
+				return nil, errors.New("custom_claims: permissions must be strings")
+			}
+			cClaims.Permissions = append(cClaims.Permissions, value)
+		}
 	}
 	if ccMap["metadata_public"] != nil {
 		cClaims.MetadataPublic = ccMap["metadata_public"].(string)
 	}
+	if organizationID, ok := ccMap["org_id"].(string); ok {
+		cClaims.OrganizationID = organizationID
+	}
+	if organizationRole, ok := ccMap["org_role"].(string); ok {
+		cClaims.OrganizationRole = organizationRole
+	}
+	if amr, ok := ccMap["amr"].([]any); ok {
+		for _, method := range amr {
+			value, ok := method.(string)
+			if !ok {
+				return nil, errors.New("custom_claims: amr must be strings")
+			}
+			cClaims.AMR = append(cClaims.AMR, value)
+		}
+	}
+	if clientID, ok := ccMap["client_id"].(string); ok {
+		cClaims.ClientID = clientID
+	}
+	if scope, ok := ccMap["scope"].(string); ok {
+		cClaims.Scope = scope
+	}
+	if subject, ok := ccMap["sub"].(string); ok {
+		cClaims.Subject = subject
+	}
 	return &cClaims, nil
 }
 
 func (cc *CustomClaims) ToMap() map[string]any {
-	return map[string]any{
+	ccMap := map[string]any{
 		"user_id":         cc.UserID,
 		"early_adopter":   cc.EarlyAdopter,
 		"roles":           cc.Roles,
 		"metadata_public": cc.MetadataPublic,
 	}
+	if len(cc.Permissions) > 0 {
+		ccMap["permissions"] = cc.Permissions
+	}
+	if cc.OrganizationID != "" {
+		ccMap["org_id"] = cc.OrganizationID
+		ccMap["org_role"] = cc.OrganizationRole
+	}
+	if len(cc.AMR) > 0 {
+		ccMap["amr"] = cc.AMR
+	}
+	if cc.ClientID != "" {
+		ccMap["client_id"] = cc.ClientID
+		ccMap["scope"] = cc.Scope
+	}
+	return ccMap
 }
 
 func (cc *CustomClaims) GetRoles() []Role {
-	roles := strings.Split(cc.Roles, ",")
-	rolesFinal := make([]Role, len(roles))
-	for i, role := range roles {
-		rolesFinal[i] = Role{Value: strings.TrimSpace(role)}
+	rolesFinal := make([]Role, len(cc.Roles))
+	for i, role := range cc.Roles {
+		rolesFinal[i] = Role{Value: role}
 	}
 	return rolesFinal
 }
+
+// HasRoles tells whether the claims hold one of the roles (RoleMatchAnyOf) or all of them (RoleMatchAllOf)
+func (cc *CustomClaims) HasRoles(roles []string, match string) bool {
+	if match == RoleMatchAllOf {
+		for _, role := range roles {
+			if !slices.Contains(cc.Roles, role) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, role := range roles {
+		if slices.Contains(cc.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package entities
+
+import (
+	"aegis/pkg/fingerprint"
+	"strings"
+)
+
+// Device is the browser or app a request comes from. ID is stable for the device (it comes from a long-lived
+// device cookie), the other fields describe it.
+type Device struct {
+	ID        string
+	UserAgent string
+	// From the Sec-CH-UA-Platform client hint, if the browser sends it (ex: "macOS")
+	Platform string
+	IP       string
+}
+
+// Fingerprint is what refresh tokens are bound to
+func (d Device) Fingerprint() string {
+	deviceFingerprint, _ := fingerprint.GenerateDeviceFingerprint(d.ID)
+	return deviceFingerprint
+}
+
+// Label is a short description of the device for humans, ex: "Firefox on Linux"
+func (d Device) Label() string {
+	browser := ""
+	// Order matters: most user agents also contain the names of the browsers they derive from
+	for _, candidate := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(d.UserAgent, candidate.token) {
+			browser = candidate.name
+			break
+		}
+	}
+	platform := d.Platform
+	if platform == "" {
+		for _, candidate := range []struct{ token, name string }{
+			{"Android", "Android"},
+			{"iPhone", "iOS"},
+			{"iPad", "iPadOS"},
+			{"Mac OS X", "macOS"},
+			{"Windows", "Windows"},
+			{"CrOS", "ChromeOS"},
+			{"Linux", "Linux"},
+		} {
+			if strings.Contains(d.UserAgent, candidate.token) {
+				platform = candidate.name
+				break
+			}
+		}
+	}
+	switch {
+	case browser != "" && platform != "":
+		return browser + " on " + platform
+	case browser != "":
+		return browser
+	case platform != "":
+		return platform
+	default:
+		return "Unknown device"
+	}
+}
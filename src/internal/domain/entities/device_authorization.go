@@ -0,0 +1,114 @@
+package entities
+
+import (
+	"aegis/pkg/tokengen"
+	"strings"
+	"time"
+)
+
+const (
+	deviceAuthorizationLifetime = 10 * time.Minute
+	// Seconds the device waits between two polls of the token endpoint
+	devicePollInterval = 5
+	// Consonants only: the codes can not spell words, and no letter looks like a digit (RFC 8628, section 6.1)
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+// Status of a device authorization
+const (
+	DeviceAuthorizationPending  = "pending"
+	DeviceAuthorizationApproved = "approved"
+	DeviceAuthorizationDenied   = "denied"
+)
+
+// DeviceAuthorization lets a device without a browser (ex: a CLI) log its user in. The device shows the user code,
+// the user enters it in their browser and approves the device, meanwhile the device polls the token endpoint with the
+// device code.
+type DeviceAuthorization struct {
+	// Stored as a keyed hash
+	DeviceCode string `json:"-" gorm:"type:varchar(64);primaryKey"`
+	// Stored as a keyed hash of its normalized form
+	UserCode string `json:"-" gorm:"type:varchar(64);uniqueIndex;not null"`
+	ClientID string `json:"client_id" gorm:"type:varchar(64);not null"`
+	Scope    string `json:"scope" gorm:"type:varchar(256);not null;default:''"`
+	Status   string `json:"status" gorm:"type:varchar(16);not null"`
+	// Set once the user approved the device, with the authentication methods of their session
+	UserID string `json:"-" gorm:"type:varchar(36);not null;default:''"`
+	AMR    string `json:"-" gorm:"type:varchar(64);not null;default:''"`
+	// Seconds the device has to wait between two polls, increased each time it polls too soon
+	PollInterval int        `json:"interval" gorm:"not null"`
+	LastPolledAt *time.Time `json:"-"`
+	ExpiresAt    time.Time  `json:"expires_at" gorm:"index;not null"`
+}
+
+func NewDeviceAuthorization(clientID, scope string) (DeviceAuthorization, error) {
+	deviceCode, err := tokengen.Generate("device_", 24)
+	if err != nil {
+		return DeviceAuthorization{}, err
+	}
+	userCode, err := tokengen.GenerateFrom(userCodeAlphabet, userCodeLength)
+	if err != nil {
+		return DeviceAuthorization{}, err
+	}
+	return DeviceAuthorization{
+		DeviceCode:   deviceCode,
+		UserCode:     userCode,
+		ClientID:     clientID,
+		Scope:        SupportedScopesOf(scope),
+		Status:       DeviceAuthorizationPending,
+		PollInterval: devicePollInterval,
+		ExpiresAt:    time.Now().Add(deviceAuthorizationLifetime),
+	}, nil
+}
+
+// NormalizeUserCode ignores the case and the characters that are not in the codes, the users type "bcdf ghjk" or
+// "BCDF-GHJK" alike
+func NormalizeUserCode(userCode string) string {
+	return strings.Map(func(r rune) rune {
+		if !strings.ContainsRune(userCodeAlphabet, r) {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(userCode))
+}
+
+// FormatUserCode splits the code in two halves, easier to read out (ex: "BCDF-GHJK")
+func FormatUserCode(userCode string) string {
+	if len(userCode) != userCodeLength {
+		return userCode
+	}
+	return userCode[:userCodeLength/2] + "-" + userCode[userCodeLength/2:]
+}
+
+func (d DeviceAuthorization) IsExpired() bool {
+	return d.ExpiresAt.Before(time.Now())
+}
+
+// IsPolledTooSoon reports whether the device did not wait the interval since its last poll
+func (d DeviceAuthorization) IsPolledTooSoon(now time.Time) bool {
+	return d.LastPolledAt != nil && now.Sub(*d.LastPolledAt) < time.Duration(d.PollInterval)*time.Second
+}
+
+func (d DeviceAuthorization) Methods() []string {
+	return splitAMR(d.AMR)
+}
+
+// DeviceAuthorizationRequest starts the login of a device, the client authenticates like at the token endpoint
+type DeviceAuthorizationRequest struct {
+	ClientID     string
+	ClientSecret string
+	Scope        string
+}
+
+// DeviceAuthorizationResponse tells the device what to show to the user (RFC 8628, section 3.2)
+type DeviceAuthorizationResponse struct {
+	DeviceCode string `json:"device_code"`
+	UserCode   string `json:"user_code"`
+	// Page where the user enters the code
+	VerificationURI string `json:"verification_uri"`
+	// Same page with the code filled in, for the devices that can show a QR code
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
@@ -0,0 +1,24 @@
+package entities
+
+import "testing"
+
+func TestDeviceLabel(t *testing.T) {
+	t.Run("should describe the browser and the platform", func(t *testing.T) {
+		device := Device{UserAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36 Edg/126.0.0.0"}
+		if device.Label() != "Edge on macOS" {
+			t.Fatal("expected Edge on macOS", device.Label())
+		}
+	})
+	t.Run("should prefer the platform client hint", func(t *testing.T) {
+		device := Device{UserAgent: "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36", Platform: "ChromeOS"}
+		if device.Label() != "Chrome on ChromeOS" {
+			t.Fatal("expected Chrome on ChromeOS", device.Label())
+		}
+	})
+	t.Run("should not fail on an unknown user agent", func(t *testing.T) {
+		device := Device{UserAgent: "some-bot"}
+		if device.Label() != "Unknown device" {
+			t.Fatal("expected Unknown device", device.Label())
+		}
+	})
+}
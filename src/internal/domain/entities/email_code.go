@@ -0,0 +1,49 @@
+package entities
+
+import (
+	"aegis/pkg/tokengen"
+	"aegis/pkg/uidgen"
+	"time"
+)
+
+// Limits on the codes sent by email, a code of six digits is easy to guess otherwise
+const (
+	// Number of times a code can be tried
+	MaxEmailCodeAttempts = 5
+	// Number of codes an email can receive, and of attempts across them, during EmailCodeWindow
+	MaxEmailCodesPerEmail        = 5
+	MaxEmailCodeAttemptsPerEmail = 10
+	EmailCodeWindow              = time.Hour
+)
+
+// EmailCode logs in the owner of an email with the code sent to them.
+// Only the latest code of an email can be used, once, before it expires.
+type EmailCode struct {
+	ID    string `json:"id" gorm:"primaryKey;type:uuid"`
+	Email string `json:"email" gorm:"type:varchar(100);not null;index"`
+	// Stored as a keyed hash
+	Code      string     `json:"-" gorm:"type:varchar(64);not null"`
+	Attempts  int        `json:"attempts" gorm:"not null;default:0"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"index;not null"`
+	CreatedAt time.Time  `json:"created_at" gorm:"index;not null"`
+	UsedAt    *time.Time `json:"used_at"`
+}
+
+func NewEmailCode(email string, lifetime time.Duration) (EmailCode, error) {
+	code, err := tokengen.GenerateDigits(6)
+	if err != nil {
+		return EmailCode{}, err
+	}
+	return EmailCode{
+		ID:        uidgen.Generate(),
+		Email:     email,
+		Code:      code,
+		ExpiresAt: time.Now().Add(lifetime),
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// IsPending is true until the code is used or expires
+func (e EmailCode) IsPending() bool {
+	return e.UsedAt == nil && e.ExpiresAt.After(time.Now())
+}
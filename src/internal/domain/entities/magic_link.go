@@ -0,0 +1,41 @@
+package entities
+
+import (
+	"aegis/pkg/tokengen"
+	"time"
+)
+
+// Number of links an email can receive during MagicLinkWindow, so that asking for links does not flood its inbox
+const (
+	MaxMagicLinksPerEmail = 5
+	MagicLinkWindow       = time.Hour
+)
+
+// MagicLink logs in the owner of an email, with the token of the link sent to them.
+// It is used once, before it expires, from the device that asked for it.
+type MagicLink struct {
+	// Stored as a keyed hash
+	Token     string    `json:"-" gorm:"type:varchar(64);primaryKey"`
+	Email     string    `json:"email" gorm:"type:varchar(100);not null;index"`
+	DeviceID  string    `json:"device_id" gorm:"type:varchar(64);not null;default:''"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"index;not null"`
+	CreatedAt time.Time `json:"created_at" gorm:"not null"`
+}
+
+func NewMagicLink(email, deviceID string, lifetime time.Duration) (MagicLink, error) {
+	token, err := tokengen.Generate("magic_", 24)
+	if err != nil {
+		return MagicLink{}, err
+	}
+	return MagicLink{
+		Token:     token,
+		Email:     email,
+		DeviceID:  deviceID,
+		ExpiresAt: time.Now().Add(lifetime),
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+func (m MagicLink) IsExpired() bool {
+	return m.ExpiresAt.Before(time.Now())
+}
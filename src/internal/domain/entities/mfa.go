@@ -0,0 +1,161 @@
+package entities
+
+import (
+	"aegis/pkg/tokengen"
+	"strings"
+	"time"
+)
+
+// Authentication methods of a session, carried by the "amr" claim of its access tokens (RFC 8176)
+const (
+	// Logged in with an OAuth or OpenID Connect provider
+	AMRFederated = "fed"
+	// Logged in with a link or a code sent by email
+	AMREmail = "email"
+	// Logged in with a passkey
+	AMRHardwareKey = "hwk"
+	// Entered a code of their authenticator app, or a recovery code
+	AMROTP = "otp"
+	// Logged in with a second factor
+	AMRMultiFactor = "mfa"
+	// Logged in with a recovery code only, after losing access to their other methods
+	AMRRecoveryCode = "recovery"
+)
+
+// TOTPFactor is the authenticator app of a user. It protects the logins once it is confirmed with a first code.
+type TOTPFactor struct {
+	UserID string `json:"-" gorm:"primaryKey;type:uuid"`
+	// Base32 encoded, encrypted by the repository
+	Secret      string     `json:"-" gorm:"type:text;not null"`
+	ConfirmedAt *time.Time `json:"confirmed_at"`
+	// The period of the last code used, a code can not be used twice
+	LastUsedStep int64     `json:"-" gorm:"not null;default:0"`
+	CreatedAt    time.Time `json:"created_at" gorm:"not null"`
+}
+
+func NewTOTPFactor(userID, secret string) TOTPFactor {
+	return TOTPFactor{
+		UserID:    userID,
+		Secret:    secret,
+		CreatedAt: time.Now(),
+	}
+}
+
+func (f TOTPFactor) IsConfirmed() bool {
+	return f.ConfirmedAt != nil
+}
+
+// Number of recovery codes given to a user, each can be used once instead of a code of the authenticator app
+const RecoveryCodesCount = 10
+
+type RecoveryCode struct {
+	UserID string `json:"-" gorm:"primaryKey;type:uuid"`
+	// Stored as a keyed hash of the normalized code
+	Code      string     `json:"-" gorm:"primaryKey;type:varchar(64)"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at" gorm:"not null"`
+}
+
+// RecoveryAttempt is a failed login with a recovery code. The failures are limited per email and IP, whether the email
+// belongs to a user or not: the limit does not lock the user out from everywhere, and does not tell who has an account.
+type RecoveryAttempt struct {
+	ID string `json:"-" gorm:"primaryKey;type:uuid"`
+	// Keyed hash of the normalized email and of the IP
+	Key       string    `json:"-" gorm:"type:varchar(64);index;not null"`
+	CreatedAt time.Time `json:"created_at" gorm:"index;not null"`
+}
+
+const (
+	// An email can fail to log in with a recovery code this many times per window from an IP, then has to wait
+	MaxRecoveryAttempts    = 5
+	RecoveryAttemptsWindow = time.Hour
+	// A user can enter this many wrong codes per window (to complete a login, remove their app or get new recovery
+	// codes), then has to wait: a stolen first factor or session is not enough to guess a code
+	MaxMFACodeAttempts    = 10
+	MFACodeAttemptsWindow = 15 * time.Minute
+)
+
+// NewRecoveryCodes returns the plain codes, formatted to be written down (ex: "3f9a1-c07be")
+func NewRecoveryCodes() ([]string, error) {
+	codes := make([]string, 0, RecoveryCodesCount)
+	for range RecoveryCodesCount {
+		code, err := tokengen.Generate("", 5)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code[:5]+"-"+code[5:])
+	}
+	return codes, nil
+}
+
+// NormalizeRecoveryCode accepts a code typed without its dash, in upper case or with spaces
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.Join(strings.Fields(code), ""))
+	code = strings.ReplaceAll(code, "-", "")
+	if len(code) != 10 {
+		return code
+	}
+	return code[:5] + "-" + code[5:]
+}
+
+const (
+	pendingMFALifetime = 5 * time.Minute
+	// Number of codes that can be tried for a login, a code of six digits is easy to guess otherwise
+	MaxPendingMFAAttempts = 5
+)
+
+// PendingMFA is a login that waits for the second factor of the user, on the device it started on
+type PendingMFA struct {
+	// Stored as a keyed hash
+	Token    string `json:"-" gorm:"primaryKey;type:varchar(64)"`
+	UserID   string `json:"user_id" gorm:"type:uuid;not null"`
+	DeviceID string `json:"device_id" gorm:"type:varchar(64);not null;default:''"`
+	// Authentication methods of the first factor, comma separated
+	AMR       string    `json:"amr" gorm:"type:varchar(64);not null;default:''"`
+	Attempts  int       `json:"attempts" gorm:"not null;default:0"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"index;not null"`
+}
+
+func NewPendingMFA(userID, deviceID string, amr []string) (PendingMFA, error) {
+	token, err := tokengen.Generate("mfa_", 16)
+	if err != nil {
+		return PendingMFA{}, err
+	}
+	return PendingMFA{
+		Token:     token,
+		UserID:    userID,
+		DeviceID:  deviceID,
+		AMR:       strings.Join(amr, ","),
+		ExpiresAt: time.Now().Add(pendingMFALifetime),
+	}, nil
+}
+
+func (p PendingMFA) IsExpired() bool {
+	return p.ExpiresAt.Before(time.Now())
+}
+
+// Methods are the authentication methods of the first factor
+func (p PendingMFA) Methods() []string {
+	return splitAMR(p.AMR)
+}
+
+func splitAMR(amr string) []string {
+	if amr == "" {
+		return nil
+	}
+	return strings.Split(amr, ",")
+}
+
+// MFAStatus is shown to the user in their security settings
+type MFAStatus struct {
+	TOTPEnabled bool `json:"totp_enabled"`
+	// Number of recovery codes the user can still use
+	RecoveryCodesLeft int64 `json:"recovery_codes_left"`
+}
+
+// TOTPEnrollment is the secret to add to an authenticator app, typed or scanned from a QR code of the URI
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	// otpauth:// provisioning URI
+	URI string `json:"uri"`
+}
@@ -0,0 +1,164 @@
+package entities
+
+import (
+	"aegis/pkg/tokengen"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Scopes an OAuth client can request, the others are ignored
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+var SupportedScopes = []string{ScopeOpenID, ScopeProfile, ScopeEmail}
+
+// SupportedScopesOf keeps the supported scopes of a space separated list, once each
+func SupportedScopesOf(scope string) string {
+	scopes := []string{}
+	for _, value := range strings.Fields(scope) {
+		if slices.Contains(SupportedScopes, value) && !slices.Contains(scopes, value) {
+			scopes = append(scopes, value)
+		}
+	}
+	return strings.Join(scopes, " ")
+}
+
+// AuthorizationRequest asks for a code for the logged in user, the client is sent back to RedirectURI with it
+type AuthorizationRequest struct {
+	// Only "code" is supported
+	ResponseType string
+	ClientID     string
+	RedirectURI  string
+	Scope        string
+	// Returned as is to the client
+	State string
+	// Copied into the ID token
+	Nonce string
+	// S256 challenge of the verifier the client sends with the code (PKCE), required
+	CodeChallenge       string
+	CodeChallengeMethod string
+	// "none" fails with login_required instead of showing the login page to a user who is not logged in
+	Prompt string
+}
+
+// Grant types of the token endpoint
+const (
+	GrantTypeAuthorizationCode = "authorization_code"
+	GrantTypeClientCredentials = "client_credentials"
+	GrantTypeDeviceCode        = "urn:ietf:params:oauth:grant-type:device_code"
+)
+
+// TokenRequest exchanges a code (authorization code grant), the credentials of a service client
+// (client credentials grant), or the device code of an approved device (device authorization grant) for tokens
+type TokenRequest struct {
+	GrantType    string
+	Code         string
+	RedirectURI  string
+	ClientID     string
+	ClientSecret string
+	CodeVerifier string
+	// Scopes requested by a service client, all its scopes if empty
+	Scope      string
+	DeviceCode string
+}
+
+type OAuthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	// Lifetime of the access token, in seconds
+	ExpiresIn int64 `json:"expires_in"`
+	// Set when the client requested the "openid" scope
+	IDToken string `json:"id_token,omitempty"`
+	Scope   string `json:"scope"`
+}
+
+// OIDCUserInfo are the claims about the user, for the scopes the client was granted
+type OIDCUserInfo struct {
+	Subject       string `json:"sub"`
+	Name          string `json:"name,omitempty"`
+	Picture       string `json:"picture,omitempty"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"email_verified,omitempty"`
+}
+
+// ToMap returns the claims, to copy into an ID token
+func (u OIDCUserInfo) ToMap() map[string]any {
+	claims := map[string]any{"sub": u.Subject}
+	if u.Name != "" {
+		claims["name"] = u.Name
+	}
+	if u.Picture != "" {
+		claims["picture"] = u.Picture
+	}
+	if u.Email != "" {
+		claims["email"] = u.Email
+		claims["email_verified"] = u.EmailVerified
+	}
+	return claims
+}
+
+// OpenIDConfiguration is the discovery document of the authorization server
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+const authorizationCodeLifetime = time.Minute
+
+// AuthorizationCode is given to an OAuth client for the login of a user. The client exchanges it for tokens once,
+// before it expires, with the verifier of its code challenge.
+type AuthorizationCode struct {
+	// Stored as a keyed hash
+	Code          string `json:"-" gorm:"type:varchar(64);primaryKey"`
+	ClientID      string `json:"client_id" gorm:"type:varchar(64);not null"`
+	UserID        string `json:"user_id" gorm:"type:uuid;not null"`
+	RedirectURI   string `json:"redirect_uri" gorm:"type:varchar(1024);not null"`
+	Scope         string `json:"scope" gorm:"type:varchar(256);not null;default:''"`
+	Nonce         string `json:"-" gorm:"type:varchar(256);not null;default:''"`
+	CodeChallenge string `json:"-" gorm:"type:varchar(64);not null"`
+	// Authentication methods of the session of the user, carried over to the ID token
+	AMR       string    `json:"-" gorm:"type:varchar(64);not null;default:''"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"index;not null"`
+}
+
+func NewAuthorizationCode(request AuthorizationRequest, userID string, amr []string) (AuthorizationCode, error) {
+	code, err := tokengen.Generate("code_", 24)
+	if err != nil {
+		return AuthorizationCode{}, err
+	}
+	return AuthorizationCode{
+		Code:          code,
+		ClientID:      request.ClientID,
+		UserID:        userID,
+		RedirectURI:   request.RedirectURI,
+		Scope:         SupportedScopesOf(request.Scope),
+		Nonce:         request.Nonce,
+		CodeChallenge: request.CodeChallenge,
+		AMR:           strings.Join(amr, ","),
+		ExpiresAt:     time.Now().Add(authorizationCodeLifetime),
+	}, nil
+}
+
+func (c AuthorizationCode) IsExpired() bool {
+	return c.ExpiresAt.Before(time.Now())
+}
+
+func (c AuthorizationCode) Methods() []string {
+	return splitAMR(c.AMR)
+}
@@ -0,0 +1,93 @@
+package entities
+
+import (
+	"aegis/pkg/uidgen"
+	"time"
+)
+
+// Roles of a member in an organization. The owners and the admins manage the members.
+const (
+	OrganizationRoleOwner  = "owner"
+	OrganizationRoleAdmin  = "admin"
+	OrganizationRoleMember = "member"
+)
+
+// Organization is a workspace shared by its members, each with their own role in it
+type Organization struct {
+	ID        string    `json:"id" gorm:"primaryKey;type:uuid"`
+	Name      string    `json:"name" gorm:"type:varchar(100);not null"`
+	CreatedAt time.Time `json:"created_at" gorm:"not null"`
+}
+
+func NewOrganization(name string) Organization {
+	return Organization{
+		ID:        uidgen.Generate(),
+		Name:      name,
+		CreatedAt: time.Now(),
+	}
+}
+
+type OrganizationMember struct {
+	OrganizationID string    `json:"organization_id" gorm:"primaryKey;type:uuid"`
+	UserID         string    `json:"user_id" gorm:"primaryKey;type:uuid;index"`
+	Role           string    `json:"role" gorm:"type:varchar(32);not null"`
+	JoinedAt       time.Time `json:"joined_at" gorm:"not null"`
+	// relations
+	Organization Organization `json:"-" gorm:"foreignKey:OrganizationID;references:ID"`
+	User         User         `json:"-" gorm:"foreignKey:UserID;references:ID"`
+}
+
+func NewOrganizationMember(organizationID, userID, role string) OrganizationMember {
+	return OrganizationMember{
+		OrganizationID: organizationID,
+		UserID:         userID,
+		Role:           role,
+		JoinedAt:       time.Now(),
+	}
+}
+
+// CanManageMembers is true for the owners and the admins of the organization
+func (m OrganizationMember) CanManageMembers() bool {
+	return m.Role == OrganizationRoleOwner || m.Role == OrganizationRoleAdmin
+}
+
+// OrganizationMembership is an organization of the user, as listed to the user
+type OrganizationMembership struct {
+	Organization
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+	// The organization of the access token
+	Active bool `json:"active"`
+}
+
+// NewOrganizationMembership describes the membership, its organization must be loaded
+func NewOrganizationMembership(member OrganizationMember, activeOrganizationID string) OrganizationMembership {
+	return OrganizationMembership{
+		Organization: member.Organization,
+		Role:         member.Role,
+		JoinedAt:     member.JoinedAt,
+		Active:       member.OrganizationID == activeOrganizationID,
+	}
+}
+
+// OrganizationMemberDetails is a member of the organization, as listed to the other members
+type OrganizationMemberDetails struct {
+	UserID    string    `json:"user_id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	AvatarURL string    `json:"avatar_url"`
+	Role      string    `json:"role"`
+	JoinedAt  time.Time `json:"joined_at"`
+}
+
+// NewOrganizationMemberDetails describes the member, their user must be loaded
+func NewOrganizationMemberDetails(member OrganizationMember) OrganizationMemberDetails {
+	return OrganizationMemberDetails{
+		UserID:    member.UserID,
+		Name:      member.User.Name,
+		Email:     member.User.Email,
+		AvatarURL: member.User.AvatarURL,
+		Role:      member.Role,
+		JoinedAt:  member.JoinedAt,
+	}
+}
@@ -0,0 +1,52 @@
+package entities
+
+import (
+	"aegis/pkg/tokengen"
+	"aegis/pkg/uidgen"
+	"strings"
+	"time"
+)
+
+// OrganizationInvitation invites the owner of an email to join an organization with a role.
+// It is accepted once, with its token, before it expires.
+type OrganizationInvitation struct {
+	ID             string `json:"id" gorm:"primaryKey;type:uuid"`
+	OrganizationID string `json:"organization_id" gorm:"type:uuid;not null;index"`
+	Email          string `json:"email" gorm:"type:varchar(100);not null"`
+	Role           string `json:"role" gorm:"type:varchar(32);not null"`
+	// Sent to the invited user, it is stored as a keyed hash and never listed
+	Token      string     `json:"-" gorm:"type:varchar(64);not null;uniqueIndex"`
+	InvitedBy  string     `json:"invited_by" gorm:"type:uuid;not null"`
+	ExpiresAt  time.Time  `json:"expires_at" gorm:"not null"`
+	CreatedAt  time.Time  `json:"created_at" gorm:"not null"`
+	AcceptedAt *time.Time `json:"accepted_at"`
+	// relations
+	Organization Organization `json:"-" gorm:"foreignKey:OrganizationID;references:ID"`
+}
+
+func NewOrganizationInvitation(organizationID, email, role, invitedBy string, lifetime time.Duration) (OrganizationInvitation, error) {
+	token, err := tokengen.Generate("invitation_", 24)
+	if err != nil {
+		return OrganizationInvitation{}, err
+	}
+	return OrganizationInvitation{
+		ID:             uidgen.Generate(),
+		OrganizationID: organizationID,
+		Email:          email,
+		Role:           role,
+		Token:          token,
+		InvitedBy:      invitedBy,
+		ExpiresAt:      time.Now().Add(lifetime),
+		CreatedAt:      time.Now(),
+	}, nil
+}
+
+// IsPending is true until the invitation is accepted or expires
+func (i OrganizationInvitation) IsPending() bool {
+	return i.AcceptedAt == nil && i.ExpiresAt.After(time.Now())
+}
+
+// IsFor is true if the invitation was sent to the email
+func (i OrganizationInvitation) IsFor(email string) bool {
+	return strings.EqualFold(strings.TrimSpace(i.Email), strings.TrimSpace(email))
+}
@@ -0,0 +1,63 @@
+package entities
+
+import (
+	"aegis/pkg/tokengen"
+	"aegis/pkg/uidgen"
+	"time"
+)
+
+// Passkey is a WebAuthn credential a user logs in with, the private key stays in their authenticator
+type Passkey struct {
+	ID     string `json:"id" gorm:"primaryKey;type:uuid"`
+	UserID string `json:"user_id" gorm:"type:uuid;index;not null"`
+	// Base64url encoded, as returned by the browser
+	CredentialID string `json:"credential_id" gorm:"type:varchar(1400);uniqueIndex;not null"`
+	// COSE encoded, base64url encoded
+	PublicKey string `json:"-" gorm:"type:text;not null"`
+	// Signature counter of the authenticator, 0 if it does not count
+	SignCount  uint32     `json:"-" gorm:"not null;default:0"`
+	Name       string     `json:"name" gorm:"type:varchar(64);not null;default:''"`
+	CreatedAt  time.Time  `json:"created_at" gorm:"not null"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+func NewPasskey(userID, credentialID, publicKey string, signCount uint32, name string) Passkey {
+	return Passkey{
+		ID:           uidgen.Generate(),
+		UserID:       userID,
+		CredentialID: credentialID,
+		PublicKey:    publicKey,
+		SignCount:    signCount,
+		Name:         name,
+		CreatedAt:    time.Now(),
+	}
+}
+
+const passkeyChallengeLifetime = 5 * time.Minute
+
+// PasskeyChallenge is the challenge of a passkey registration or login, signed by the authenticator.
+// It is used once, before it expires, from the device that asked for it.
+type PasskeyChallenge struct {
+	Challenge string `json:"-" gorm:"type:varchar(64);primaryKey"`
+	DeviceID  string `json:"device_id" gorm:"type:varchar(64);not null;default:''"`
+	// Set for a registration: the user the passkey is added to. Empty for a login.
+	UserID    string    `json:"user_id" gorm:"type:varchar(36);not null;default:''"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"index;not null"`
+}
+
+func NewPasskeyChallenge(deviceID, userID string) (PasskeyChallenge, error) {
+	challenge, err := tokengen.Generate("", 32)
+	if err != nil {
+		return PasskeyChallenge{}, err
+	}
+	return PasskeyChallenge{
+		Challenge: challenge,
+		DeviceID:  deviceID,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(passkeyChallengeLifetime),
+	}, nil
+}
+
+func (c PasskeyChallenge) IsExpired() bool {
+	return c.ExpiresAt.Before(time.Now())
+}
@@ -2,15 +2,34 @@ package entities
 
 import (
 	"aegis/pkg/tokengen"
+	"aegis/pkg/uidgen"
 	"time"
 )
 
 type RefreshToken struct {
-	UserID            string    `json:"id" gorm:"type:uuid"`
-	CreatedAt         time.Time `json:"created_at" gorm:"index;not null"`
-	ExpiresAt         time.Time `json:"expires_at" gorm:"index;not null"`
-	Token             string    `json:"token" gorm:"primaryKey;type:char(32);not null"`
-	DeviceFingerprint string    `json:"device_fingerprint" gorm:"type:char(32);index;not null"`
+	UserID string `json:"id" gorm:"type:uuid"`
+	// When the session started: the tokens issued by rotation keep the creation date of the first one
+	CreatedAt  time.Time `json:"created_at" gorm:"index;not null"`
+	ExpiresAt  time.Time `json:"expires_at" gorm:"index;not null"`
+	LastUsedAt time.Time `json:"last_used_at" gorm:"index"`
+	// Stored as a keyed hash, the repository hashes the plain token on every read and write
+	Token             string `json:"token" gorm:"primaryKey;type:varchar(64);not null"`
+	DeviceFingerprint string `json:"device_fingerprint" gorm:"type:char(32);index;not null"`
+	// All the tokens issued by rotation from the same login share a family
+	FamilyID    string `json:"family_id" gorm:"type:varchar(36);index;not null;default:''"`
+	ParentToken string `json:"parent_token" gorm:"type:varchar(64);not null;default:''"`
+	// Set when the token is exchanged for a new one. Rotated tokens are kept until they expire to detect their reuse.
+	RotatedAt *time.Time `json:"rotated_at" gorm:"index"`
+	// Random value the child token was derived from, so that it can be derived again during the grace period
+	SuccessorNonce string `json:"-" gorm:"type:varchar(64);not null;default:''"`
+	// The device that last used the session, shown to the user in the list of their sessions
+	UserAgent   string `json:"user_agent" gorm:"type:varchar(512);not null;default:''"`
+	IP          string `json:"ip" gorm:"type:varchar(45);not null;default:''"`
+	DeviceLabel string `json:"device_label" gorm:"type:varchar(128);not null;default:''"`
+	// The organization the session acts in, carried by the access tokens it issues
+	OrganizationID string `json:"organization_id" gorm:"type:varchar(36);not null;default:''"`
+	// Authentication methods of the login, comma separated, carried by the access tokens of the session
+	AMR string `json:"-" gorm:"type:varchar(64);not null;default:''"`
 	// relations
 	User User `json:"user" gorm:"foreignKey:UserID;references:ID"`
 }
@@ -22,6 +41,64 @@ func (r RefreshToken) IsExpired() bool {
 	return r.ExpiresAt.Before(time.Now())
 }
 
+// Methods are the authentication methods of the login that started the session
+func (r RefreshToken) Methods() []string {
+	return splitAMR(r.AMR)
+}
+
+func (r RefreshToken) IsRotated() bool {
+	return r.RotatedAt != nil
+}
+
+// IsInGracePeriod is true if the token was rotated less than gracePeriod ago
+func (r RefreshToken) IsInGracePeriod(gracePeriod time.Duration) bool {
+	return r.RotatedAt != nil && time.Since(*r.RotatedAt) <= gracePeriod
+}
+
+// IsIdle is true if the session was not refreshed for longer than idleTimeout (0 disables it)
+func (r RefreshToken) IsIdle(idleTimeout time.Duration) bool {
+	if idleTimeout <= 0 {
+		return false
+	}
+	lastUsedAt := r.LastUsedAt
+	if lastUsedAt.IsZero() {
+		// Issued before the last use was tracked
+		lastUsedAt = r.CreatedAt
+	}
+	return time.Since(lastUsedAt) > idleTimeout
+}
+
+// HasOutlived is true if the session started longer than absoluteLifetime ago (0 disables it)
+func (r RefreshToken) HasOutlived(absoluteLifetime time.Duration) bool {
+	return absoluteLifetime > 0 && time.Since(r.CreatedAt) > absoluteLifetime
+}
+
+// WithLifetime brings the expiration of the token forward to the end of the session, if it comes first
+func (r RefreshToken) WithLifetime(lifetime SessionLifetime) RefreshToken {
+	if idleTimeout := lifetime.IdleTimeout(); idleTimeout > 0 && r.LastUsedAt.Add(idleTimeout).Before(r.ExpiresAt) {
+		r.ExpiresAt = r.LastUsedAt.Add(idleTimeout)
+	}
+	if absoluteLifetime := lifetime.AbsoluteLifetime(); absoluteLifetime > 0 && r.CreatedAt.Add(absoluteLifetime).Before(r.ExpiresAt) {
+		r.ExpiresAt = r.CreatedAt.Add(absoluteLifetime)
+	}
+	return r
+}
+
+// SuccessorToken is the value of the token that replaced this one. Deriving it requires the plain token,
+// so it cannot be computed from the database alone.
+func (r RefreshToken) SuccessorToken(plainToken string) string {
+	return tokengen.Derive("refresh_", plainToken, r.SuccessorNonce, 12)
+}
+
+// WithDevice describes the device using the token
+func (r RefreshToken) WithDevice(device Device) RefreshToken {
+	r.UserAgent = truncate(device.UserAgent, 512)
+	r.IP = truncate(device.IP, 45)
+	r.DeviceLabel = truncate(device.Label(), 128)
+	return r
+}
+
+// NewRefreshToken creates the first token of a new family, on login
 func NewRefreshToken(user User, deviceFingerprint string, config Config) (RefreshToken, int64, error) {
 	token, err := tokengen.Generate("refresh_", 12)
 	if err != nil {
@@ -33,7 +110,39 @@ func NewRefreshToken(user User, deviceFingerprint string, config Config) (Refres
 		UserID:            user.ID,
 		CreatedAt:         createdAt,
 		ExpiresAt:         expiresAt,
+		LastUsedAt:        createdAt,
 		Token:             token,
 		DeviceFingerprint: deviceFingerprint,
+		FamilyID:          uidgen.Generate(),
 	}, expiresAt.Unix(), nil
 }
+
+// NewChildRefreshToken creates the token that replaces parent when it is rotated. Its value is derived from the
+// parent token and successorNonce, the nonce must be stored on the parent (see SuccessorToken).
+func NewChildRefreshToken(parent RefreshToken, successorNonce string, config Config) (RefreshToken, int64, error) {
+	child, expiresAt, err := NewRefreshToken(User{ID: parent.UserID}, parent.DeviceFingerprint, config)
+	if err != nil {
+		return RefreshToken{}, -1, err
+	}
+	parent.SuccessorNonce = successorNonce
+	child.Token = parent.SuccessorToken(parent.Token)
+	// Tokens issued before families existed start their own
+	if parent.FamilyID != "" {
+		child.FamilyID = parent.FamilyID
+	}
+	child.ParentToken = parent.Token
+	child.CreatedAt = parent.CreatedAt
+	child.UserAgent = parent.UserAgent
+	child.IP = parent.IP
+	child.DeviceLabel = parent.DeviceLabel
+	child.OrganizationID = parent.OrganizationID
+	child.AMR = parent.AMR
+	return child, expiresAt, nil
+}
+
+func truncate(value string, maxLength int) string {
+	if len(value) > maxLength {
+		return value[:maxLength]
+	}
+	return value
+}
@@ -5,6 +5,12 @@ const (
 	RolePlatformAdmin = "platform_admin"
 )
 
+// How the roles of an authorization request are matched against the roles of the user
+const (
+	RoleMatchAnyOf = "any_of"
+	RoleMatchAllOf = "all_of"
+)
+
 type Role struct {
 	UserID string `json:"user_id" gorm:"not null;uniqueIndex:idx_user_role"`
 	Value  string `json:"role" gorm:"not null;uniqueIndex:idx_user_role"`
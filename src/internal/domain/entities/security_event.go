@@ -0,0 +1,49 @@
+package entities
+
+import (
+	"aegis/pkg/uidgen"
+	"encoding/json"
+	"time"
+)
+
+const (
+	// A refresh token was used after it had been rotated: it was likely stolen, and its family was revoked
+	SecurityEventRefreshTokenReuse = "refresh_token_reuse"
+	// A refresh token was presented by another device than the one it was issued to, and was rejected
+	SecurityEventRefreshTokenDeviceMismatch = "refresh_token_device_mismatch"
+	// A session was revoked to make room for a new login, because the user reached their session limit
+	SecurityEventSessionEvicted = "session_evicted"
+	// The signature counter of a passkey went backwards: the authenticator was likely cloned, and the login was rejected
+	SecurityEventPasskeySignCountMismatch = "passkey_sign_count_mismatch"
+	// New recovery codes replaced the previous ones of the user
+	SecurityEventRecoveryCodesGenerated = "recovery_codes_generated"
+	// A recovery code was used, to log in or instead of a code of the authenticator app
+	SecurityEventRecoveryCodeUsed = "recovery_code_used"
+	// A login with a recovery code failed, the code was wrong or already used
+	SecurityEventRecoveryCodeFailed = "recovery_code_failed"
+	// A wrong code of the authenticator app, or a wrong recovery code, was entered instead of the second factor
+	SecurityEventMFACodeFailed = "mfa_code_failed"
+)
+
+// SecurityEvent records something that happened to a user's account and that an admin may want to review
+type SecurityEvent struct {
+	ID        string    `json:"id" gorm:"primaryKey;type:uuid"`
+	UserID    string    `json:"user_id" gorm:"type:uuid;index;not null"`
+	Type      string    `json:"type" gorm:"type:varchar(32);index;not null"`
+	Details   string    `json:"details" gorm:"type:varchar(1024);not null"`
+	CreatedAt time.Time `json:"created_at" gorm:"index;not null"`
+}
+
+func NewSecurityEvent(userID, eventType string, details map[string]string) (SecurityEvent, error) {
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return SecurityEvent{}, err
+	}
+	return SecurityEvent{
+		ID:        uidgen.Generate(),
+		UserID:    userID,
+		Type:      eventType,
+		Details:   string(detailsJSON),
+		CreatedAt: time.Now(),
+	}, nil
+}
@@ -0,0 +1,67 @@
+package entities
+
+import (
+	"aegis/pkg/tokengen"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ServiceClient is a backend service, a worker or a script that gets access tokens of its own with its credentials
+// (client credentials grant). Its tokens carry the scopes it is allowed, not the roles of a user.
+type ServiceClient struct {
+	ClientID string `json:"client_id" gorm:"primaryKey;type:varchar(64)"`
+	Name     string `json:"name" gorm:"type:varchar(100);not null"`
+	// Stored as a keyed hash, the plain secret is shown once when the client is created
+	Secret string `json:"-" gorm:"type:varchar(64);not null"`
+	// Space separated (ex: "reports:read reports:write")
+	Scopes     string     `json:"scopes" gorm:"type:varchar(1024);not null;default:''"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at" gorm:"not null"`
+}
+
+// Characters allowed in a scope (RFC 6749, section 3.3), without quotes and backslashes
+var scopeRegexp = regexp.MustCompile(`^[!#-\[\]-~]+$`)
+
+func IsValidScope(scope string) bool {
+	return scopeRegexp.MatchString(scope)
+}
+
+// NewServiceClient returns the client and its plain secret
+func NewServiceClient(name string, scopes []string) (ServiceClient, string, error) {
+	clientID, err := tokengen.Generate("svc_", 12)
+	if err != nil {
+		return ServiceClient{}, "", err
+	}
+	secret, err := tokengen.Generate("secret_", 32)
+	if err != nil {
+		return ServiceClient{}, "", err
+	}
+	return ServiceClient{
+		ClientID:  clientID,
+		Name:      name,
+		Secret:    secret,
+		Scopes:    strings.Join(scopes, " "),
+		CreatedAt: time.Now(),
+	}, secret, nil
+}
+
+// GrantScopes returns the scopes requested by the client, all its scopes if it requested none.
+// It returns false if one of them is not allowed.
+func (c ServiceClient) GrantScopes(requested string) (string, bool) {
+	allowed := strings.Fields(c.Scopes)
+	if strings.TrimSpace(requested) == "" {
+		return strings.Join(allowed, " "), true
+	}
+	scopes := []string{}
+	for _, scope := range strings.Fields(requested) {
+		if !slices.Contains(allowed, scope) {
+			return "", false
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return strings.Join(scopes, " "), true
+}
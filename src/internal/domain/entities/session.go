@@ -1,5 +1,59 @@
 package entities
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type Session struct {
 	CustomClaims
 }
+
+// ActiveSession is a login of the user on one device, as listed to the user. It is the family of refresh tokens
+// issued from that login: its ID is the family ID.
+type ActiveSession struct {
+	ID          string    `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastUsedAt  time.Time `json:"last_used_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	UserAgent   string    `json:"user_agent"`
+	IP          string    `json:"ip"`
+	DeviceLabel string    `json:"device_label"`
+	// The session of the request
+	Current bool `json:"current"`
+}
+
+// NewActiveSession describes the session of token, the latest refresh token of its family
+func NewActiveSession(token RefreshToken, currentFamilyID string) ActiveSession {
+	return ActiveSession{
+		ID:          token.FamilyID,
+		CreatedAt:   token.CreatedAt,
+		LastUsedAt:  token.LastUsedAt,
+		ExpiresAt:   token.ExpiresAt,
+		UserAgent:   token.UserAgent,
+		IP:          token.IP,
+		DeviceLabel: token.DeviceLabel,
+		Current:     currentFamilyID != "" && token.FamilyID == currentFamilyID,
+	}
+}
+
+// EvictedSession is a session that was revoked because the user logged in on one device too many
+type EvictedSession struct {
+	ID          string    `json:"id"`
+	DeviceLabel string    `json:"device_label"`
+	IP          string    `json:"ip"`
+	EvictedAt   time.Time `json:"evicted_at"`
+}
+
+func NewEvictedSession(event SecurityEvent) (EvictedSession, error) {
+	var details map[string]string
+	if err := json.Unmarshal([]byte(event.Details), &details); err != nil {
+		return EvictedSession{}, err
+	}
+	return EvictedSession{
+		ID:          details["family_id"],
+		DeviceLabel: details["device_label"],
+		IP:          details["ip"],
+		EvictedAt:   event.CreatedAt,
+	}, nil
+}
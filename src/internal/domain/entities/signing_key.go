@@ -0,0 +1,38 @@
+package entities
+
+import "time"
+
+// How long the services verifying the tokens may cache the JWKS (the max-age of its Cache-Control header)
+const JWKSCacheMaxAge = 5 * time.Minute
+
+// SigningKey is an access token signing key stored in the database, so that all instances share the same key ring
+type SigningKey struct {
+	ID        string `json:"id" gorm:"primaryKey;type:varchar(64)"`
+	Algorithm string `json:"algorithm" gorm:"type:varchar(16);not null"`
+	// PEM encoded, encrypted with jwt.key_encryption_key in the database
+	PrivateKey string    `json:"-" gorm:"type:text;not null"`
+	CreatedAt  time.Time `json:"created_at" gorm:"index;not null"`
+	// Set for a key that replaces another: it is published in the JWKS, and only signs from then on. Nil for a key
+	// that signed as soon as it was stored.
+	SignsFrom *time.Time `json:"signs_from"`
+	// Set when the key is replaced: the key stops signing when the next key starts, and keeps verifying tokens until then
+	RetiresAt *time.Time `json:"retires_at" gorm:"index"`
+}
+
+// IsActive is true for the latest key, the one the next rotation replaces. It signs new tokens from SignsFrom on.
+func (k SigningKey) IsActive() bool {
+	return k.RetiresAt == nil
+}
+
+func (k SigningKey) IsRetired() bool {
+	return k.RetiresAt != nil && k.RetiresAt.Before(time.Now())
+}
+
+func NewSigningKey(id, algorithm, privateKey string) SigningKey {
+	return SigningKey{
+		ID:         id,
+		Algorithm:  algorithm,
+		PrivateKey: privateKey,
+		CreatedAt:  time.Now(),
+	}
+}
@@ -5,6 +5,12 @@ import "time"
 type State struct {
 	Value     string    `json:"value" gorm:"type:char(32);index;not null"`
 	ExpiresAt time.Time `json:"expires_at" gorm:"index;not null"`
+	// Device that started the login, the callback must come from the same one
+	DeviceID string `json:"device_id" gorm:"type:varchar(64);not null;default:''"`
+	// Set when a logged in user links another account: the callback links it to them instead of logging in
+	LinkUserID string `json:"link_user_id" gorm:"type:varchar(36);not null;default:''"`
+	// Set when the login accepts an invitation to an organization
+	InvitationID string `json:"invitation_id" gorm:"type:varchar(36);not null;default:''"`
 	// could add some actual state, like a redirect or a plan selected etc
 }
 
@@ -12,9 +18,16 @@ func (s State) IsExpired() bool {
 	return s.ExpiresAt.Before(time.Now())
 }
 
-func NewState(value string) State {
+func NewState(value, deviceID string) State {
 	return State{
 		Value:     value,
 		ExpiresAt: time.Now().Add(3 * time.Minute),
+		DeviceID:  deviceID,
 	}
 }
+
+func NewLinkState(value, deviceID, userID string) State {
+	state := NewState(value, deviceID)
+	state.LinkUserID = userID
+	return state
+}
@@ -7,4 +7,12 @@ type TokenPair struct {
 	AccessTokenExpiresAt  time.Time
 	RefreshToken          string
 	RefreshTokenExpiresAt time.Time
+	// Set instead of the tokens when the login waits for the second factor of the user
+	PendingMFAToken     string
+	PendingMFAExpiresAt time.Time
+}
+
+// IsPendingMFA is true when the user has to enter the code of their second factor to get the tokens
+func (t TokenPair) IsPendingMFA() bool {
+	return t.PendingMFAToken != ""
 }
@@ -54,3 +54,46 @@ func NewUser(name, avatar, email string, authMethod string) (User, error) {
 		AuthMethod:      authMethod,
 	}, nil
 }
+
+// UserDetails is a user as shown to admins
+type UserDetails struct {
+	ID             string     `json:"id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DeletedAt      *time.Time `json:"deleted_at"`
+	BlockedAt      *time.Time `json:"blocked_at"`
+	EarlyAdopter   bool       `json:"early_adopter"`
+	Name           string     `json:"name"`
+	AvatarURL      string     `json:"avatar_url"`
+	Email          string     `json:"email"`
+	MetadataPublic string     `json:"metadata_public"`
+	AuthMethod     string     `json:"auth_method"`
+	Roles          []string   `json:"roles"`
+}
+
+func NewUserDetails(user User) UserDetails {
+	roles := make([]string, 0, len(user.Roles))
+	for _, role := range user.Roles {
+		roles = append(roles, role.Value)
+	}
+	return UserDetails{
+		ID:             user.ID,
+		CreatedAt:      user.CreatedAt,
+		DeletedAt:      user.DeletedAt,
+		BlockedAt:      user.BlockedAt,
+		EarlyAdopter:   user.EarlyAdopter,
+		Name:           user.Name,
+		AvatarURL:      user.AvatarURL,
+		Email:          user.Email,
+		MetadataPublic: user.MetadataPublic,
+		AuthMethod:     user.AuthMethod,
+		Roles:          roles,
+	}
+}
+
+// UsersPage is one page of a list of users
+type UsersPage struct {
+	Users   []UserDetails `json:"users"`
+	Total   int64         `json:"total"`
+	Page    int           `json:"page"`
+	PerPage int           `json:"per_page"`
+}
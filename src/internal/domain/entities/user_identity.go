@@ -0,0 +1,30 @@
+package entities
+
+import (
+	"aegis/pkg/uidgen"
+	"time"
+)
+
+// UserIdentity is an account of a user at a provider, the user can log in with any of their identities
+type UserIdentity struct {
+	ID       string `json:"id" gorm:"primaryKey;type:uuid"`
+	UserID   string `json:"user_id" gorm:"type:uuid;not null;uniqueIndex:idx_user_provider"`
+	Provider string `json:"provider" gorm:"type:varchar(32);not null;uniqueIndex:idx_user_provider;uniqueIndex:idx_provider_subject,where:provider_subject_id <> ''"`
+	// ID of the account at the provider. Empty for the identities migrated from User.AuthMethod, until the next login.
+	ProviderSubjectID string    `json:"provider_subject_id" gorm:"type:varchar(255);not null;default:'';uniqueIndex:idx_provider_subject,where:provider_subject_id <> ''"`
+	Email             string    `json:"email" gorm:"type:varchar(100);not null;default:''"`
+	LinkedAt          time.Time `json:"linked_at" gorm:"not null"`
+	// relations
+	User User `json:"-" gorm:"foreignKey:UserID;references:ID"`
+}
+
+func NewUserIdentity(userID, provider, providerSubjectID, email string) UserIdentity {
+	return UserIdentity{
+		ID:                uidgen.Generate(),
+		UserID:            userID,
+		Provider:          provider,
+		ProviderSubjectID: providerSubjectID,
+		Email:             email,
+		LinkedAt:          time.Now(),
+	}
+}
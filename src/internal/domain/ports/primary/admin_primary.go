@@ -0,0 +1,19 @@
+package primary
+
+import "aegis/internal/domain/entities"
+
+type AdminUseCasesInterface interface {
+	ListUsers(search string, page, perPage int) (entities.UsersPage, error)
+	GetUser(userID string) (entities.UserDetails, error)
+	BlockUser(userID string) error
+	UnblockUser(userID string) error
+	DeleteUser(userID string) error
+	RestoreUser(userID string) error
+	SetEarlyAdopter(userID string, earlyAdopter bool) error
+	GrantRole(userID, role string) error
+	RevokeRole(userID, role string) error
+	ListServiceClients() ([]entities.ServiceClient, error)
+	// CreateServiceClient returns the new client and its secret, shown once
+	CreateServiceClient(name string, scopes []string) (entities.ServiceClient, string, error)
+	DeleteServiceClient(clientID string) error
+}
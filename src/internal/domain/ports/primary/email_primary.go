@@ -0,0 +1,13 @@
+package primary
+
+import "aegis/internal/domain/entities"
+
+type EmailUseCasesInterface interface {
+	OAuthUseCasesForMiddlewares
+	// SendMagicLink emails a login link to the address, to open on the same device
+	SendMagicLink(email string, device entities.Device) error
+	VerifyMagicLink(token string, device entities.Device) (*entities.TokenPair, error)
+	// SendLoginCode emails a code, to enter on any device
+	SendLoginCode(email string) error
+	VerifyLoginCode(email, code string, device entities.Device) (*entities.TokenPair, error)
+}
@@ -0,0 +1,8 @@
+package primary
+
+import "aegis/internal/domain/entities"
+
+type IdentityUseCasesInterface interface {
+	ListIdentities(accessToken string) ([]entities.UserIdentity, error)
+	UnlinkIdentity(accessToken, provider string) error
+}
@@ -0,0 +1,20 @@
+package primary
+
+import "aegis/internal/domain/entities"
+
+type MFAUseCasesInterface interface {
+	GetMFAStatus(accessToken string) (entities.MFAStatus, error)
+	// EnrollTOTP creates the secret of a new authenticator app for the logged in user, to confirm with ConfirmTOTP
+	EnrollTOTP(accessToken string) (entities.TOTPEnrollment, error)
+	// ConfirmTOTP enables the authenticator app with a first code, and returns new recovery codes
+	ConfirmTOTP(accessToken, code string, device entities.Device) ([]string, error)
+	// DisableTOTP removes the authenticator app, with a code of the app or a recovery code
+	DisableTOTP(accessToken, code string, device entities.Device) error
+	// GenerateRecoveryCodes replaces the recovery codes of the logged in user, and returns the new ones.
+	// A user with an authenticator app gives a code of the app or a recovery code.
+	GenerateRecoveryCodes(accessToken, code string, device entities.Device) ([]string, error)
+	// VerifyMFA completes a login pending for the second factor, with a code of the app or a recovery code
+	VerifyMFA(pendingToken, code string, device entities.Device) (*entities.TokenPair, error)
+	// LogInWithRecoveryCode logs a user in with a recovery code, to link a new identity when they lost access to theirs
+	LogInWithRecoveryCode(email, code string, device entities.Device) (*entities.TokenPair, error)
+}
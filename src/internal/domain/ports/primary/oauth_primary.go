@@ -3,8 +3,11 @@ package primary
 import "aegis/internal/domain/entities"
 
 type OAuthUseCasesForHandlers interface {
-	GetAuthURL(redirectUri string) (string, error)
-	ExchangeCode(code, state string) (*entities.TokenPair, error)
+	// GetAuthURL starts a login, which accepts the invitation to an organization of invitationToken if it is not empty
+	GetAuthURL(redirectUri, invitationToken string, device entities.Device) (string, error)
+	GetLinkURL(accessToken, redirectUri string, device entities.Device) (string, error)
+	// ExchangeCode logs the user in, or links the account to the user who started the link (no tokens are returned then)
+	ExchangeCode(code, state string, device entities.Device) (*entities.TokenPair, error)
 }
 
 type OAuthUseCasesForMiddlewares interface {
@@ -0,0 +1,22 @@
+package primary
+
+import "aegis/internal/domain/entities"
+
+type OAuthServerUseCasesInterface interface {
+	OAuthUseCasesForMiddlewares
+	GetOpenIDConfiguration() entities.OpenIDConfiguration
+	// Authorize returns the redirect URI of the client with a code for the user logged in with accessToken.
+	// It fails with ErrOAuthLoginRequired if the user is not logged in.
+	Authorize(request entities.AuthorizationRequest, accessToken string) (string, error)
+	// IssueToken returns the tokens of a grant: a code given by Authorize, the credentials of a service client, or the
+	// device code of an approved device
+	IssueToken(request entities.TokenRequest) (entities.OAuthTokenResponse, error)
+	// GetUserInfo returns the claims about the user of an access token, for the scopes granted to its client
+	GetUserInfo(accessToken string) (entities.OIDCUserInfo, error)
+	StartDeviceAuthorization(request entities.DeviceAuthorizationRequest) (entities.DeviceAuthorizationResponse, error)
+	// GetDeviceAuthorization returns the pending authorization of a user code, or nothing without a code.
+	// It fails with ErrOAuthLoginRequired if the user is not logged in, and ErrUserCodeInvalid if the code is unknown,
+	// expired or already used.
+	GetDeviceAuthorization(userCode, accessToken string) (entities.DeviceAuthorization, error)
+	DecideDeviceAuthorization(userCode, accessToken string, approve bool) error
+}
@@ -0,0 +1,16 @@
+package primary
+
+import "aegis/internal/domain/entities"
+
+type OrganizationUseCasesInterface interface {
+	CreateOrganization(accessToken, name string) (entities.Organization, error)
+	ListOrganizations(accessToken string) ([]entities.OrganizationMembership, error)
+	SwitchOrganization(accessToken, refreshToken, organizationID string, device entities.Device) (*entities.TokenPair, error)
+	ListMembers(accessToken, organizationID string) ([]entities.OrganizationMemberDetails, error)
+	SetMemberRole(accessToken, organizationID, userID, role string) error
+	RemoveMember(accessToken, organizationID, userID string) error
+	CreateInvitation(accessToken, organizationID, email, role string) (entities.OrganizationInvitation, error)
+	ListInvitations(accessToken, organizationID string) ([]entities.OrganizationInvitation, error)
+	RevokeInvitation(accessToken, organizationID, invitationID string) error
+	AcceptInvitation(accessToken, invitationToken string) (entities.Organization, error)
+}
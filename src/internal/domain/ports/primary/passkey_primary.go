@@ -0,0 +1,20 @@
+package primary
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/pkg/webauthn"
+)
+
+type PasskeyUseCasesInterface interface {
+	OAuthUseCasesForMiddlewares
+	// BeginRegistration returns the options of navigator.credentials.create() for the logged in user
+	BeginRegistration(accessToken string, device entities.Device) (webauthn.CreationOptions, error)
+	// FinishRegistration adds the passkey created by the authenticator to the logged in user
+	FinishRegistration(accessToken, name string, clientDataJSON, attestationObject []byte, device entities.Device) (entities.Passkey, error)
+	// BeginLogin returns the options of navigator.credentials.get()
+	BeginLogin(device entities.Device) (webauthn.RequestOptions, error)
+	// FinishLogin logs in the owner of the passkey that signed the challenge
+	FinishLogin(credentialID string, clientDataJSON, authenticatorData, signature, userHandle []byte, device entities.Device) (*entities.TokenPair, error)
+	ListPasskeys(accessToken string) ([]entities.Passkey, error)
+	DeletePasskey(accessToken, passkeyID string) error
+}
@@ -1,15 +1,23 @@
 package primary
 
-import "aegis/internal/domain/entities"
+import (
+	"aegis/internal/domain/entities"
+	"aegis/pkg/jwks"
+)
 
 type UseCasesForHandlers interface {
 	GetSession(accessToken string) (entities.Session, error)
 	Logout(refreshToken string) (*entities.TokenPair, error)
-	Authorize(accessToken string, authorizedRoles []string) (*entities.CustomClaims, error)
+	Authorize(accessToken string, authorizedRoles []string, match string, requiredPermissions []string) (*entities.CustomClaims, error)
+	GetJWKS() jwks.Set
+	ListSessions(accessToken, refreshToken string) ([]entities.ActiveSession, error)
+	RevokeSession(accessToken, refreshToken, sessionID string) (*entities.TokenPair, error)
+	RevokeOtherSessions(accessToken, refreshToken string) error
+	ListEvictedSessions(accessToken string) ([]entities.EvictedSession, error)
 }
 
 type UseCasesForMiddlewares interface {
-	CheckAndRefreshToken(accessToken, refreshToken string, forceRefresh bool) (*entities.TokenPair, error)
+	CheckAndRefreshToken(accessToken, refreshToken string, forceRefresh bool, device entities.Device) (*entities.TokenPair, error)
 	AuthorizeInternalAPICall(key string) error
 }
 
@@ -1,6 +1,9 @@
 package secondary
 
-import "aegis/internal/domain/entities"
+import (
+	"aegis/internal/domain/entities"
+	"time"
+)
 
 type RefreshTokenRepository interface {
 	CreateRefreshToken(refreshToken entities.RefreshToken) error
@@ -9,6 +12,17 @@ type RefreshTokenRepository interface {
 	CleanExpiredTokens(userID string) error
 	DeleteRefreshToken(token string) error
 	DeleteRefreshTokenByDeviceFingerprint(userID, deviceFingerprint string) error
+	// RotateRefreshToken marks token as rotated, stores the nonce its child was derived from and inserts the child.
+	// It fails with ErrRefreshTokenReused if token was already rotated.
+	RotateRefreshToken(token, successorNonce string, child entities.RefreshToken) error
+	DeleteRefreshTokenFamily(familyID string) error
+	// GetActiveRefreshTokensForUser returns the latest token of each valid session, most recently used first.
+	// The tokens are returned hashed.
+	GetActiveRefreshTokensForUser(userID string) ([]entities.RefreshToken, error)
+	// DeleteRefreshTokenFamilyForUser fails with ErrSessionNotFound if the user has no token in the family
+	DeleteRefreshTokenFamilyForUser(userID, familyID string) error
+	DeleteOtherRefreshTokenFamilies(userID, keptFamilyID string) error
+	DeleteRefreshTokensForUser(userID string) error
 }
 
 type StateRepository interface {
@@ -16,9 +30,173 @@ type StateRepository interface {
 	GetAndDeleteState(value string) (entities.State, error)
 }
 
+type MagicLinkRepository interface {
+	CreateMagicLink(magicLink entities.MagicLink) error
+	// CountMagicLinksSince returns the number of links sent to the email since the date, and not used yet
+	CountMagicLinksSince(email string, since time.Time) (int64, error)
+	// GetAndDeleteMagicLink fails with ErrMagicLinkInvalid if the link does not exist or was already used
+	GetAndDeleteMagicLink(token string) (entities.MagicLink, error)
+}
+
+type AuthorizationCodeRepository interface {
+	CreateAuthorizationCode(code entities.AuthorizationCode) error
+	// GetAndDeleteAuthorizationCode fails with ErrOAuthInvalidGrant if the code does not exist or was already used
+	GetAndDeleteAuthorizationCode(code string) (entities.AuthorizationCode, error)
+}
+
+type DeviceAuthorizationRepository interface {
+	CreateDeviceAuthorization(authorization entities.DeviceAuthorization) error
+	// GetDeviceAuthorization fails with ErrOAuthInvalidGrant if the device code does not exist
+	GetDeviceAuthorization(deviceCode string) (entities.DeviceAuthorization, error)
+	// GetDeviceAuthorizationByUserCode fails with ErrUserCodeInvalid if the user code does not exist
+	GetDeviceAuthorizationByUserCode(userCode string) (entities.DeviceAuthorization, error)
+	// DecideDeviceAuthorization approves or denies a pending authorization, it fails with ErrUserCodeInvalid if the
+	// user code does not exist or was already used
+	DecideDeviceAuthorization(userCode string, decision entities.DeviceAuthorization) error
+	UpdateDeviceAuthorizationPoll(deviceCode string, lastPolledAt time.Time, interval int) error
+	// DeleteDeviceAuthorization fails with ErrOAuthInvalidGrant if the device code does not exist or was already used
+	DeleteDeviceAuthorization(deviceCode string) error
+}
+
+type ServiceClientRepository interface {
+	CreateServiceClient(client entities.ServiceClient) error
+	ListServiceClients() ([]entities.ServiceClient, error)
+	// AuthenticateServiceClient returns the client of the credentials, and records its use.
+	// It fails with ErrOAuthInvalidClient if the client does not exist or the secret is wrong.
+	AuthenticateServiceClient(clientID, secret string) (entities.ServiceClient, error)
+	DeleteServiceClient(clientID string) error
+}
+
+type EmailCodeRepository interface {
+	CreateEmailCode(emailCode entities.EmailCode) error
+	// CountEmailCodesSince returns the number of codes sent to the email since the date, and the attempts on them
+	CountEmailCodesSince(email string, since time.Time) (codes int64, attempts int64, err error)
+	// ConsumeEmailCode counts an attempt on the latest code of the email, and marks it as used if code matches.
+	// It fails with ErrEmailCodeInvalid if the code is wrong, used, expired or was tried MaxEmailCodeAttempts times.
+	ConsumeEmailCode(email, code string) error
+}
+
+type PasskeyRepository interface {
+	CreatePasskeyChallenge(challenge entities.PasskeyChallenge) error
+	// GetAndDeletePasskeyChallenge fails with ErrPasskeyInvalid if the challenge does not exist or was already used
+	GetAndDeletePasskeyChallenge(challenge string) (entities.PasskeyChallenge, error)
+	// CreatePasskey fails with ErrPasskeyAlreadyRegistered if the credential is registered already
+	CreatePasskey(passkey entities.Passkey) error
+	// GetPasskeyByCredentialID fails with ErrPasskeyNotFound if the credential is not registered
+	GetPasskeyByCredentialID(credentialID string) (entities.Passkey, error)
+	GetPasskeysForUser(userID string) ([]entities.Passkey, error)
+	// UsePasskey stores the new signature counter of the passkey and when it was used. It fails with
+	// ErrPasskeyInvalid if the counter changed in the meantime: the same signature was used twice.
+	UsePasskey(passkeyID string, previousSignCount, signCount uint32) error
+	// DeletePasskey fails with ErrPasskeyNotFound if the user has no such passkey
+	DeletePasskey(userID, passkeyID string) error
+}
+
+type MFARepository interface {
+	// CreateTOTPFactor replaces the unconfirmed factor of the user, if any. It fails with ErrTOTPAlreadyEnabled
+	// if the user has a confirmed one.
+	CreateTOTPFactor(factor entities.TOTPFactor) error
+	// GetTOTPFactor returns the factor with its secret decrypted. It fails with ErrTOTPNotEnabled if the user has none.
+	GetTOTPFactor(userID string) (entities.TOTPFactor, error)
+	// ConfirmTOTPFactor enables the factor, with the period of the code that confirmed it
+	ConfirmTOTPFactor(userID string, step int64) error
+	// UseTOTPStep records the period of a code. It fails with ErrMFACodeInvalid if a code of this period, or of a
+	// later one, was already used.
+	UseTOTPStep(userID string, step int64) error
+	// DeleteTOTPFactor fails with ErrTOTPNotEnabled if the user has no factor
+	DeleteTOTPFactor(userID string) error
+	CreatePendingMFA(pending entities.PendingMFA) error
+	// GetPendingMFA fails with ErrMFATokenInvalid if the login does not exist or expired
+	GetPendingMFA(token string) (entities.PendingMFA, error)
+	// CountPendingMFAAttempt fails with ErrTooManyMFAAttempts if the login used all its attempts
+	CountPendingMFAAttempt(token string) error
+	// DeletePendingMFA fails with ErrMFATokenInvalid if the login was completed in the meantime
+	DeletePendingMFA(token string) error
+}
+
+type RecoveryCodeRepository interface {
+	// ReplaceRecoveryCodes deletes the codes of the user and stores the new ones, given in plain
+	ReplaceRecoveryCodes(userID string, codes []string) error
+	// UseRecoveryCode fails with ErrMFACodeInvalid if the user has no such unused code
+	UseRecoveryCode(userID, code string) error
+	CountUnusedRecoveryCodes(userID string) (int64, error)
+	// RecordFailedRecoveryAttempt records a failed login with a recovery code for the email, from the IP
+	RecordFailedRecoveryAttempt(email, ip string) error
+	CountFailedRecoveryAttemptsSince(email, ip string, since time.Time) (int64, error)
+}
+
 type UserRepository interface {
 	CreateUser(user entities.User, roles []entities.Role) error
 	GetUserByID(userID string) (entities.User, error)
 	GetUserByEmail(email string) (entities.User, error)
 	DoesNameExist(nameFingerprint string) (bool, error)
+	// ListUsers returns the users whose name or email contains search (all of them if empty), oldest first,
+	// and the total number of matching users
+	ListUsers(search string, offset, limit int) ([]entities.User, int64, error)
+	// The following methods fail with ErrNoUser if the user does not exist
+	SetUserBlockedAt(userID string, blockedAt *time.Time) error
+	SetUserDeletedAt(userID string, deletedAt *time.Time) error
+	SetUserEarlyAdopter(userID string, earlyAdopter bool) error
+	AddRole(role entities.Role) error
+	RemoveRole(userID, role string) error
+}
+
+type UserIdentityRepository interface {
+	CreateUserIdentity(identity entities.UserIdentity) error
+	// GetUserIdentity fails with ErrIdentityNotFound if no user is linked to the account
+	GetUserIdentity(provider, providerSubjectID string) (entities.UserIdentity, error)
+	GetUserIdentitiesForUser(userID string) ([]entities.UserIdentity, error)
+	// SetUserIdentitySubject completes an identity migrated without the ID of the account at the provider
+	SetUserIdentitySubject(identityID, providerSubjectID, email string) error
+	// DeleteUserIdentity fails with ErrIdentityNotFound if the user has no identity at the provider
+	DeleteUserIdentity(userID, provider string) error
+}
+
+type OrganizationRepository interface {
+	// CreateOrganization creates the organization with its first member
+	CreateOrganization(organization entities.Organization, owner entities.OrganizationMember) error
+	// GetOrganizationMember fails with ErrNotOrganizationMember if the user is not a member
+	GetOrganizationMember(organizationID, userID string) (entities.OrganizationMember, error)
+	// GetOrganizationsForUser returns the memberships of the user with their organization, oldest first
+	GetOrganizationsForUser(userID string) ([]entities.OrganizationMember, error)
+	// GetOrganizationMembers returns the members with their user, oldest first
+	GetOrganizationMembers(organizationID string) ([]entities.OrganizationMember, error)
+	CountOrganizationOwners(organizationID string) (int64, error)
+	// UpdateOrganizationMemberRole fails with ErrNotOrganizationMember if the user is not a member
+	UpdateOrganizationMemberRole(organizationID, userID, role string) error
+	// DeleteOrganizationMember fails with ErrNotOrganizationMember if the user is not a member
+	DeleteOrganizationMember(organizationID, userID string) error
+}
+
+type OrganizationInvitationRepository interface {
+	CreateInvitation(invitation entities.OrganizationInvitation) error
+	// GetPendingInvitationByToken returns the invitation with its organization.
+	// It fails with ErrInvitationInvalid if the invitation does not exist, was accepted or expired.
+	GetPendingInvitationByToken(token string) (entities.OrganizationInvitation, error)
+	// GetPendingInvitation fails with ErrInvitationInvalid if the invitation does not exist, was accepted or expired
+	GetPendingInvitation(invitationID string) (entities.OrganizationInvitation, error)
+	// GetPendingInvitations returns the invitations of the organization that can still be accepted, newest first
+	GetPendingInvitations(organizationID string) ([]entities.OrganizationInvitation, error)
+	// DeleteInvitation fails with ErrInvitationNotFound if the organization has no such invitation
+	DeleteInvitation(organizationID, invitationID string) error
+	// AcceptInvitation marks the invitation as accepted and adds the member, a user who is already a member keeps
+	// their role. It fails with ErrInvitationInvalid if the invitation was accepted in the meantime.
+	AcceptInvitation(invitationID string, member entities.OrganizationMember) error
+}
+
+type SigningKeyRepository interface {
+	// GetValidSigningKeys returns the keys that are not retired yet, newest first
+	GetValidSigningKeys() ([]entities.SigningKey, error)
+	// RotateSigningKey retires the active key currentID at retiresAt and inserts the new key.
+	// It fails with ErrSigningKeyAlreadyRotated if currentID is not the active key anymore, or, without currentID,
+	// if a key is already active or already stored under the ID of the new key.
+	RotateSigningKey(currentID string, newKey entities.SigningKey, retiresAt time.Time) error
+	DeleteRetiredSigningKeys() error
+}
+
+type SecurityEventRepository interface {
+	CreateSecurityEvent(event entities.SecurityEvent) error
+	// GetSecurityEventsForUser returns the latest events of a type, newest first
+	GetSecurityEventsForUser(userID, eventType string, limit int) ([]entities.SecurityEvent, error)
+	CountSecurityEventsForUserSince(userID, eventType string, since time.Time) (int64, error)
 }
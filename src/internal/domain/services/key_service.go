@@ -0,0 +1,315 @@
+package services
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/ports/secondary"
+	"aegis/pkg/apperrors"
+	"aegis/pkg/jwks"
+	"aegis/pkg/jwtgen"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	KeyStorageConfig   = "config"
+	KeyStorageDatabase = "database"
+)
+
+const (
+	// How often the key ring is reloaded, to pick up the keys rotated by another instance
+	keyRingReloadInterval = time.Minute
+	// Minimum time between two reloads triggered by a token signed with an unknown key
+	keyRingMinReloadInterval = 10 * time.Second
+	// Added to the access token lifetime when retiring a key: other instances may sign with it until their next reload,
+	// and the clocks of the services verifying the tokens may drift
+	keyRetirementLeeway = keyRingReloadInterval + time.Minute
+	// A new key is published this long before it signs: the services verifying the tokens may have cached a JWKS
+	// without it, and the other instances publish it from their next reload
+	keyPublicationDelay = entities.JWKSCacheMaxAge + keyRingReloadInterval
+)
+
+// KeyService holds the keys used to sign and verify access tokens.
+// With the "database" key storage, it holds a key ring: a new key is published before it signs, and the keys it
+// replaced keep verifying tokens until the last access token they signed expires.
+type KeyService struct {
+	config               entities.Config
+	signingKeyRepository secondary.SigningKeyRepository
+	mu                   sync.RWMutex
+	// Newest first, the first one that signs at a date signs the tokens issued then
+	signingKeys      []scheduledKey
+	verificationKeys []jwtgen.Key
+	loadedAt         time.Time
+}
+
+type scheduledKey struct {
+	key       jwtgen.Key
+	signsFrom time.Time
+}
+
+func NewKeyService(config entities.Config, signingKeyRepository secondary.SigningKeyRepository) (*KeyService, error) {
+	s := &KeyService{config: config}
+	switch config.JWT.KeyStorage {
+	case "", KeyStorageConfig:
+		signingKey, err := keyFromConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		s.signingKeys = []scheduledKey{{key: signingKey}}
+		s.verificationKeys = []jwtgen.Key{signingKey}
+		return s, nil
+	case KeyStorageDatabase:
+		if config.JWT.Algorithm == "" || config.JWT.Algorithm == jwtgen.AlgorithmHS256 {
+			return nil, errors.New("jwt: the database key storage requires an asymmetric algorithm")
+		}
+		if config.JWT.KeyEncryptionKey == "" {
+			return nil, errors.New("jwt: the database key storage requires a key_encryption_key")
+		}
+		if signingKeyRepository == nil {
+			return nil, errors.New("jwt: the database key storage requires a signing key repository")
+		}
+		s.signingKeyRepository = signingKeyRepository
+		if err := s.loadKeyRing(); err != nil {
+			return nil, err
+		}
+		return s, nil
+	default:
+		return nil, errors.New("jwt: unknown key storage " + config.JWT.KeyStorage)
+	}
+}
+
+func keyFromConfig(config entities.Config) (jwtgen.Key, error) {
+	switch config.JWT.Algorithm {
+	case "", jwtgen.AlgorithmHS256:
+		if config.JWT.Secret == "" {
+			return jwtgen.Key{}, errors.New("jwt: secret is required for HS256")
+		}
+		// No kid, for compatibility with the tokens issued before keys had IDs
+		return jwtgen.NewHMACKey("", config.JWT.Secret), nil
+	default:
+		if config.JWT.PrivateKey == "" {
+			return jwtgen.Key{}, errors.New("jwt: private_key is required for " + config.JWT.Algorithm)
+		}
+		return jwtgen.ParsePrivateKey(config.JWT.KeyID, config.JWT.Algorithm, config.JWT.PrivateKey)
+	}
+}
+
+// Sign issues an access token with the key that signs at issuedAt
+func (s *KeyService) Sign(claims map[string]any, issuedAt time.Time) (string, int64, error) {
+	s.reloadIfStale()
+	s.mu.RLock()
+	signingKeys := s.signingKeys
+	s.mu.RUnlock()
+	for _, scheduled := range signingKeys {
+		if !scheduled.signsFrom.After(issuedAt) {
+			return jwtgen.GenerateWithKey(claims, issuedAt, s.config.JWT.AccessTokenExpirationMin, s.config.App.Name, scheduled.key)
+		}
+	}
+	return "", -1, errors.New("jwt: no key signs yet")
+}
+
+// ReadClaims verifies an access token and returns its claims.
+// The tokens issued to OAuth clients are rejected, they do not grant access to the sessions of the user.
+func (s *KeyService) ReadClaims(accessToken string) (map[string]any, error) {
+	claims, err := s.ReadClientClaims(accessToken)
+	if err != nil {
+		return claims, err
+	}
+	if claims["client_id"] != nil {
+		return map[string]any{}, apperrors.ErrAccessTokenInvalid
+	}
+	return claims, nil
+}
+
+// ReadClientClaims verifies an access token, which may have been issued to an OAuth client, and returns its claims.
+// ID tokens are rejected, they identify the user to the client and are not access tokens.
+func (s *KeyService) ReadClientClaims(accessToken string) (map[string]any, error) {
+	claims, err := s.readClaims(accessToken)
+	if err != nil {
+		return claims, err
+	}
+	if claims["azp"] != nil {
+		return map[string]any{}, apperrors.ErrAccessTokenInvalid
+	}
+	return claims, nil
+}
+
+func (s *KeyService) readClaims(accessToken string) (map[string]any, error) {
+	s.reloadIfStale()
+	if s.signingKeyRepository != nil && !s.knowsKey(jwtgen.KeyID(accessToken)) {
+		// The token may have been signed by a key another instance just rotated in
+		s.reload(keyRingMinReloadInterval)
+	}
+	s.mu.RLock()
+	verificationKeys := s.verificationKeys
+	s.mu.RUnlock()
+	return jwtgen.ReadClaimsWithKeys(accessToken, verificationKeys)
+}
+
+// GetJWKS returns the public keys that verify access tokens, empty for HS256
+func (s *KeyService) GetJWKS() jwks.Set {
+	s.reloadIfStale()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	set := jwks.Set{Keys: []jwks.Key{}}
+	for _, key := range s.verificationKeys {
+		if jwk, ok := key.PublicJWK(); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
+
+// Rotate replaces the active signing key with a new one. The new key is published in the JWKS at once, and signs
+// after keyPublicationDelay. The previous key signs until then, and keeps verifying tokens until the last access token
+// it signed expires.
+func (s *KeyService) Rotate() (entities.SigningKey, error) {
+	if s.signingKeyRepository == nil {
+		return entities.SigningKey{}, errors.New("jwt: keys can only be rotated with the database key storage")
+	}
+	keys, err := s.signingKeyRepository.GetValidSigningKeys()
+	if err != nil {
+		return entities.SigningKey{}, err
+	}
+	currentID := ""
+	if active, ok := activeSigningKey(keys); ok {
+		currentID = active.ID
+	}
+	newKey, err := s.rotate(currentID)
+	if err != nil {
+		return entities.SigningKey{}, err
+	}
+	return newKey, s.loadKeyRing()
+}
+
+func (s *KeyService) rotate(currentID string) (entities.SigningKey, error) {
+	key, pemData, err := jwtgen.GenerateKey("", s.config.JWT.Algorithm)
+	if err != nil {
+		return entities.SigningKey{}, err
+	}
+	newKey := entities.NewSigningKey(key.ID, key.Algorithm, pemData)
+	signsFrom := time.Now()
+	if currentID != "" {
+		signsFrom = signsFrom.Add(keyPublicationDelay)
+		newKey.SignsFrom = &signsFrom
+	}
+	retiresAt := signsFrom.Add(time.Duration(s.config.JWT.AccessTokenExpirationMin)*time.Minute + keyRetirementLeeway)
+	if err := s.signingKeyRepository.RotateSigningKey(currentID, newKey, retiresAt); err != nil {
+		return entities.SigningKey{}, err
+	}
+	if err := s.signingKeyRepository.DeleteRetiredSigningKeys(); err != nil {
+		return entities.SigningKey{}, err
+	}
+	return newKey, nil
+}
+
+// loadKeyRing reads the valid keys from the database. It creates the first key if there is none
+// (from the configured private_key if any), and rotates the active key if it is too old.
+func (s *KeyService) loadKeyRing() error {
+	keys, err := s.signingKeyRepository.GetValidSigningKeys()
+	if err != nil {
+		return err
+	}
+	active, ok := activeSigningKey(keys)
+	switch {
+	case !ok:
+		if err := s.createFirstKey(); err != nil && !errors.Is(err, apperrors.ErrSigningKeyAlreadyRotated) {
+			return err
+		}
+	case s.config.JWT.KeyRotationDays > 0 && time.Since(active.CreatedAt) > time.Duration(s.config.JWT.KeyRotationDays)*24*time.Hour:
+		// Another instance may rotate at the same time, only one of them wins
+		if _, err := s.rotate(active.ID); err != nil && !errors.Is(err, apperrors.ErrSigningKeyAlreadyRotated) {
+			return err
+		}
+	default:
+		return s.setKeyRing(keys)
+	}
+	keys, err = s.signingKeyRepository.GetValidSigningKeys()
+	if err != nil {
+		return err
+	}
+	return s.setKeyRing(keys)
+}
+
+func (s *KeyService) createFirstKey() error {
+	if s.config.JWT.PrivateKey == "" {
+		_, err := s.rotate("")
+		return err
+	}
+	key, err := jwtgen.ParsePrivateKey(s.config.JWT.KeyID, s.config.JWT.Algorithm, s.config.JWT.PrivateKey)
+	if err != nil {
+		return err
+	}
+	return s.signingKeyRepository.RotateSigningKey("", entities.NewSigningKey(key.ID, key.Algorithm, s.config.JWT.PrivateKey), time.Time{})
+}
+
+func (s *KeyService) setKeyRing(keys []entities.SigningKey) error {
+	if _, ok := activeSigningKey(keys); !ok {
+		return errors.New("jwt: no active signing key")
+	}
+	signingKeys := []scheduledKey{}
+	verificationKeys := []jwtgen.Key{}
+	for _, stored := range keys {
+		key, err := jwtgen.ParsePrivateKey(stored.ID, stored.Algorithm, stored.PrivateKey)
+		if err != nil {
+			return err
+		}
+		scheduled := scheduledKey{key: key}
+		if stored.SignsFrom != nil {
+			scheduled.signsFrom = *stored.SignsFrom
+		}
+		signingKeys = append(signingKeys, scheduled)
+		verificationKeys = append(verificationKeys, key)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.signingKeys = signingKeys
+	s.verificationKeys = verificationKeys
+	s.loadedAt = time.Now()
+	return nil
+}
+
+func (s *KeyService) reloadIfStale() {
+	if s.signingKeyRepository != nil {
+		s.reload(keyRingReloadInterval)
+	}
+}
+
+// reload reloads the key ring if it was loaded more than minAge ago.
+// On failure, the current keys are kept: a database outage must not stop the tokens from being verified.
+func (s *KeyService) reload(minAge time.Duration) {
+	s.mu.RLock()
+	loadedAt := s.loadedAt
+	s.mu.RUnlock()
+	if time.Since(loadedAt) < minAge {
+		return
+	}
+	if err := s.loadKeyRing(); err != nil {
+		log.Println("failed to reload the signing keys:", err)
+		s.mu.Lock()
+		s.loadedAt = time.Now()
+		s.mu.Unlock()
+	}
+}
+
+func (s *KeyService) knowsKey(kid string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, key := range s.verificationKeys {
+		if key.ID == kid {
+			return true
+		}
+	}
+	return false
+}
+
+func activeSigningKey(keys []entities.SigningKey) (entities.SigningKey, bool) {
+	// Keys are sorted newest first
+	for _, key := range keys {
+		if key.IsActive() {
+			return key, true
+		}
+	}
+	return entities.SigningKey{}, false
+}
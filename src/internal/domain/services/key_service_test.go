@@ -0,0 +1,157 @@
+package services
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/infrastructure/repositories"
+	"aegis/pkg/jwtgen"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestKeyService(t *testing.T) {
+	baseConfig := entities.Config{
+		JWT: entities.JWTConfig{
+			Algorithm:                jwtgen.AlgorithmES256,
+			KeyStorage:               KeyStorageDatabase,
+			KeyEncryptionKey:         "test-key-encryption-key",
+			AccessTokenExpirationMin: 15,
+		},
+	}
+	prepare := func(t *testing.T, config entities.Config) (*KeyService, *gorm.DB) {
+		db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.AutoMigrate(&entities.SigningKey{})
+		keyService, err := NewKeyService(config, repositories.NewSigningKeyRepository(db, config.JWT.KeyEncryptionKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return keyService, db
+	}
+	t.Run("should create the first key", func(t *testing.T) {
+		keyService, db := prepare(t, baseConfig)
+		var count int64
+		db.Model(&entities.SigningKey{}).Count(&count)
+		if count != 1 {
+			t.Fatal("expected one key to be created", count)
+		}
+		if len(keyService.GetJWKS().Keys) != 1 {
+			t.Fatal("expected the key to be published", keyService.GetJWKS().Keys)
+		}
+	})
+	t.Run("should import the configured private key as the first key", func(t *testing.T) {
+		key, pemData, err := jwtgen.GenerateKey("", jwtgen.AlgorithmES256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config := baseConfig
+		config.JWT.PrivateKey = pemData
+		keyService, _ := prepare(t, config)
+		if keyService.GetJWKS().Keys[0].Kid != key.ID {
+			t.Fatal("expected the configured key to be used", keyService.GetJWKS().Keys[0].Kid)
+		}
+	})
+	t.Run("tokens signed with the previous key remain valid after a rotation", func(t *testing.T) {
+		keyService, _ := prepare(t, baseConfig)
+		before, _, err := keyService.Sign(map[string]any{"user_id": "123"}, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		newKey, err := keyService.Rotate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		after, _, err := keyService.Sign(map[string]any{"user_id": "123"}, time.Now().Add(keyPublicationDelay))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if jwtgen.KeyID(after) != newKey.ID {
+			t.Fatal("expected new tokens to be signed with the new key", jwtgen.KeyID(after))
+		}
+		if jwtgen.KeyID(before) == newKey.ID {
+			t.Fatal("expected the previous token to be signed with the previous key")
+		}
+		for _, token := range []string{before, after} {
+			if _, err := keyService.ReadClaims(token); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(keyService.GetJWKS().Keys) != 2 {
+			t.Fatal("expected both keys to be published", len(keyService.GetJWKS().Keys))
+		}
+	})
+	t.Run("a new key is published before it signs", func(t *testing.T) {
+		keyService, _ := prepare(t, baseConfig)
+		before, _, _ := keyService.Sign(map[string]any{"user_id": "123"}, time.Now())
+		newKey, err := keyService.Rotate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if newKey.SignsFrom == nil || time.Until(*newKey.SignsFrom) < entities.JWKSCacheMaxAge {
+			t.Fatal("expected the new key to sign after the JWKS cache expires", newKey.SignsFrom)
+		}
+		published := false
+		for _, key := range keyService.GetJWKS().Keys {
+			published = published || key.Kid == newKey.ID
+		}
+		if !published {
+			t.Fatal("expected the new key to be published", keyService.GetJWKS().Keys)
+		}
+		token, _, err := keyService.Sign(map[string]any{"user_id": "123"}, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if jwtgen.KeyID(token) != jwtgen.KeyID(before) {
+			t.Fatal("expected the previous key to sign until the new key starts", jwtgen.KeyID(token))
+		}
+	})
+	t.Run("should pick up a key rotated by another instance", func(t *testing.T) {
+		keyService, db := prepare(t, baseConfig)
+		otherInstance, err := NewKeyService(baseConfig, repositories.NewSigningKeyRepository(db, baseConfig.JWT.KeyEncryptionKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := otherInstance.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+		token, _, err := otherInstance.Sign(map[string]any{"user_id": "123"}, time.Now().Add(keyPublicationDelay))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Pretend the last reload was long enough ago to allow another one
+		keyService.loadedAt = time.Now().Add(-keyRingMinReloadInterval)
+		if _, err := keyService.ReadClaims(token); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("should rotate a key older than key_rotation_days", func(t *testing.T) {
+		config := baseConfig
+		config.JWT.KeyRotationDays = 30
+		_, db := prepare(t, config)
+		db.Model(&entities.SigningKey{}).Where("1 = 1").Update("created_at", time.Now().AddDate(0, 0, -31))
+		if _, err := NewKeyService(config, repositories.NewSigningKeyRepository(db, config.JWT.KeyEncryptionKey)); err != nil {
+			t.Fatal(err)
+		}
+		var count int64
+		db.Model(&entities.SigningKey{}).Where("retires_at IS NULL").Count(&count)
+		if count != 1 {
+			t.Fatal("expected one active key", count)
+		}
+		db.Model(&entities.SigningKey{}).Count(&count)
+		if count != 2 {
+			t.Fatal("expected the old key to be kept until it retires", count)
+		}
+	})
+	t.Run("should refuse the database key storage with HS256", func(t *testing.T) {
+		config := baseConfig
+		config.JWT.Algorithm = jwtgen.AlgorithmHS256
+		config.JWT.Secret = "secret"
+		if _, err := NewKeyService(config, nil); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
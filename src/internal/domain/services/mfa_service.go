@@ -0,0 +1,58 @@
+package services
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/ports/secondary"
+	"aegis/pkg/apperrors"
+	"errors"
+	"time"
+)
+
+type MFAService struct {
+	mfaRepository secondary.MFARepository
+	tokenService  *TokenService
+}
+
+func NewMFAService(mfaRepository secondary.MFARepository, tokenService *TokenService) *MFAService {
+	return &MFAService{
+		mfaRepository: mfaRepository,
+		tokenService:  tokenService,
+	}
+}
+
+// LogIn completes the first factor of a login, made with the methods amr. It returns the tokens of the user,
+// or a pending login to complete with the second factor when the user has one.
+func (s *MFAService) LogIn(user entities.User, device entities.Device, amr []string) (*entities.TokenPair, error) {
+	factor, err := s.mfaRepository.GetTOTPFactor(user.ID)
+	if err != nil && !errors.Is(err, apperrors.ErrTOTPNotEnabled) {
+		return nil, err
+	}
+	if err == nil && factor.IsConfirmed() {
+		pending, err := entities.NewPendingMFA(user.ID, device.ID, amr)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.mfaRepository.CreatePendingMFA(pending); err != nil {
+			return nil, err
+		}
+		return &entities.TokenPair{
+			PendingMFAToken:     pending.Token,
+			PendingMFAExpiresAt: pending.ExpiresAt,
+		}, nil
+	}
+	return s.IssueTokens(user, device, amr)
+}
+
+// IssueTokens creates the session of a completed login
+func (s *MFAService) IssueTokens(user entities.User, device entities.Device, amr []string) (*entities.TokenPair, error) {
+	accessToken, atExpiresAt, refreshToken, rtExpiresAt, err := s.tokenService.GenerateTokensForUser(user, device, amr)
+	if err != nil {
+		return nil, err
+	}
+	return &entities.TokenPair{
+		AccessToken:           accessToken,
+		AccessTokenExpiresAt:  time.Unix(atExpiresAt, 0),
+		RefreshToken:          refreshToken,
+		RefreshTokenExpiresAt: time.Unix(rtExpiresAt, 0),
+	}, nil
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/ports/secondary"
+	"strconv"
+)
+
+// Why a recovery code was used, in the details of its security event
+const (
+	RecoveryCodeForMFA         = "mfa"
+	RecoveryCodeForDisableTOTP = "disable_totp"
+	RecoveryCodeForLogin       = "login"
+	RecoveryCodeForNewCodes    = "new_recovery_codes"
+)
+
+// RecoveryCodeService generates and uses the recovery codes of the users, and records every use as a security event
+type RecoveryCodeService struct {
+	recoveryCodeRepository  secondary.RecoveryCodeRepository
+	securityEventRepository secondary.SecurityEventRepository
+}
+
+func NewRecoveryCodeService(recoveryCodeRepository secondary.RecoveryCodeRepository, securityEventRepository secondary.SecurityEventRepository) *RecoveryCodeService {
+	return &RecoveryCodeService{
+		recoveryCodeRepository:  recoveryCodeRepository,
+		securityEventRepository: securityEventRepository,
+	}
+}
+
+// GenerateRecoveryCodes replaces the recovery codes of the user, and returns the plain new ones
+func (s *RecoveryCodeService) GenerateRecoveryCodes(userID string, device entities.Device) ([]string, error) {
+	recoveryCodes, err := entities.NewRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.recoveryCodeRepository.ReplaceRecoveryCodes(userID, recoveryCodes); err != nil {
+		return nil, err
+	}
+	if err := s.recordEvent(userID, entities.SecurityEventRecoveryCodesGenerated, map[string]string{
+		"device_id": device.ID,
+	}); err != nil {
+		return nil, err
+	}
+	return recoveryCodes, nil
+}
+
+// UseRecoveryCode uses a code of the user for purpose, it fails with ErrMFACodeInvalid if the code is wrong or already used
+func (s *RecoveryCodeService) UseRecoveryCode(userID, code string, device entities.Device, purpose string) error {
+	if err := s.recoveryCodeRepository.UseRecoveryCode(userID, code); err != nil {
+		return err
+	}
+	left, err := s.recoveryCodeRepository.CountUnusedRecoveryCodes(userID)
+	if err != nil {
+		return err
+	}
+	return s.recordEvent(userID, entities.SecurityEventRecoveryCodeUsed, map[string]string{
+		"purpose":    purpose,
+		"device_id":  device.ID,
+		"codes_left": strconv.FormatInt(left, 10),
+	})
+}
+
+func (s *RecoveryCodeService) recordEvent(userID, eventType string, details map[string]string) error {
+	event, err := entities.NewSecurityEvent(userID, eventType, details)
+	if err != nil {
+		return err
+	}
+	return s.securityEventRepository.CreateSecurityEvent(event)
+}
@@ -5,30 +5,41 @@ import (
 	"aegis/internal/domain/ports/secondary"
 	"aegis/pkg/apperrors"
 	"aegis/pkg/fingerprint"
-	"aegis/pkg/jwtgen"
+	"aegis/pkg/tokengen"
+	"errors"
+	"slices"
+	"strings"
 	"time"
 )
 
 type TokenService struct {
-	refreshTokenRepository secondary.RefreshTokenRepository
-	config                 entities.Config
+	refreshTokenRepository  secondary.RefreshTokenRepository
+	securityEventRepository secondary.SecurityEventRepository
+	organizationRepository  secondary.OrganizationRepository
+	keyService              *KeyService
+	config                  entities.Config
 }
 
-func NewTokenService(refreshTokenRepository secondary.RefreshTokenRepository, config entities.Config) *TokenService {
+func NewTokenService(
+	refreshTokenRepository secondary.RefreshTokenRepository,
+	securityEventRepository secondary.SecurityEventRepository,
+	organizationRepository secondary.OrganizationRepository,
+	keyService *KeyService,
+	config entities.Config,
+) *TokenService {
 	return &TokenService{
-		refreshTokenRepository: refreshTokenRepository,
-		config:                 config,
+		refreshTokenRepository:  refreshTokenRepository,
+		securityEventRepository: securityEventRepository,
+		organizationRepository:  organizationRepository,
+		keyService:              keyService,
+		config:                  config,
 	}
 }
 
-// GenerateTokensForUser creates new access and refresh tokens for a user
+// GenerateTokensForUser creates new access and refresh tokens for a user, who logged in with the methods amr
 // It handles device fingerprinting, token cleanup, and validation
-func (s *TokenService) GenerateTokensForUser(user entities.User, deviceID string) (accessToken string, atExpiresAt int64, refreshToken string, rtExpiresAt int64, err error) {
-	// Generate device fingerprint
-	deviceFingerprint, err := fingerprint.GenerateDeviceFingerprint(deviceID)
-	if err != nil {
-		return "", -1, "", -1, err
-	}
+func (s *TokenService) GenerateTokensForUser(user entities.User, device entities.Device, amr []string) (accessToken string, atExpiresAt int64, refreshToken string, rtExpiresAt int64, err error) {
+	deviceFingerprint := device.Fingerprint()
 
 	// Delete existing refresh token for this device
 	err = s.refreshTokenRepository.DeleteRefreshTokenByDeviceFingerprint(user.ID, deviceFingerprint)
@@ -36,16 +47,12 @@ func (s *TokenService) GenerateTokensForUser(user entities.User, deviceID string
 		return "", -1, "", -1, err
 	}
 
-	// Check token limit
-	validRefreshTokens, err := s.refreshTokenRepository.CountValidRefreshTokensForUser(user.ID)
+	// Check session limit
+	err = s.enforceSessionLimit(user)
 	if err != nil {
 		return "", -1, "", -1, err
 	}
 
-	if validRefreshTokens >= 5 {
-		return "", -1, "", -1, apperrors.ErrTooManyRefreshTokens
-	}
-
 	// Clean expired tokens
 	_ = s.refreshTokenRepository.CleanExpiredTokens(user.ID)
 
@@ -54,20 +61,287 @@ func (s *TokenService) GenerateTokensForUser(user entities.User, deviceID string
 	if err != nil {
 		return "", -1, "", -1, err
 	}
+	newRefreshToken = newRefreshToken.WithDevice(device).WithLifetime(s.config.SessionLifetimeFor(user.Roles))
+	newRefreshToken.AMR = strings.Join(amr, ",")
+	rtExpiresAt = newRefreshToken.ExpiresAt.Unix()
 	err = s.refreshTokenRepository.CreateRefreshToken(newRefreshToken)
 	if err != nil {
 		return "", -1, "", -1, err
 	}
 
 	// Generate access token
-	cc, err := entities.NewCustomClaimsFromValues(user.ID, user.EarlyAdopter, user.Roles, user.MetadataPublic)
+	accessToken, atExpiresAt, err = s.generateAccessToken(user, newRefreshToken)
 	if err != nil {
 		return "", -1, "", -1, err
 	}
-	accessToken, atExpiresAt, err = jwtgen.Generate(cc.ToMap(), time.Now(), s.config.JWT.AccessTokenExpirationMin, s.config.App.Name, s.config.JWT.Secret)
+
+	return accessToken, atExpiresAt, newRefreshToken.Token, rtExpiresAt, nil
+}
+
+// RotateTokensForUser exchanges a refresh token for a new pair. The new refresh token joins the family of the old one.
+// A token rotated during the grace period returns the same new refresh token again.
+func (s *TokenService) RotateTokensForUser(user entities.User, parent entities.RefreshToken, device entities.Device) (accessToken string, atExpiresAt int64, refreshToken string, rtExpiresAt int64, err error) {
+	if parent.IsRotated() {
+		return s.rotateAgain(user, parent)
+	}
+	if parent.DeviceFingerprint == legacyDeviceFingerprint {
+		// The child is bound to the device that uses the token
+		parent.DeviceFingerprint = device.Fingerprint()
+	}
+
+	successorNonce, err := tokengen.Generate("", 16)
+	if err != nil {
+		return "", -1, "", -1, err
+	}
+	child, _, err := entities.NewChildRefreshToken(parent, successorNonce, s.config)
+	if err != nil {
+		return "", -1, "", -1, err
+	}
+	if device.ID != "" {
+		child = child.WithDevice(device)
+	}
+	// Sliding expiration: the child expires later than its parent, but not after the end of the session
+	child = child.WithLifetime(s.config.SessionLifetimeFor(user.Roles))
+	rtExpiresAt = child.ExpiresAt.Unix()
+	err = s.refreshTokenRepository.RotateRefreshToken(parent.Token, successorNonce, child)
+	if errors.Is(err, apperrors.ErrRefreshTokenReused) {
+		// Another request rotated it in the meantime, read the nonce it stored
+		parent, err = s.refreshTokenRepository.GetRefreshTokenByToken(parent.Token)
+		if err != nil {
+			return "", -1, "", -1, err
+		}
+		return s.rotateAgain(user, parent)
+	}
 	if err != nil {
 		return "", -1, "", -1, err
 	}
 
-	return accessToken, atExpiresAt, newRefreshToken.Token, rtExpiresAt, nil
+	accessToken, atExpiresAt, err = s.generateAccessToken(user, child)
+	if err != nil {
+		return "", -1, "", -1, err
+	}
+
+	return accessToken, atExpiresAt, child.Token, rtExpiresAt, nil
+}
+
+// SwitchOrganization rotates the session into an organization of the user, or out of the organizations with an
+// empty organizationID. The access tokens of the session then carry the organization and the role of the user in it.
+func (s *TokenService) SwitchOrganization(user entities.User, current entities.RefreshToken, organizationID string, device entities.Device) (accessToken string, atExpiresAt int64, refreshToken string, rtExpiresAt int64, err error) {
+	if organizationID != "" {
+		if _, err := s.organizationRepository.GetOrganizationMember(organizationID, user.ID); err != nil {
+			return "", -1, "", -1, err
+		}
+	}
+	if current.IsRotated() {
+		// Only the latest token of the session can move it
+		return "", -1, "", -1, apperrors.ErrRefreshTokenInvalid
+	}
+	current.OrganizationID = organizationID
+	return s.RotateTokensForUser(user, current, device)
+}
+
+// enforceSessionLimit makes room for a new session of the user, according to the session limit policy
+func (s *TokenService) enforceSessionLimit(user entities.User) error {
+	sessions, err := s.refreshTokenRepository.GetActiveRefreshTokensForUser(user.ID)
+	if err != nil {
+		return err
+	}
+	maxSessions := s.config.MaxSessions(user.Roles)
+	if len(sessions) < maxSessions {
+		return nil
+	}
+	switch s.config.Sessions.LimitPolicy {
+	case entities.SessionLimitPolicyEvictOldest:
+		slices.SortFunc(sessions, func(a, b entities.RefreshToken) int {
+			return a.CreatedAt.Compare(b.CreatedAt)
+		})
+	case entities.SessionLimitPolicyEvictLRU:
+		slices.SortFunc(sessions, func(a, b entities.RefreshToken) int {
+			return a.LastUsedAt.Compare(b.LastUsedAt)
+		})
+	default:
+		return apperrors.ErrTooManyRefreshTokens
+	}
+	for _, session := range sessions[:len(sessions)-maxSessions+1] {
+		if err := s.refreshTokenRepository.DeleteRefreshTokenFamilyForUser(user.ID, session.FamilyID); err != nil {
+			return err
+		}
+		event, err := entities.NewSecurityEvent(user.ID, entities.SecurityEventSessionEvicted, map[string]string{
+			"family_id":    session.FamilyID,
+			"device_label": session.DeviceLabel,
+			"ip":           session.IP,
+			"policy":       s.config.Sessions.LimitPolicy,
+		})
+		if err != nil {
+			return err
+		}
+		if err := s.securityEventRepository.CreateSecurityEvent(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const maxEvictedSessionsListed = 20
+
+// GetEvictedSessions returns the sessions of the user that were recently revoked by the session limit
+func (s *TokenService) GetEvictedSessions(userID string) ([]entities.EvictedSession, error) {
+	events, err := s.securityEventRepository.GetSecurityEventsForUser(userID, entities.SecurityEventSessionEvicted, maxEvictedSessionsListed)
+	if err != nil {
+		return nil, err
+	}
+	evictedSessions := make([]entities.EvictedSession, 0, len(events))
+	for _, event := range events {
+		evictedSession, err := entities.NewEvictedSession(event)
+		if err != nil {
+			return nil, err
+		}
+		evictedSessions = append(evictedSessions, evictedSession)
+	}
+	return evictedSessions, nil
+}
+
+// Fingerprint of the placeholder device all the tokens were bound to before devices were identified
+var legacyDeviceFingerprint, _ = fingerprint.GenerateDeviceFingerprint("device-id")
+
+// CheckDevice rejects a refresh token presented by another device than the one it was issued to.
+// The token was likely stolen: the attempt is recorded, but the session is kept for its legitimate device.
+func (s *TokenService) CheckDevice(token entities.RefreshToken, device entities.Device) error {
+	if token.DeviceFingerprint == legacyDeviceFingerprint || token.DeviceFingerprint == device.Fingerprint() {
+		return nil
+	}
+	event, err := entities.NewSecurityEvent(token.UserID, entities.SecurityEventRefreshTokenDeviceMismatch, map[string]string{
+		"family_id":  token.FamilyID,
+		"user_agent": device.UserAgent,
+		"ip":         device.IP,
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.securityEventRepository.CreateSecurityEvent(event); err != nil {
+		return err
+	}
+	return apperrors.ErrRefreshTokenInvalid
+}
+
+// CheckSessionLifetime rejects a token whose session was idle for too long or started too long ago, even if the
+// token itself has not expired (ex: it was issued before the lifetime of the session was reduced)
+func (s *TokenService) CheckSessionLifetime(token entities.RefreshToken, user entities.User) error {
+	lifetime := s.config.SessionLifetimeFor(user.Roles)
+	if token.IsIdle(lifetime.IdleTimeout()) || token.HasOutlived(lifetime.AbsoluteLifetime()) {
+		return apperrors.ErrRefreshTokenExpired
+	}
+	return nil
+}
+
+// IsInRefreshGracePeriod is true if the rotated token can still be exchanged for its successor
+func (s *TokenService) IsInRefreshGracePeriod(token entities.RefreshToken) bool {
+	return token.IsInGracePeriod(time.Duration(s.config.Sessions.RefreshGracePeriodSeconds) * time.Second)
+}
+
+// rotateAgain handles a token that was already rotated: during the grace period it returns its successor,
+// afterwards it is a reuse
+func (s *TokenService) rotateAgain(user entities.User, parent entities.RefreshToken) (accessToken string, atExpiresAt int64, refreshToken string, rtExpiresAt int64, err error) {
+	if !s.IsInRefreshGracePeriod(parent) {
+		return "", -1, "", -1, s.HandleRefreshTokenReuse(parent)
+	}
+	child, err := s.refreshTokenRepository.GetRefreshTokenByToken(parent.SuccessorToken(parent.Token))
+	if err != nil {
+		return "", -1, "", -1, err
+	}
+	if child.IsExpired() {
+		return "", -1, "", -1, apperrors.ErrRefreshTokenExpired
+	}
+
+	accessToken, atExpiresAt, err = s.generateAccessToken(user, child)
+	if err != nil {
+		return "", -1, "", -1, err
+	}
+
+	return accessToken, atExpiresAt, child.Token, child.ExpiresAt.Unix(), nil
+}
+
+// ReadAccessTokenClaims verifies an access token and returns its claims
+func (s *TokenService) ReadAccessTokenClaims(accessToken string) (*entities.CustomClaims, error) {
+	ccMap, err := s.keyService.ReadClaims(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	return entities.NewCusomClaimsFromMap(ccMap)
+}
+
+// ReadClientAccessTokenClaims verifies an access token of a session or of an OAuth client, and returns its claims
+func (s *TokenService) ReadClientAccessTokenClaims(accessToken string) (*entities.CustomClaims, error) {
+	ccMap, err := s.keyService.ReadClientClaims(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	return entities.NewCusomClaimsFromMap(ccMap)
+}
+
+// RevokeUserSessions logs the user out of all their devices
+func (s *TokenService) RevokeUserSessions(userID string) error {
+	return s.refreshTokenRepository.DeleteRefreshTokensForUser(userID)
+}
+
+// HandleRefreshTokenReuse is called when a token that was already rotated is presented again.
+// Either the legitimate client or an attacker holds a stolen copy, so the whole family is revoked
+// and both have to log in again. It always returns ErrRefreshTokenReused, unless revoking fails.
+func (s *TokenService) HandleRefreshTokenReuse(token entities.RefreshToken) error {
+	if err := s.RevokeRefreshTokenFamily(token); err != nil {
+		return err
+	}
+	event, err := entities.NewSecurityEvent(token.UserID, entities.SecurityEventRefreshTokenReuse, map[string]string{
+		"family_id": token.FamilyID,
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.securityEventRepository.CreateSecurityEvent(event); err != nil {
+		return err
+	}
+	return apperrors.ErrRefreshTokenReused
+}
+
+// RevokeRefreshTokenFamily deletes the token and all the tokens rotated from the same login
+func (s *TokenService) RevokeRefreshTokenFamily(token entities.RefreshToken) error {
+	if token.FamilyID == "" {
+		return s.refreshTokenRepository.DeleteRefreshToken(token.Token)
+	}
+	return s.refreshTokenRepository.DeleteRefreshTokenFamily(token.FamilyID)
+}
+
+// generateAccessToken issues an access token of the session of the refresh token
+func (s *TokenService) generateAccessToken(user entities.User, session entities.RefreshToken) (string, int64, error) {
+	cc, err := s.CustomClaimsFor(user, session.Methods(), session.OrganizationID)
+	if err != nil {
+		return "", -1, err
+	}
+	return s.keyService.Sign(cc.ToMap(), time.Now())
+}
+
+// CustomClaimsFor returns the claims of the access tokens of a user, who logged in with the methods amr and acts in
+// the organization organizationID (empty outside of an organization)
+func (s *TokenService) CustomClaimsFor(user entities.User, amr []string, organizationID string) (*entities.CustomClaims, error) {
+	cc, err := entities.NewCustomClaimsFromValues(user.ID, user.EarlyAdopter, user.Roles, user.MetadataPublic)
+	if err != nil {
+		return nil, err
+	}
+	cc.AMR = amr
+	if !slices.Contains(cc.AMR, entities.AMRMultiFactor) {
+		cc.Roles = s.config.RolesWithoutMFA(cc.Roles)
+	}
+	cc.Permissions = s.config.PermissionsFor(cc.Roles)
+	if organizationID != "" {
+		// The role is read on every refresh, a member who left the organization loses it
+		member, err := s.organizationRepository.GetOrganizationMember(organizationID, user.ID)
+		if err != nil && !errors.Is(err, apperrors.ErrNotOrganizationMember) {
+			return nil, err
+		}
+		if err == nil {
+			cc.OrganizationID = member.OrganizationID
+			cc.OrganizationRole = member.Role
+		}
+	}
+	return cc, nil
 }
@@ -0,0 +1,174 @@
+package services
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/infrastructure/repositories"
+	"aegis/pkg/apperrors"
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestTokenService_RotateTokensForUser(t *testing.T) {
+	baseConfig := entities.Config{
+		JWT: entities.JWTConfig{
+			Secret:                     "some-secret",
+			AccessTokenExpirationMin:   1,
+			RefreshTokenExpirationDays: 1,
+		},
+	}
+	prepare := func(t *testing.T, config entities.Config) (*TokenService, *repositories.RefreshTokenRepository, entities.User) {
+		db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.AutoMigrate(&entities.RefreshToken{}, &entities.SecurityEvent{})
+		keyService, err := NewKeyService(config, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		refreshTokenRepository := repositories.NewRefreshTokenRepository(db, "some-pepper")
+		tokenService := NewTokenService(refreshTokenRepository, repositories.NewSecurityEventRepository(db), repositories.NewOrganizationRepository(db), keyService, config)
+		user := entities.User{ID: "123", Roles: []entities.Role{{UserID: "123", Value: "user"}}, MetadataPublic: "{}"}
+		return tokenService, refreshTokenRepository, user
+	}
+	// Simulates two requests (possibly on two instances) that read the same token before either rotated it
+	rotateConcurrently := func(t *testing.T, tokenService *TokenService, refreshTokenRepository *repositories.RefreshTokenRepository, user entities.User) (string, string, error) {
+		device := entities.Device{ID: "some-device-id"}
+		parent, _, err := entities.NewRefreshToken(user, device.Fingerprint(), baseConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := refreshTokenRepository.CreateRefreshToken(parent); err != nil {
+			t.Fatal(err)
+		}
+		_, _, first, _, err := tokenService.RotateTokensForUser(user, parent, device)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, second, _, err := tokenService.RotateTokensForUser(user, parent, device)
+		return first, second, err
+	}
+	t.Run("the request that loses the race gets the same new token during the grace period", func(t *testing.T) {
+		config := baseConfig
+		config.Sessions.RefreshGracePeriodSeconds = 10
+		tokenService, refreshTokenRepository, user := prepare(t, config)
+		first, second, err := rotateConcurrently(t, tokenService, refreshTokenRepository, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first != second {
+			t.Fatal("expected the same new refresh token", first, second)
+		}
+	})
+	t.Run("the request that loses the race is a reuse without grace period", func(t *testing.T) {
+		tokenService, refreshTokenRepository, user := prepare(t, baseConfig)
+		_, _, err := rotateConcurrently(t, tokenService, refreshTokenRepository, user)
+		if !errors.Is(err, apperrors.ErrRefreshTokenReused) {
+			t.Fatal("expected error to be 'refresh_token_reused'", err)
+		}
+	})
+}
+
+func TestTokenService_SessionLimit(t *testing.T) {
+	baseConfig := entities.Config{
+		JWT: entities.JWTConfig{
+			Secret:                     "some-secret",
+			AccessTokenExpirationMin:   1,
+			RefreshTokenExpirationDays: 1,
+		},
+	}
+	withLimit := func(maxPerUser int, policy string) entities.Config {
+		config := baseConfig
+		config.Sessions.MaxPerUser = maxPerUser
+		config.Sessions.LimitPolicy = policy
+		return config
+	}
+	prepare := func(t *testing.T, config entities.Config) (*TokenService, *repositories.RefreshTokenRepository, *gorm.DB, entities.User) {
+		db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.AutoMigrate(&entities.RefreshToken{}, &entities.SecurityEvent{})
+		keyService, err := NewKeyService(config, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		refreshTokenRepository := repositories.NewRefreshTokenRepository(db, "some-pepper")
+		tokenService := NewTokenService(refreshTokenRepository, repositories.NewSecurityEventRepository(db), repositories.NewOrganizationRepository(db), keyService, config)
+		user := entities.User{ID: "123", Roles: []entities.Role{{UserID: "123", Value: "user"}}, MetadataPublic: "{}"}
+		return tokenService, refreshTokenRepository, db, user
+	}
+	login := func(t *testing.T, tokenService *TokenService, user entities.User, deviceID string) (string, error) {
+		_, _, refreshToken, _, err := tokenService.GenerateTokensForUser(user, entities.Device{ID: deviceID, UserAgent: "Firefox/128.0"}, nil)
+		return refreshToken, err
+	}
+
+	t.Run("rejects the login by default", func(t *testing.T) {
+		tokenService, _, _, user := prepare(t, withLimit(2, ""))
+		for _, deviceID := range []string{"laptop", "phone"} {
+			if _, err := login(t, tokenService, user, deviceID); err != nil {
+				t.Fatal("expected no error", err)
+			}
+		}
+		if _, err := login(t, tokenService, user, "tablet"); !errors.Is(err, apperrors.ErrTooManyRefreshTokens) {
+			t.Fatal("expected error ErrTooManyRefreshTokens", err)
+		}
+		if _, err := login(t, tokenService, user, "laptop"); err != nil {
+			t.Fatal("expected a new login on the same device to replace its session", err)
+		}
+	})
+	t.Run("evicts the oldest session", func(t *testing.T) {
+		tokenService, refreshTokenRepository, db, user := prepare(t, withLimit(2, entities.SessionLimitPolicyEvictOldest))
+		laptop, _ := login(t, tokenService, user, "laptop")
+		phone, _ := login(t, tokenService, user, "phone")
+		db.Model(&entities.RefreshToken{}).Where("token = ?", refreshTokenRepository.Hash(laptop)).Update("created_at", time.Now().Add(-time.Hour))
+		if _, err := login(t, tokenService, user, "tablet"); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if _, err := refreshTokenRepository.GetRefreshTokenByToken(laptop); err == nil {
+			t.Fatal("expected the laptop session to be evicted")
+		}
+		if _, err := refreshTokenRepository.GetRefreshTokenByToken(phone); err != nil {
+			t.Fatal("expected the phone session to survive", err)
+		}
+		evictedSessions, err := tokenService.GetEvictedSessions(user.ID)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if len(evictedSessions) != 1 || evictedSessions[0].DeviceLabel != "Firefox" {
+			t.Fatal("expected the eviction to be recorded", evictedSessions)
+		}
+	})
+	t.Run("evicts the least recently used session", func(t *testing.T) {
+		tokenService, refreshTokenRepository, db, user := prepare(t, withLimit(2, entities.SessionLimitPolicyEvictLRU))
+		laptop, _ := login(t, tokenService, user, "laptop")
+		phone, _ := login(t, tokenService, user, "phone")
+		db.Model(&entities.RefreshToken{}).Where("token = ?", refreshTokenRepository.Hash(laptop)).Update("created_at", time.Now().Add(-time.Hour))
+		db.Model(&entities.RefreshToken{}).Where("token = ?", refreshTokenRepository.Hash(phone)).Update("last_used_at", time.Now().Add(-time.Minute))
+		if _, err := login(t, tokenService, user, "tablet"); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if _, err := refreshTokenRepository.GetRefreshTokenByToken(phone); err == nil {
+			t.Fatal("expected the phone session to be evicted")
+		}
+		if _, err := refreshTokenRepository.GetRefreshTokenByToken(laptop); err != nil {
+			t.Fatal("expected the laptop session to survive", err)
+		}
+	})
+	t.Run("the smallest limit among the roles of the user applies", func(t *testing.T) {
+		config := withLimit(5, "")
+		config.Sessions.MaxPerRole = map[string]int{"admin": 1, "support": 3}
+		tokenService, _, _, user := prepare(t, config)
+		user.Roles = append(user.Roles, entities.Role{UserID: user.ID, Value: "admin"}, entities.Role{UserID: user.ID, Value: "support"})
+		if _, err := login(t, tokenService, user, "laptop"); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if _, err := login(t, tokenService, user, "phone"); !errors.Is(err, apperrors.ErrTooManyRefreshTokens) {
+			t.Fatal("expected error ErrTooManyRefreshTokens", err)
+		}
+	})
+}
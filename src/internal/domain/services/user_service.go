@@ -5,23 +5,26 @@ import (
 	"aegis/internal/domain/ports/secondary"
 	"aegis/pkg/apperrors"
 	"aegis/pkg/plugins/providers"
+	"errors"
 )
 
 type UserService struct {
-	userRepository secondary.UserRepository
-	config         entities.Config
+	userRepository         secondary.UserRepository
+	userIdentityRepository secondary.UserIdentityRepository
+	config                 entities.Config
 }
 
-func NewUserService(userRepository secondary.UserRepository, config entities.Config) *UserService {
+func NewUserService(userRepository secondary.UserRepository, userIdentityRepository secondary.UserIdentityRepository, config entities.Config) *UserService {
 	return &UserService{
-		userRepository: userRepository,
-		config:         config,
+		userRepository:         userRepository,
+		userIdentityRepository: userIdentityRepository,
+		config:                 config,
 	}
 }
 
 // GetOrCreateUserIfAllowed handles the business logic for user creation and validation
-// during OAuth authentication flows
-func (s *UserService) GetOrCreateUserIfAllowed(userInfos *providers.UserInfos, authMethod string) (entities.User, error) {
+// during OAuth authentication flows. A user invited to an organization becomes an early adopter.
+func (s *UserService) GetOrCreateUserIfAllowed(userInfos *providers.UserInfos, authMethod string, invited bool) (entities.User, error) {
 	if userInfos.Email == "" {
 		return entities.User{}, apperrors.ErrNoEmail
 	}
@@ -37,8 +40,7 @@ func (s *UserService) GetOrCreateUserIfAllowed(userInfos *providers.UserInfos, a
 		return entities.User{}, apperrors.ErrNameAlreadyExists
 	}
 
-	// Try to get existing user by email
-	user, err := s.userRepository.GetUserByEmail(userInfos.Email)
+	user, err := s.findUser(userInfos, authMethod)
 	if err != nil && err.Error() != apperrors.ErrNoUser.Error() {
 		return entities.User{}, err
 	}
@@ -54,22 +56,114 @@ func (s *UserService) GetOrCreateUserIfAllowed(userInfos *providers.UserInfos, a
 		if err != nil {
 			return entities.User{}, err
 		}
+		err = s.userIdentityRepository.CreateUserIdentity(entities.NewUserIdentity(user.ID, authMethod, userInfos.SubjectID, userInfos.Email))
+		if err != nil {
+			return entities.User{}, err
+		}
 		user, err = s.userRepository.GetUserByEmail(userInfos.Email)
 		if err != nil {
 			return entities.User{}, err
 		}
 	}
 
-	// Validate user status
+	if err := s.CheckUserAllowed(user); err != nil {
+		if !errors.Is(err, apperrors.ErrEarlyAdoptersOnly) || !invited {
+			return entities.User{}, err
+		}
+		// The flag is checked again on every refresh
+		if err := s.userRepository.SetUserEarlyAdopter(user.ID, true); err != nil {
+			return entities.User{}, err
+		}
+		user.EarlyAdopter = true
+	}
+
+	return user, nil
+}
+
+// CheckUserAllowed tells whether an existing user can log in
+func (s *UserService) CheckUserAllowed(user entities.User) error {
 	if user.IsDeleted() {
-		return entities.User{}, apperrors.ErrUserDeleted
+		return apperrors.ErrUserDeleted
 	}
 	if user.IsBlocked() {
-		return entities.User{}, apperrors.ErrUserBlocked
+		return apperrors.ErrUserBlocked
 	}
 	if s.config.App.EarlyAdoptersOnly && !user.IsEarlyAdopter() {
-		return entities.User{}, apperrors.ErrEarlyAdoptersOnly
+		return apperrors.ErrEarlyAdoptersOnly
 	}
+	return nil
+}
 
-	return user, nil
+// findUser returns the user linked to the account, or the user with the same email if they registered with this
+// provider before identities were introduced. An email registered with another provider is rejected with
+// ErrWrongAuthMethod: the user has to log in with that provider and link this one.
+// The account is matched by its ID at the provider, the email is only trusted when the provider verified it.
+func (s *UserService) findUser(userInfos *providers.UserInfos, provider string) (entities.User, error) {
+	if userInfos.SubjectID != "" {
+		identity, err := s.userIdentityRepository.GetUserIdentity(provider, userInfos.SubjectID)
+		if err == nil {
+			if identity.Email != userInfos.Email {
+				if err := s.userIdentityRepository.SetUserIdentitySubject(identity.ID, userInfos.SubjectID, userInfos.Email); err != nil {
+					return entities.User{}, err
+				}
+			}
+			return s.userRepository.GetUserByID(identity.UserID)
+		}
+		if !errors.Is(err, apperrors.ErrIdentityNotFound) {
+			return entities.User{}, err
+		}
+	}
+
+	// Anyone can claim an unverified email: it would take over the account with this email, or squat it
+	if !userInfos.EmailVerified {
+		return entities.User{}, apperrors.ErrEmailNotVerified
+	}
+
+	user, err := s.userRepository.GetUserByEmail(userInfos.Email)
+	if err != nil {
+		return entities.User{}, err
+	}
+	identities, err := s.userIdentityRepository.GetUserIdentitiesForUser(user.ID)
+	if err != nil {
+		return entities.User{}, err
+	}
+	if len(identities) == 0 && user.AuthMethod == provider {
+		// Not migrated yet
+		return user, s.userIdentityRepository.CreateUserIdentity(entities.NewUserIdentity(user.ID, provider, userInfos.SubjectID, userInfos.Email))
+	}
+	for _, identity := range identities {
+		if identity.Provider == provider && identity.ProviderSubjectID == "" {
+			// Migrated from the auth method, the first login tells which account it is
+			return user, s.userIdentityRepository.SetUserIdentitySubject(identity.ID, userInfos.SubjectID, userInfos.Email)
+		}
+	}
+	return entities.User{}, apperrors.ErrWrongAuthMethod
+}
+
+// LinkIdentity adds the account to the identities of the user, so that they can log in with it
+func (s *UserService) LinkIdentity(userID string, userInfos *providers.UserInfos, provider string) error {
+	if userInfos.SubjectID == "" {
+		return apperrors.ErrProviderUnavailable
+	}
+	identity, err := s.userIdentityRepository.GetUserIdentity(provider, userInfos.SubjectID)
+	if err == nil {
+		if identity.UserID == userID {
+			return nil
+		}
+		return apperrors.ErrIdentityAlreadyLinked
+	}
+	if !errors.Is(err, apperrors.ErrIdentityNotFound) {
+		return err
+	}
+	identities, err := s.userIdentityRepository.GetUserIdentitiesForUser(userID)
+	if err != nil {
+		return err
+	}
+	for _, identity := range identities {
+		if identity.Provider == provider {
+			// One account per provider, unlink the other one first
+			return apperrors.ErrIdentityAlreadyLinked
+		}
+	}
+	return s.userIdentityRepository.CreateUserIdentity(entities.NewUserIdentity(userID, provider, userInfos.SubjectID, userInfos.Email))
 }
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/infrastructure/repositories"
+	"aegis/pkg/apperrors"
+	"aegis/pkg/plugins/providers"
+	"errors"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestUserService_Identities(t *testing.T) {
+	prepare := func(t *testing.T) (*UserService, *repositories.UserRepository, *repositories.UserIdentityRepository) {
+		db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.AutoMigrate(&entities.User{}, &entities.Role{}, &entities.UserIdentity{})
+		userRepository := repositories.NewUserRepository(db)
+		userIdentityRepository := repositories.NewUserIdentityRepository(db)
+		return NewUserService(userRepository, userIdentityRepository, entities.Config{}), userRepository, userIdentityRepository
+	}
+	createLegacyUser := func(t *testing.T, userRepository *repositories.UserRepository) entities.User {
+		user, err := entities.NewUser("some-name", "", "some-email@example.com", "github")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := userRepository.CreateUser(user, []entities.Role{entities.NewRole(user.ID, "user")}); err != nil {
+			t.Fatal(err)
+		}
+		return user
+	}
+	t.Run("creates the identity of a new user", func(t *testing.T) {
+		userService, _, userIdentityRepository := prepare(t)
+		user, err := userService.GetOrCreateUserIfAllowed(&providers.UserInfos{SubjectID: "42", Name: "some-name", Email: "some-email@example.com", EmailVerified: true}, "github", false)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		identity, err := userIdentityRepository.GetUserIdentity("github", "42")
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if identity.UserID != user.ID {
+			t.Fatal("expected the identity to belong to the user", identity.UserID)
+		}
+	})
+	t.Run("migrates a legacy user on their next login", func(t *testing.T) {
+		userService, userRepository, userIdentityRepository := prepare(t)
+		legacyUser := createLegacyUser(t, userRepository)
+		if err := userIdentityRepository.MigrateLegacyUserIdentities(); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		user, err := userService.GetOrCreateUserIfAllowed(&providers.UserInfos{SubjectID: "42", Name: "some-name", Email: "some-email@example.com", EmailVerified: true}, "github", false)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if user.ID != legacyUser.ID {
+			t.Fatal("expected the legacy user", user.ID)
+		}
+		identities, _ := userIdentityRepository.GetUserIdentitiesForUser(user.ID)
+		if len(identities) != 1 || identities[0].ProviderSubjectID != "42" {
+			t.Fatal("expected the migrated identity to get the subject", identities)
+		}
+	})
+	t.Run("rejects an email registered with another provider", func(t *testing.T) {
+		userService, userRepository, _ := prepare(t)
+		createLegacyUser(t, userRepository)
+		_, err := userService.GetOrCreateUserIfAllowed(&providers.UserInfos{SubjectID: "42", Name: "some-name", Email: "some-email@example.com", EmailVerified: true}, "discord", false)
+		if !errors.Is(err, apperrors.ErrWrongAuthMethod) {
+			t.Fatal("expected ErrWrongAuthMethod", err)
+		}
+	})
+	t.Run("logs in with a linked identity whatever its email", func(t *testing.T) {
+		userService, userRepository, _ := prepare(t)
+		legacyUser := createLegacyUser(t, userRepository)
+		err := userService.LinkIdentity(legacyUser.ID, &providers.UserInfos{SubjectID: "7", Email: "other@example.com", EmailVerified: true}, "discord")
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		user, err := userService.GetOrCreateUserIfAllowed(&providers.UserInfos{SubjectID: "7", Name: "other-name", Email: "other@example.com", EmailVerified: true}, "discord", false)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if user.ID != legacyUser.ID {
+			t.Fatal("expected the linked user", user.ID)
+		}
+	})
+	t.Run("refuses to link an identity of another user", func(t *testing.T) {
+		userService, userRepository, _ := prepare(t)
+		legacyUser := createLegacyUser(t, userRepository)
+		other, _ := entities.NewUser("other-name", "", "other@example.com", "discord")
+		userRepository.CreateUser(other, []entities.Role{entities.NewRole(other.ID, "user")})
+		if err := userService.LinkIdentity(other.ID, &providers.UserInfos{SubjectID: "7"}, "discord"); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		err := userService.LinkIdentity(legacyUser.ID, &providers.UserInfos{SubjectID: "7"}, "discord")
+		if !errors.Is(err, apperrors.ErrIdentityAlreadyLinked) {
+			t.Fatal("expected ErrIdentityAlreadyLinked", err)
+		}
+		if err := userService.LinkIdentity(other.ID, &providers.UserInfos{SubjectID: "7"}, "discord"); err != nil {
+			t.Fatal("expected linking the same identity twice to be a no-op", err)
+		}
+	})
+	t.Run("keeps the user when their email changes at the provider", func(t *testing.T) {
+		userService, _, userIdentityRepository := prepare(t)
+		user, err := userService.GetOrCreateUserIfAllowed(&providers.UserInfos{SubjectID: "42", Name: "some-name", Email: "some-email@example.com", EmailVerified: true}, "github", false)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		sameUser, err := userService.GetOrCreateUserIfAllowed(&providers.UserInfos{SubjectID: "42", Name: "some-name", Email: "new-email@example.com"}, "github", false)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if sameUser.ID != user.ID {
+			t.Fatal("expected the same user", sameUser.ID)
+		}
+		identity, _ := userIdentityRepository.GetUserIdentity("github", "42")
+		if identity.Email != "new-email@example.com" {
+			t.Fatal("expected the email of the identity to be updated", identity.Email)
+		}
+	})
+	t.Run("rejects an unverified email", func(t *testing.T) {
+		userService, userRepository, _ := prepare(t)
+		createLegacyUser(t, userRepository)
+		_, err := userService.GetOrCreateUserIfAllowed(&providers.UserInfos{SubjectID: "42", Name: "some-name", Email: "some-email@example.com"}, "github", false)
+		if !errors.Is(err, apperrors.ErrEmailNotVerified) {
+			t.Fatal("expected ErrEmailNotVerified", err)
+		}
+		_, err = userService.GetOrCreateUserIfAllowed(&providers.UserInfos{SubjectID: "43", Name: "new-name", Email: "new-email@example.com"}, "github", false)
+		if !errors.Is(err, apperrors.ErrEmailNotVerified) {
+			t.Fatal("expected ErrEmailNotVerified for a new user", err)
+		}
+	})
+	t.Run("lets invited users in when only early adopters can log in", func(t *testing.T) {
+		_, userRepository, userIdentityRepository := prepare(t)
+		config := entities.Config{}
+		config.App.EarlyAdoptersOnly = true
+		userService := NewUserService(userRepository, userIdentityRepository, config)
+		userInfos := &providers.UserInfos{SubjectID: "42", Name: "some-name", Email: "some-email@example.com", EmailVerified: true}
+		_, err := userService.GetOrCreateUserIfAllowed(userInfos, "github", false)
+		if !errors.Is(err, apperrors.ErrEarlyAdoptersOnly) {
+			t.Fatal("expected ErrEarlyAdoptersOnly", err)
+		}
+		user, err := userService.GetOrCreateUserIfAllowed(userInfos, "github", true)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		stored, _ := userRepository.GetUserByID(user.ID)
+		if !user.EarlyAdopter || !stored.EarlyAdopter {
+			t.Fatal("expected the invited user to become an early adopter", stored.EarlyAdopter)
+		}
+	})
+}
@@ -26,6 +26,14 @@ func Read(configPath string) (entities.Config, error) {
 	// Replace environment variables in the config
 	replaceEnvVars(&config)
 
+	if config.JWT.PrivateKeyFile != "" && config.JWT.PrivateKey == "" {
+		privateKey, err := os.ReadFile(config.JWT.PrivateKeyFile)
+		if err != nil {
+			return entities.Config{}, errors.New("failed to read jwt private key file: " + err.Error())
+		}
+		config.JWT.PrivateKey = string(privateKey)
+	}
+
 	return config, nil
 }
 
@@ -61,6 +69,9 @@ func replaceEnvVars(config any) {
 					replaced := interpolateEnvVars(str)
 					elem.SetString(replaced)
 				}
+				if elem.Kind() == reflect.Struct && elem.CanAddr() {
+					replaceEnvVars(elem.Addr().Interface())
+				}
 			}
 		}
 	}
@@ -15,6 +15,7 @@ func TestReadWithEnvReplacement(t *testing.T) {
 	os.Setenv("PROTOCOL", "https")
 	os.Setenv("HOST", "api.example.com")
 	os.Setenv("PORT", "8080")
+	os.Setenv("TEST_OIDC_CLIENT_SECRET", "oidc-secret-456")
 	defer func() {
 		os.Unsetenv("TEST_DB_URL")
 		os.Unsetenv("TEST_JWT_SECRET")
@@ -24,6 +25,7 @@ func TestReadWithEnvReplacement(t *testing.T) {
 		os.Unsetenv("PROTOCOL")
 		os.Unsetenv("HOST")
 		os.Unsetenv("PORT")
+		os.Unsetenv("TEST_OIDC_CLIENT_SECRET")
 	}()
 
 	// Create a temporary config file with various env: references
@@ -50,7 +52,16 @@ func TestReadWithEnvReplacement(t *testing.T) {
 					"enabled": true,
 					"client_id": "${env:TEST_GITHUB_CLIENT_ID}",
 					"client_secret": "static-github-secret"
-				}
+				},
+				"oidc": [
+					{
+						"name": "keycloak",
+						"enabled": true,
+						"issuer": "https://sso.example.com/realms/main",
+						"client_id": "aegis",
+						"client_secret": "${env:TEST_OIDC_CLIENT_SECRET}"
+					}
+				]
 			}
 		}
 	}`
@@ -102,4 +113,12 @@ func TestReadWithEnvReplacement(t *testing.T) {
 	if config.App.CorsAllowedOrigins[1] != "http://localhost:3000" {
 		t.Errorf("Expected second CORS origin to be 'http://localhost:3000', got '%s'", config.App.CorsAllowedOrigins[1])
 	}
+
+	// Check slice of structs handling
+	if len(config.Auth.Providers.OIDC) != 1 {
+		t.Fatalf("Expected 1 OIDC provider, got %d", len(config.Auth.Providers.OIDC))
+	}
+	if config.Auth.Providers.OIDC[0].ClientSecret != "oidc-secret-456" {
+		t.Errorf("Expected OIDC client secret to be 'oidc-secret-456', got '%s'", config.Auth.Providers.OIDC[0].ClientSecret)
+	}
 }
@@ -28,6 +28,23 @@ func Migrate(db *gorm.DB) error {
 		&entities.User{},
 		&entities.Role{},
 		&entities.State{},
+		&entities.MagicLink{},
+		&entities.EmailCode{},
+		&entities.PasskeyChallenge{},
+		&entities.Passkey{},
+		&entities.TOTPFactor{},
+		&entities.RecoveryCode{},
+		&entities.RecoveryAttempt{},
+		&entities.PendingMFA{},
+		&entities.AuthorizationCode{},
+		&entities.ServiceClient{},
+		&entities.DeviceAuthorization{},
 		&entities.RefreshToken{},
+		&entities.SigningKey{},
+		&entities.SecurityEvent{},
+		&entities.Organization{},
+		&entities.OrganizationMember{},
+		&entities.OrganizationInvitation{},
+		&entities.UserIdentity{},
 	)
 }
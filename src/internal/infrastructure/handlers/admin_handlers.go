@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/ports/primary"
+	"aegis/pkg/apperrors"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+type AdminHandlersInterface interface {
+	ListUsers(c echo.Context) error
+	GetUser(c echo.Context) error
+	BlockUser(c echo.Context) error
+	UnblockUser(c echo.Context) error
+	DeleteUser(c echo.Context) error
+	RestoreUser(c echo.Context) error
+	SetEarlyAdopter(c echo.Context) error
+	GrantRole(c echo.Context) error
+	RevokeRole(c echo.Context) error
+	ListServiceClients(c echo.Context) error
+	CreateServiceClient(c echo.Context) error
+	DeleteServiceClient(c echo.Context) error
+}
+
+type AdminHandlers struct {
+	Config  entities.Config
+	Service primary.AdminUseCasesInterface
+}
+
+var _ AdminHandlersInterface = (*AdminHandlers)(nil)
+
+func NewAdminHandlers(c entities.Config, s primary.AdminUseCasesInterface) *AdminHandlers {
+	return &AdminHandlers{
+		Config:  c,
+		Service: s,
+	}
+}
+
+func (h AdminHandlers) ListUsers(c echo.Context) error {
+	// Invalid numbers fall back to the defaults
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	perPage, _ := strconv.Atoi(c.QueryParam("per_page"))
+	usersPage, err := h.Service.ListUsers(c.QueryParam("q"), page, perPage)
+	if err != nil {
+		return adminError(c, err)
+	}
+	return c.JSON(http.StatusOK, usersPage)
+}
+
+func (h AdminHandlers) GetUser(c echo.Context) error {
+	user, err := h.Service.GetUser(c.Param("id"))
+	if err != nil {
+		return adminError(c, err)
+	}
+	return c.JSON(http.StatusOK, user)
+}
+
+func (h AdminHandlers) BlockUser(c echo.Context) error {
+	return h.respond(c, h.Service.BlockUser(c.Param("id")))
+}
+
+func (h AdminHandlers) UnblockUser(c echo.Context) error {
+	return h.respond(c, h.Service.UnblockUser(c.Param("id")))
+}
+
+func (h AdminHandlers) DeleteUser(c echo.Context) error {
+	return h.respond(c, h.Service.DeleteUser(c.Param("id")))
+}
+
+func (h AdminHandlers) RestoreUser(c echo.Context) error {
+	return h.respond(c, h.Service.RestoreUser(c.Param("id")))
+}
+
+func (h AdminHandlers) SetEarlyAdopter(c echo.Context) error {
+	type Body struct {
+		EarlyAdopter *bool `json:"early_adopter"`
+	}
+	body := Body{}
+	if err := c.Bind(&body); err != nil || body.EarlyAdopter == nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": apperrors.ErrGeneric.Error()})
+	}
+	return h.respond(c, h.Service.SetEarlyAdopter(c.Param("id"), *body.EarlyAdopter))
+}
+
+func (h AdminHandlers) GrantRole(c echo.Context) error {
+	return h.respond(c, h.Service.GrantRole(c.Param("id"), c.Param("role")))
+}
+
+func (h AdminHandlers) RevokeRole(c echo.Context) error {
+	return h.respond(c, h.Service.RevokeRole(c.Param("id"), c.Param("role")))
+}
+
+func (h AdminHandlers) ListServiceClients(c echo.Context) error {
+	clients, err := h.Service.ListServiceClients()
+	if err != nil {
+		return adminError(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{"service_clients": clients})
+}
+
+// CreateServiceClient returns the client with its secret, which is not shown again
+func (h AdminHandlers) CreateServiceClient(c echo.Context) error {
+	type Body struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+	body := Body{}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": apperrors.ErrGeneric.Error()})
+	}
+	client, secret, err := h.Service.CreateServiceClient(body.Name, body.Scopes)
+	if err != nil {
+		return adminError(c, err)
+	}
+	return c.JSON(http.StatusCreated, struct {
+		entities.ServiceClient
+		ClientSecret string `json:"client_secret"`
+	}{client, secret})
+}
+
+func (h AdminHandlers) DeleteServiceClient(c echo.Context) error {
+	if err := h.Service.DeleteServiceClient(c.Param("client_id")); err != nil {
+		return adminError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// respond returns the updated user
+func (h AdminHandlers) respond(c echo.Context, err error) error {
+	if err != nil {
+		return adminError(c, err)
+	}
+	return h.GetUser(c)
+}
+
+func adminError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, apperrors.ErrNoUser), errors.Is(err, apperrors.ErrServiceClientNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, apperrors.ErrUnknownRole), errors.Is(err, apperrors.ErrNoRoles),
+		errors.Is(err, apperrors.ErrServiceClientNameRequired), errors.Is(err, apperrors.ErrOAuthInvalidScope):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": apperrors.ErrGeneric.Error()})
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/ports/primary"
+	"aegis/internal/infrastructure/requestctx"
+	"aegis/pkg/apperrors"
+	"aegis/pkg/urlbuilder"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type EmailHandlersInterface interface {
+	SendMagicLink(c echo.Context) error
+	VerifyMagicLink(c echo.Context) error
+	SendLoginCode(c echo.Context) error
+	VerifyLoginCode(c echo.Context) error
+}
+
+type EmailHandlers struct {
+	Config  entities.Config
+	Service primary.EmailUseCasesInterface
+}
+
+var _ EmailHandlersInterface = (*EmailHandlers)(nil)
+
+func NewEmailHandlers(c entities.Config, s primary.EmailUseCasesInterface) *EmailHandlers {
+	return &EmailHandlers{
+		Config:  c,
+		Service: s,
+	}
+}
+
+// SendMagicLink answers the same whether the email belongs to a user or not
+func (h EmailHandlers) SendMagicLink(c echo.Context) error {
+	type Body struct {
+		Email string `json:"email"`
+	}
+	body := Body{}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": apperrors.ErrGeneric.Error()})
+	}
+	if err := h.Service.SendMagicLink(body.Email, requestctx.Device(c)); err != nil {
+		if errors.Is(err, apperrors.ErrInvalidEmail) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		if errors.Is(err, apperrors.ErrTooManyMagicLinks) {
+			return c.JSON(http.StatusTooManyRequests, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": apperrors.ErrGeneric.Error()})
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func (h EmailHandlers) VerifyMagicLink(c echo.Context) error {
+	tokensPair, err := h.Service.VerifyMagicLink(c.QueryParam("token"), requestctx.Device(c))
+	if err != nil {
+		redirectURL, err := urlbuilder.Build(h.Config.App.RedirectAfterError, "", map[string]string{"error": loginErrorType(err)})
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": apperrors.ErrGeneric.Error()})
+		}
+		return c.Redirect(http.StatusFound, redirectURL)
+	}
+	redirectURL, err := completeLogin(c, tokensPair, h.Config)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": apperrors.ErrGeneric.Error()})
+	}
+	return c.Redirect(http.StatusFound, redirectURL)
+}
+
+// SendLoginCode answers the same whether the email belongs to a user or not
+func (h EmailHandlers) SendLoginCode(c echo.Context) error {
+	type Body struct {
+		Email string `json:"email"`
+	}
+	body := Body{}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": apperrors.ErrGeneric.Error()})
+	}
+	if err := h.Service.SendLoginCode(body.Email); err != nil {
+		return emailCodeError(c, err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// VerifyLoginCode sets the cookies of the session and returns where to go next
+func (h EmailHandlers) VerifyLoginCode(c echo.Context) error {
+	type Body struct {
+		Email string `json:"email"`
+		Code  string `json:"code"`
+	}
+	body := Body{}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": apperrors.ErrGeneric.Error()})
+	}
+	tokensPair, err := h.Service.VerifyLoginCode(body.Email, body.Code, requestctx.Device(c))
+	if err != nil {
+		return emailCodeError(c, err)
+	}
+	redirectURL, err := completeLogin(c, tokensPair, h.Config)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": apperrors.ErrGeneric.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"redirect_url": redirectURL})
+}
+
+func emailCodeError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, apperrors.ErrInvalidEmail):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, apperrors.ErrEmailCodeInvalid):
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	case errors.Is(err, apperrors.ErrTooManyEmailCodes), errors.Is(err, apperrors.ErrTooManyEmailCodeAttempts):
+		return c.JSON(http.StatusTooManyRequests, map[string]string{"error": err.Error()})
+	}
+	// The user can not log in (blocked, not an early adopter...), with the error of the error page
+	if errorType := loginErrorType(err); errorType != "unknown_error" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": errorType})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": apperrors.ErrGeneric.Error()})
+}
@@ -3,12 +3,18 @@ package handlers
 import (
 	"aegis/internal/domain/entities"
 	"aegis/internal/domain/ports/primary"
+	"aegis/internal/infrastructure/requestctx"
 	"aegis/pkg/apperrors"
 	"aegis/pkg/cookies"
+	"aegis/pkg/urlbuilder"
 	"embed"
+	"encoding/base64"
 	"errors"
 	"html/template"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 )
@@ -23,6 +29,11 @@ type HandlersInterface interface {
 	ServeLoginPage(c echo.Context) error
 	ServeErrorPage(c echo.Context) error
 	Authorize(c echo.Context) error
+	GetJWKS(c echo.Context) error
+	ListSessions(c echo.Context) error
+	RevokeSession(c echo.Context) error
+	RevokeOtherSessions(c echo.Context) error
+	ListEvictedSessions(c echo.Context) error
 }
 
 type Handlers struct {
@@ -68,10 +79,7 @@ func (h Handlers) Logout(c echo.Context) error {
 	}
 	tokensPair, err := h.Service.Logout(refreshToken)
 	if tokensPair != nil {
-		accessCookie := cookies.NewAccessCookie(tokensPair.AccessToken, tokensPair.AccessTokenExpiresAt.Unix(), h.Config)
-		refreshCookie := cookies.NewRefreshCookie(tokensPair.RefreshToken, tokensPair.RefreshTokenExpiresAt.Unix(), h.Config)
-		c.SetCookie(&accessCookie)
-		c.SetCookie(&refreshCookie)
+		setSessionCookies(c, tokensPair, h.Config)
 	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": apperrors.ErrGeneric.Error()})
@@ -83,6 +91,130 @@ func (h Handlers) DoNothing(c echo.Context) error {
 	return c.NoContent(http.StatusOK)
 }
 
+// GetJWKS publishes the public keys that verify access tokens, so that other services can verify them offline
+func (h Handlers) GetJWKS(c echo.Context) error {
+	c.Response().Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(entities.JWKSCacheMaxAge.Seconds())))
+	return c.JSON(http.StatusOK, h.Service.GetJWKS())
+}
+
+func (h Handlers) ListSessions(c echo.Context) error {
+	accessToken, refreshToken := sessionCookies(c)
+	sessions, err := h.Service.ListSessions(accessToken, refreshToken)
+	if err != nil {
+		return sessionError(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{"sessions": sessions})
+}
+
+func (h Handlers) RevokeSession(c echo.Context) error {
+	accessToken, refreshToken := sessionCookies(c)
+	tokensPair, err := h.Service.RevokeSession(accessToken, refreshToken, c.Param("id"))
+	if err != nil {
+		return sessionError(c, err)
+	}
+	if tokensPair != nil {
+		setSessionCookies(c, tokensPair, h.Config)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func (h Handlers) RevokeOtherSessions(c echo.Context) error {
+	accessToken, refreshToken := sessionCookies(c)
+	if err := h.Service.RevokeOtherSessions(accessToken, refreshToken); err != nil {
+		return sessionError(c, err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func (h Handlers) ListEvictedSessions(c echo.Context) error {
+	accessToken, _ := sessionCookies(c)
+	evictedSessions, err := h.Service.ListEvictedSessions(accessToken)
+	if err != nil {
+		return sessionError(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{"evicted_sessions": evictedSessions})
+}
+
+func sessionCookies(c echo.Context) (accessToken, refreshToken string) {
+	if cookie, err := c.Cookie("access_token"); err == nil {
+		accessToken = cookie.Value
+	}
+	if cookie, err := c.Cookie("refresh_token"); err == nil {
+		refreshToken = cookie.Value
+	}
+	return accessToken, refreshToken
+}
+
+// setSessionCookies sets the cookies of the tokens of a session
+func setSessionCookies(c echo.Context, tokensPair *entities.TokenPair, config entities.Config) {
+	accessCookie := cookies.NewAccessCookie(tokensPair.AccessToken, tokensPair.AccessTokenExpiresAt.Unix(), config)
+	refreshCookie := cookies.NewRefreshCookie(tokensPair.RefreshToken, tokensPair.RefreshTokenExpiresAt.Unix(), config)
+	c.SetCookie(&accessCookie)
+	c.SetCookie(&refreshCookie)
+}
+
+// completeLogin sets the cookies of a login and returns where to go next: the app, or the login page where the
+// user enters the code of their second factor. Without the login page, the app gets the error "mfa_required"
+// and posts the code to /auth/mfa/verify itself.
+func completeLogin(c echo.Context, tokensPair *entities.TokenPair, config entities.Config) (string, error) {
+	if !tokensPair.IsPendingMFA() {
+		setSessionCookies(c, tokensPair, config)
+		return redirectAfterLogin(c, config), nil
+	}
+	mfaCookie := cookies.NewMFACookie(tokensPair.PendingMFAToken, tokensPair.PendingMFAExpiresAt.Unix(), config)
+	c.SetCookie(&mfaCookie)
+	if !config.LoginPage.Enabled {
+		return urlbuilder.Build(config.App.RedirectAfterError, "", map[string]string{"error": "mfa_required"})
+	}
+	return urlbuilder.Build(config.App.URL, config.LoginPage.FullPath, map[string]string{"mfa": "required"})
+}
+
+// redirectAfterLogin returns where to send a user who just logged in: the page of the OAuth server they logged in for
+// (the authorization request of a client, or the approval of a device), or the app
+func redirectAfterLogin(c echo.Context, config entities.Config) string {
+	cookie, err := c.Cookie("oauth_request")
+	if err != nil || cookie.Value == "" {
+		return config.App.RedirectAfterSuccess
+	}
+	oauthRequestCookie := cookies.NewOAuthRequestCookieZero(config)
+	c.SetCookie(&oauthRequestCookie)
+	path, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil || !slices.ContainsFunc(oauthRequestPaths, func(prefix string) bool { return strings.HasPrefix(string(path), prefix) }) {
+		return config.App.RedirectAfterSuccess
+	}
+	return strings.TrimSuffix(config.App.URL, "/") + string(path)
+}
+
+// Pages of the OAuth server a user can be sent back to after their login, the cookie can not send them elsewhere
+var oauthRequestPaths = []string{"/auth/authorize?", "/auth/device?"}
+
+// resumeAfterLogin sends a user who is not logged in to the login page, and back to path once they are logged in.
+// Without the login page, the app gets the error "login_required".
+func resumeAfterLogin(c echo.Context, config entities.Config, path string) error {
+	redirectURL, err := urlbuilder.Build(config.App.RedirectAfterError, "", map[string]string{"error": apperrors.ErrOAuthLoginRequired.Error()})
+	if config.LoginPage.Enabled {
+		oauthRequestCookie := cookies.NewOAuthRequestCookie(base64.RawURLEncoding.EncodeToString([]byte(path)), config)
+		c.SetCookie(&oauthRequestCookie)
+		redirectURL, err = urlbuilder.Build(config.App.URL, config.LoginPage.FullPath, nil)
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": apperrors.ErrGeneric.Error()})
+	}
+	return c.Redirect(http.StatusFound, redirectURL)
+}
+
+func sessionError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, apperrors.ErrAccessTokenExpired),
+		errors.Is(err, apperrors.ErrAccessTokenInvalid),
+		errors.Is(err, apperrors.ErrRefreshTokenInvalid):
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	case errors.Is(err, apperrors.ErrSessionNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": apperrors.ErrGeneric.Error()})
+}
+
 func (h Handlers) ServeLoginPage(c echo.Context) error {
 	accessTokenValue := ""
 	if accessToken, err := c.Cookie("access_token"); err == nil {
@@ -92,29 +224,75 @@ func (h Handlers) ServeLoginPage(c echo.Context) error {
 	if refreshToken, err := c.Cookie("refresh_token"); err == nil {
 		refreshTokenValue = refreshToken.Value
 	}
-	tokensPair, err := h.Service.CheckAndRefreshToken(accessTokenValue, refreshTokenValue, false)
+	tokensPair, err := h.Service.CheckAndRefreshToken(accessTokenValue, refreshTokenValue, false, requestctx.Device(c))
 	if tokensPair != nil {
-		accessCookie := cookies.NewAccessCookie(tokensPair.AccessToken, tokensPair.AccessTokenExpiresAt.Unix(), h.Config)
-		refreshCookie := cookies.NewRefreshCookie(tokensPair.RefreshToken, tokensPair.RefreshTokenExpiresAt.Unix(), h.Config)
-		c.SetCookie(&accessCookie)
-		c.SetCookie(&refreshCookie)
+		setSessionCookies(c, tokensPair, h.Config)
 	}
 	if err == nil {
-		return c.Redirect(http.StatusFound, h.Config.App.RedirectAfterSuccess)
+		// A logged in user accepts an invitation from the app, with POST /auth/invitations/accept
+		if invitation := c.QueryParam("invitation"); invitation != "" {
+			redirectURL, err := urlbuilder.Build(h.Config.App.RedirectAfterSuccess, "", map[string]string{"invitation": invitation})
+			if err != nil {
+				return c.String(http.StatusInternalServerError, apperrors.ErrGeneric.Error())
+			}
+			return c.Redirect(http.StatusFound, redirectURL)
+		}
+		return c.Redirect(http.StatusFound, redirectAfterLogin(c, h.Config))
 	}
+	oidcProviders := []oidcProvider{}
+	for _, provider := range h.Config.Auth.Providers.OIDC {
+		if !provider.Enabled {
+			continue
+		}
+		displayName := provider.DisplayName
+		if displayName == "" {
+			displayName = provider.Name
+		}
+		oidcProviders = append(oidcProviders, oidcProvider{Name: provider.Name, DisplayName: displayName})
+	}
+	return renderLoginPage(c, loginPageData{
+		AppName:         h.Config.App.Name,
+		GitHubEnabled:   h.Config.Auth.Providers.GitHub.Enabled,
+		DiscordEnabled:  h.Config.Auth.Providers.Discord.Enabled,
+		OIDCProviders:   oidcProviders,
+		EmailEnabled:    h.Config.Auth.Providers.Email.Enabled,
+		PasskeysEnabled: h.Config.Auth.Providers.Passkeys.Enabled,
+		MFARequired:     c.QueryParam("mfa") == "required",
+	})
+}
+
+type oidcProvider struct {
+	Name        string
+	DisplayName string
+}
+
+type loginPageData struct {
+	AppName         string
+	GitHubEnabled   bool
+	DiscordEnabled  bool
+	OIDCProviders   []oidcProvider
+	EmailEnabled    bool
+	PasskeysEnabled bool
+	// The user logged in and has to enter the code of their second factor
+	MFARequired bool
+	// The logged in user approves a device, instead of logging in
+	Device *deviceApproval
+}
+
+type deviceApproval struct {
+	// Filled in from the link shown by the device
+	UserCode string
+	// Name of the client of the code, shown to the user before they approve it
+	ClientName string
+	// The code of the link is unknown, expired or already used
+	CodeInvalid bool
+}
+
+func renderLoginPage(c echo.Context, data loginPageData) error {
 	tmpl, err := template.ParseFS(templates, "templates/login.html")
 	if err != nil {
 		return c.String(http.StatusInternalServerError, apperrors.ErrGeneric.Error())
 	}
-	data := struct {
-		AppName        string
-		GitHubEnabled  bool
-		DiscordEnabled bool
-	}{
-		AppName:        h.Config.App.Name,
-		GitHubEnabled:  h.Config.Auth.Providers.GitHub.Enabled,
-		DiscordEnabled: h.Config.Auth.Providers.Discord.Enabled,
-	}
 	return tmpl.Execute(c.Response().Writer, data)
 }
 
@@ -137,13 +315,23 @@ func (h Handlers) Authorize(c echo.Context) error {
 	type Body struct {
 		AccessToken string   `json:"access_token"`
 		Roles       []string `json:"authorized_roles"`
+		// "any_of" (default) or "all_of"
+		Match               string   `json:"match"`
+		RequiredPermissions []string `json:"required_permissions"`
 	}
 	body := Body{}
 	if err := c.Bind(&body); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": apperrors.ErrGeneric.Error()})
 	}
-	cc, err := h.Service.Authorize(body.AccessToken, body.Roles)
+	cc, err := h.Service.Authorize(body.AccessToken, body.Roles, body.Match, body.RequiredPermissions)
 	if err != nil {
+		if errors.Is(err, apperrors.ErrInvalidRoleMatch) {
+			return c.JSON(http.StatusBadRequest, map[string]any{
+				"error":      apperrors.ErrInvalidRoleMatch.Error(),
+				"authorized": false,
+				"data":       cc,
+			})
+		}
 		if errors.Is(err, apperrors.ErrAccessTokenExpired) {
 			return c.JSON(http.StatusUnauthorized, map[string]any{
 				"error":      apperrors.ErrAccessTokenExpired.Error(),
@@ -179,6 +367,13 @@ func (h Handlers) Authorize(c echo.Context) error {
 				"data":       cc,
 			})
 		}
+		if errors.Is(err, apperrors.ErrMissingPermission) {
+			return c.JSON(http.StatusUnauthorized, map[string]any{
+				"error":      apperrors.ErrMissingPermission.Error(),
+				"authorized": false,
+				"data":       cc,
+			})
+		}
 		if errors.Is(err, apperrors.ErrAccessTokenInvalid) {
 			return c.JSON(http.StatusUnauthorized, map[string]any{
 				"error":      apperrors.ErrAccessTokenInvalid.Error(),
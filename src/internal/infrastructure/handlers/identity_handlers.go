@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/ports/primary"
+	"aegis/pkg/apperrors"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type IdentityHandlersInterface interface {
+	ListIdentities(c echo.Context) error
+	UnlinkIdentity(c echo.Context) error
+}
+
+type IdentityHandlers struct {
+	Config  entities.Config
+	Service primary.IdentityUseCasesInterface
+}
+
+var _ IdentityHandlersInterface = (*IdentityHandlers)(nil)
+
+func NewIdentityHandlers(c entities.Config, s primary.IdentityUseCasesInterface) *IdentityHandlers {
+	return &IdentityHandlers{
+		Config:  c,
+		Service: s,
+	}
+}
+
+func (h IdentityHandlers) ListIdentities(c echo.Context) error {
+	accessToken, _ := sessionCookies(c)
+	identities, err := h.Service.ListIdentities(accessToken)
+	if err != nil {
+		return identityError(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{"identities": identities})
+}
+
+func (h IdentityHandlers) UnlinkIdentity(c echo.Context) error {
+	accessToken, _ := sessionCookies(c)
+	if err := h.Service.UnlinkIdentity(accessToken, c.Param("provider")); err != nil {
+		return identityError(c, err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func identityError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, apperrors.ErrAccessTokenExpired), errors.Is(err, apperrors.ErrAccessTokenInvalid):
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	case errors.Is(err, apperrors.ErrIdentityNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, apperrors.ErrLastIdentity):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": apperrors.ErrGeneric.Error()})
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/ports/primary"
+	"aegis/internal/infrastructure/requestctx"
+	"aegis/pkg/apperrors"
+	"aegis/pkg/cookies"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type MFAHandlersInterface interface {
+	GetMFAStatus(c echo.Context) error
+	EnrollTOTP(c echo.Context) error
+	ConfirmTOTP(c echo.Context) error
+	DisableTOTP(c echo.Context) error
+	GenerateRecoveryCodes(c echo.Context) error
+	VerifyMFA(c echo.Context) error
+	LogInWithRecoveryCode(c echo.Context) error
+}
+
+type MFAHandlers struct {
+	Config  entities.Config
+	Service primary.MFAUseCasesInterface
+}
+
+var _ MFAHandlersInterface = (*MFAHandlers)(nil)
+
+func NewMFAHandlers(c entities.Config, s primary.MFAUseCasesInterface) *MFAHandlers {
+	return &MFAHandlers{
+		Config:  c,
+		Service: s,
+	}
+}
+
+func (h MFAHandlers) GetMFAStatus(c echo.Context) error {
+	accessToken, _ := sessionCookies(c)
+	status, err := h.Service.GetMFAStatus(accessToken)
+	if err != nil {
+		return mfaError(c, err)
+	}
+	return c.JSON(http.StatusOK, status)
+}
+
+// EnrollTOTP returns the secret to add to the authenticator app, and its provisioning URI to show as a QR code
+func (h MFAHandlers) EnrollTOTP(c echo.Context) error {
+	accessToken, _ := sessionCookies(c)
+	enrollment, err := h.Service.EnrollTOTP(accessToken)
+	if err != nil {
+		return mfaError(c, err)
+	}
+	return c.JSON(http.StatusOK, enrollment)
+}
+
+// ConfirmTOTP returns the recovery codes, they are shown once
+func (h MFAHandlers) ConfirmTOTP(c echo.Context) error {
+	type Body struct {
+		Code string `json:"code"`
+	}
+	body := Body{}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": apperrors.ErrGeneric.Error()})
+	}
+	accessToken, _ := sessionCookies(c)
+	recoveryCodes, err := h.Service.ConfirmTOTP(accessToken, body.Code, requestctx.Device(c))
+	if err != nil {
+		return mfaError(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{"recovery_codes": recoveryCodes})
+}
+
+func (h MFAHandlers) DisableTOTP(c echo.Context) error {
+	type Body struct {
+		Code string `json:"code"`
+	}
+	body := Body{}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": apperrors.ErrGeneric.Error()})
+	}
+	accessToken, _ := sessionCookies(c)
+	if err := h.Service.DisableTOTP(accessToken, body.Code, requestctx.Device(c)); err != nil {
+		return mfaError(c, err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// GenerateRecoveryCodes returns the new recovery codes, they are shown once
+func (h MFAHandlers) GenerateRecoveryCodes(c echo.Context) error {
+	type Body struct {
+		// Required when the user has an authenticator app
+		Code string `json:"code"`
+	}
+	body := Body{}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": apperrors.ErrGeneric.Error()})
+	}
+	accessToken, _ := sessionCookies(c)
+	recoveryCodes, err := h.Service.GenerateRecoveryCodes(accessToken, body.Code, requestctx.Device(c))
+	if err != nil {
+		return mfaError(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{"recovery_codes": recoveryCodes})
+}
+
+// VerifyMFA completes the login of the mfa_token cookie, sets the cookies of the session and returns where to go next
+func (h MFAHandlers) VerifyMFA(c echo.Context) error {
+	type Body struct {
+		Code string `json:"code"`
+	}
+	body := Body{}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": apperrors.ErrGeneric.Error()})
+	}
+	pendingToken := ""
+	if cookie, err := c.Cookie("mfa_token"); err == nil {
+		pendingToken = cookie.Value
+	}
+	tokensPair, err := h.Service.VerifyMFA(pendingToken, body.Code, requestctx.Device(c))
+	if err != nil {
+		if !errors.Is(err, apperrors.ErrMFACodeInvalid) {
+			// The login can not be completed anymore, the user starts again
+			mfaCookie := cookies.NewMFACookieZero(h.Config)
+			c.SetCookie(&mfaCookie)
+		}
+		return mfaError(c, err)
+	}
+	mfaCookie := cookies.NewMFACookieZero(h.Config)
+	c.SetCookie(&mfaCookie)
+	setSessionCookies(c, tokensPair, h.Config)
+	return c.JSON(http.StatusOK, map[string]string{"redirect_url": redirectAfterLogin(c, h.Config)})
+}
+
+// LogInWithRecoveryCode sets the cookies of the session and returns where to go next, where the user links a new identity
+func (h MFAHandlers) LogInWithRecoveryCode(c echo.Context) error {
+	type Body struct {
+		Email string `json:"email"`
+		Code  string `json:"code"`
+	}
+	body := Body{}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": apperrors.ErrGeneric.Error()})
+	}
+	tokensPair, err := h.Service.LogInWithRecoveryCode(body.Email, body.Code, requestctx.Device(c))
+	if err != nil {
+		return mfaError(c, err)
+	}
+	setSessionCookies(c, tokensPair, h.Config)
+	return c.JSON(http.StatusOK, map[string]string{"redirect_url": h.Config.App.RedirectAfterSuccess})
+}
+
+func mfaError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, apperrors.ErrAccessTokenExpired),
+		errors.Is(err, apperrors.ErrAccessTokenInvalid),
+		errors.Is(err, apperrors.ErrMFACodeInvalid),
+		errors.Is(err, apperrors.ErrMFATokenInvalid):
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	case errors.Is(err, apperrors.ErrTooManyMFAAttempts):
+		return c.JSON(http.StatusTooManyRequests, map[string]string{"error": err.Error()})
+	case errors.Is(err, apperrors.ErrTOTPNotEnabled):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, apperrors.ErrTOTPAlreadyEnabled):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	case errors.Is(err, apperrors.ErrInvalidEmail):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	// The user can not log in (blocked, not an early adopter...), with the error of the error page
+	if errorType := loginErrorType(err); errorType != "unknown_error" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": errorType})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": apperrors.ErrGeneric.Error()})
+}
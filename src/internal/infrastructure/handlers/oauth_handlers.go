@@ -3,8 +3,8 @@ package handlers
 import (
 	"aegis/internal/domain/entities"
 	"aegis/internal/domain/ports/primary"
+	"aegis/internal/infrastructure/requestctx"
 	"aegis/pkg/apperrors"
-	"aegis/pkg/cookies"
 	"aegis/pkg/urlbuilder"
 	"errors"
 	"net/http"
@@ -14,6 +14,7 @@ import (
 
 type OAuthHandlersInterface interface {
 	GetAuthURL(c echo.Context) error
+	GetLinkURL(c echo.Context) error
 	ExchangeCode(c echo.Context) error
 }
 
@@ -32,8 +33,23 @@ func NewOAuthHandlers(c entities.Config, s primary.OAuthUseCasesInterface) *OAut
 }
 
 func (h OAuthHandlers) GetAuthURL(c echo.Context) error {
-	redirectUrl, err := h.Service.GetAuthURL(c.QueryParam("redirect_uri"))
+	redirectUrl, err := h.Service.GetAuthURL(c.QueryParam("redirect_uri"), c.QueryParam("invitation"), requestctx.Device(c))
 	if err != nil {
+		if errors.Is(err, apperrors.ErrInvitationInvalid) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "an error occurred"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"redirect_url": redirectUrl})
+}
+
+func (h OAuthHandlers) GetLinkURL(c echo.Context) error {
+	accessToken, _ := sessionCookies(c)
+	redirectUrl, err := h.Service.GetLinkURL(accessToken, c.QueryParam("redirect_uri"), requestctx.Device(c))
+	if err != nil {
+		if errors.Is(err, apperrors.ErrAccessTokenExpired) || errors.Is(err, apperrors.ErrAccessTokenInvalid) {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		}
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "an error occurred"})
 	}
 	return c.JSON(http.StatusOK, map[string]string{"redirect_url": redirectUrl})
@@ -50,33 +66,46 @@ func (h OAuthHandlers) ExchangeCode(c echo.Context) error {
 		}
 		return c.Redirect(http.StatusFound, redirectURL)
 	}
-	tokensPair, err := h.Service.ExchangeCode(code, state)
+	tokensPair, err := h.Service.ExchangeCode(code, state, requestctx.Device(c))
 	if err != nil {
-		var errorType string
-		if errors.Is(err, apperrors.ErrWrongAuthMethod) {
-			errorType = "wrong_auth_method"
-		} else if errors.Is(err, apperrors.ErrEarlyAdoptersOnly) {
-			errorType = "early_adopters_only"
-		} else if errors.Is(err, apperrors.ErrUserBlocked) {
-			errorType = "user_blocked"
-		} else if errors.Is(err, apperrors.ErrUserDeleted) {
-			errorType = "user_deleted"
-		} else {
-			// invalid state and invalid code are handled here
-			errorType = "unknown_error"
-		}
-		redirectURL, err := urlbuilder.Build(h.Config.App.RedirectAfterError, "", map[string]string{"error": errorType})
+		redirectURL, err := urlbuilder.Build(h.Config.App.RedirectAfterError, "", map[string]string{"error": loginErrorType(err)})
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "an error occurred"})
 		}
 		return c.Redirect(http.StatusFound, redirectURL)
 	}
-	if tokensPair != nil {
-		accessCookie := cookies.NewAccessCookie(tokensPair.AccessToken, tokensPair.AccessTokenExpiresAt.Unix(), h.Config)
-		refreshCookie := cookies.NewRefreshCookie(tokensPair.RefreshToken, tokensPair.RefreshTokenExpiresAt.Unix(), h.Config)
+	if tokensPair == nil {
+		return c.Redirect(http.StatusFound, h.Config.App.RedirectAfterSuccess)
+	}
+	redirectURL, err := completeLogin(c, tokensPair, h.Config)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "an error occurred"})
+	}
+	return c.Redirect(http.StatusFound, redirectURL)
+}
 
-		c.SetCookie(&accessCookie)
-		c.SetCookie(&refreshCookie)
+// loginErrorType is the error passed to the error page when a login fails
+func loginErrorType(err error) string {
+	switch {
+	case errors.Is(err, apperrors.ErrWrongAuthMethod):
+		return "wrong_auth_method"
+	case errors.Is(err, apperrors.ErrEarlyAdoptersOnly):
+		return "early_adopters_only"
+	case errors.Is(err, apperrors.ErrUserBlocked):
+		return "user_blocked"
+	case errors.Is(err, apperrors.ErrUserDeleted):
+		return "user_deleted"
+	case errors.Is(err, apperrors.ErrIdentityAlreadyLinked):
+		return "identity_already_linked"
+	case errors.Is(err, apperrors.ErrEmailNotVerified):
+		return "email_not_verified"
+	case errors.Is(err, apperrors.ErrInvitationInvalid):
+		return "invitation_invalid"
+	case errors.Is(err, apperrors.ErrInvitationEmailMismatch):
+		return "invitation_email_mismatch"
+	case errors.Is(err, apperrors.ErrMagicLinkInvalid):
+		return "magic_link_invalid"
 	}
-	return c.Redirect(http.StatusFound, h.Config.App.RedirectAfterSuccess)
+	// invalid state and invalid code are handled here
+	return "unknown_error"
 }
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/ports/primary"
+	"aegis/pkg/apperrors"
+	"aegis/pkg/urlbuilder"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+type OAuthServerHandlersInterface interface {
+	GetOpenIDConfiguration(c echo.Context) error
+	Authorize(c echo.Context) error
+	IssueToken(c echo.Context) error
+	GetUserInfo(c echo.Context) error
+	StartDeviceAuthorization(c echo.Context) error
+	ServeDevicePage(c echo.Context) error
+	DecideDeviceAuthorization(c echo.Context) error
+}
+
+type OAuthServerHandlers struct {
+	Config  entities.Config
+	Service primary.OAuthServerUseCasesInterface
+}
+
+var _ OAuthServerHandlersInterface = (*OAuthServerHandlers)(nil)
+
+func NewOAuthServerHandlers(c entities.Config, s primary.OAuthServerUseCasesInterface) *OAuthServerHandlers {
+	return &OAuthServerHandlers{
+		Config:  c,
+		Service: s,
+	}
+}
+
+// GetOpenIDConfiguration serves the discovery document, the clients configure themselves with the issuer only
+func (h OAuthServerHandlers) GetOpenIDConfiguration(c echo.Context) error {
+	c.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return c.JSON(http.StatusOK, h.Service.GetOpenIDConfiguration())
+}
+
+// Authorize sends the user back to the client with a code. A user who is not logged in goes through the login page
+// first, and comes back here once logged in.
+func (h OAuthServerHandlers) Authorize(c echo.Context) error {
+	request := entities.AuthorizationRequest{
+		ResponseType:        c.QueryParam("response_type"),
+		ClientID:            c.QueryParam("client_id"),
+		RedirectURI:         c.QueryParam("redirect_uri"),
+		Scope:               c.QueryParam("scope"),
+		State:               c.QueryParam("state"),
+		Nonce:               c.QueryParam("nonce"),
+		CodeChallenge:       c.QueryParam("code_challenge"),
+		CodeChallengeMethod: c.QueryParam("code_challenge_method"),
+		Prompt:              c.QueryParam("prompt"),
+	}
+	accessToken, _ := sessionCookies(c)
+	redirectURL, err := h.Service.Authorize(request, accessToken)
+	if err == nil {
+		return c.Redirect(http.StatusFound, redirectURL)
+	}
+	// The redirect URI can not be trusted, the user sees the error instead of the client
+	if errors.Is(err, apperrors.ErrOAuthInvalidClient) || errors.Is(err, apperrors.ErrOAuthInvalidRedirectURI) {
+		redirectURL, err := urlbuilder.Build(h.Config.App.RedirectAfterError, "", map[string]string{"error": err.Error()})
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": apperrors.ErrGeneric.Error()})
+		}
+		return c.Redirect(http.StatusFound, redirectURL)
+	}
+	if errors.Is(err, apperrors.ErrOAuthLoginRequired) && request.Prompt != "none" && h.Config.LoginPage.Enabled {
+		return resumeAfterLogin(c, h.Config, "/auth/authorize?"+c.QueryString())
+	}
+	queryParams := map[string]string{"error": oauthErrorType(err)}
+	if request.State != "" {
+		queryParams["state"] = request.State
+	}
+	redirectURL, err = urlbuilder.AddQuery(request.RedirectURI, queryParams)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": apperrors.ErrGeneric.Error()})
+	}
+	return c.Redirect(http.StatusFound, redirectURL)
+}
+
+// IssueToken is the token endpoint. The clients authenticate with HTTP basic auth or with the form.
+func (h OAuthServerHandlers) IssueToken(c echo.Context) error {
+	request := entities.TokenRequest{
+		GrantType:    c.FormValue("grant_type"),
+		Code:         c.FormValue("code"),
+		RedirectURI:  c.FormValue("redirect_uri"),
+		ClientID:     c.FormValue("client_id"),
+		ClientSecret: c.FormValue("client_secret"),
+		CodeVerifier: c.FormValue("code_verifier"),
+		Scope:        c.FormValue("scope"),
+		DeviceCode:   c.FormValue("device_code"),
+	}
+	if err := readClientCredentials(c, &request.ClientID, &request.ClientSecret); err != nil {
+		return oauthTokenError(c, err)
+	}
+	response, err := h.Service.IssueToken(request)
+	if err != nil {
+		return oauthTokenError(c, err)
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, response)
+}
+
+// readClientCredentials overrides the credentials of the form with the ones of HTTP basic auth, if any
+func readClientCredentials(c echo.Context, clientID, clientSecret *string) error {
+	basicClientID, basicClientSecret, ok := c.Request().BasicAuth()
+	if !ok {
+		return nil
+	}
+	// The credentials are form encoded before being put in the header (RFC 6749, section 2.3.1)
+	basicClientID, err1 := url.QueryUnescape(basicClientID)
+	basicClientSecret, err2 := url.QueryUnescape(basicClientSecret)
+	if err1 != nil || err2 != nil {
+		return apperrors.ErrOAuthInvalidClient
+	}
+	*clientID = basicClientID
+	*clientSecret = basicClientSecret
+	return nil
+}
+
+// StartDeviceAuthorization is the device authorization endpoint, the clients authenticate like at the token endpoint
+func (h OAuthServerHandlers) StartDeviceAuthorization(c echo.Context) error {
+	request := entities.DeviceAuthorizationRequest{
+		ClientID:     c.FormValue("client_id"),
+		ClientSecret: c.FormValue("client_secret"),
+		Scope:        c.FormValue("scope"),
+	}
+	if err := readClientCredentials(c, &request.ClientID, &request.ClientSecret); err != nil {
+		return oauthTokenError(c, err)
+	}
+	response, err := h.Service.StartDeviceAuthorization(request)
+	if err != nil {
+		return oauthTokenError(c, err)
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, response)
+}
+
+// ServeDevicePage shows the login page, where the logged in user enters the code of their device and approves it.
+// A user who is not logged in goes through the login first, and comes back here once logged in.
+func (h OAuthServerHandlers) ServeDevicePage(c echo.Context) error {
+	userCode := c.QueryParam("user_code")
+	accessToken, _ := sessionCookies(c)
+	authorization, err := h.Service.GetDeviceAuthorization(userCode, accessToken)
+	switch {
+	case errors.Is(err, apperrors.ErrOAuthLoginRequired):
+		return resumeAfterLogin(c, h.Config, "/auth/device?"+c.QueryString())
+	case errors.Is(err, apperrors.ErrOAuthAccessDenied):
+		redirectURL, err := urlbuilder.Build(h.Config.App.RedirectAfterError, "", map[string]string{"error": apperrors.ErrOAuthAccessDenied.Error()})
+		if err != nil {
+			return c.String(http.StatusInternalServerError, apperrors.ErrGeneric.Error())
+		}
+		return c.Redirect(http.StatusFound, redirectURL)
+	case err != nil && !errors.Is(err, apperrors.ErrUserCodeInvalid):
+		return c.String(http.StatusInternalServerError, apperrors.ErrGeneric.Error())
+	}
+	device := deviceApproval{CodeInvalid: err != nil}
+	if err == nil && userCode != "" {
+		device.UserCode = entities.FormatUserCode(authorization.UserCode)
+		device.ClientName = authorization.ClientID
+		if client, ok := h.Config.OAuthClient(authorization.ClientID); ok && client.Name != "" {
+			device.ClientName = client.Name
+		}
+	}
+	return renderLoginPage(c, loginPageData{AppName: h.Config.App.Name, Device: &device})
+}
+
+// DecideDeviceAuthorization approves or denies a device, for the logged in user
+func (h OAuthServerHandlers) DecideDeviceAuthorization(c echo.Context) error {
+	// The page posts JSON: a form of another site can not, even when the cookies are sent with SameSite=None
+	if !strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEApplicationJSON) {
+		return c.JSON(http.StatusUnsupportedMediaType, map[string]string{"error": apperrors.ErrGeneric.Error()})
+	}
+	type Body struct {
+		UserCode string `json:"user_code"`
+		Approve  bool   `json:"approve"`
+	}
+	body := Body{}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": apperrors.ErrGeneric.Error()})
+	}
+	accessToken, _ := sessionCookies(c)
+	if err := h.Service.DecideDeviceAuthorization(body.UserCode, accessToken, body.Approve); err != nil {
+		switch {
+		case errors.Is(err, apperrors.ErrOAuthLoginRequired):
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		case errors.Is(err, apperrors.ErrOAuthAccessDenied):
+			return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+		case errors.Is(err, apperrors.ErrUserCodeInvalid):
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": apperrors.ErrGeneric.Error()})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetUserInfo takes the access token in the Authorization header
+func (h OAuthServerHandlers) GetUserInfo(c echo.Context) error {
+	accessToken, found := strings.CutPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+	if !found || accessToken == "" {
+		c.Response().Header().Set("WWW-Authenticate", `Bearer`)
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid_token"})
+	}
+	userInfo, err := h.Service.GetUserInfo(accessToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, apperrors.ErrAccessTokenExpired),
+			errors.Is(err, apperrors.ErrAccessTokenInvalid),
+			errors.Is(err, apperrors.ErrNoUser):
+			c.Response().Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid_token"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": apperrors.ErrGeneric.Error()})
+	}
+	return c.JSON(http.StatusOK, userInfo)
+}
+
+// oauthErrorType is the error sent back to the client (RFC 6749, section 4.1.2.1)
+func oauthErrorType(err error) string {
+	switch {
+	case errors.Is(err, apperrors.ErrOAuthInvalidRequest),
+		errors.Is(err, apperrors.ErrOAuthUnsupportedResponseType),
+		errors.Is(err, apperrors.ErrOAuthLoginRequired),
+		errors.Is(err, apperrors.ErrOAuthAccessDenied):
+		return err.Error()
+	}
+	return "server_error"
+}
+
+func oauthTokenError(c echo.Context, err error) error {
+	c.Response().Header().Set("Cache-Control", "no-store")
+	switch {
+	case errors.Is(err, apperrors.ErrOAuthInvalidClient):
+		c.Response().Header().Set("WWW-Authenticate", `Basic realm="token"`)
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	case errors.Is(err, apperrors.ErrOAuthInvalidRequest),
+		errors.Is(err, apperrors.ErrOAuthInvalidGrant),
+		errors.Is(err, apperrors.ErrOAuthInvalidScope),
+		errors.Is(err, apperrors.ErrOAuthUnsupportedGrantType),
+		errors.Is(err, apperrors.ErrOAuthUnauthorizedClient),
+		errors.Is(err, apperrors.ErrOAuthAuthorizationPending),
+		errors.Is(err, apperrors.ErrOAuthSlowDown),
+		errors.Is(err, apperrors.ErrOAuthExpiredToken),
+		errors.Is(err, apperrors.ErrOAuthAccessDenied):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+}
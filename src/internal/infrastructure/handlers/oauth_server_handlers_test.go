@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/ports/primary"
+	"aegis/pkg/apperrors"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// failingOAuthServer fails every token request with err, the other use cases are not called
+type failingOAuthServer struct {
+	primary.OAuthServerUseCasesInterface
+	err error
+}
+
+func (s failingOAuthServer) IssueToken(request entities.TokenRequest) (entities.OAuthTokenResponse, error) {
+	return entities.OAuthTokenResponse{}, s.err
+}
+
+func TestOAuthServerHandlers_IssueToken(t *testing.T) {
+	issueToken := func(t *testing.T, err error) *httptest.ResponseRecorder {
+		h := NewOAuthServerHandlers(entities.Config{}, failingOAuthServer{err: err})
+		form := url.Values{"grant_type": {entities.GrantTypeDeviceCode}, "client_id": {"cli"}, "device_code": {"device_code"}}
+		req := httptest.NewRequest(http.MethodPost, "/auth/token", strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		rec := httptest.NewRecorder()
+		if err := h.IssueToken(echo.New().NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+	t.Run("the errors of the device flow are sent to the device with 400", func(t *testing.T) {
+		for _, err := range []error{
+			apperrors.ErrOAuthAuthorizationPending,
+			apperrors.ErrOAuthSlowDown,
+			apperrors.ErrOAuthExpiredToken,
+			apperrors.ErrOAuthAccessDenied,
+			apperrors.ErrOAuthUnauthorizedClient,
+		} {
+			rec := issueToken(t, err)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatal("expected status 400 for", err, rec.Code)
+			}
+			body := map[string]string{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body["error"] != err.Error() {
+				t.Fatal("expected the error code", err, body)
+			}
+			if rec.Header().Get("Cache-Control") != "no-store" {
+				t.Fatal("expected no-store", rec.Header())
+			}
+		}
+	})
+	t.Run("an invalid client gets 401, an unexpected error 500", func(t *testing.T) {
+		if rec := issueToken(t, apperrors.ErrOAuthInvalidClient); rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+			t.Fatal("expected status 401 with WWW-Authenticate", rec.Code, rec.Header())
+		}
+		if rec := issueToken(t, errors.New("database is down")); rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "server_error") {
+			t.Fatal("expected status 500 with server_error", rec.Code, rec.Body.String())
+		}
+	})
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/ports/primary"
+	"aegis/internal/infrastructure/requestctx"
+	"aegis/pkg/apperrors"
+	"aegis/pkg/urlbuilder"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type OrganizationHandlersInterface interface {
+	CreateOrganization(c echo.Context) error
+	ListOrganizations(c echo.Context) error
+	SwitchOrganization(c echo.Context) error
+	ListMembers(c echo.Context) error
+	SetMemberRole(c echo.Context) error
+	RemoveMember(c echo.Context) error
+	CreateInvitation(c echo.Context) error
+	ListInvitations(c echo.Context) error
+	RevokeInvitation(c echo.Context) error
+	AcceptInvitation(c echo.Context) error
+}
+
+type OrganizationHandlers struct {
+	Config  entities.Config
+	Service primary.OrganizationUseCasesInterface
+}
+
+var _ OrganizationHandlersInterface = (*OrganizationHandlers)(nil)
+
+func NewOrganizationHandlers(c entities.Config, s primary.OrganizationUseCasesInterface) *OrganizationHandlers {
+	return &OrganizationHandlers{
+		Config:  c,
+		Service: s,
+	}
+}
+
+func (h OrganizationHandlers) CreateOrganization(c echo.Context) error {
+	type Body struct {
+		Name string `json:"name"`
+	}
+	body := Body{}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": apperrors.ErrGeneric.Error()})
+	}
+	accessToken, _ := sessionCookies(c)
+	organization, err := h.Service.CreateOrganization(accessToken, body.Name)
+	if err != nil {
+		return organizationError(c, err)
+	}
+	return c.JSON(http.StatusCreated, organization)
+}
+
+func (h OrganizationHandlers) ListOrganizations(c echo.Context) error {
+	accessToken, _ := sessionCookies(c)
+	organizations, err := h.Service.ListOrganizations(accessToken)
+	if err != nil {
+		return organizationError(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{"organizations": organizations})
+}
+
+func (h OrganizationHandlers) SwitchOrganization(c echo.Context) error {
+	type Body struct {
+		OrganizationID string `json:"organization_id"`
+	}
+	body := Body{}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": apperrors.ErrGeneric.Error()})
+	}
+	accessToken, refreshToken := sessionCookies(c)
+	tokensPair, err := h.Service.SwitchOrganization(accessToken, refreshToken, body.OrganizationID, requestctx.Device(c))
+	if err != nil {
+		return organizationError(c, err)
+	}
+	setSessionCookies(c, tokensPair, h.Config)
+	return c.NoContent(http.StatusOK)
+}
+
+func (h OrganizationHandlers) ListMembers(c echo.Context) error {
+	accessToken, _ := sessionCookies(c)
+	members, err := h.Service.ListMembers(accessToken, c.Param("id"))
+	if err != nil {
+		return organizationError(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{"members": members})
+}
+
+func (h OrganizationHandlers) SetMemberRole(c echo.Context) error {
+	type Body struct {
+		Role string `json:"role"`
+	}
+	body := Body{}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": apperrors.ErrGeneric.Error()})
+	}
+	accessToken, _ := sessionCookies(c)
+	if err := h.Service.SetMemberRole(accessToken, c.Param("id"), c.Param("user_id"), body.Role); err != nil {
+		return organizationError(c, err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func (h OrganizationHandlers) RemoveMember(c echo.Context) error {
+	accessToken, _ := sessionCookies(c)
+	if err := h.Service.RemoveMember(accessToken, c.Param("id"), c.Param("user_id")); err != nil {
+		return organizationError(c, err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// CreateInvitation returns the token of the invitation, which is only shown once, and the link to the login page
+// that accepts it when the login page is enabled
+func (h OrganizationHandlers) CreateInvitation(c echo.Context) error {
+	type Body struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+	body := Body{}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": apperrors.ErrGeneric.Error()})
+	}
+	accessToken, _ := sessionCookies(c)
+	invitation, err := h.Service.CreateInvitation(accessToken, c.Param("id"), body.Email, body.Role)
+	if err != nil {
+		return organizationError(c, err)
+	}
+	invitationURL := ""
+	if h.Config.LoginPage.Enabled {
+		invitationURL, err = urlbuilder.Build(h.Config.App.URL, h.Config.LoginPage.FullPath, map[string]string{"invitation": invitation.Token})
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": apperrors.ErrGeneric.Error()})
+		}
+	}
+	return c.JSON(http.StatusCreated, map[string]any{"invitation": invitation, "token": invitation.Token, "url": invitationURL})
+}
+
+func (h OrganizationHandlers) ListInvitations(c echo.Context) error {
+	accessToken, _ := sessionCookies(c)
+	invitations, err := h.Service.ListInvitations(accessToken, c.Param("id"))
+	if err != nil {
+		return organizationError(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{"invitations": invitations})
+}
+
+func (h OrganizationHandlers) RevokeInvitation(c echo.Context) error {
+	accessToken, _ := sessionCookies(c)
+	if err := h.Service.RevokeInvitation(accessToken, c.Param("id"), c.Param("invitation_id")); err != nil {
+		return organizationError(c, err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func (h OrganizationHandlers) AcceptInvitation(c echo.Context) error {
+	type Body struct {
+		Token string `json:"token"`
+	}
+	body := Body{}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": apperrors.ErrGeneric.Error()})
+	}
+	accessToken, _ := sessionCookies(c)
+	organization, err := h.Service.AcceptInvitation(accessToken, body.Token)
+	if err != nil {
+		return organizationError(c, err)
+	}
+	return c.JSON(http.StatusOK, organization)
+}
+
+func organizationError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, apperrors.ErrAccessTokenExpired),
+		errors.Is(err, apperrors.ErrAccessTokenInvalid),
+		errors.Is(err, apperrors.ErrRefreshTokenInvalid):
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	case errors.Is(err, apperrors.ErrOrganizationForbidden), errors.Is(err, apperrors.ErrInvitationEmailMismatch):
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	case errors.Is(err, apperrors.ErrNotOrganizationMember), errors.Is(err, apperrors.ErrNoUser), errors.Is(err, apperrors.ErrInvitationNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, apperrors.ErrNoOrganizationName), errors.Is(err, apperrors.ErrUnknownOrganizationRole),
+		errors.Is(err, apperrors.ErrInvalidInvitationEmail), errors.Is(err, apperrors.ErrInvitationInvalid):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, apperrors.ErrLastOrganizationOwner):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": apperrors.ErrGeneric.Error()})
+}
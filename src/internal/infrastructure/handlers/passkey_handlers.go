@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/ports/primary"
+	"aegis/internal/infrastructure/requestctx"
+	"aegis/pkg/apperrors"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+type PasskeyHandlersInterface interface {
+	BeginRegistration(c echo.Context) error
+	FinishRegistration(c echo.Context) error
+	BeginLogin(c echo.Context) error
+	FinishLogin(c echo.Context) error
+	ListPasskeys(c echo.Context) error
+	DeletePasskey(c echo.Context) error
+}
+
+type PasskeyHandlers struct {
+	Config  entities.Config
+	Service primary.PasskeyUseCasesInterface
+}
+
+var _ PasskeyHandlersInterface = (*PasskeyHandlers)(nil)
+
+func NewPasskeyHandlers(c entities.Config, s primary.PasskeyUseCasesInterface) *PasskeyHandlers {
+	return &PasskeyHandlers{
+		Config:  c,
+		Service: s,
+	}
+}
+
+// BeginRegistration returns the options to pass to navigator.credentials.create(), under "publicKey"
+func (h PasskeyHandlers) BeginRegistration(c echo.Context) error {
+	accessToken, _ := sessionCookies(c)
+	options, err := h.Service.BeginRegistration(accessToken, requestctx.Device(c))
+	if err != nil {
+		return passkeyError(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{"publicKey": options})
+}
+
+// FinishRegistration takes the credential returned by navigator.credentials.create(), binary values base64url encoded
+func (h PasskeyHandlers) FinishRegistration(c echo.Context) error {
+	type Body struct {
+		Name     string `json:"name"`
+		Response struct {
+			ClientDataJSON    string `json:"clientDataJSON"`
+			AttestationObject string `json:"attestationObject"`
+		} `json:"response"`
+	}
+	body := Body{}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": apperrors.ErrGeneric.Error()})
+	}
+	clientDataJSON, err1 := decodeBase64URL(body.Response.ClientDataJSON)
+	attestationObject, err2 := decodeBase64URL(body.Response.AttestationObject)
+	if err1 != nil || err2 != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": apperrors.ErrPasskeyInvalid.Error()})
+	}
+	accessToken, _ := sessionCookies(c)
+	passkey, err := h.Service.FinishRegistration(accessToken, body.Name, clientDataJSON, attestationObject, requestctx.Device(c))
+	if err != nil {
+		return passkeyError(c, err)
+	}
+	return c.JSON(http.StatusCreated, passkey)
+}
+
+// BeginLogin returns the options to pass to navigator.credentials.get(), under "publicKey"
+func (h PasskeyHandlers) BeginLogin(c echo.Context) error {
+	options, err := h.Service.BeginLogin(requestctx.Device(c))
+	if err != nil {
+		return passkeyError(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{"publicKey": options})
+}
+
+// FinishLogin takes the credential returned by navigator.credentials.get(), sets the cookies of the session
+// and returns where to go next
+func (h PasskeyHandlers) FinishLogin(c echo.Context) error {
+	type Body struct {
+		ID       string `json:"id"`
+		Response struct {
+			ClientDataJSON    string `json:"clientDataJSON"`
+			AuthenticatorData string `json:"authenticatorData"`
+			Signature         string `json:"signature"`
+			UserHandle        string `json:"userHandle"`
+		} `json:"response"`
+	}
+	body := Body{}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": apperrors.ErrGeneric.Error()})
+	}
+	clientDataJSON, err1 := decodeBase64URL(body.Response.ClientDataJSON)
+	authenticatorData, err2 := decodeBase64URL(body.Response.AuthenticatorData)
+	signature, err3 := decodeBase64URL(body.Response.Signature)
+	userHandle, err4 := decodeBase64URL(body.Response.UserHandle)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": apperrors.ErrPasskeyInvalid.Error()})
+	}
+	tokensPair, err := h.Service.FinishLogin(body.ID, clientDataJSON, authenticatorData, signature, userHandle, requestctx.Device(c))
+	if err != nil {
+		return passkeyError(c, err)
+	}
+	redirectURL, err := completeLogin(c, tokensPair, h.Config)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": apperrors.ErrGeneric.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"redirect_url": redirectURL})
+}
+
+func (h PasskeyHandlers) ListPasskeys(c echo.Context) error {
+	accessToken, _ := sessionCookies(c)
+	passkeys, err := h.Service.ListPasskeys(accessToken)
+	if err != nil {
+		return passkeyError(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{"passkeys": passkeys})
+}
+
+func (h PasskeyHandlers) DeletePasskey(c echo.Context) error {
+	accessToken, _ := sessionCookies(c)
+	if err := h.Service.DeletePasskey(accessToken, c.Param("id")); err != nil {
+		return passkeyError(c, err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// decodeBase64URL accepts the padded and unpadded encodings, browsers and libraries differ
+func decodeBase64URL(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+func passkeyError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, apperrors.ErrAccessTokenExpired), errors.Is(err, apperrors.ErrAccessTokenInvalid), errors.Is(err, apperrors.ErrPasskeyInvalid):
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	case errors.Is(err, apperrors.ErrPasskeyNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, apperrors.ErrPasskeyAlreadyRegistered):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+	// The user can not log in (blocked, not an early adopter...), with the error of the error page
+	if errorType := loginErrorType(err); errorType != "unknown_error" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": errorType})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": apperrors.ErrGeneric.Error()})
+}
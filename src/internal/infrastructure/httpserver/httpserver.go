@@ -62,16 +62,72 @@ Drop-in auth service - no SaaS, no lock-in, just the banana.
 		return err
 	}
 
-	group := e.Group("/auth")
+	group := e.Group("/auth", r.Middlewares.IdentifyDevice)
 
 	group.GET("/me", r.Handlers.GetSession, r.Middlewares.CheckAndRefreshToken)
 	group.GET("/refresh", r.Handlers.DoNothing, r.Middlewares.CheckAndForceRefreshToken)
 	group.GET("/logout", r.Handlers.Logout)
 	group.GET("/health", r.Handlers.DoNothing)
 	group.POST("/authorize-access-token", r.Handlers.Authorize, r.Middlewares.CheckInternalAPICall)
+	group.GET("/.well-known/jwks.json", r.Handlers.GetJWKS)
+	group.GET("/sessions", r.Handlers.ListSessions, r.Middlewares.CheckAndRefreshToken)
+	group.GET("/sessions/evicted", r.Handlers.ListEvictedSessions, r.Middlewares.CheckAndRefreshToken)
+	group.DELETE("/sessions/others", r.Handlers.RevokeOtherSessions, r.Middlewares.CheckAndRefreshToken)
+	group.DELETE("/sessions/:id", r.Handlers.RevokeSession, r.Middlewares.CheckAndRefreshToken)
+	group.GET("/identities", r.IdentityHandlers.ListIdentities, r.Middlewares.CheckAndRefreshToken)
+	group.DELETE("/identities/:provider", r.IdentityHandlers.UnlinkIdentity, r.Middlewares.CheckAndRefreshToken)
+	group.GET("/organizations", r.OrganizationHandlers.ListOrganizations, r.Middlewares.CheckAndRefreshToken)
+	group.POST("/organizations", r.OrganizationHandlers.CreateOrganization, r.Middlewares.CheckAndRefreshToken)
+	group.POST("/organizations/switch", r.OrganizationHandlers.SwitchOrganization, r.Middlewares.CheckAndRefreshToken)
+	group.GET("/organizations/:id/members", r.OrganizationHandlers.ListMembers, r.Middlewares.CheckAndRefreshToken)
+	group.PUT("/organizations/:id/members/:user_id", r.OrganizationHandlers.SetMemberRole, r.Middlewares.CheckAndRefreshToken)
+	group.DELETE("/organizations/:id/members/:user_id", r.OrganizationHandlers.RemoveMember, r.Middlewares.CheckAndRefreshToken)
+	group.GET("/organizations/:id/invitations", r.OrganizationHandlers.ListInvitations, r.Middlewares.CheckAndRefreshToken)
+	group.POST("/organizations/:id/invitations", r.OrganizationHandlers.CreateInvitation, r.Middlewares.CheckAndRefreshToken)
+	group.DELETE("/organizations/:id/invitations/:invitation_id", r.OrganizationHandlers.RevokeInvitation, r.Middlewares.CheckAndRefreshToken)
+	group.POST("/invitations/accept", r.OrganizationHandlers.AcceptInvitation, r.Middlewares.CheckAndRefreshToken)
+	group.POST("/email/login", r.EmailHandlers.SendMagicLink, r.EmailMiddlewares.CheckAuthEnabled)
+	group.GET("/email/verify", r.EmailHandlers.VerifyMagicLink, r.EmailMiddlewares.CheckAuthEnabled)
+	group.POST("/email/code", r.EmailHandlers.SendLoginCode, r.EmailMiddlewares.CheckAuthEnabled)
+	group.POST("/email/code/verify", r.EmailHandlers.VerifyLoginCode, r.EmailMiddlewares.CheckAuthEnabled)
+	group.POST("/passkeys/login/begin", r.PasskeyHandlers.BeginLogin, r.PasskeyMiddlewares.CheckAuthEnabled)
+	group.POST("/passkeys/login/finish", r.PasskeyHandlers.FinishLogin, r.PasskeyMiddlewares.CheckAuthEnabled)
+	group.POST("/passkeys/register/begin", r.PasskeyHandlers.BeginRegistration, r.PasskeyMiddlewares.CheckAuthEnabled, r.Middlewares.CheckAndRefreshToken)
+	group.POST("/passkeys/register/finish", r.PasskeyHandlers.FinishRegistration, r.PasskeyMiddlewares.CheckAuthEnabled, r.Middlewares.CheckAndRefreshToken)
+	group.GET("/passkeys", r.PasskeyHandlers.ListPasskeys, r.PasskeyMiddlewares.CheckAuthEnabled, r.Middlewares.CheckAndRefreshToken)
+	group.DELETE("/passkeys/:id", r.PasskeyHandlers.DeletePasskey, r.PasskeyMiddlewares.CheckAuthEnabled, r.Middlewares.CheckAndRefreshToken)
+	group.GET("/mfa", r.MFAHandlers.GetMFAStatus, r.Middlewares.CheckAndRefreshToken)
+	group.POST("/mfa/totp", r.MFAHandlers.EnrollTOTP, r.Middlewares.CheckAndRefreshToken)
+	group.POST("/mfa/totp/confirm", r.MFAHandlers.ConfirmTOTP, r.Middlewares.CheckAndRefreshToken)
+	group.POST("/mfa/totp/disable", r.MFAHandlers.DisableTOTP, r.Middlewares.CheckAndRefreshToken)
+	group.POST("/mfa/recovery-codes", r.MFAHandlers.GenerateRecoveryCodes, r.Middlewares.CheckAndRefreshToken)
+	group.POST("/mfa/verify", r.MFAHandlers.VerifyMFA)
+	group.POST("/recovery", r.MFAHandlers.LogInWithRecoveryCode)
+	group.GET("/.well-known/openid-configuration", r.OAuthServerHandlers.GetOpenIDConfiguration, r.OAuthServerMiddlewares.CheckAuthEnabled)
+	group.GET("/authorize", r.OAuthServerHandlers.Authorize, r.OAuthServerMiddlewares.CheckAuthEnabled, r.Middlewares.RefreshTokenIfLoggedIn)
+	group.POST("/token", r.OAuthServerHandlers.IssueToken, r.OAuthServerMiddlewares.CheckAuthEnabled)
+	group.GET("/userinfo", r.OAuthServerHandlers.GetUserInfo, r.OAuthServerMiddlewares.CheckAuthEnabled)
+	group.POST("/userinfo", r.OAuthServerHandlers.GetUserInfo, r.OAuthServerMiddlewares.CheckAuthEnabled)
+	group.POST("/device/code", r.OAuthServerHandlers.StartDeviceAuthorization, r.OAuthServerMiddlewares.CheckAuthEnabled)
+	group.GET("/device", r.OAuthServerHandlers.ServeDevicePage, r.OAuthServerMiddlewares.CheckAuthEnabled, r.Middlewares.RefreshTokenIfLoggedIn)
+	group.POST("/device", r.OAuthServerHandlers.DecideDeviceAuthorization, r.OAuthServerMiddlewares.CheckAuthEnabled, r.Middlewares.RefreshTokenIfLoggedIn)
+
+	admin := group.Group("/admin", r.Middlewares.CheckPlatformAdmin)
+	admin.GET("/users", r.AdminHandlers.ListUsers)
+	admin.GET("/users/:id", r.AdminHandlers.GetUser)
+	admin.POST("/users/:id/block", r.AdminHandlers.BlockUser)
+	admin.POST("/users/:id/unblock", r.AdminHandlers.UnblockUser)
+	admin.POST("/users/:id/delete", r.AdminHandlers.DeleteUser)
+	admin.POST("/users/:id/restore", r.AdminHandlers.RestoreUser)
+	admin.PUT("/users/:id/early-adopter", r.AdminHandlers.SetEarlyAdopter)
+	admin.PUT("/users/:id/roles/:role", r.AdminHandlers.GrantRole)
+	admin.DELETE("/users/:id/roles/:role", r.AdminHandlers.RevokeRole)
+	admin.GET("/service-clients", r.AdminHandlers.ListServiceClients)
+	admin.POST("/service-clients", r.AdminHandlers.CreateServiceClient)
+	admin.DELETE("/service-clients/:client_id", r.AdminHandlers.DeleteServiceClient)
 
 	if c.LoginPage.Enabled {
-		e.GET(c.LoginPage.FullPath, r.Handlers.ServeLoginPage)
+		e.GET(c.LoginPage.FullPath, r.Handlers.ServeLoginPage, r.Middlewares.IdentifyDevice)
 	}
 
 	if c.ErrorPage.Enabled {
@@ -81,6 +137,7 @@ Drop-in auth service - no SaaS, no lock-in, just the banana.
 	for _, provider := range r.Providers {
 		group.GET(fmt.Sprintf("/%s", provider.Name), provider.Handlers.GetAuthURL, provider.Middlewares.CheckAuthEnabled)
 		group.GET(fmt.Sprintf("/%s/callback", provider.Name), provider.Handlers.ExchangeCode, provider.Middlewares.CheckAuthEnabled)
+		group.GET(fmt.Sprintf("/%s/link", provider.Name), provider.Handlers.GetLinkURL, provider.Middlewares.CheckAuthEnabled, r.Middlewares.CheckAndRefreshToken)
 	}
 
 	return e.Start(fmt.Sprintf(":%d", c.App.Port))
@@ -3,11 +3,14 @@ package middlewares
 import (
 	"aegis/internal/domain/entities"
 	"aegis/internal/domain/ports/primary"
+	"aegis/internal/infrastructure/requestctx"
 	"aegis/pkg/apperrors"
 	"aegis/pkg/cookies"
+	"aegis/pkg/tokengen"
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 )
@@ -16,7 +19,10 @@ type AuthMiddlewareInterface interface {
 	CheckToken(next echo.HandlerFunc) echo.HandlerFunc
 	CheckAndRefreshToken(next echo.HandlerFunc) echo.HandlerFunc
 	CheckAndForceRefreshToken(next echo.HandlerFunc) echo.HandlerFunc
+	RefreshTokenIfLoggedIn(next echo.HandlerFunc) echo.HandlerFunc
 	CheckInternalAPICall(next echo.HandlerFunc) echo.HandlerFunc
+	IdentifyDevice(next echo.HandlerFunc) echo.HandlerFunc
+	CheckPlatformAdmin(next echo.HandlerFunc) echo.HandlerFunc
 }
 
 type AuthMiddleware struct {
@@ -43,7 +49,7 @@ func (m AuthMiddleware) CheckAndRefreshToken(next echo.HandlerFunc) echo.Handler
 		if refreshToken, err := c.Cookie("refresh_token"); err == nil {
 			refreshTokenValue = refreshToken.Value
 		}
-		tokensPair, err := m.Service.CheckAndRefreshToken(accessTokenValue, refreshTokenValue, false)
+		tokensPair, err := m.Service.CheckAndRefreshToken(accessTokenValue, refreshTokenValue, false, requestctx.Device(c))
 		if tokensPair != nil {
 			accessCookie := cookies.NewAccessCookie(tokensPair.AccessToken, tokensPair.AccessTokenExpiresAt.Unix(), m.Config)
 			refreshCookie := cookies.NewRefreshCookie(tokensPair.RefreshToken, tokensPair.RefreshTokenExpiresAt.Unix(), m.Config)
@@ -60,6 +66,9 @@ func (m AuthMiddleware) CheckAndRefreshToken(next echo.HandlerFunc) echo.Handler
 			if errors.Is(err, apperrors.ErrRefreshTokenExpired) {
 				return c.JSON(http.StatusUnauthorized, map[string]string{"error": apperrors.ErrRefreshTokenExpired.Error()})
 			}
+			if errors.Is(err, apperrors.ErrRefreshTokenReused) {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": apperrors.ErrRefreshTokenReused.Error()})
+			}
 			if errors.Is(err, apperrors.ErrUserDeleted) {
 				return c.JSON(http.StatusUnauthorized, map[string]string{"error": apperrors.ErrUserDeleted.Error()})
 			}
@@ -82,7 +91,7 @@ func (m AuthMiddleware) CheckAndForceRefreshToken(next echo.HandlerFunc) echo.Ha
 		if refreshToken, err := c.Cookie("refresh_token"); err == nil {
 			refreshTokenValue = refreshToken.Value
 		}
-		tokensPair, err := m.Service.CheckAndRefreshToken(accessTokenValue, refreshTokenValue, true)
+		tokensPair, err := m.Service.CheckAndRefreshToken(accessTokenValue, refreshTokenValue, true, requestctx.Device(c))
 		if tokensPair != nil {
 			accessCookie := cookies.NewAccessCookie(tokensPair.AccessToken, tokensPair.AccessTokenExpiresAt.Unix(), m.Config)
 			refreshCookie := cookies.NewRefreshCookie(tokensPair.RefreshToken, tokensPair.RefreshTokenExpiresAt.Unix(), m.Config)
@@ -98,6 +107,9 @@ func (m AuthMiddleware) CheckAndForceRefreshToken(next echo.HandlerFunc) echo.Ha
 			if errors.Is(err, apperrors.ErrRefreshTokenExpired) {
 				return c.JSON(http.StatusUnauthorized, map[string]string{"error": apperrors.ErrRefreshTokenExpired.Error()})
 			}
+			if errors.Is(err, apperrors.ErrRefreshTokenReused) {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": apperrors.ErrRefreshTokenReused.Error()})
+			}
 			if errors.Is(err, apperrors.ErrUserDeleted) {
 				return c.JSON(http.StatusUnauthorized, map[string]string{"error": apperrors.ErrUserDeleted.Error()})
 			}
@@ -110,6 +122,31 @@ func (m AuthMiddleware) CheckAndForceRefreshToken(next echo.HandlerFunc) echo.Ha
 	}
 }
 
+// RefreshTokenIfLoggedIn refreshes the session of a logged in user whose access token expired, and lets the requests
+// of the users who are not logged in through: the handler decides what to do with them
+func (m AuthMiddleware) RefreshTokenIfLoggedIn(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		refreshToken, err := c.Cookie("refresh_token")
+		if err != nil || refreshToken.Value == "" {
+			return next(c)
+		}
+		accessTokenValue := ""
+		if accessToken, err := c.Cookie("access_token"); err == nil {
+			accessTokenValue = accessToken.Value
+		}
+		tokensPair, _ := m.Service.CheckAndRefreshToken(accessTokenValue, refreshToken.Value, false, requestctx.Device(c))
+		if tokensPair != nil {
+			accessCookie := cookies.NewAccessCookie(tokensPair.AccessToken, tokensPair.AccessTokenExpiresAt.Unix(), m.Config)
+			refreshCookie := cookies.NewRefreshCookie(tokensPair.RefreshToken, tokensPair.RefreshTokenExpiresAt.Unix(), m.Config)
+			c.SetCookie(&accessCookie)
+			c.SetCookie(&refreshCookie)
+			// Override cookies in the request for the next middleware/handler to use
+			c.Request().Header.Set("Cookie", fmt.Sprintf("access_token=%s; refresh_token=%s", tokensPair.AccessToken, tokensPair.RefreshToken))
+		}
+		return next(c)
+	}
+}
+
 func (m AuthMiddleware) CheckToken(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		return errors.New("not_implemented")
@@ -126,3 +163,55 @@ func (m AuthMiddleware) CheckInternalAPICall(next echo.HandlerFunc) echo.Handler
 		return next(c)
 	}
 }
+
+// CheckPlatformAdmin lets through the internal API calls, and the users with the platform_admin role
+func (m AuthMiddleware) CheckPlatformAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if apiKey := c.Request().Header.Get("X-Authorize"); apiKey != "" {
+			if err := m.Service.AuthorizeInternalAPICall(apiKey); err != nil {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+			}
+			return next(c)
+		}
+		accessTokenValue := ""
+		if accessToken, err := c.Cookie("access_token"); err == nil {
+			accessTokenValue = accessToken.Value
+		}
+		if _, err := m.Service.Authorize(accessTokenValue, []string{entities.RolePlatformAdmin}, entities.RoleMatchAnyOf, nil); err != nil {
+			if errors.Is(err, apperrors.ErrUnauthorizedRole) {
+				return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+			}
+			if errors.Is(err, apperrors.ErrAccessTokenExpired) || errors.Is(err, apperrors.ErrAccessTokenInvalid) {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": apperrors.ErrGeneric.Error()})
+		}
+		return next(c)
+	}
+}
+
+// IdentifyDevice reads the signed device cookie, or sets a new one, and puts the device in the request context
+func (m AuthMiddleware) IdentifyDevice(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		deviceID := ""
+		if cookie, err := c.Cookie("device_id"); err == nil {
+			deviceID = cookies.VerifyDeviceID(cookie.Value, m.Config.DeviceCookieSecret())
+		}
+		if deviceID == "" {
+			newDeviceID, err := tokengen.Generate("device_", 16)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": apperrors.ErrGeneric.Error()})
+			}
+			deviceID = newDeviceID
+			deviceCookie := cookies.NewDeviceCookie(cookies.SignDeviceID(deviceID, m.Config.DeviceCookieSecret()), m.Config)
+			c.SetCookie(&deviceCookie)
+		}
+		requestctx.SetDevice(c, entities.Device{
+			ID:        deviceID,
+			UserAgent: c.Request().UserAgent(),
+			Platform:  strings.Trim(c.Request().Header.Get("Sec-CH-UA-Platform"), `"`),
+			IP:        c.RealIP(),
+		})
+		return next(c)
+	}
+}
@@ -15,12 +15,12 @@ type OAuthMiddlewaresInterface interface {
 
 type OAuthMiddlewares struct {
 	Config  entities.Config
-	Service primary.OAuthUseCasesInterface
+	Service primary.OAuthUseCasesForMiddlewares
 }
 
 var _ OAuthMiddlewaresInterface = (*OAuthMiddlewares)(nil)
 
-func NewOAuthMiddlewares(c entities.Config, s primary.OAuthUseCasesInterface) *OAuthMiddlewares {
+func NewOAuthMiddlewares(c entities.Config, s primary.OAuthUseCasesForMiddlewares) *OAuthMiddlewares {
 	return &OAuthMiddlewares{
 		Config:  c,
 		Service: s,
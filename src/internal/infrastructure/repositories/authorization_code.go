@@ -0,0 +1,58 @@
+package repositories
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/ports/secondary"
+	"aegis/pkg/apperrors"
+	"aegis/pkg/tokengen"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AuthorizationCodeRepository stores the codes as keyed hashes. Methods take and return plain codes.
+type AuthorizationCodeRepository struct {
+	db     *gorm.DB
+	pepper string
+}
+
+var _ secondary.AuthorizationCodeRepository = (*AuthorizationCodeRepository)(nil)
+
+func NewAuthorizationCodeRepository(db *gorm.DB, pepper string) *AuthorizationCodeRepository {
+	return &AuthorizationCodeRepository{db: db, pepper: pepper}
+}
+
+func (r *AuthorizationCodeRepository) CreateAuthorizationCode(code entities.AuthorizationCode) error {
+	if err := r.db.Where("expires_at < ?", time.Now()).Delete(&entities.AuthorizationCode{}).Error; err != nil {
+		return err
+	}
+	code.Code = tokengen.Hash(code.Code, r.pepper)
+	return r.db.Create(&code).Error
+}
+
+func (r *AuthorizationCodeRepository) GetAndDeleteAuthorizationCode(code string) (entities.AuthorizationCode, error) {
+	var authorizationCode entities.AuthorizationCode
+	hashed := tokengen.Hash(code, r.pepper)
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("code = ?", hashed).First(&authorizationCode).Error; err != nil {
+			return err
+		}
+		// Only one of two concurrent exchanges deletes the row
+		result := tx.Where("code = ?", hashed).Delete(&entities.AuthorizationCode{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	if err == gorm.ErrRecordNotFound {
+		return entities.AuthorizationCode{}, apperrors.ErrOAuthInvalidGrant
+	}
+	if err != nil {
+		return entities.AuthorizationCode{}, err
+	}
+	authorizationCode.Code = code
+	return authorizationCode, nil
+}
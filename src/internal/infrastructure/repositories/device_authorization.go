@@ -0,0 +1,98 @@
+package repositories
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/ports/secondary"
+	"aegis/pkg/apperrors"
+	"aegis/pkg/tokengen"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DeviceAuthorizationRepository stores the device codes and the user codes as keyed hashes. Methods take and return
+// plain codes.
+type DeviceAuthorizationRepository struct {
+	db     *gorm.DB
+	pepper string
+}
+
+var _ secondary.DeviceAuthorizationRepository = (*DeviceAuthorizationRepository)(nil)
+
+func NewDeviceAuthorizationRepository(db *gorm.DB, pepper string) *DeviceAuthorizationRepository {
+	return &DeviceAuthorizationRepository{db: db, pepper: pepper}
+}
+
+func (r *DeviceAuthorizationRepository) CreateDeviceAuthorization(authorization entities.DeviceAuthorization) error {
+	if err := r.db.Where("expires_at < ?", time.Now()).Delete(&entities.DeviceAuthorization{}).Error; err != nil {
+		return err
+	}
+	authorization.DeviceCode = tokengen.Hash(authorization.DeviceCode, r.pepper)
+	authorization.UserCode = tokengen.Hash(entities.NormalizeUserCode(authorization.UserCode), r.pepper)
+	return r.db.Create(&authorization).Error
+}
+
+func (r *DeviceAuthorizationRepository) GetDeviceAuthorization(deviceCode string) (entities.DeviceAuthorization, error) {
+	var authorization entities.DeviceAuthorization
+	err := r.db.Where("device_code = ?", tokengen.Hash(deviceCode, r.pepper)).First(&authorization).Error
+	if err == gorm.ErrRecordNotFound {
+		return entities.DeviceAuthorization{}, apperrors.ErrOAuthInvalidGrant
+	}
+	if err != nil {
+		return entities.DeviceAuthorization{}, err
+	}
+	authorization.DeviceCode = deviceCode
+	authorization.UserCode = ""
+	return authorization, nil
+}
+
+func (r *DeviceAuthorizationRepository) GetDeviceAuthorizationByUserCode(userCode string) (entities.DeviceAuthorization, error) {
+	var authorization entities.DeviceAuthorization
+	normalized := entities.NormalizeUserCode(userCode)
+	err := r.db.Where("user_code = ?", tokengen.Hash(normalized, r.pepper)).First(&authorization).Error
+	if err == gorm.ErrRecordNotFound {
+		return entities.DeviceAuthorization{}, apperrors.ErrUserCodeInvalid
+	}
+	if err != nil {
+		return entities.DeviceAuthorization{}, err
+	}
+	authorization.DeviceCode = ""
+	authorization.UserCode = normalized
+	return authorization, nil
+}
+
+func (r *DeviceAuthorizationRepository) DecideDeviceAuthorization(userCode string, decision entities.DeviceAuthorization) error {
+	// Only one of two concurrent decisions updates the row
+	result := r.db.Model(&entities.DeviceAuthorization{}).
+		Where("user_code = ? AND status = ?", tokengen.Hash(entities.NormalizeUserCode(userCode), r.pepper), entities.DeviceAuthorizationPending).
+		Updates(map[string]any{
+			"status":  decision.Status,
+			"user_id": decision.UserID,
+			"amr":     decision.AMR,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return apperrors.ErrUserCodeInvalid
+	}
+	return nil
+}
+
+func (r *DeviceAuthorizationRepository) UpdateDeviceAuthorizationPoll(deviceCode string, lastPolledAt time.Time, interval int) error {
+	return r.db.Model(&entities.DeviceAuthorization{}).
+		Where("device_code = ?", tokengen.Hash(deviceCode, r.pepper)).
+		Updates(map[string]any{"last_polled_at": lastPolledAt, "poll_interval": interval}).Error
+}
+
+func (r *DeviceAuthorizationRepository) DeleteDeviceAuthorization(deviceCode string) error {
+	// Only one of two concurrent polls deletes the row, the other does not get tokens
+	result := r.db.Where("device_code = ?", tokengen.Hash(deviceCode, r.pepper)).Delete(&entities.DeviceAuthorization{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return apperrors.ErrOAuthInvalidGrant
+	}
+	return nil
+}
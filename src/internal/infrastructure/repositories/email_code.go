@@ -0,0 +1,81 @@
+package repositories
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/ports/secondary"
+	"aegis/pkg/apperrors"
+	"aegis/pkg/tokengen"
+	"crypto/subtle"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// EmailCodeRepository stores the codes as keyed hashes of the email and the code. Methods take plain codes.
+type EmailCodeRepository struct {
+	db     *gorm.DB
+	pepper string
+}
+
+var _ secondary.EmailCodeRepository = (*EmailCodeRepository)(nil)
+
+func NewEmailCodeRepository(db *gorm.DB, pepper string) *EmailCodeRepository {
+	return &EmailCodeRepository{db: db, pepper: pepper}
+}
+
+func (r *EmailCodeRepository) hash(email, code string) string {
+	return tokengen.Hash(email+":"+code, r.pepper)
+}
+
+func (r *EmailCodeRepository) CreateEmailCode(emailCode entities.EmailCode) error {
+	// The codes are kept during the window for the limits
+	if err := r.db.Where("expires_at < ?", time.Now().Add(-entities.EmailCodeWindow)).Delete(&entities.EmailCode{}).Error; err != nil {
+		return err
+	}
+	emailCode.Code = r.hash(emailCode.Email, emailCode.Code)
+	return r.db.Create(&emailCode).Error
+}
+
+func (r *EmailCodeRepository) CountEmailCodesSince(email string, since time.Time) (int64, int64, error) {
+	var counts struct {
+		Codes    int64
+		Attempts int64
+	}
+	err := r.db.Model(&entities.EmailCode{}).
+		Select("COUNT(*) AS codes, COALESCE(SUM(attempts), 0) AS attempts").
+		Where("email = ? AND created_at > ?", email, since).
+		Scan(&counts).Error
+	return counts.Codes, counts.Attempts, err
+}
+
+func (r *EmailCodeRepository) ConsumeEmailCode(email, code string) error {
+	var emailCode entities.EmailCode
+	result := r.db.Where("email = ?", email).Order("created_at DESC").First(&emailCode)
+	if result.Error != nil && result.Error != gorm.ErrRecordNotFound {
+		return result.Error
+	}
+	if result.Error == gorm.ErrRecordNotFound || !emailCode.IsPending() {
+		return apperrors.ErrEmailCodeInvalid
+	}
+	// The attempt is counted before the code is checked, concurrent attempts can not go over the limit
+	result = r.db.Model(&entities.EmailCode{}).
+		Where("id = ? AND attempts < ? AND used_at IS NULL", emailCode.ID, entities.MaxEmailCodeAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return apperrors.ErrEmailCodeInvalid
+	}
+	if subtle.ConstantTimeCompare([]byte(r.hash(email, code)), []byte(emailCode.Code)) != 1 {
+		return apperrors.ErrEmailCodeInvalid
+	}
+	result = r.db.Model(&entities.EmailCode{}).Where("id = ? AND used_at IS NULL", emailCode.ID).Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return apperrors.ErrEmailCodeInvalid
+	}
+	return nil
+}
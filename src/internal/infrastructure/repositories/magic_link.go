@@ -0,0 +1,65 @@
+package repositories
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/ports/secondary"
+	"aegis/pkg/apperrors"
+	"aegis/pkg/tokengen"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MagicLinkRepository stores the tokens of the links as keyed hashes. Methods take and return plain tokens.
+type MagicLinkRepository struct {
+	db     *gorm.DB
+	pepper string
+}
+
+var _ secondary.MagicLinkRepository = (*MagicLinkRepository)(nil)
+
+func NewMagicLinkRepository(db *gorm.DB, pepper string) *MagicLinkRepository {
+	return &MagicLinkRepository{db: db, pepper: pepper}
+}
+
+func (r *MagicLinkRepository) CreateMagicLink(magicLink entities.MagicLink) error {
+	// The expired links are kept until they leave the window, they count in the limit of their email
+	if err := r.db.Where("expires_at < ? AND created_at < ?", time.Now(), time.Now().Add(-entities.MagicLinkWindow)).Delete(&entities.MagicLink{}).Error; err != nil {
+		return err
+	}
+	magicLink.Token = tokengen.Hash(magicLink.Token, r.pepper)
+	return r.db.Create(&magicLink).Error
+}
+
+func (r *MagicLinkRepository) CountMagicLinksSince(email string, since time.Time) (int64, error) {
+	var count int64
+	err := r.db.Model(&entities.MagicLink{}).Where("email = ? AND created_at > ?", email, since).Count(&count).Error
+	return count, err
+}
+
+func (r *MagicLinkRepository) GetAndDeleteMagicLink(token string) (entities.MagicLink, error) {
+	var magicLink entities.MagicLink
+	hashed := tokengen.Hash(token, r.pepper)
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("token = ?", hashed).First(&magicLink).Error; err != nil {
+			return err
+		}
+		// Only one of two concurrent uses deletes the row
+		result := tx.Where("token = ?", hashed).Delete(&entities.MagicLink{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	if err == gorm.ErrRecordNotFound {
+		return entities.MagicLink{}, apperrors.ErrMagicLinkInvalid
+	}
+	if err != nil {
+		return entities.MagicLink{}, err
+	}
+	magicLink.Token = token
+	return magicLink, nil
+}
@@ -0,0 +1,147 @@
+package repositories
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/ports/secondary"
+	"aegis/pkg/apperrors"
+	"aegis/pkg/secretbox"
+	"aegis/pkg/tokengen"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MFARepository encrypts the secrets of the authenticator apps, and stores the tokens of the pending logins as
+// keyed hashes. Methods take and return plain values.
+type MFARepository struct {
+	db            *gorm.DB
+	pepper        string
+	encryptionKey string
+}
+
+var _ secondary.MFARepository = (*MFARepository)(nil)
+
+func NewMFARepository(db *gorm.DB, pepper, encryptionKey string) *MFARepository {
+	return &MFARepository{db: db, pepper: pepper, encryptionKey: encryptionKey}
+}
+
+func (r *MFARepository) CreateTOTPFactor(factor entities.TOTPFactor) error {
+	secret, err := secretbox.Seal(factor.Secret, r.encryptionKey)
+	if err != nil {
+		return err
+	}
+	factor.Secret = secret
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&entities.TOTPFactor{}).Where("user_id = ? AND confirmed_at IS NOT NULL", factor.UserID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return apperrors.ErrTOTPAlreadyEnabled
+		}
+		if err := tx.Where("user_id = ?", factor.UserID).Delete(&entities.TOTPFactor{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&factor).Error
+	})
+}
+
+func (r *MFARepository) GetTOTPFactor(userID string) (entities.TOTPFactor, error) {
+	var factor entities.TOTPFactor
+	result := r.db.Where("user_id = ?", userID).First(&factor)
+	if result.Error != nil && result.Error != gorm.ErrRecordNotFound {
+		return entities.TOTPFactor{}, result.Error
+	}
+	if result.Error == gorm.ErrRecordNotFound {
+		return entities.TOTPFactor{}, apperrors.ErrTOTPNotEnabled
+	}
+	secret, err := secretbox.Open(factor.Secret, r.encryptionKey)
+	if err != nil {
+		return entities.TOTPFactor{}, err
+	}
+	factor.Secret = secret
+	return factor, nil
+}
+
+func (r *MFARepository) ConfirmTOTPFactor(userID string, step int64) error {
+	result := r.db.Model(&entities.TOTPFactor{}).
+		Where("user_id = ? AND confirmed_at IS NULL", userID).
+		Updates(map[string]any{"confirmed_at": time.Now(), "last_used_step": step})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return apperrors.ErrTOTPAlreadyEnabled
+	}
+	return nil
+}
+
+func (r *MFARepository) UseTOTPStep(userID string, step int64) error {
+	result := r.db.Model(&entities.TOTPFactor{}).
+		Where("user_id = ? AND last_used_step < ?", userID, step).
+		Update("last_used_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return apperrors.ErrMFACodeInvalid
+	}
+	return nil
+}
+
+func (r *MFARepository) DeleteTOTPFactor(userID string) error {
+	result := r.db.Where("user_id = ?", userID).Delete(&entities.TOTPFactor{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return apperrors.ErrTOTPNotEnabled
+	}
+	return nil
+}
+
+func (r *MFARepository) CreatePendingMFA(pending entities.PendingMFA) error {
+	if err := r.db.Where("expires_at < ?", time.Now()).Delete(&entities.PendingMFA{}).Error; err != nil {
+		return err
+	}
+	pending.Token = tokengen.Hash(pending.Token, r.pepper)
+	return r.db.Create(&pending).Error
+}
+
+func (r *MFARepository) GetPendingMFA(token string) (entities.PendingMFA, error) {
+	var pending entities.PendingMFA
+	result := r.db.Where("token = ?", tokengen.Hash(token, r.pepper)).First(&pending)
+	if result.Error != nil && result.Error != gorm.ErrRecordNotFound {
+		return entities.PendingMFA{}, result.Error
+	}
+	if result.Error == gorm.ErrRecordNotFound || pending.IsExpired() {
+		return entities.PendingMFA{}, apperrors.ErrMFATokenInvalid
+	}
+	pending.Token = token
+	return pending, nil
+}
+
+func (r *MFARepository) CountPendingMFAAttempt(token string) error {
+	// The attempt is counted before the code is checked, concurrent attempts can not go over the limit
+	result := r.db.Model(&entities.PendingMFA{}).
+		Where("token = ? AND attempts < ?", tokengen.Hash(token, r.pepper), entities.MaxPendingMFAAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return apperrors.ErrTooManyMFAAttempts
+	}
+	return nil
+}
+
+func (r *MFARepository) DeletePendingMFA(token string) error {
+	result := r.db.Where("token = ?", tokengen.Hash(token, r.pepper)).Delete(&entities.PendingMFA{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return apperrors.ErrMFATokenInvalid
+	}
+	return nil
+}
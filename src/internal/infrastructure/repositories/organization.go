@@ -0,0 +1,84 @@
+package repositories
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/ports/secondary"
+	"aegis/pkg/apperrors"
+
+	"gorm.io/gorm"
+)
+
+type OrganizationRepository struct {
+	db *gorm.DB
+}
+
+var _ secondary.OrganizationRepository = (*OrganizationRepository)(nil)
+
+func NewOrganizationRepository(db *gorm.DB) *OrganizationRepository {
+	return &OrganizationRepository{db: db}
+}
+
+func (r *OrganizationRepository) CreateOrganization(organization entities.Organization, owner entities.OrganizationMember) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&organization).Error; err != nil {
+			return err
+		}
+		return tx.Create(&owner).Error
+	})
+}
+
+func (r *OrganizationRepository) GetOrganizationMember(organizationID, userID string) (entities.OrganizationMember, error) {
+	var member entities.OrganizationMember
+	result := r.db.Where("organization_id = ? AND user_id = ?", organizationID, userID).Preload("Organization").First(&member)
+	if result.Error != nil && result.Error != gorm.ErrRecordNotFound {
+		return entities.OrganizationMember{}, result.Error
+	}
+	if result.Error == gorm.ErrRecordNotFound {
+		return entities.OrganizationMember{}, apperrors.ErrNotOrganizationMember
+	}
+	return member, nil
+}
+
+func (r *OrganizationRepository) GetOrganizationsForUser(userID string) ([]entities.OrganizationMember, error) {
+	var members []entities.OrganizationMember
+	if err := r.db.Where("user_id = ?", userID).Preload("Organization").Order("joined_at ASC").Find(&members).Error; err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func (r *OrganizationRepository) GetOrganizationMembers(organizationID string) ([]entities.OrganizationMember, error) {
+	var members []entities.OrganizationMember
+	if err := r.db.Where("organization_id = ?", organizationID).Preload("User").Order("joined_at ASC").Find(&members).Error; err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func (r *OrganizationRepository) CountOrganizationOwners(organizationID string) (int64, error) {
+	var count int64
+	err := r.db.Model(&entities.OrganizationMember{}).Where("organization_id = ? AND role = ?", organizationID, entities.OrganizationRoleOwner).Count(&count).Error
+	return count, err
+}
+
+func (r *OrganizationRepository) UpdateOrganizationMemberRole(organizationID, userID, role string) error {
+	result := r.db.Model(&entities.OrganizationMember{}).Where("organization_id = ? AND user_id = ?", organizationID, userID).Update("role", role)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return apperrors.ErrNotOrganizationMember
+	}
+	return nil
+}
+
+func (r *OrganizationRepository) DeleteOrganizationMember(organizationID, userID string) error {
+	result := r.db.Where("organization_id = ? AND user_id = ?", organizationID, userID).Delete(&entities.OrganizationMember{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return apperrors.ErrNotOrganizationMember
+	}
+	return nil
+}
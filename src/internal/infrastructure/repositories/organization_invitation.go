@@ -0,0 +1,94 @@
+package repositories
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/ports/secondary"
+	"aegis/pkg/apperrors"
+	"aegis/pkg/tokengen"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OrganizationInvitationRepository stores the tokens of the invitations as keyed hashes, like the refresh tokens.
+// Methods take and return plain tokens.
+type OrganizationInvitationRepository struct {
+	db     *gorm.DB
+	pepper string
+}
+
+var _ secondary.OrganizationInvitationRepository = (*OrganizationInvitationRepository)(nil)
+
+func NewOrganizationInvitationRepository(db *gorm.DB, pepper string) *OrganizationInvitationRepository {
+	return &OrganizationInvitationRepository{db: db, pepper: pepper}
+}
+
+func (r *OrganizationInvitationRepository) CreateInvitation(invitation entities.OrganizationInvitation) error {
+	invitation.Token = tokengen.Hash(invitation.Token, r.pepper)
+	return r.db.Create(&invitation).Error
+}
+
+func (r *OrganizationInvitationRepository) GetPendingInvitationByToken(token string) (entities.OrganizationInvitation, error) {
+	invitation, err := r.getPendingInvitation(r.db.Where("token = ?", tokengen.Hash(token, r.pepper)))
+	if err != nil {
+		return entities.OrganizationInvitation{}, err
+	}
+	invitation.Token = token
+	return invitation, nil
+}
+
+func (r *OrganizationInvitationRepository) GetPendingInvitation(invitationID string) (entities.OrganizationInvitation, error) {
+	return r.getPendingInvitation(r.db.Where("id = ?", invitationID))
+}
+
+func (r *OrganizationInvitationRepository) getPendingInvitation(query *gorm.DB) (entities.OrganizationInvitation, error) {
+	var invitation entities.OrganizationInvitation
+	result := query.Preload("Organization").First(&invitation)
+	if result.Error != nil && result.Error != gorm.ErrRecordNotFound {
+		return entities.OrganizationInvitation{}, result.Error
+	}
+	if result.Error == gorm.ErrRecordNotFound || !invitation.IsPending() {
+		return entities.OrganizationInvitation{}, apperrors.ErrInvitationInvalid
+	}
+	return invitation, nil
+}
+
+func (r *OrganizationInvitationRepository) GetPendingInvitations(organizationID string) ([]entities.OrganizationInvitation, error) {
+	var invitations []entities.OrganizationInvitation
+	err := r.db.Where("organization_id = ? AND accepted_at IS NULL AND expires_at > ?", organizationID, time.Now()).
+		Order("created_at DESC").
+		Find(&invitations).Error
+	if err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+func (r *OrganizationInvitationRepository) DeleteInvitation(organizationID, invitationID string) error {
+	result := r.db.Where("organization_id = ? AND id = ?", organizationID, invitationID).Delete(&entities.OrganizationInvitation{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return apperrors.ErrInvitationNotFound
+	}
+	return nil
+}
+
+func (r *OrganizationInvitationRepository) AcceptInvitation(invitationID string, member entities.OrganizationMember) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		// Only one of two concurrent acceptances updates the row
+		result := tx.Model(&entities.OrganizationInvitation{}).
+			Where("id = ? AND accepted_at IS NULL AND expires_at > ?", invitationID, now).
+			Update("accepted_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return apperrors.ErrInvitationInvalid
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&member).Error
+	})
+}
@@ -0,0 +1,107 @@
+package repositories
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/ports/secondary"
+	"aegis/pkg/apperrors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type PasskeyRepository struct {
+	db *gorm.DB
+}
+
+var _ secondary.PasskeyRepository = (*PasskeyRepository)(nil)
+
+func NewPasskeyRepository(db *gorm.DB) *PasskeyRepository {
+	return &PasskeyRepository{db: db}
+}
+
+func (r *PasskeyRepository) CreatePasskeyChallenge(challenge entities.PasskeyChallenge) error {
+	if err := r.db.Where("expires_at < ?", time.Now()).Delete(&entities.PasskeyChallenge{}).Error; err != nil {
+		return err
+	}
+	return r.db.Create(&challenge).Error
+}
+
+func (r *PasskeyRepository) GetAndDeletePasskeyChallenge(challenge string) (entities.PasskeyChallenge, error) {
+	var passkeyChallenge entities.PasskeyChallenge
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("challenge = ?", challenge).First(&passkeyChallenge).Error; err != nil {
+			return err
+		}
+		// Only one of two concurrent uses deletes the row
+		result := tx.Where("challenge = ?", challenge).Delete(&entities.PasskeyChallenge{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	if err == gorm.ErrRecordNotFound {
+		return entities.PasskeyChallenge{}, apperrors.ErrPasskeyInvalid
+	}
+	if err != nil {
+		return entities.PasskeyChallenge{}, err
+	}
+	return passkeyChallenge, nil
+}
+
+func (r *PasskeyRepository) CreatePasskey(passkey entities.Passkey) error {
+	var count int64
+	if err := r.db.Model(&entities.Passkey{}).Where("credential_id = ?", passkey.CredentialID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return apperrors.ErrPasskeyAlreadyRegistered
+	}
+	return r.db.Create(&passkey).Error
+}
+
+func (r *PasskeyRepository) GetPasskeyByCredentialID(credentialID string) (entities.Passkey, error) {
+	var passkey entities.Passkey
+	result := r.db.Where("credential_id = ?", credentialID).First(&passkey)
+	if result.Error != nil && result.Error != gorm.ErrRecordNotFound {
+		return entities.Passkey{}, result.Error
+	}
+	if result.Error == gorm.ErrRecordNotFound {
+		return entities.Passkey{}, apperrors.ErrPasskeyNotFound
+	}
+	return passkey, nil
+}
+
+func (r *PasskeyRepository) GetPasskeysForUser(userID string) ([]entities.Passkey, error) {
+	var passkeys []entities.Passkey
+	if err := r.db.Where("user_id = ?", userID).Order("created_at ASC").Find(&passkeys).Error; err != nil {
+		return nil, err
+	}
+	return passkeys, nil
+}
+
+func (r *PasskeyRepository) UsePasskey(passkeyID string, previousSignCount, signCount uint32) error {
+	result := r.db.Model(&entities.Passkey{}).
+		Where("id = ? AND sign_count = ?", passkeyID, previousSignCount).
+		Updates(map[string]any{"sign_count": signCount, "last_used_at": time.Now()})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return apperrors.ErrPasskeyInvalid
+	}
+	return nil
+}
+
+func (r *PasskeyRepository) DeletePasskey(userID, passkeyID string) error {
+	result := r.db.Where("user_id = ? AND id = ?", userID, passkeyID).Delete(&entities.Passkey{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return apperrors.ErrPasskeyNotFound
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package repositories
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/ports/secondary"
+	"aegis/pkg/apperrors"
+	"aegis/pkg/tokengen"
+	"aegis/pkg/uidgen"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RecoveryCodeRepository stores the codes as keyed hashes of the normalized codes, and the failed attempts under a keyed
+// hash of their email and IP. Methods take plain values.
+type RecoveryCodeRepository struct {
+	db     *gorm.DB
+	pepper string
+}
+
+var _ secondary.RecoveryCodeRepository = (*RecoveryCodeRepository)(nil)
+
+func NewRecoveryCodeRepository(db *gorm.DB, pepper string) *RecoveryCodeRepository {
+	return &RecoveryCodeRepository{db: db, pepper: pepper}
+}
+
+func (r *RecoveryCodeRepository) hash(code string) string {
+	return tokengen.Hash(entities.NormalizeRecoveryCode(code), r.pepper)
+}
+
+func (r *RecoveryCodeRepository) ReplaceRecoveryCodes(userID string, codes []string) error {
+	recoveryCodes := make([]entities.RecoveryCode, 0, len(codes))
+	for _, code := range codes {
+		recoveryCodes = append(recoveryCodes, entities.RecoveryCode{UserID: userID, Code: r.hash(code), CreatedAt: time.Now()})
+	}
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&entities.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if len(recoveryCodes) == 0 {
+			return nil
+		}
+		return tx.Create(&recoveryCodes).Error
+	})
+}
+
+func (r *RecoveryCodeRepository) UseRecoveryCode(userID, code string) error {
+	result := r.db.Model(&entities.RecoveryCode{}).
+		Where("user_id = ? AND code = ? AND used_at IS NULL", userID, r.hash(code)).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return apperrors.ErrMFACodeInvalid
+	}
+	return nil
+}
+
+func (r *RecoveryCodeRepository) CountUnusedRecoveryCodes(userID string) (int64, error) {
+	var count int64
+	err := r.db.Model(&entities.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+func (r *RecoveryCodeRepository) attemptKey(email, ip string) string {
+	return tokengen.Hash(email+":"+ip, r.pepper)
+}
+
+func (r *RecoveryCodeRepository) RecordFailedRecoveryAttempt(email, ip string) error {
+	if err := r.db.Where("created_at < ?", time.Now().Add(-entities.RecoveryAttemptsWindow)).Delete(&entities.RecoveryAttempt{}).Error; err != nil {
+		return err
+	}
+	return r.db.Create(&entities.RecoveryAttempt{ID: uidgen.Generate(), Key: r.attemptKey(email, ip), CreatedAt: time.Now()}).Error
+}
+
+func (r *RecoveryCodeRepository) CountFailedRecoveryAttemptsSince(email, ip string, since time.Time) (int64, error) {
+	var count int64
+	err := r.db.Model(&entities.RecoveryAttempt{}).Where("key = ? AND created_at >= ?", r.attemptKey(email, ip), since).Count(&count).Error
+	return count, err
+}
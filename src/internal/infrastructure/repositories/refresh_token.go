@@ -4,22 +4,46 @@ import (
 	"aegis/internal/domain/entities"
 	"aegis/internal/domain/ports/secondary"
 	"aegis/pkg/apperrors"
+	"aegis/pkg/tokengen"
+	"aegis/pkg/uidgen"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
 )
 
+// RefreshTokenRepository stores refresh tokens (and their parent) as keyed hashes: someone who can read the
+// database cannot use them. Methods take and return plain tokens.
 type RefreshTokenRepository struct {
-	db *gorm.DB
+	db     *gorm.DB
+	pepper string
 }
 
 var _ secondary.RefreshTokenRepository = (*RefreshTokenRepository)(nil)
 
-func NewRefreshTokenRepository(db *gorm.DB) *RefreshTokenRepository {
-	return &RefreshTokenRepository{db: db}
+func NewRefreshTokenRepository(db *gorm.DB, pepper string) *RefreshTokenRepository {
+	return &RefreshTokenRepository{db: db, pepper: pepper}
+}
+
+func (r *RefreshTokenRepository) Hash(token string) string {
+	return tokengen.Hash(token, r.pepper)
+}
+
+// Tokens stored before hashing was introduced are the plain token, which has a prefix
+func isLegacyToken(token string) bool {
+	return strings.HasPrefix(token, "refresh_")
+}
+
+func (r *RefreshTokenRepository) hashed(refreshToken entities.RefreshToken) entities.RefreshToken {
+	refreshToken.Token = r.Hash(refreshToken.Token)
+	if refreshToken.ParentToken != "" {
+		refreshToken.ParentToken = r.Hash(refreshToken.ParentToken)
+	}
+	return refreshToken
 }
 
 func (r *RefreshTokenRepository) CreateRefreshToken(refreshToken entities.RefreshToken) error {
+	refreshToken = r.hashed(refreshToken)
 	result := r.db.Model(&entities.RefreshToken{}).Create(&refreshToken)
 	if result.Error != nil {
 		return result.Error
@@ -29,19 +53,30 @@ func (r *RefreshTokenRepository) CreateRefreshToken(refreshToken entities.Refres
 
 func (r *RefreshTokenRepository) GetRefreshTokenByToken(token string) (entities.RefreshToken, error) {
 	var refreshToken entities.RefreshToken
-	result := r.db.Model(&entities.RefreshToken{}).Where("token = ?", token).First(&refreshToken)
+	result := r.db.Model(&entities.RefreshToken{}).Where("token = ?", r.Hash(token)).First(&refreshToken)
+	if result.Error == gorm.ErrRecordNotFound && isLegacyToken(token) {
+		// May have been stored in plain text by an instance that does not hash tokens yet (rolling deployment).
+		// It is hashed only if it is found, a miss costs a single lookup.
+		result = r.db.Model(&entities.RefreshToken{}).Where("token = ?", token).First(&refreshToken)
+		if result.Error == nil {
+			if err := r.hashLegacyRefreshToken(token); err != nil {
+				return entities.RefreshToken{}, err
+			}
+		}
+	}
 	if result.Error != nil && result.Error != gorm.ErrRecordNotFound {
 		return entities.RefreshToken{}, result.Error
 	}
 	if result.Error == gorm.ErrRecordNotFound {
 		return entities.RefreshToken{}, apperrors.ErrRefreshTokenInvalid
 	}
+	refreshToken.Token = token
 	return refreshToken, nil
 }
 
 func (r *RefreshTokenRepository) CountValidRefreshTokensForUser(userID string) (int, error) {
 	var count int64
-	result := r.db.Model(&entities.RefreshToken{}).Where("user_id = ? AND expires_at > ?", userID, time.Now()).Count(&count)
+	result := r.db.Model(&entities.RefreshToken{}).Where("user_id = ? AND expires_at > ? AND rotated_at IS NULL", userID, time.Now()).Count(&count)
 	if result.Error != nil {
 		return 0, result.Error
 	}
@@ -57,7 +92,7 @@ func (r *RefreshTokenRepository) CleanExpiredTokens(userID string) error {
 }
 
 func (r *RefreshTokenRepository) DeleteRefreshToken(token string) error {
-	result := r.db.Model(&entities.RefreshToken{}).Where("token = ?", token).Delete(&entities.RefreshToken{})
+	result := r.db.Model(&entities.RefreshToken{}).Where("token = ?", r.Hash(token)).Delete(&entities.RefreshToken{})
 	if result.Error != nil {
 		return result.Error
 	}
@@ -71,3 +106,108 @@ func (r *RefreshTokenRepository) DeleteRefreshTokenByDeviceFingerprint(userID, d
 	}
 	return nil
 }
+
+func (r *RefreshTokenRepository) RotateRefreshToken(token, successorNonce string, child entities.RefreshToken) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		// Conditional update, so that a token can only be rotated once, even by concurrent requests on several instances.
+		// A concurrent request waits for this transaction, then sees the nonce and the child.
+		result := tx.Model(&entities.RefreshToken{}).Where("token = ? AND rotated_at IS NULL", r.Hash(token)).Updates(map[string]any{
+			"rotated_at":      time.Now(),
+			"successor_nonce": successorNonce,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return apperrors.ErrRefreshTokenReused
+		}
+		child = r.hashed(child)
+		return tx.Create(&child).Error
+	})
+}
+
+func (r *RefreshTokenRepository) DeleteRefreshTokenFamily(familyID string) error {
+	result := r.db.Model(&entities.RefreshToken{}).Where("family_id = ?", familyID).Delete(&entities.RefreshToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+func (r *RefreshTokenRepository) GetActiveRefreshTokensForUser(userID string) ([]entities.RefreshToken, error) {
+	var refreshTokens []entities.RefreshToken
+	result := r.db.Model(&entities.RefreshToken{}).Where("user_id = ? AND expires_at > ? AND rotated_at IS NULL", userID, time.Now()).Order("last_used_at DESC").Find(&refreshTokens)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return refreshTokens, nil
+}
+
+func (r *RefreshTokenRepository) DeleteRefreshTokenFamilyForUser(userID, familyID string) error {
+	result := r.db.Model(&entities.RefreshToken{}).Where("user_id = ? AND family_id = ?", userID, familyID).Delete(&entities.RefreshToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return apperrors.ErrSessionNotFound
+	}
+	return nil
+}
+
+func (r *RefreshTokenRepository) DeleteOtherRefreshTokenFamilies(userID, keptFamilyID string) error {
+	result := r.db.Model(&entities.RefreshToken{}).Where("user_id = ? AND family_id <> ?", userID, keptFamilyID).Delete(&entities.RefreshToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+func (r *RefreshTokenRepository) DeleteRefreshTokensForUser(userID string) error {
+	result := r.db.Model(&entities.RefreshToken{}).Where("user_id = ?", userID).Delete(&entities.RefreshToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+// AssignLegacyRefreshTokenFamilies gives a family to the tokens created before families were introduced, so that
+// their session can be listed and revoked
+func (r *RefreshTokenRepository) AssignLegacyRefreshTokenFamilies() error {
+	var tokens []string
+	if err := r.db.Model(&entities.RefreshToken{}).Where("family_id = ?", "").Pluck("token", &tokens).Error; err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if err := r.db.Model(&entities.RefreshToken{}).Where("token = ?", token).Update("family_id", uidgen.Generate()).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HashLegacyRefreshTokens replaces the plain tokens stored before hashing was introduced by their hash
+func (r *RefreshTokenRepository) HashLegacyRefreshTokens() error {
+	var tokens []string
+	if err := r.db.Model(&entities.RefreshToken{}).Where("token LIKE ?", "refresh_%").Pluck("token", &tokens).Error; err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if err := r.hashLegacyRefreshToken(token); err != nil {
+			return err
+		}
+	}
+	var parentTokens []string
+	if err := r.db.Model(&entities.RefreshToken{}).Where("parent_token LIKE ?", "refresh_%").Distinct().Pluck("parent_token", &parentTokens).Error; err != nil {
+		return err
+	}
+	for _, parentToken := range parentTokens {
+		if err := r.db.Model(&entities.RefreshToken{}).Where("parent_token = ?", parentToken).Update("parent_token", r.Hash(parentToken)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *RefreshTokenRepository) hashLegacyRefreshToken(token string) error {
+	return r.db.Model(&entities.RefreshToken{}).Where("token = ?", token).Update("token", r.Hash(token)).Error
+}
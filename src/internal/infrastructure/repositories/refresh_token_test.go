@@ -2,6 +2,7 @@ package repositories
 
 import (
 	"aegis/internal/domain/entities"
+	"aegis/pkg/apperrors"
 	"aegis/pkg/fingerprint"
 	"fmt"
 	"testing"
@@ -18,7 +19,7 @@ func TestCreateRefreshToken(t *testing.T) {
 			t.Fatal(err)
 		}
 		db.AutoMigrate(&entities.User{}, &entities.RefreshToken{})
-		refreshTokenRepository := NewRefreshTokenRepository(db)
+		refreshTokenRepository := NewRefreshTokenRepository(db, "some-pepper")
 		refreshToken, _, err := entities.NewRefreshToken(entities.User{ID: "123"}, "device-id", entities.Config{
 			JWT: entities.JWTConfig{
 				Secret:                     "xxxsecret",
@@ -34,12 +35,12 @@ func TestCreateRefreshToken(t *testing.T) {
 			t.Fatal("expected no error", err)
 		}
 		var retrievedToken entities.RefreshToken
-		result := db.Model(&entities.RefreshToken{}).Where("token = ?", refreshToken.Token).First(&retrievedToken)
+		result := db.Model(&entities.RefreshToken{}).Where("token = ?", refreshTokenRepository.Hash(refreshToken.Token)).First(&retrievedToken)
 		if result.Error != nil {
 			t.Fatal("expected no error", result.Error)
 		}
-		if retrievedToken.Token != refreshToken.Token {
-			t.Fatal("expected token to be the same", retrievedToken.Token, refreshToken.Token)
+		if retrievedToken.Token != refreshTokenRepository.Hash(refreshToken.Token) {
+			t.Fatal("expected token to be stored hashed", retrievedToken.Token, refreshToken.Token)
 		}
 		if retrievedToken.UserID != refreshToken.UserID {
 			t.Fatal("expected user_id to be the same", retrievedToken.UserID, refreshToken.UserID)
@@ -63,7 +64,7 @@ func TestGetRefreshTokenByToken(t *testing.T) {
 			t.Fatal(err)
 		}
 		db.AutoMigrate(&entities.User{}, &entities.RefreshToken{})
-		refreshTokenRepository := NewRefreshTokenRepository(db)
+		refreshTokenRepository := NewRefreshTokenRepository(db, "some-pepper")
 		deviceFingerprint1, err := fingerprint.GenerateDeviceFingerprint("device-id")
 		if err != nil {
 			t.Fatal("expected no error", err)
@@ -121,7 +122,7 @@ func TestCountValidRefreshTokensForUser(t *testing.T) {
 			t.Fatal(err)
 		}
 		db.AutoMigrate(&entities.User{}, &entities.RefreshToken{})
-		refreshTokenRepository := NewRefreshTokenRepository(db)
+		refreshTokenRepository := NewRefreshTokenRepository(db, "some-pepper")
 		deviceFingerprint, err := fingerprint.GenerateDeviceFingerprint("device-id")
 		if err != nil {
 			t.Fatal("expected no error", err)
@@ -172,7 +173,7 @@ func TestCleanExpiredTokens(t *testing.T) {
 			t.Fatal(err)
 		}
 		db.AutoMigrate(&entities.User{}, &entities.RefreshToken{})
-		refreshTokenRepository := NewRefreshTokenRepository(db)
+		refreshTokenRepository := NewRefreshTokenRepository(db, "some-pepper")
 		deviceFingerprint, err := fingerprint.GenerateDeviceFingerprint("device-id")
 		if err != nil {
 			t.Fatal("expected no error", err)
@@ -211,7 +212,7 @@ func TestCleanExpiredTokens(t *testing.T) {
 			t.Fatal("expected no error", err)
 		}
 		var countActive int64
-		result := db.Model(&entities.RefreshToken{}).Where("user_id = ? AND token = ?", "123", refreshTokenActive.Token).Count(&countActive)
+		result := db.Model(&entities.RefreshToken{}).Where("user_id = ? AND token = ?", "123", refreshTokenRepository.Hash(refreshTokenActive.Token)).Count(&countActive)
 		if result.Error != nil {
 			t.Fatal("expected no error", result.Error)
 		}
@@ -219,7 +220,7 @@ func TestCleanExpiredTokens(t *testing.T) {
 			t.Fatal("expected count to be 1", countActive)
 		}
 		var countExpired int64
-		result = db.Model(&entities.RefreshToken{}).Where("user_id = ? AND token = ?", "123", refreshTokenExpired.Token).Count(&countExpired)
+		result = db.Model(&entities.RefreshToken{}).Where("user_id = ? AND token = ?", "123", refreshTokenRepository.Hash(refreshTokenExpired.Token)).Count(&countExpired)
 		if result.Error != nil {
 			t.Fatal("expected no error", result.Error)
 		}
@@ -236,7 +237,7 @@ func TestDeleteRefreshToken(t *testing.T) {
 			t.Fatal(err)
 		}
 		db.AutoMigrate(&entities.User{}, &entities.RefreshToken{})
-		refreshTokenRepository := NewRefreshTokenRepository(db)
+		refreshTokenRepository := NewRefreshTokenRepository(db, "some-pepper")
 		deviceFingerprint, err := fingerprint.GenerateDeviceFingerprint("device-id")
 		if err != nil {
 			t.Fatal("expected no error", err)
@@ -275,7 +276,7 @@ func TestDeleteRefreshToken(t *testing.T) {
 			t.Fatal("expected no error", err)
 		}
 		var count int64
-		result := db.Model(&entities.RefreshToken{}).Where("user_id = ? AND token = ?", "123", refreshTokenActive.Token).Count(&count)
+		result := db.Model(&entities.RefreshToken{}).Where("user_id = ? AND token = ?", "123", refreshTokenRepository.Hash(refreshTokenActive.Token)).Count(&count)
 		if result.Error != nil {
 			t.Fatal("expected no error", result.Error)
 		}
@@ -284,3 +285,93 @@ func TestDeleteRefreshToken(t *testing.T) {
 		}
 	})
 }
+
+func TestHashedRefreshTokens(t *testing.T) {
+	prepare := func(t *testing.T) (*gorm.DB, *RefreshTokenRepository, entities.RefreshToken) {
+		db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.AutoMigrate(&entities.User{}, &entities.RefreshToken{})
+		refreshToken, _, err := entities.NewRefreshToken(entities.User{ID: "123"}, "device-id", entities.Config{
+			JWT: entities.JWTConfig{
+				RefreshTokenExpirationDays: 30,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db, NewRefreshTokenRepository(db, "some-pepper"), refreshToken
+	}
+	t.Run("should not store the plain token", func(t *testing.T) {
+		db, refreshTokenRepository, refreshToken := prepare(t)
+		if err := refreshTokenRepository.CreateRefreshToken(refreshToken); err != nil {
+			t.Fatal(err)
+		}
+		var count int64
+		db.Model(&entities.RefreshToken{}).Where("token = ?", refreshToken.Token).Count(&count)
+		if count != 0 {
+			t.Fatal("expected the plain token to not be stored", count)
+		}
+		retrieved, err := refreshTokenRepository.GetRefreshTokenByToken(refreshToken.Token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if retrieved.Token != refreshToken.Token {
+			t.Fatal("expected the plain token to be returned", retrieved.Token)
+		}
+	})
+	t.Run("should not find a token hashed with another pepper", func(t *testing.T) {
+		db, refreshTokenRepository, refreshToken := prepare(t)
+		if err := refreshTokenRepository.CreateRefreshToken(refreshToken); err != nil {
+			t.Fatal(err)
+		}
+		_, err := NewRefreshTokenRepository(db, "other-pepper").GetRefreshTokenByToken(refreshToken.Token)
+		if err != apperrors.ErrRefreshTokenInvalid {
+			t.Fatal("expected error to be 'refresh_token_invalid'", err)
+		}
+	})
+	t.Run("should hash the legacy plain tokens", func(t *testing.T) {
+		db, refreshTokenRepository, refreshToken := prepare(t)
+		child, _, err := entities.NewChildRefreshToken(refreshToken, "nonce", entities.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.Create(&refreshToken)
+		db.Create(&child)
+		if err := refreshTokenRepository.HashLegacyRefreshTokens(); err != nil {
+			t.Fatal(err)
+		}
+		var count int64
+		db.Model(&entities.RefreshToken{}).Where("token LIKE ? OR parent_token LIKE ?", "refresh_%", "refresh_%").Count(&count)
+		if count != 0 {
+			t.Fatal("expected no plain token to be left", count)
+		}
+		if _, err := refreshTokenRepository.GetRefreshTokenByToken(child.Token); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("should hash a legacy plain token when it is used", func(t *testing.T) {
+		db, refreshTokenRepository, refreshToken := prepare(t)
+		db.Create(&refreshToken)
+		if _, err := refreshTokenRepository.GetRefreshTokenByToken(refreshToken.Token); err != nil {
+			t.Fatal(err)
+		}
+		var count int64
+		db.Model(&entities.RefreshToken{}).Where("token = ?", refreshTokenRepository.Hash(refreshToken.Token)).Count(&count)
+		if count != 1 {
+			t.Fatal("expected the token to be hashed", count)
+		}
+	})
+	t.Run("should not update anything for an unknown token", func(t *testing.T) {
+		db, refreshTokenRepository, refreshToken := prepare(t)
+		var updates int
+		db.Callback().Update().Register("count_updates", func(*gorm.DB) { updates++ })
+		if _, err := refreshTokenRepository.GetRefreshTokenByToken(refreshToken.Token); err != apperrors.ErrRefreshTokenInvalid {
+			t.Fatal("expected error to be 'refresh_token_invalid'", err)
+		}
+		if updates != 0 {
+			t.Fatal("expected no update for a token that is not stored", updates)
+		}
+	})
+}
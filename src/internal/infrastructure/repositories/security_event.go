@@ -0,0 +1,38 @@
+package repositories
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/ports/secondary"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type SecurityEventRepository struct {
+	db *gorm.DB
+}
+
+var _ secondary.SecurityEventRepository = (*SecurityEventRepository)(nil)
+
+func NewSecurityEventRepository(db *gorm.DB) *SecurityEventRepository {
+	return &SecurityEventRepository{db: db}
+}
+
+func (r *SecurityEventRepository) CreateSecurityEvent(event entities.SecurityEvent) error {
+	return r.db.Create(&event).Error
+}
+
+func (r *SecurityEventRepository) GetSecurityEventsForUser(userID, eventType string, limit int) ([]entities.SecurityEvent, error) {
+	var events []entities.SecurityEvent
+	result := r.db.Model(&entities.SecurityEvent{}).Where("user_id = ? AND type = ?", userID, eventType).Order("created_at DESC").Limit(limit).Find(&events)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return events, nil
+}
+
+func (r *SecurityEventRepository) CountSecurityEventsForUserSince(userID, eventType string, since time.Time) (int64, error) {
+	var count int64
+	err := r.db.Model(&entities.SecurityEvent{}).Where("user_id = ? AND type = ? AND created_at >= ?", userID, eventType, since).Count(&count).Error
+	return count, err
+}
@@ -0,0 +1,66 @@
+package repositories
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/ports/secondary"
+	"aegis/pkg/apperrors"
+	"aegis/pkg/tokengen"
+	"crypto/subtle"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ServiceClientRepository stores the secrets as keyed hashes. Methods take plain secrets.
+type ServiceClientRepository struct {
+	db     *gorm.DB
+	pepper string
+}
+
+var _ secondary.ServiceClientRepository = (*ServiceClientRepository)(nil)
+
+func NewServiceClientRepository(db *gorm.DB, pepper string) *ServiceClientRepository {
+	return &ServiceClientRepository{db: db, pepper: pepper}
+}
+
+func (r *ServiceClientRepository) CreateServiceClient(client entities.ServiceClient) error {
+	client.Secret = tokengen.Hash(client.Secret, r.pepper)
+	return r.db.Create(&client).Error
+}
+
+func (r *ServiceClientRepository) ListServiceClients() ([]entities.ServiceClient, error) {
+	var clients []entities.ServiceClient
+	err := r.db.Order("created_at ASC").Find(&clients).Error
+	return clients, err
+}
+
+func (r *ServiceClientRepository) AuthenticateServiceClient(clientID, secret string) (entities.ServiceClient, error) {
+	var client entities.ServiceClient
+	err := r.db.Where("client_id = ?", clientID).First(&client).Error
+	if err == gorm.ErrRecordNotFound {
+		return entities.ServiceClient{}, apperrors.ErrOAuthInvalidClient
+	}
+	if err != nil {
+		return entities.ServiceClient{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(client.Secret), []byte(tokengen.Hash(secret, r.pepper))) != 1 {
+		return entities.ServiceClient{}, apperrors.ErrOAuthInvalidClient
+	}
+	now := time.Now()
+	if err := r.db.Model(&entities.ServiceClient{}).Where("client_id = ?", clientID).Update("last_used_at", now).Error; err != nil {
+		return entities.ServiceClient{}, err
+	}
+	client.LastUsedAt = &now
+	return client, nil
+}
+
+func (r *ServiceClientRepository) DeleteServiceClient(clientID string) error {
+	result := r.db.Where("client_id = ?", clientID).Delete(&entities.ServiceClient{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return apperrors.ErrServiceClientNotFound
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package repositories
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/ports/secondary"
+	"aegis/pkg/apperrors"
+	"aegis/pkg/secretbox"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SigningKeyRepository encrypts the private keys, so that reading the database is not enough to sign tokens.
+// Methods take and return plain keys.
+type SigningKeyRepository struct {
+	db            *gorm.DB
+	encryptionKey string
+}
+
+var _ secondary.SigningKeyRepository = (*SigningKeyRepository)(nil)
+
+func NewSigningKeyRepository(db *gorm.DB, encryptionKey string) *SigningKeyRepository {
+	return &SigningKeyRepository{db: db, encryptionKey: encryptionKey}
+}
+
+func (r *SigningKeyRepository) GetValidSigningKeys() ([]entities.SigningKey, error) {
+	var keys []entities.SigningKey
+	result := r.db.Model(&entities.SigningKey{}).Where("retires_at IS NULL OR retires_at > ?", time.Now()).Order("created_at DESC").Find(&keys)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	for i, key := range keys {
+		if isPlainPrivateKey(key.PrivateKey) {
+			if err := r.encryptLegacySigningKey(key); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		privateKey, err := secretbox.Open(key.PrivateKey, r.encryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		keys[i].PrivateKey = privateKey
+	}
+	return keys, nil
+}
+
+// isPlainPrivateKey is true for the PEM keys stored before they were encrypted
+func isPlainPrivateKey(privateKey string) bool {
+	return strings.HasPrefix(privateKey, "-----BEGIN")
+}
+
+// encryptLegacySigningKey encrypts a key stored in plain text. Another instance may encrypt it at the same time,
+// only one of them updates it.
+func (r *SigningKeyRepository) encryptLegacySigningKey(key entities.SigningKey) error {
+	privateKey, err := secretbox.Seal(key.PrivateKey, r.encryptionKey)
+	if err != nil {
+		return err
+	}
+	return r.db.Model(&entities.SigningKey{}).Where("id = ? AND private_key = ?", key.ID, key.PrivateKey).Update("private_key", privateKey).Error
+}
+
+func (r *SigningKeyRepository) RotateSigningKey(currentID string, newKey entities.SigningKey, retiresAt time.Time) error {
+	privateKey, err := secretbox.Seal(newKey.PrivateKey, r.encryptionKey)
+	if err != nil {
+		return err
+	}
+	newKey.PrivateKey = privateKey
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if currentID == "" {
+			return createFirstSigningKey(tx, newKey)
+		}
+		// Conditional update, so that two instances rotating at the same time do not both insert a key
+		result := tx.Model(&entities.SigningKey{}).Where("id = ? AND retires_at IS NULL", currentID).Update("retires_at", retiresAt)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return apperrors.ErrSigningKeyAlreadyRotated
+		}
+		return tx.Create(&newKey).Error
+	})
+}
+
+// createFirstSigningKey inserts the key only if there is no active key. The instances starting on an empty table
+// insert one after the other: the table is locked until the transaction ends (SQLite serializes the writes itself).
+func createFirstSigningKey(tx *gorm.DB, newKey entities.SigningKey) error {
+	if tx.Dialector.Name() == "postgres" {
+		if err := tx.Exec("LOCK TABLE signing_keys IN SHARE ROW EXCLUSIVE MODE").Error; err != nil {
+			return err
+		}
+	}
+	var activeKeys int64
+	if err := tx.Model(&entities.SigningKey{}).Where("retires_at IS NULL").Count(&activeKeys).Error; err != nil {
+		return err
+	}
+	if activeKeys > 0 {
+		return apperrors.ErrSigningKeyAlreadyRotated
+	}
+	// The configured private key has the same ID on every instance, and may already be stored
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&newKey)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return apperrors.ErrSigningKeyAlreadyRotated
+	}
+	return nil
+}
+
+func (r *SigningKeyRepository) DeleteRetiredSigningKeys() error {
+	result := r.db.Model(&entities.SigningKey{}).Where("retires_at < ?", time.Now()).Delete(&entities.SigningKey{})
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
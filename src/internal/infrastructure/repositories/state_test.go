@@ -16,7 +16,7 @@ func TestStateRepository_GetAndDeleteState(t *testing.T) {
 		}
 		db.AutoMigrate(&entities.State{})
 		repo := NewStateRepository(db)
-		state := entities.NewState("some-value")
+		state := entities.NewState("some-value", "some-device-id")
 		if err := repo.CreateState(state); err != nil {
 			t.Fatal(err)
 		}
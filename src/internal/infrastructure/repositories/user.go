@@ -4,6 +4,8 @@ import (
 	"aegis/internal/domain/entities"
 	"aegis/internal/domain/ports/secondary"
 	"aegis/pkg/apperrors"
+	"strings"
+	"time"
 
 	"gorm.io/gorm"
 )
@@ -65,3 +67,64 @@ func (r *UserRepository) DoesNameExist(nameFingerprint string) (bool, error) {
 	}
 	return result.Error == nil, nil
 }
+
+func (r *UserRepository) ListUsers(search string, offset, limit int) ([]entities.User, int64, error) {
+	query := r.db.Model(&entities.User{})
+	if search != "" {
+		pattern := "%" + strings.ToLower(search) + "%"
+		query = query.Where("LOWER(name) LIKE ? OR LOWER(email) LIKE ?", pattern, pattern)
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var users []entities.User
+	if err := query.Preload("Roles").Order("created_at ASC, id ASC").Offset(offset).Limit(limit).Find(&users).Error; err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+func (r *UserRepository) SetUserBlockedAt(userID string, blockedAt *time.Time) error {
+	return r.updateUser(userID, "blocked_at", blockedAt)
+}
+
+func (r *UserRepository) SetUserDeletedAt(userID string, deletedAt *time.Time) error {
+	return r.updateUser(userID, "deleted_at", deletedAt)
+}
+
+func (r *UserRepository) SetUserEarlyAdopter(userID string, earlyAdopter bool) error {
+	return r.updateUser(userID, "early_adopter", earlyAdopter)
+}
+
+func (r *UserRepository) updateUser(userID, column string, value any) error {
+	result := r.db.Model(&entities.User{}).Where("id = ?", userID).Update(column, value)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return apperrors.ErrNoUser
+	}
+	return nil
+}
+
+func (r *UserRepository) AddRole(role entities.Role) error {
+	if _, err := r.GetUserByID(role.UserID); err != nil {
+		return err
+	}
+	var count int64
+	if err := r.db.Model(&entities.Role{}).Where("user_id = ? AND value = ?", role.UserID, role.Value).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	return r.db.Model(&entities.Role{}).Create(&role).Error
+}
+
+func (r *UserRepository) RemoveRole(userID, role string) error {
+	if _, err := r.GetUserByID(userID); err != nil {
+		return err
+	}
+	return r.db.Model(&entities.Role{}).Where("user_id = ? AND value = ?", userID, role).Delete(&entities.Role{}).Error
+}
@@ -0,0 +1,79 @@
+package repositories
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/internal/domain/ports/secondary"
+	"aegis/pkg/apperrors"
+
+	"gorm.io/gorm"
+)
+
+type UserIdentityRepository struct {
+	db *gorm.DB
+}
+
+var _ secondary.UserIdentityRepository = (*UserIdentityRepository)(nil)
+
+func NewUserIdentityRepository(db *gorm.DB) *UserIdentityRepository {
+	return &UserIdentityRepository{db: db}
+}
+
+func (r *UserIdentityRepository) CreateUserIdentity(identity entities.UserIdentity) error {
+	return r.db.Create(&identity).Error
+}
+
+func (r *UserIdentityRepository) GetUserIdentity(provider, providerSubjectID string) (entities.UserIdentity, error) {
+	var identity entities.UserIdentity
+	result := r.db.Where("provider = ? AND provider_subject_id = ?", provider, providerSubjectID).First(&identity)
+	if result.Error != nil && result.Error != gorm.ErrRecordNotFound {
+		return entities.UserIdentity{}, result.Error
+	}
+	if result.Error == gorm.ErrRecordNotFound {
+		return entities.UserIdentity{}, apperrors.ErrIdentityNotFound
+	}
+	return identity, nil
+}
+
+func (r *UserIdentityRepository) GetUserIdentitiesForUser(userID string) ([]entities.UserIdentity, error) {
+	var identities []entities.UserIdentity
+	if err := r.db.Where("user_id = ?", userID).Order("linked_at ASC").Find(&identities).Error; err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+func (r *UserIdentityRepository) SetUserIdentitySubject(identityID, providerSubjectID, email string) error {
+	return r.db.Model(&entities.UserIdentity{}).Where("id = ?", identityID).Updates(map[string]any{
+		"provider_subject_id": providerSubjectID,
+		"email":               email,
+	}).Error
+}
+
+func (r *UserIdentityRepository) DeleteUserIdentity(userID, provider string) error {
+	result := r.db.Where("user_id = ? AND provider = ?", userID, provider).Delete(&entities.UserIdentity{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return apperrors.ErrIdentityNotFound
+	}
+	return nil
+}
+
+// MigrateLegacyUserIdentities creates the identity of the users created before identities were introduced, from
+// their auth method. The ID of their account at the provider is stored on their next login.
+func (r *UserIdentityRepository) MigrateLegacyUserIdentities() error {
+	var users []entities.User
+	err := r.db.Where("auth_method <> '' AND NOT EXISTS (SELECT 1 FROM user_identities WHERE user_identities.user_id = users.id)").Find(&users).Error
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		identity := entities.NewUserIdentity(user.ID, user.AuthMethod, "", user.Email)
+		identity.LinkedAt = user.CreatedAt
+		if err := r.db.Create(&identity).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
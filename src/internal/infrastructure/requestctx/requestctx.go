@@ -0,0 +1,20 @@
+// Package requestctx passes the values computed by middlewares to the handlers
+package requestctx
+
+import (
+	"aegis/internal/domain/entities"
+
+	"github.com/labstack/echo/v4"
+)
+
+const deviceKey = "device"
+
+func SetDevice(c echo.Context, device entities.Device) {
+	c.Set(deviceKey, device)
+}
+
+// Device returns the device identified by the IdentifyDevice middleware, or a zero device
+func Device(c echo.Context) entities.Device {
+	device, _ := c.Get(deviceKey).(entities.Device)
+	return device
+}
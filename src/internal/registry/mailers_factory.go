@@ -0,0 +1,28 @@
+package registry
+
+import (
+	"aegis/internal/domain/entities"
+	"aegis/pkg/plugins/mailers"
+	"aegis/pkg/plugins/mailers/logfile"
+	"aegis/pkg/plugins/mailers/smtp"
+	"fmt"
+)
+
+// NewMailer returns the mailer of the config. It must be set when a login by email is enabled.
+func NewMailer(c entities.Config) (mailers.MailerInterface, error) {
+	switch c.Mailer.Type {
+	case entities.MailerTypeSMTP:
+		if c.Mailer.SMTP.Host == "" || c.Mailer.SMTP.Port == 0 || c.Mailer.From == "" {
+			return nil, fmt.Errorf("invalid mailer: smtp needs a host, a port and a from address")
+		}
+		return smtp.NewSMTPMailer(c.Mailer.SMTP.Host, c.Mailer.SMTP.Port, c.Mailer.SMTP.Username, c.Mailer.SMTP.Password, c.Mailer.From), nil
+	case entities.MailerTypeLog:
+		return logfile.NewLogFileMailer(c.Mailer.LogFile), nil
+	case "":
+		if c.Auth.Providers.Email.Enabled {
+			return nil, fmt.Errorf("invalid mailer: the email login needs a mailer, use %q or %q", entities.MailerTypeSMTP, entities.MailerTypeLog)
+		}
+		return logfile.NewLogFileMailer(""), nil
+	}
+	return nil, fmt.Errorf("invalid mailer type %q: use %q or %q", c.Mailer.Type, entities.MailerTypeSMTP, entities.MailerTypeLog)
+}
@@ -4,6 +4,7 @@ import (
 	"aegis/internal/application/use_cases"
 	"aegis/internal/domain/entities"
 	"aegis/internal/domain/ports/secondary"
+	"aegis/internal/domain/services"
 	"aegis/internal/infrastructure/handlers"
 	"aegis/internal/infrastructure/middlewares"
 	"aegis/pkg/plugins/providers"
@@ -18,11 +19,15 @@ type Provider struct {
 func NewProvider(
 	c entities.Config,
 	provider providers.OAuthProviderInterface,
+	tokenService *services.TokenService,
+	mfaService *services.MFAService,
 	userRepository secondary.UserRepository,
 	refreshTokenRepository secondary.RefreshTokenRepository,
 	stateRepository secondary.StateRepository,
+	userIdentityRepository secondary.UserIdentityRepository,
+	invitationRepository secondary.OrganizationInvitationRepository,
 ) Provider {
-	service := usecases.NewOAuthUseCases(c, provider, userRepository, refreshTokenRepository, stateRepository)
+	service := usecases.NewOAuthUseCases(c, provider, tokenService, mfaService, userRepository, refreshTokenRepository, stateRepository, userIdentityRepository, invitationRepository)
 	handlers := handlers.NewOAuthHandlers(c, service)
 	middlewares := middlewares.NewOAuthMiddlewares(c, service)
 
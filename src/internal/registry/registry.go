@@ -3,30 +3,105 @@ package registry
 import (
 	usecases "aegis/internal/application/use_cases"
 	"aegis/internal/domain/entities"
+	"aegis/internal/domain/services"
 	"aegis/internal/infrastructure/handlers"
 	"aegis/internal/infrastructure/middlewares"
 	"aegis/internal/infrastructure/repositories"
+	"aegis/pkg/jwtgen"
 	"aegis/pkg/plugins/providers/discord"
 	"aegis/pkg/plugins/providers/github"
+	"aegis/pkg/plugins/providers/oidc"
+	"errors"
 	"fmt"
+	"regexp"
+	"slices"
 
 	"gorm.io/gorm"
 )
 
 type Registry struct {
-	Handlers    handlers.HandlersInterface
-	Middlewares middlewares.AuthMiddlewareInterface
-	Providers   []Provider
+	Handlers               handlers.HandlersInterface
+	AdminHandlers          handlers.AdminHandlersInterface
+	IdentityHandlers       handlers.IdentityHandlersInterface
+	OrganizationHandlers   handlers.OrganizationHandlersInterface
+	EmailHandlers          handlers.EmailHandlersInterface
+	PasskeyHandlers        handlers.PasskeyHandlersInterface
+	MFAHandlers            handlers.MFAHandlersInterface
+	OAuthServerHandlers    handlers.OAuthServerHandlersInterface
+	Middlewares            middlewares.AuthMiddlewareInterface
+	EmailMiddlewares       middlewares.OAuthMiddlewaresInterface
+	PasskeyMiddlewares     middlewares.OAuthMiddlewaresInterface
+	OAuthServerMiddlewares middlewares.OAuthMiddlewaresInterface
+	Providers              []Provider
 }
 
 func NewRegistry(c entities.Config, db *gorm.DB) (Registry, error) {
 	userRepository := repositories.NewUserRepository(db)
-	refreshTokenRepository := repositories.NewRefreshTokenRepository(db)
+	refreshTokenRepository := repositories.NewRefreshTokenRepository(db, c.PepperFor(entities.PepperRefreshToken))
 	stateRepository := repositories.NewStateRepository(db)
+	userIdentityRepository := repositories.NewUserIdentityRepository(db)
+	organizationRepository := repositories.NewOrganizationRepository(db)
+	invitationRepository := repositories.NewOrganizationInvitationRepository(db, c.PepperFor(entities.PepperInvitation))
+	magicLinkRepository := repositories.NewMagicLinkRepository(db, c.PepperFor(entities.PepperMagicLink))
+	emailCodeRepository := repositories.NewEmailCodeRepository(db, c.PepperFor(entities.PepperEmailCode))
+	passkeyRepository := repositories.NewPasskeyRepository(db)
+	mfaRepository := repositories.NewMFARepository(db, c.PepperFor(entities.PepperPendingMFA), c.MFA.EncryptionKey)
+	recoveryCodeRepository := repositories.NewRecoveryCodeRepository(db, c.PepperFor(entities.PepperRecoveryCode))
+	signingKeyRepository := repositories.NewSigningKeyRepository(db, c.JWT.KeyEncryptionKey)
+	securityEventRepository := repositories.NewSecurityEventRepository(db)
+	authorizationCodeRepository := repositories.NewAuthorizationCodeRepository(db, c.PepperFor(entities.PepperAuthorizationCode))
+	serviceClientRepository := repositories.NewServiceClientRepository(db, c.PepperFor(entities.PepperServiceClient))
+	deviceAuthorizationRepository := repositories.NewDeviceAuthorizationRepository(db, c.PepperFor(entities.PepperDeviceAuthorization))
 
-	authService := usecases.NewService(c, refreshTokenRepository, userRepository)
+	if err := validateSessionLimitPolicy(c.Sessions.LimitPolicy); err != nil {
+		return Registry{}, err
+	}
+	if err := validateSecrets(c); err != nil {
+		return Registry{}, err
+	}
+	if err := validateRolePermissions(c); err != nil {
+		return Registry{}, err
+	}
+	if err := validateMFARoles(c); err != nil {
+		return Registry{}, err
+	}
+	if err := validateOAuthServer(c); err != nil {
+		return Registry{}, err
+	}
+
+	if err := refreshTokenRepository.HashLegacyRefreshTokens(); err != nil {
+		return Registry{}, err
+	}
+	if err := refreshTokenRepository.AssignLegacyRefreshTokenFamilies(); err != nil {
+		return Registry{}, err
+	}
+	if err := userIdentityRepository.MigrateLegacyUserIdentities(); err != nil {
+		return Registry{}, err
+	}
+
+	keyService, err := services.NewKeyService(c, signingKeyRepository)
+	if err != nil {
+		return Registry{}, err
+	}
+
+	mailer, err := NewMailer(c)
+	if err != nil {
+		return Registry{}, err
+	}
+
+	tokenService := services.NewTokenService(refreshTokenRepository, securityEventRepository, organizationRepository, keyService, c)
+	mfaService := services.NewMFAService(mfaRepository, tokenService)
+
+	authService := usecases.NewService(c, refreshTokenRepository, userRepository, keyService, tokenService)
 	authHandlers := handlers.NewHandlers(c, authService)
 	authMiddlewares := middlewares.NewAuthMiddleware(c, authService)
+	adminHandlers := handlers.NewAdminHandlers(c, usecases.NewAdminUseCases(c, userRepository, tokenService, serviceClientRepository))
+	identityHandlers := handlers.NewIdentityHandlers(c, usecases.NewIdentityUseCases(c, userIdentityRepository, tokenService))
+	organizationHandlers := handlers.NewOrganizationHandlers(c, usecases.NewOrganizationUseCases(c, organizationRepository, invitationRepository, userRepository, refreshTokenRepository, tokenService))
+	emailService := usecases.NewEmailUseCases(c, mailer, tokenService, mfaService, userRepository, userIdentityRepository, magicLinkRepository, emailCodeRepository)
+	passkeyService := usecases.NewPasskeyUseCases(c, tokenService, mfaService, userRepository, userIdentityRepository, passkeyRepository, securityEventRepository)
+	mfaUseCases := usecases.NewMFAUseCases(c, tokenService, mfaService, userRepository, userIdentityRepository, mfaRepository, recoveryCodeRepository, securityEventRepository)
+	oauthServerUseCases := usecases.NewOAuthServerUseCases(c, tokenService, keyService, userRepository, userIdentityRepository, authorizationCodeRepository, serviceClientRepository, deviceAuthorizationRepository)
 
 	providers := []Provider{
 		NewProvider(
@@ -35,23 +110,187 @@ func NewRegistry(c entities.Config, db *gorm.DB) (Registry, error) {
 				c.Auth.Providers.GitHub.ClientID,
 				c.Auth.Providers.GitHub.ClientSecret,
 				fmt.Sprintf("%s/auth/github/callback", c.App.URL)),
+			tokenService,
+			mfaService,
 			userRepository,
 			refreshTokenRepository,
-			stateRepository),
+			stateRepository,
+			userIdentityRepository,
+			invitationRepository),
 		NewProvider(
 			c, discord.NewOAuthDiscordRepository(
 				c.Auth.Providers.Discord.Enabled,
 				c.Auth.Providers.Discord.ClientID,
 				c.Auth.Providers.Discord.ClientSecret,
 				fmt.Sprintf("%s/auth/discord/callback", c.App.URL)),
+			tokenService,
+			mfaService,
+			userRepository,
+			refreshTokenRepository,
+			stateRepository,
+			userIdentityRepository,
+			invitationRepository),
+	}
+
+	for _, oidcConfig := range c.Auth.Providers.OIDC {
+		if err := validateProviderName(oidcConfig.Name, providers); err != nil {
+			return Registry{}, err
+		}
+		providers = append(providers, NewProvider(
+			c, oidc.NewOAuthOIDCRepository(
+				oidcConfig.Name,
+				oidcConfig.Enabled,
+				oidcConfig.Issuer,
+				oidcConfig.ClientID,
+				oidcConfig.ClientSecret,
+				fmt.Sprintf("%s/auth/%s/callback", c.App.URL, oidcConfig.Name),
+				oidcConfig.Scopes),
+			tokenService,
+			mfaService,
 			userRepository,
 			refreshTokenRepository,
-			stateRepository),
+			stateRepository,
+			userIdentityRepository,
+			invitationRepository))
 	}
 
 	return Registry{
-		Handlers:    authHandlers,
-		Middlewares: authMiddlewares,
-		Providers:   providers,
+		Handlers:               authHandlers,
+		AdminHandlers:          adminHandlers,
+		IdentityHandlers:       identityHandlers,
+		OrganizationHandlers:   organizationHandlers,
+		EmailHandlers:          handlers.NewEmailHandlers(c, emailService),
+		PasskeyHandlers:        handlers.NewPasskeyHandlers(c, passkeyService),
+		MFAHandlers:            handlers.NewMFAHandlers(c, mfaUseCases),
+		OAuthServerHandlers:    handlers.NewOAuthServerHandlers(c, oauthServerUseCases),
+		Middlewares:            authMiddlewares,
+		EmailMiddlewares:       middlewares.NewOAuthMiddlewares(c, emailService),
+		PasskeyMiddlewares:     middlewares.NewOAuthMiddlewares(c, passkeyService),
+		OAuthServerMiddlewares: middlewares.NewOAuthMiddlewares(c, oauthServerUseCases),
+		Providers:              providers,
 	}, nil
 }
+
+var providerNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// Provider names are used as routes under /auth, they must not shadow another route
+var reservedProviderNames = []string{"me", "refresh", "logout", "health", "login", "login-error", "authorize-access-token", ".well-known", "sessions", "admin", "identities", "organizations", "invitations", "email", "passkeys", "mfa", "recovery", "authorize", "token", "userinfo", "device"}
+
+func validateProviderName(name string, existing []Provider) error {
+	if !providerNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid oidc provider name %q: use lowercase letters, digits and dashes", name)
+	}
+	if slices.Contains(reservedProviderNames, name) {
+		return fmt.Errorf("invalid oidc provider name %q: reserved route", name)
+	}
+	for _, provider := range existing {
+		if provider.Name == name {
+			return fmt.Errorf("invalid oidc provider name %q: already used by another provider", name)
+		}
+	}
+	return nil
+}
+
+func validateSessionLimitPolicy(policy string) error {
+	switch policy {
+	case "", entities.SessionLimitPolicyReject, entities.SessionLimitPolicyEvictOldest, entities.SessionLimitPolicyEvictLRU:
+		return nil
+	}
+	return fmt.Errorf("invalid sessions limit_policy %q: use %q, %q or %q", policy, entities.SessionLimitPolicyReject, entities.SessionLimitPolicyEvictOldest, entities.SessionLimitPolicyEvictLRU)
+}
+
+// Minimum length of the secret keys, a shorter key could be guessed from the hashes of the database
+const minSecretLength = 16
+
+// validateSecrets rejects an empty or short pepper: it keys the hashes of the tokens and the codes, and is the default
+// device cookie secret and MFA encryption key. The keys that override it are checked when they are set.
+func validateSecrets(c entities.Config) error {
+	if len(c.Sessions.RefreshTokenPepper) < minSecretLength {
+		return fmt.Errorf("invalid sessions refresh_token_pepper: use a random secret of at least %d characters", minSecretLength)
+	}
+	if c.Sessions.DeviceCookieSecret != "" && len(c.Sessions.DeviceCookieSecret) < minSecretLength {
+		return fmt.Errorf("invalid sessions device_cookie_secret: use a random secret of at least %d characters", minSecretLength)
+	}
+	if len(c.MFA.EncryptionKey) < minSecretLength {
+		return fmt.Errorf("invalid mfa encryption_key: use a random secret of at least %d characters", minSecretLength)
+	}
+	if c.MFA.EncryptionKey == c.Sessions.RefreshTokenPepper {
+		return errors.New("invalid mfa encryption_key: use a secret distinct from sessions refresh_token_pepper")
+	}
+	if c.JWT.KeyStorage == services.KeyStorageDatabase {
+		if len(c.JWT.KeyEncryptionKey) < minSecretLength {
+			return fmt.Errorf("invalid jwt key_encryption_key: use a random secret of at least %d characters", minSecretLength)
+		}
+		if c.JWT.KeyEncryptionKey == c.Sessions.RefreshTokenPepper {
+			return errors.New("invalid jwt key_encryption_key: use a secret distinct from sessions refresh_token_pepper")
+		}
+	}
+	return nil
+}
+
+// validateRolePermissions rejects permissions given to unknown roles, and inheritance cycles
+func validateRolePermissions(c entities.Config) error {
+	for role, rolePermissions := range c.User.Permissions {
+		if !slices.Contains(c.User.Roles, role) {
+			return fmt.Errorf("invalid user permissions: unknown role %q", role)
+		}
+		for _, inherited := range rolePermissions.Inherits {
+			if !slices.Contains(c.User.Roles, inherited) {
+				return fmt.Errorf("invalid user permissions: role %q inherits from unknown role %q", role, inherited)
+			}
+		}
+	}
+	var inheritsFrom func(role, ancestor string, path []string) bool
+	inheritsFrom = func(role, ancestor string, path []string) bool {
+		for _, inherited := range c.User.Permissions[role].Inherits {
+			if inherited == ancestor {
+				return true
+			}
+			if !slices.Contains(path, inherited) && inheritsFrom(inherited, ancestor, append(path, inherited)) {
+				return true
+			}
+		}
+		return false
+	}
+	for role := range c.User.Permissions {
+		if inheritsFrom(role, role, []string{role}) {
+			return fmt.Errorf("invalid user permissions: role %q inherits from itself", role)
+		}
+	}
+	return nil
+}
+
+// validateMFARoles rejects unknown roles, a typo would leave the role without a second factor
+func validateMFARoles(c entities.Config) error {
+	for _, role := range c.MFA.RequiredForRoles {
+		if !slices.Contains(c.User.Roles, role) {
+			return fmt.Errorf("invalid mfa required_for_roles: unknown role %q", role)
+		}
+	}
+	return nil
+}
+
+// validateOAuthServer rejects the clients that could not log in, and the symmetric algorithm: the clients could not
+// verify the ID tokens without the secret that signs the sessions
+func validateOAuthServer(c entities.Config) error {
+	if !c.OAuthServer.Enabled {
+		return nil
+	}
+	if c.JWT.Algorithm == "" || c.JWT.Algorithm == jwtgen.AlgorithmHS256 {
+		return errors.New("invalid oauth_server: requires an asymmetric jwt algorithm")
+	}
+	clientIDs := []string{}
+	for _, client := range c.OAuthServer.Clients {
+		if client.ClientID == "" {
+			return errors.New("invalid oauth_server client: client_id is required")
+		}
+		if slices.Contains(clientIDs, client.ClientID) {
+			return fmt.Errorf("invalid oauth_server client %q: client_id already used", client.ClientID)
+		}
+		if len(client.RedirectURIs) == 0 && !client.DeviceFlow {
+			return fmt.Errorf("invalid oauth_server client %q: redirect_uris is required", client.ClientID)
+		}
+		clientIDs = append(clientIDs, client.ClientID)
+	}
+	return nil
+}
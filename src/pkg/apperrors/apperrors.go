@@ -12,11 +12,20 @@ var (
 	ErrRefreshTokenInvalid  = errors.New("refresh_token_invalid")
 	ErrRefreshTokenExpired  = errors.New("refresh_token_expired")
 	ErrTooManyRefreshTokens = errors.New("too_many_refresh_tokens")
+	ErrRefreshTokenReused   = errors.New("refresh_token_reused")
+	ErrSessionNotFound      = errors.New("session_not_found")
 )
 
 var (
-	ErrNoRoles          = errors.New("no_roles")
-	ErrUnauthorizedRole = errors.New("unauthorized_role")
+	ErrSigningKeyAlreadyRotated = errors.New("signing_key_already_rotated")
+)
+
+var (
+	ErrNoRoles           = errors.New("no_roles")
+	ErrInvalidRoleMatch  = errors.New("invalid_role_match")
+	ErrUnauthorizedRole  = errors.New("unauthorized_role")
+	ErrMissingPermission = errors.New("missing_permission")
+	ErrUnknownRole       = errors.New("unknown_role")
 )
 
 var (
@@ -27,9 +36,72 @@ var (
 	ErrNameAlreadyExists    = errors.New("name_already_exists")
 	ErrNoName               = errors.New("no_name")
 	ErrNoEmail              = errors.New("no_email")
+	ErrEmailNotVerified     = errors.New("email_not_verified")
 	ErrWrongAuthMethod      = errors.New("wrong_auth_method")
 	ErrAuthMethodNotEnabled = errors.New("auth_method_not_enabled")
+	ErrProviderUnavailable  = errors.New("provider_unavailable")
 	ErrInvalidState         = errors.New("invalid_state")
+	ErrInvalidEmail         = errors.New("invalid_email")
+	ErrMagicLinkInvalid     = errors.New("magic_link_invalid")
+	ErrTooManyMagicLinks    = errors.New("too_many_magic_links")
+)
+
+var (
+	ErrEmailCodeInvalid         = errors.New("email_code_invalid")
+	ErrTooManyEmailCodes        = errors.New("too_many_email_codes")
+	ErrTooManyEmailCodeAttempts = errors.New("too_many_email_code_attempts")
+)
+
+var (
+	ErrPasskeyInvalid           = errors.New("passkey_invalid")
+	ErrPasskeyNotFound          = errors.New("passkey_not_found")
+	ErrPasskeyAlreadyRegistered = errors.New("passkey_already_registered")
+)
+
+var (
+	ErrTOTPAlreadyEnabled = errors.New("totp_already_enabled")
+	ErrTOTPNotEnabled     = errors.New("totp_not_enabled")
+	ErrMFACodeInvalid     = errors.New("mfa_code_invalid")
+	ErrMFATokenInvalid    = errors.New("mfa_token_invalid")
+	ErrTooManyMFAAttempts = errors.New("too_many_mfa_attempts")
+)
+
+// Errors of the authorization server, named after the error codes of OAuth 2.0 and OpenID Connect
+var (
+	ErrOAuthInvalidRequest          = errors.New("invalid_request")
+	ErrOAuthInvalidClient           = errors.New("invalid_client")
+	ErrOAuthInvalidRedirectURI      = errors.New("invalid_redirect_uri")
+	ErrOAuthInvalidGrant            = errors.New("invalid_grant")
+	ErrOAuthInvalidScope            = errors.New("invalid_scope")
+	ErrOAuthUnsupportedGrantType    = errors.New("unsupported_grant_type")
+	ErrOAuthUnsupportedResponseType = errors.New("unsupported_response_type")
+	ErrOAuthLoginRequired           = errors.New("login_required")
+	ErrOAuthAccessDenied            = errors.New("access_denied")
+	ErrOAuthUnauthorizedClient      = errors.New("unauthorized_client")
+	ErrOAuthAuthorizationPending    = errors.New("authorization_pending")
+	ErrOAuthSlowDown                = errors.New("slow_down")
+	ErrOAuthExpiredToken            = errors.New("expired_token")
+	ErrUserCodeInvalid              = errors.New("user_code_invalid")
+	ErrServiceClientNotFound        = errors.New("service_client_not_found")
+	ErrServiceClientNameRequired    = errors.New("service_client_name_required")
+)
+
+var (
+	ErrIdentityNotFound      = errors.New("identity_not_found")
+	ErrIdentityAlreadyLinked = errors.New("identity_already_linked")
+	ErrLastIdentity          = errors.New("last_identity")
+)
+
+var (
+	ErrNoOrganizationName      = errors.New("no_organization_name")
+	ErrNotOrganizationMember   = errors.New("not_organization_member")
+	ErrOrganizationForbidden   = errors.New("organization_forbidden")
+	ErrUnknownOrganizationRole = errors.New("unknown_organization_role")
+	ErrLastOrganizationOwner   = errors.New("last_organization_owner")
+	ErrInvalidInvitationEmail  = errors.New("invalid_invitation_email")
+	ErrInvitationNotFound      = errors.New("invitation_not_found")
+	ErrInvitationInvalid       = errors.New("invitation_invalid")
+	ErrInvitationEmailMismatch = errors.New("invitation_email_mismatch")
 )
 
 var (
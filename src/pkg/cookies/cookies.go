@@ -1,9 +1,13 @@
 package cookies
 
 import (
+	"crypto/hmac"
 	"net/http"
+	"strings"
 	"time"
 
+	"aegis/pkg/tokengen"
+
 	"aegis/internal/domain/entities"
 )
 
@@ -25,6 +29,58 @@ func NewRefreshCookieZero(config entities.Config) http.Cookie {
 	return newCookie("refresh_token", "", 0, config)
 }
 
+// NewMFACookie holds the login that waits for the second factor of the user, until they enter the code.
+// It is sent on the redirect back from the OAuth provider whatever the configured SameSite mode.
+func NewMFACookie(token string, expiresAt int64, config entities.Config) http.Cookie {
+	cookie := newCookie("mfa_token", token, expiresAt, config)
+	cookie.HttpOnly = true
+	cookie.SameSite = http.SameSiteLaxMode
+	return cookie
+}
+
+func NewMFACookieZero(config entities.Config) http.Cookie {
+	return NewMFACookie("", 0, config)
+}
+
+// NewOAuthRequestCookie holds the page of the OAuth server the user was on (the authorization request of a client, or
+// the approval of a device) while they log in, to resume it after.
+// It is sent on the redirect back from the OAuth provider whatever the configured SameSite mode.
+func NewOAuthRequestCookie(value string, config entities.Config) http.Cookie {
+	cookie := newCookie("oauth_request", value, time.Now().Add(10*time.Minute).Unix(), config)
+	cookie.HttpOnly = true
+	cookie.SameSite = http.SameSiteLaxMode
+	return cookie
+}
+
+func NewOAuthRequestCookieZero(config entities.Config) http.Cookie {
+	cookie := NewOAuthRequestCookie("", config)
+	cookie.Expires = time.Unix(0, 0)
+	return cookie
+}
+
+// NewDeviceCookie identifies the browser for a year. It is sent on the redirect back from the OAuth provider
+// whatever the configured SameSite mode, since it is not a credential.
+func NewDeviceCookie(value string, config entities.Config) http.Cookie {
+	cookie := newCookie("device_id", value, time.Now().AddDate(1, 0, 0).Unix(), config)
+	cookie.HttpOnly = true
+	cookie.SameSite = http.SameSiteLaxMode
+	return cookie
+}
+
+// SignDeviceID returns the value of the device cookie, so that clients cannot choose their device ID
+func SignDeviceID(deviceID, secret string) string {
+	return deviceID + "." + tokengen.Hash(deviceID, secret)
+}
+
+// VerifyDeviceID returns the device ID of a device cookie value, empty if the signature is invalid
+func VerifyDeviceID(value, secret string) string {
+	deviceID, _, found := strings.Cut(value, ".")
+	if !found || !hmac.Equal([]byte(SignDeviceID(deviceID, secret)), []byte(value)) {
+		return ""
+	}
+	return deviceID
+}
+
 func newCookie(name, token string, expiresAt int64, config entities.Config) http.Cookie {
 	cookie := http.Cookie{
 		Name:     name,
@@ -32,4 +32,20 @@ func TestNewCookie(t *testing.T) {
 			t.Errorf("expected cookie to be non-zero")
 		}
 	})
+
+	t.Run("device cookie value is signed", func(t *testing.T) {
+		value := SignDeviceID("device_123", "secret")
+		if VerifyDeviceID(value, "secret") != "device_123" {
+			t.Errorf("expected the device id to be verified, got %s", VerifyDeviceID(value, "secret"))
+		}
+		if VerifyDeviceID(value, "other-secret") != "" {
+			t.Errorf("expected a value signed with another secret to be rejected")
+		}
+		if VerifyDeviceID("device_456"+value[len("device_123"):], "secret") != "" {
+			t.Errorf("expected a tampered device id to be rejected")
+		}
+		if VerifyDeviceID("device_123", "secret") != "" {
+			t.Errorf("expected an unsigned value to be rejected")
+		}
+	})
 }
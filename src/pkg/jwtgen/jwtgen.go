@@ -14,7 +14,21 @@ func Generate(
 	appName string,
 	secret string,
 ) (accessToken string, expiresAt int64, err error) {
-	token := jwt.New(jwt.SigningMethodHS256)
+	return GenerateWithKey(cClaims, issuedAt, accessTokenExpirationMin, appName, NewHMACKey("", secret))
+}
+
+// GenerateWithKey signs the token with the key, and sets the "kid" header if the key has an ID
+func GenerateWithKey(
+	cClaims map[string]any,
+	issuedAt time.Time,
+	accessTokenExpirationMin int,
+	appName string,
+	key Key,
+) (accessToken string, expiresAt int64, err error) {
+	token := jwt.New(key.signingMethod())
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
 	secondsOfValidity := accessTokenExpirationMin * 60
 	expiresAt = issuedAt.Add(time.Second * time.Duration(secondsOfValidity)).Unix()
 	claims := token.Claims.(jwt.MapClaims)
@@ -25,7 +39,7 @@ func Generate(
 	for key, value := range cClaims {
 		claims[key] = value
 	}
-	tokenString, err := token.SignedString([]byte(secret))
+	tokenString, err := token.SignedString(key.signingKey())
 	if err != nil {
 		return "", -1, err
 	}
@@ -33,11 +47,35 @@ func Generate(
 }
 
 func ReadClaims(accessToken string, secret string) (map[string]any, error) {
+	return ReadClaimsWithKeys(accessToken, []Key{NewHMACKey("", secret)})
+}
+
+// KeyID returns the "kid" header of the token without verifying it, empty if the token has none or is malformed
+func KeyID(accessToken string) string {
+	token, _, err := new(jwt.Parser).ParseUnverified(accessToken, jwt.MapClaims{})
+	if err != nil {
+		return ""
+	}
+	kid, _ := token.Header["kid"].(string)
+	return kid
+}
+
+// ReadClaimsWithKeys verifies the token with the key matching its "kid" header.
+// Tokens without a "kid" header can only be verified by keys without an ID.
+func ReadClaimsWithKeys(accessToken string, keys []Key) (map[string]any, error) {
 	parsedToken, err := jwt.Parse(accessToken, func(token *jwt.Token) (any, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, apperrors.ErrAccessTokenInvalid
+		kid, _ := token.Header["kid"].(string)
+		for _, key := range keys {
+			if key.ID != kid {
+				continue
+			}
+			// The algorithm is pinned by the key, never by the token
+			if token.Method.Alg() != key.Algorithm {
+				return nil, apperrors.ErrAccessTokenInvalid
+			}
+			return key.verificationKey(), nil
 		}
-		return []byte(secret), nil
+		return nil, apperrors.ErrAccessTokenInvalid
 	})
 	if err != nil {
 		if validationError, ok := err.(*jwt.ValidationError); ok {
@@ -0,0 +1,243 @@
+package jwtgen
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"aegis/pkg/jwks"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmES256 = "ES256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// Key is a JWT signing key. HMAC keys sign and verify with the same secret,
+// asymmetric keys sign with the private key and can be verified by anyone with the public key.
+type Key struct {
+	ID        string
+	Algorithm string
+	secret    []byte
+	private   crypto.Signer
+	public    crypto.PublicKey
+}
+
+func NewHMACKey(id, secret string) Key {
+	return Key{
+		ID:        id,
+		Algorithm: AlgorithmHS256,
+		secret:    []byte(secret),
+	}
+}
+
+// NewPublicKey returns a key that can only verify tokens
+func NewPublicKey(id, algorithm string, public crypto.PublicKey) (Key, error) {
+	key := Key{ID: id, Algorithm: algorithm, public: public}
+	if err := key.checkAlgorithm(); err != nil {
+		return Key{}, err
+	}
+	return key, nil
+}
+
+// ParsePrivateKey reads a PEM encoded private key (PKCS#8, PKCS#1 or SEC1).
+// If id is empty, the RFC 7638 thumbprint of the public key is used.
+func ParsePrivateKey(id, algorithm, pemData string) (Key, error) {
+	block, _ := pem.Decode([]byte(pemData))
+	if block == nil {
+		return Key{}, errors.New("jwtgen: no PEM block found in private key")
+	}
+	var parsed any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return Key{}, fmt.Errorf("jwtgen: failed to parse private key: %w", err)
+	}
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return Key{}, errors.New("jwtgen: unsupported private key type")
+	}
+	return newPrivateKey(id, algorithm, signer)
+}
+
+// GenerateKey creates a new random key for the algorithm and returns it with its PEM encoding (PKCS#8)
+func GenerateKey(id, algorithm string) (Key, string, error) {
+	var signer crypto.Signer
+	var err error
+	switch algorithm {
+	case AlgorithmRS256:
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgorithmES256:
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgorithmEdDSA:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return Key{}, "", fmt.Errorf("jwtgen: cannot generate a key for algorithm %q", algorithm)
+	}
+	if err != nil {
+		return Key{}, "", err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return Key{}, "", err
+	}
+	key, err := newPrivateKey(id, algorithm, signer)
+	if err != nil {
+		return Key{}, "", err
+	}
+	return key, string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+func newPrivateKey(id, algorithm string, signer crypto.Signer) (Key, error) {
+	key := Key{ID: id, Algorithm: algorithm, private: signer, public: signer.Public()}
+	if err := key.checkAlgorithm(); err != nil {
+		return Key{}, err
+	}
+	if key.ID == "" {
+		jwk, _ := key.PublicJWK()
+		thumbprint, err := thumbprint(jwk)
+		if err != nil {
+			return Key{}, err
+		}
+		key.ID = thumbprint
+	}
+	return key, nil
+}
+
+func (k Key) checkAlgorithm() error {
+	switch k.Algorithm {
+	case AlgorithmRS256:
+		if _, ok := k.public.(*rsa.PublicKey); ok {
+			return nil
+		}
+	case AlgorithmES256:
+		if public, ok := k.public.(*ecdsa.PublicKey); ok && public.Curve == elliptic.P256() {
+			return nil
+		}
+	case AlgorithmEdDSA:
+		if _, ok := k.public.(ed25519.PublicKey); ok {
+			return nil
+		}
+	default:
+		return fmt.Errorf("jwtgen: unsupported asymmetric algorithm %q", k.Algorithm)
+	}
+	return fmt.Errorf("jwtgen: key type does not match algorithm %q", k.Algorithm)
+}
+
+// IsAsymmetric is true when the public part of the key can be published
+func (k Key) IsAsymmetric() bool {
+	return k.public != nil
+}
+
+// CanSign is false for keys that were only loaded to verify tokens
+func (k Key) CanSign() bool {
+	return len(k.secret) > 0 || k.private != nil
+}
+
+func (k Key) signingMethod() jwt.SigningMethod {
+	switch k.Algorithm {
+	case AlgorithmRS256:
+		return jwt.SigningMethodRS256
+	case AlgorithmES256:
+		return jwt.SigningMethodES256
+	case AlgorithmEdDSA:
+		return jwt.SigningMethodEdDSA
+	default:
+		return jwt.SigningMethodHS256
+	}
+}
+
+func (k Key) signingKey() any {
+	if k.private != nil {
+		return k.private
+	}
+	return k.secret
+}
+
+func (k Key) verificationKey() any {
+	if k.public != nil {
+		return k.public
+	}
+	return k.secret
+}
+
+// PublicJWK returns the public part of the key as a JWK, false for HMAC keys
+func (k Key) PublicJWK() (jwks.Key, bool) {
+	switch public := k.public.(type) {
+	case *rsa.PublicKey:
+		return jwks.Key{
+			Kty: "RSA",
+			Kid: k.ID,
+			Use: "sig",
+			Alg: k.Algorithm,
+			N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		}, true
+	case *ecdsa.PublicKey:
+		size := (public.Curve.Params().BitSize + 7) / 8
+		return jwks.Key{
+			Kty: "EC",
+			Kid: k.ID,
+			Use: "sig",
+			Alg: k.Algorithm,
+			Crv: public.Curve.Params().Name,
+			X:   base64.RawURLEncoding.EncodeToString(public.X.FillBytes(make([]byte, size))),
+			Y:   base64.RawURLEncoding.EncodeToString(public.Y.FillBytes(make([]byte, size))),
+		}, true
+	case ed25519.PublicKey:
+		return jwks.Key{
+			Kty: "OKP",
+			Kid: k.ID,
+			Use: "sig",
+			Alg: k.Algorithm,
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(public),
+		}, true
+	default:
+		return jwks.Key{}, false
+	}
+}
+
+// thumbprint is the RFC 7638 JWK thumbprint (required members, lexicographic order)
+func thumbprint(jwk jwks.Key) (string, error) {
+	var members map[string]string
+	switch jwk.Kty {
+	case "RSA":
+		members = map[string]string{"e": jwk.E, "kty": jwk.Kty, "n": jwk.N}
+	case "EC":
+		members = map[string]string{"crv": jwk.Crv, "kty": jwk.Kty, "x": jwk.X, "y": jwk.Y}
+	case "OKP":
+		members = map[string]string{"crv": jwk.Crv, "kty": jwk.Kty, "x": jwk.X}
+	default:
+		return "", fmt.Errorf("jwtgen: cannot compute thumbprint of key type %q", jwk.Kty)
+	}
+	// encoding/json sorts map keys
+	raw, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(raw)
+	return base64.RawURLEncoding.EncodeToString(hash[:]), nil
+}
@@ -0,0 +1,140 @@
+package jwtgen
+
+import (
+	"aegis/pkg/apperrors"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeys(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmRS256, AlgorithmES256, AlgorithmEdDSA} {
+		t.Run(algorithm+" should sign and verify a token", func(t *testing.T) {
+			key, _, err := GenerateKey("key-1", algorithm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			token, _, err := GenerateWithKey(map[string]any{"user_id": "123"}, time.Now(), 15, "app_name", key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			header := decodeHeader(t, token)
+			if header["alg"] != algorithm {
+				t.Fatal("expected alg header to be", algorithm, header["alg"])
+			}
+			if header["kid"] != "key-1" {
+				t.Fatal("expected kid header to be key-1", header["kid"])
+			}
+			claims, err := ReadClaimsWithKeys(token, []Key{key})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims["user_id"] != "123" {
+				t.Fatal("expected user_id to be 123", claims["user_id"])
+			}
+		})
+		t.Run(algorithm+" should verify a token with the public key only", func(t *testing.T) {
+			key, _, err := GenerateKey("key-1", algorithm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			token, _, err := GenerateWithKey(map[string]any{"user_id": "123"}, time.Now(), 15, "app_name", key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			jwk, ok := key.PublicJWK()
+			if !ok {
+				t.Fatal("expected a public jwk")
+			}
+			public, err := jwk.PublicKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			publicKey, err := NewPublicKey(jwk.Kid, jwk.Alg, public)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if publicKey.CanSign() {
+				t.Fatal("expected a public key to not be able to sign")
+			}
+			if _, err := ReadClaimsWithKeys(token, []Key{publicKey}); err != nil {
+				t.Fatal(err)
+			}
+		})
+		t.Run(algorithm+" should round-trip through PEM", func(t *testing.T) {
+			key, pemData, err := GenerateKey("", algorithm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := ParsePrivateKey("", algorithm, pemData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed.ID == "" || parsed.ID != key.ID {
+				t.Fatal("expected the thumbprint to be used as the key id", parsed.ID, key.ID)
+			}
+		})
+	}
+	t.Run("should reject a token signed by an unknown kid", func(t *testing.T) {
+		key1, _, err := GenerateKey("key-1", AlgorithmES256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key2, _, err := GenerateKey("key-2", AlgorithmES256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, _, err := GenerateWithKey(map[string]any{"user_id": "123"}, time.Now(), 15, "app_name", key1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ReadClaimsWithKeys(token, []Key{key2})
+		if err == nil || err.Error() != apperrors.ErrAccessTokenInvalid.Error() {
+			t.Fatal("expected error to be 'access_token_invalid'", err)
+		}
+	})
+	t.Run("should reject an HMAC token signed with the public key as secret", func(t *testing.T) {
+		key, _, err := GenerateKey("key-1", AlgorithmRS256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jwk, _ := key.PublicJWK()
+		forged, _, err := GenerateWithKey(map[string]any{"user_id": "123"}, time.Now(), 15, "app_name", NewHMACKey("key-1", jwk.N))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ReadClaimsWithKeys(forged, []Key{key})
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+	t.Run("should reject a key that does not match the algorithm", func(t *testing.T) {
+		_, pemData, err := GenerateKey("", AlgorithmRS256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ParsePrivateKey("", AlgorithmES256, pemData)
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+	t.Run("HMAC keys have no public jwk", func(t *testing.T) {
+		if _, ok := NewHMACKey("", "secret").PublicJWK(); ok {
+			t.Fatal("expected no public jwk")
+		}
+	})
+}
+
+func decodeHeader(t *testing.T, token string) map[string]any {
+	raw, err := base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := map[string]any{}
+	if err := json.Unmarshal(raw, &header); err != nil {
+		t.Fatal(err)
+	}
+	return header
+}
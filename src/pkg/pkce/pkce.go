@@ -0,0 +1,34 @@
+// Package pkce verifies the code challenges of OAuth clients (RFC 7636), with the S256 method only
+package pkce
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"regexp"
+)
+
+const MethodS256 = "S256"
+
+// A verifier is 43 to 128 characters among the unreserved ones of URLs
+var verifierRegexp = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+// Challenge returns the S256 challenge of a verifier
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// IsValidChallenge tells whether a challenge can be the S256 challenge of a verifier
+func IsValidChallenge(challenge string) bool {
+	decoded, err := base64.RawURLEncoding.DecodeString(challenge)
+	return err == nil && len(decoded) == sha256.Size
+}
+
+// Verify tells whether the verifier sent with the code matches the challenge sent with the authorization request
+func Verify(verifier, challenge string) bool {
+	if !verifierRegexp.MatchString(verifier) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(Challenge(verifier)), []byte(challenge)) == 1
+}
@@ -0,0 +1,32 @@
+package pkce
+
+import "testing"
+
+func TestVerify(t *testing.T) {
+	// Example of RFC 7636, appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	t.Run("should match the example of RFC 7636", func(t *testing.T) {
+		if value := Challenge(verifier); value != challenge {
+			t.Fatal("expected the challenge of the example", value)
+		}
+		if !Verify(verifier, challenge) || !IsValidChallenge(challenge) {
+			t.Fatal("expected the verifier to match the challenge")
+		}
+	})
+	t.Run("should reject another verifier", func(t *testing.T) {
+		if Verify(verifier[:len(verifier)-1]+"x", challenge) {
+			t.Fatal("expected another verifier to be rejected")
+		}
+	})
+	t.Run("should reject a verifier that is too short, even if it matches", func(t *testing.T) {
+		if Verify("short", Challenge("short")) {
+			t.Fatal("expected a short verifier to be rejected")
+		}
+	})
+	t.Run("should reject a challenge that is not a hash", func(t *testing.T) {
+		if IsValidChallenge("short") || IsValidChallenge("not base64url!") {
+			t.Fatal("expected the challenge to be rejected")
+		}
+	})
+}
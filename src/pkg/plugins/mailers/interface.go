@@ -0,0 +1,12 @@
+package mailers
+
+type MailerInterface interface {
+	Send(message Message) error
+}
+
+type Message struct {
+	To      string
+	Subject string
+	// Plain text body
+	Body string
+}
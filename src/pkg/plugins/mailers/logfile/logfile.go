@@ -0,0 +1,40 @@
+package logfile
+
+import (
+	"aegis/pkg/plugins/mailers"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// LogFileMailer writes the emails to a file, or to the logs if no file is set, instead of sending them.
+// It is meant for development and tests.
+type LogFileMailer struct {
+	Path string
+	mu   sync.Mutex
+}
+
+var _ mailers.MailerInterface = (*LogFileMailer)(nil)
+
+func NewLogFileMailer(path string) *LogFileMailer {
+	return &LogFileMailer{Path: path}
+}
+
+func (m *LogFileMailer) Send(message mailers.Message) error {
+	entry := fmt.Sprintf("To: %s\nSubject: %s\n\n%s\n", message.To, message.Subject, message.Body)
+	if m.Path == "" {
+		log.Printf("email not sent (log mailer)\n%s", entry)
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	file, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = fmt.Fprintf(file, "--- %s\n%s", time.Now().UTC().Format(time.RFC3339), entry)
+	return err
+}
@@ -0,0 +1,29 @@
+package logfile
+
+import (
+	"aegis/pkg/plugins/mailers"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogFileMailer(t *testing.T) {
+	t.Run("appends the emails to the file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "emails.log")
+		mailer := NewLogFileMailer(path)
+		if err := mailer.Send(mailers.Message{To: "first@example.com", Subject: "First", Body: "Hello"}); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if err := mailer.Send(mailers.Message{To: "second@example.com", Subject: "Second", Body: "Hello again"}); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if !strings.Contains(string(content), "To: first@example.com\nSubject: First\n\nHello\n") || !strings.Contains(string(content), "To: second@example.com") {
+			t.Fatal("expected both emails in the file", string(content))
+		}
+	})
+}
@@ -148,9 +148,11 @@ func (p OAuthDiscordRepository) ExchangeCodeForUserInfos(code, state string) (*p
 	}
 
 	result := &providers.UserInfos{
-		Name:   displayName,
-		Email:  user.Email,
-		Avatar: avatarURL,
+		SubjectID:     user.ID,
+		Name:          displayName,
+		Email:         user.Email,
+		EmailVerified: user.Verified,
+		Avatar:        avatarURL,
 	}
 
 	return result, nil
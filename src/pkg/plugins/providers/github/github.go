@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 )
 
 type OAuthGithubRepository providers.OAuthRepository
@@ -29,6 +30,7 @@ type gitHubTokenResponse struct {
 }
 
 type gitHubUser struct {
+	ID        int64  `json:"id"`
 	Login     string `json:"login"`
 	Name      string `json:"name"`
 	Email     string `json:"email"`
@@ -112,21 +114,30 @@ func (p OAuthGithubRepository) ExchangeCodeForUserInfos(code, state string) (*pr
 		return nil, fmt.Errorf("failed to decode user emails: %w", err)
 	}
 	var em string
+	var verified bool
 	for _, email := range emails {
 		if email.Primary && email.Verified {
 			em = email.Email
+			verified = true
 		}
 	}
 
 	if em == "" && user.Email != "" {
 		em = user.Email
+		for _, email := range emails {
+			if email.Email == em {
+				verified = email.Verified
+			}
+		}
 	}
 
 	userName := user.Name
 
 	return &providers.UserInfos{
-		Name:   userName,
-		Email:  em,
-		Avatar: user.AvatarURL,
+		SubjectID:     strconv.FormatInt(user.ID, 10),
+		Name:          userName,
+		Email:         em,
+		EmailVerified: verified,
+		Avatar:        user.AvatarURL,
 	}, nil
 }
@@ -24,7 +24,11 @@ type OAuthRepository struct {
 }
 
 type UserInfos struct {
-	Name   string
-	Email  string
-	Avatar string
+	// Stable ID of the account at the provider, unlike the email
+	SubjectID string
+	Name      string
+	Email     string
+	// Whether the provider checked that the user owns the email
+	EmailVerified bool
+	Avatar        string
 }
@@ -0,0 +1,379 @@
+package oidc
+
+import (
+	"aegis/pkg/jwks"
+	"aegis/pkg/plugins/providers"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// OAuthOIDCRepository is a provider for any OpenID Connect issuer (Keycloak, Authentik, Zitadel...).
+// Endpoints and signing keys are discovered from {issuer}/.well-known/openid-configuration.
+type OAuthOIDCRepository struct {
+	providers.OAuthRepository
+	Issuer string
+	Scopes []string
+
+	client *http.Client
+
+	mu            sync.Mutex
+	discovery     *discoveryDocument
+	keys          jwks.Set
+	keysFetchedAt time.Time
+}
+
+var _ providers.OAuthProviderInterface = (*OAuthOIDCRepository)(nil)
+
+// keys are refetched at most once per minute when an unknown kid shows up
+const keysRefreshInterval = time.Minute
+
+func NewOAuthOIDCRepository(name string, enabled bool, issuer, clientID, clientSecret, redirectURL string, scopes []string) *OAuthOIDCRepository {
+	return &OAuthOIDCRepository{
+		OAuthRepository: providers.OAuthRepository{
+			Name:         name,
+			Enabled:      enabled,
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+		},
+		Issuer: strings.TrimSuffix(issuer, "/"),
+		Scopes: scopes,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type discoveryDocument struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JwksURI                           string   `json:"jwks_uri"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+type oidcTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+}
+
+type oidcClaims struct {
+	Subject           string `json:"sub"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+	GivenName         string `json:"given_name"`
+	FamilyName        string `json:"family_name"`
+	Email             string `json:"email"`
+	EmailVerified     *bool  `json:"email_verified"`
+	Picture           string `json:"picture"`
+}
+
+func (p *OAuthOIDCRepository) IsEnabled() bool {
+	return p.Enabled
+}
+
+func (p *OAuthOIDCRepository) GetName() string {
+	return p.Name
+}
+
+// GetOauthRedirectURL returns an empty string if the issuer cannot be discovered
+func (p *OAuthOIDCRepository) GetOauthRedirectURL(state string) string {
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		log.Println("failed to discover the oidc issuer of "+p.Name+":", err)
+		return ""
+	}
+
+	scopes := []string{"openid"}
+	for _, scope := range p.Scopes {
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	params := url.Values{}
+	params.Set("client_id", p.ClientID)
+	params.Set("redirect_uri", p.RedirectURL)
+	params.Set("response_type", "code")
+	params.Set("scope", strings.Join(scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonceFromState(state))
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + params.Encode()
+}
+
+func (p *OAuthOIDCRepository) ExchangeCodeForUserInfos(code, state string) (*providers.UserInfos, error) {
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+
+	// Step 1: exchange the code
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	useBasicAuth := len(discovery.TokenEndpointAuthMethodsSupported) == 0 ||
+		slices.Contains(discovery.TokenEndpointAuthMethodsSupported, "client_secret_basic")
+	if !useBasicAuth {
+		form.Set("client_id", p.ClientID)
+		form.Set("client_secret", p.ClientSecret)
+	}
+	req1, err := http.NewRequest("POST", discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token request: %w", err)
+	}
+	req1.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req1.Header.Set("Accept", "application/json")
+	if useBasicAuth {
+		req1.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+	resp1, err := p.client.Do(req1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access token: %w", err)
+	}
+	defer resp1.Body.Close()
+	if resp1.StatusCode != http.StatusOK {
+		var errorBody bytes.Buffer
+		errorBody.ReadFrom(resp1.Body)
+		return nil, fmt.Errorf("oidc token exchange failed with status %d: %s", resp1.StatusCode, errorBody.String())
+	}
+	var tokenResponse oidcTokenResponse
+	if err := json.NewDecoder(resp1.Body).Decode(&tokenResponse); err != nil {
+		return nil, fmt.Errorf("failed to decode token response: %w", err)
+	}
+	if tokenResponse.IDToken == "" {
+		return nil, errors.New("no id_token received from the issuer")
+	}
+
+	// Step 2: verify the id_token
+	claims, err := p.verifyIDToken(tokenResponse.IDToken, discovery, nonceFromState(state))
+	if err != nil {
+		return nil, err
+	}
+
+	// Step 3: some issuers only expose the profile on the userinfo endpoint
+	if (claims.Email == "" || claims.displayName() == "") && discovery.UserinfoEndpoint != "" && tokenResponse.AccessToken != "" {
+		userinfo, err := p.getUserinfo(discovery.UserinfoEndpoint, tokenResponse.AccessToken)
+		if err != nil {
+			return nil, err
+		}
+		if userinfo.Subject != claims.Subject {
+			return nil, errors.New("userinfo subject does not match the id_token subject")
+		}
+		claims.merge(userinfo)
+	}
+
+	return &providers.UserInfos{
+		SubjectID: claims.Subject,
+		Name:      claims.displayName(),
+		Email:     claims.Email,
+		// Issuers that do not send email_verified are not trusted with the email
+		EmailVerified: claims.EmailVerified != nil && *claims.EmailVerified,
+		Avatar:        claims.Picture,
+	}, nil
+}
+
+func (p *OAuthOIDCRepository) verifyIDToken(idToken string, discovery *discoveryDocument, nonce string) (*oidcClaims, error) {
+	parsedToken, err := jwt.Parse(idToken, func(token *jwt.Token) (any, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA, *jwt.SigningMethodEd25519:
+		default:
+			return nil, fmt.Errorf("unexpected id_token signing method %s", token.Method.Alg())
+		}
+		kid, _ := token.Header["kid"].(string)
+		key, err := p.getKey(discovery, kid)
+		if err != nil {
+			return nil, err
+		}
+		if key.Alg != "" && key.Alg != token.Method.Alg() {
+			return nil, fmt.Errorf("id_token alg %s does not match key alg %s", token.Method.Alg(), key.Alg)
+		}
+		return key.PublicKey()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid id_token: %w", err)
+	}
+	mapClaims, ok := parsedToken.Claims.(jwt.MapClaims)
+	if !ok || !parsedToken.Valid {
+		return nil, errors.New("invalid id_token")
+	}
+	if !mapClaims.VerifyIssuer(discovery.Issuer, true) {
+		return nil, errors.New("invalid id_token issuer")
+	}
+	if !mapClaims.VerifyAudience(p.ClientID, true) {
+		return nil, errors.New("invalid id_token audience")
+	}
+	if audiences, ok := mapClaims["aud"].([]any); ok && len(audiences) > 1 {
+		if azp, _ := mapClaims["azp"].(string); azp != p.ClientID {
+			return nil, errors.New("invalid id_token authorized party")
+		}
+	}
+	if _, ok := mapClaims["exp"]; !ok {
+		return nil, errors.New("id_token has no expiration")
+	}
+	if tokenNonce, _ := mapClaims["nonce"].(string); tokenNonce != nonce {
+		return nil, errors.New("invalid id_token nonce")
+	}
+
+	// Round-trip through JSON to map the standard claims
+	raw, err := json.Marshal(mapClaims)
+	if err != nil {
+		return nil, err
+	}
+	var claims oidcClaims
+	if err := json.Unmarshal(raw, &claims); err != nil {
+		return nil, fmt.Errorf("failed to decode id_token claims: %w", err)
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("id_token has no subject")
+	}
+	return &claims, nil
+}
+
+func (p *OAuthOIDCRepository) getUserinfo(endpoint, accessToken string) (*oidcClaims, error) {
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create userinfo request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get userinfo: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc userinfo failed with status %d", resp.StatusCode)
+	}
+	var claims oidcClaims
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return nil, fmt.Errorf("failed to decode userinfo: %w", err)
+	}
+	return &claims, nil
+}
+
+func (p *OAuthOIDCRepository) getDiscovery() (*discoveryDocument, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+	var discovery discoveryDocument
+	if err := p.getJSON(p.Issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("failed to discover issuer: %w", err)
+	}
+	if discovery.Issuer != p.Issuer {
+		return nil, fmt.Errorf("discovered issuer %q does not match configured issuer %q", discovery.Issuer, p.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JwksURI == "" {
+		return nil, errors.New("discovery document is missing required endpoints")
+	}
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+func (p *OAuthOIDCRepository) getKey(discovery *discoveryDocument, kid string) (jwks.Key, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.findKey(kid); ok {
+		return key, nil
+	}
+	// Unknown kid: the issuer probably rotated its keys
+	if time.Since(p.keysFetchedAt) < keysRefreshInterval {
+		return jwks.Key{}, fmt.Errorf("unknown id_token key %q", kid)
+	}
+	var keys jwks.Set
+	if err := p.getJSON(discovery.JwksURI, &keys); err != nil {
+		return jwks.Key{}, fmt.Errorf("failed to fetch jwks: %w", err)
+	}
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+	if key, ok := p.findKey(kid); ok {
+		return key, nil
+	}
+	return jwks.Key{}, fmt.Errorf("unknown id_token key %q", kid)
+}
+
+// findKey must be called with the lock held. An empty kid is only accepted if the issuer has a single key.
+func (p *OAuthOIDCRepository) findKey(kid string) (jwks.Key, bool) {
+	if kid == "" {
+		if len(p.keys.Keys) == 1 {
+			return p.keys.Keys[0], true
+		}
+		return jwks.Key{}, false
+	}
+	return p.keys.Find(kid)
+}
+
+func (p *OAuthOIDCRepository) getJSON(endpoint string, target any) error {
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", endpoint, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+func (c oidcClaims) displayName() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	if c.PreferredUsername != "" {
+		return c.PreferredUsername
+	}
+	return strings.TrimSpace(c.GivenName + " " + c.FamilyName)
+}
+
+func (c *oidcClaims) merge(other *oidcClaims) {
+	if c.Name == "" {
+		c.Name = other.Name
+	}
+	if c.PreferredUsername == "" {
+		c.PreferredUsername = other.PreferredUsername
+	}
+	if c.GivenName == "" {
+		c.GivenName = other.GivenName
+	}
+	if c.FamilyName == "" {
+		c.FamilyName = other.FamilyName
+	}
+	if c.Email == "" {
+		c.Email = other.Email
+		c.EmailVerified = other.EmailVerified
+	}
+	if c.Picture == "" {
+		c.Picture = other.Picture
+	}
+}
+
+// nonceFromState binds the id_token to the (random, single-use) state of the login attempt
+func nonceFromState(state string) string {
+	hash := sha256.Sum256([]byte(state))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
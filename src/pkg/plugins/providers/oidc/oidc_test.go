@@ -0,0 +1,282 @@
+package oidc
+
+import (
+	"aegis/pkg/jwks"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+type fakeIssuer struct {
+	server     *httptest.Server
+	key        *rsa.PrivateKey
+	kid        string
+	claims     jwt.MapClaims
+	userinfo   map[string]any
+	tokenCalls int
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &fakeIssuer{key: key, kid: "key-1"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                 issuer.server.URL,
+			"authorization_endpoint": issuer.server.URL + "/authorize",
+			"token_endpoint":         issuer.server.URL + "/token",
+			"userinfo_endpoint":      issuer.server.URL + "/userinfo",
+			"jwks_uri":               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jwks.Set{Keys: []jwks.Key{{
+			Kty: "RSA",
+			Kid: issuer.kid,
+			Alg: "RS256",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(issuer.key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(issuer.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		issuer.tokenCalls++
+		clientID, clientSecret, ok := r.BasicAuth()
+		if !ok || clientID != "client-id" || clientSecret != "client-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.FormValue("code") != "good-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, issuer.claims)
+		token.Header["kid"] = issuer.kid
+		idToken, err := token.SignedString(issuer.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"id_token":     idToken,
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(issuer.userinfo)
+	})
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+func (i *fakeIssuer) validClaims(state string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            i.server.URL,
+		"sub":            "subject-123",
+		"aud":            "client-id",
+		"exp":            time.Now().Add(5 * time.Minute).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          nonceFromState(state),
+		"name":           "John Doe",
+		"email":          "john.doe@example.com",
+		"email_verified": true,
+		"picture":        "https://example.com/john.png",
+	}
+}
+
+func newProvider(issuer *fakeIssuer) *OAuthOIDCRepository {
+	return NewOAuthOIDCRepository("keycloak", true, issuer.server.URL+"/", "client-id", "client-secret", "http://localhost:5000/auth/keycloak/callback", []string{"profile", "email"})
+}
+
+func TestGetOauthRedirectURL(t *testing.T) {
+	t.Run("should build the authorization url from discovery", func(t *testing.T) {
+		issuer := newFakeIssuer(t)
+		provider := newProvider(issuer)
+		redirectURL := provider.GetOauthRedirectURL("state_123")
+		parsed, err := url.Parse(redirectURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(redirectURL, issuer.server.URL+"/authorize?") {
+			t.Fatal("expected redirect to the authorization endpoint", redirectURL)
+		}
+		query := parsed.Query()
+		if query.Get("scope") != "openid profile email" {
+			t.Fatal("expected scope to be 'openid profile email'", query.Get("scope"))
+		}
+		if query.Get("state") != "state_123" {
+			t.Fatal("expected state to be state_123", query.Get("state"))
+		}
+		if query.Get("nonce") != nonceFromState("state_123") {
+			t.Fatal("expected nonce to be derived from the state", query.Get("nonce"))
+		}
+		if query.Get("redirect_uri") != "http://localhost:5000/auth/keycloak/callback" {
+			t.Fatal("expected redirect_uri to be the callback", query.Get("redirect_uri"))
+		}
+	})
+	t.Run("should return an empty url if the issuer cannot be discovered", func(t *testing.T) {
+		provider := NewOAuthOIDCRepository("keycloak", true, "http://127.0.0.1:1", "client-id", "client-secret", "", nil)
+		if provider.GetOauthRedirectURL("state_123") != "" {
+			t.Fatal("expected an empty redirect url")
+		}
+	})
+}
+
+func TestExchangeCodeForUserInfos(t *testing.T) {
+	t.Run("should map the standard claims of a valid id_token", func(t *testing.T) {
+		issuer := newFakeIssuer(t)
+		issuer.claims = issuer.validClaims("state_123")
+		userInfos, err := newProvider(issuer).ExchangeCodeForUserInfos("good-code", "state_123")
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if userInfos.Name != "John Doe" {
+			t.Fatal("expected name to be John Doe", userInfos.Name)
+		}
+		if userInfos.Email != "john.doe@example.com" {
+			t.Fatal("expected email to be john.doe@example.com", userInfos.Email)
+		}
+		if userInfos.Avatar != "https://example.com/john.png" {
+			t.Fatal("expected avatar to be set", userInfos.Avatar)
+		}
+	})
+	t.Run("should reject an invalid code", func(t *testing.T) {
+		issuer := newFakeIssuer(t)
+		issuer.claims = issuer.validClaims("state_123")
+		_, err := newProvider(issuer).ExchangeCodeForUserInfos("bad-code", "state_123")
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+	t.Run("should reject a nonce bound to another state", func(t *testing.T) {
+		issuer := newFakeIssuer(t)
+		issuer.claims = issuer.validClaims("another_state")
+		_, err := newProvider(issuer).ExchangeCodeForUserInfos("good-code", "state_123")
+		if err == nil || !strings.Contains(err.Error(), "nonce") {
+			t.Fatal("expected a nonce error", err)
+		}
+	})
+	t.Run("should reject another audience", func(t *testing.T) {
+		issuer := newFakeIssuer(t)
+		issuer.claims = issuer.validClaims("state_123")
+		issuer.claims["aud"] = "another-client"
+		_, err := newProvider(issuer).ExchangeCodeForUserInfos("good-code", "state_123")
+		if err == nil || !strings.Contains(err.Error(), "audience") {
+			t.Fatal("expected an audience error", err)
+		}
+	})
+	t.Run("should reject another issuer", func(t *testing.T) {
+		issuer := newFakeIssuer(t)
+		issuer.claims = issuer.validClaims("state_123")
+		issuer.claims["iss"] = "https://evil.example.com"
+		_, err := newProvider(issuer).ExchangeCodeForUserInfos("good-code", "state_123")
+		if err == nil || !strings.Contains(err.Error(), "issuer") {
+			t.Fatal("expected an issuer error", err)
+		}
+	})
+	t.Run("should reject an expired id_token", func(t *testing.T) {
+		issuer := newFakeIssuer(t)
+		issuer.claims = issuer.validClaims("state_123")
+		issuer.claims["exp"] = time.Now().Add(-time.Minute).Unix()
+		_, err := newProvider(issuer).ExchangeCodeForUserInfos("good-code", "state_123")
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+	t.Run("should reject an id_token signed by another key", func(t *testing.T) {
+		issuer := newFakeIssuer(t)
+		issuer.claims = issuer.validClaims("state_123")
+		provider := newProvider(issuer)
+		// prime the key cache with the legitimate key, then sign with another one
+		if _, err := provider.ExchangeCodeForUserInfos("good-code", "state_123"); err != nil {
+			t.Fatal("expected no error", err)
+		}
+		otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		issuer.key = otherKey
+		_, err = provider.ExchangeCodeForUserInfos("good-code", "state_123")
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+	t.Run("should flag unverified emails", func(t *testing.T) {
+		issuer := newFakeIssuer(t)
+		issuer.claims = issuer.validClaims("state_123")
+		issuer.claims["email_verified"] = false
+		userInfos, err := newProvider(issuer).ExchangeCodeForUserInfos("good-code", "state_123")
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if userInfos.Email != "john.doe@example.com" {
+			t.Fatal("expected email to be john.doe@example.com", userInfos.Email)
+		}
+		if userInfos.EmailVerified {
+			t.Fatal("expected email to be unverified")
+		}
+	})
+	t.Run("should fall back to the userinfo endpoint", func(t *testing.T) {
+		issuer := newFakeIssuer(t)
+		issuer.claims = jwt.MapClaims{
+			"iss":   issuer.server.URL,
+			"sub":   "subject-123",
+			"aud":   []string{"client-id"},
+			"exp":   time.Now().Add(5 * time.Minute).Unix(),
+			"nonce": nonceFromState("state_123"),
+		}
+		issuer.userinfo = map[string]any{
+			"sub":                "subject-123",
+			"preferred_username": "jdoe",
+			"email":              "jdoe@example.com",
+			"email_verified":     true,
+		}
+		userInfos, err := newProvider(issuer).ExchangeCodeForUserInfos("good-code", "state_123")
+		if err != nil {
+			t.Fatal("expected no error", err)
+		}
+		if userInfos.Name != "jdoe" {
+			t.Fatal("expected name to be jdoe", userInfos.Name)
+		}
+		if userInfos.Email != "jdoe@example.com" {
+			t.Fatal("expected email to be jdoe@example.com", userInfos.Email)
+		}
+	})
+	t.Run("should reject a userinfo response for another subject", func(t *testing.T) {
+		issuer := newFakeIssuer(t)
+		issuer.claims = jwt.MapClaims{
+			"iss":   issuer.server.URL,
+			"sub":   "subject-123",
+			"aud":   "client-id",
+			"exp":   time.Now().Add(5 * time.Minute).Unix(),
+			"nonce": nonceFromState("state_123"),
+		}
+		issuer.userinfo = map[string]any{
+			"sub":   "subject-456",
+			"name":  "Someone Else",
+			"email": "someone@example.com",
+		}
+		_, err := newProvider(issuer).ExchangeCodeForUserInfos("good-code", "state_123")
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
@@ -0,0 +1,42 @@
+package secretbox
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSeal(t *testing.T) {
+	t.Run("should open what it sealed", func(t *testing.T) {
+		sealed, err := Seal("JBSWY3DPEHPK3PXP", "some-secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sealed == "JBSWY3DPEHPK3PXP" {
+			t.Fatal("expected the value to be encrypted")
+		}
+		value, err := Open(sealed, "some-secret")
+		if err != nil || value != "JBSWY3DPEHPK3PXP" {
+			t.Fatal("expected the value back", value, err)
+		}
+	})
+	t.Run("should seal the same value differently every time", func(t *testing.T) {
+		first, _ := Seal("value", "some-secret")
+		second, _ := Seal("value", "some-secret")
+		if first == second {
+			t.Fatal("expected a new nonce for every seal")
+		}
+	})
+	t.Run("should not open with another secret", func(t *testing.T) {
+		sealed, _ := Seal("value", "some-secret")
+		if _, err := Open(sealed, "other-secret"); !errors.Is(err, ErrDecrypt) {
+			t.Fatal("expected error ErrDecrypt", err)
+		}
+	})
+	t.Run("should not open a tampered value", func(t *testing.T) {
+		for _, sealed := range []string{"", "not base64", "c2hvcnQ="} {
+			if _, err := Open(sealed, "some-secret"); !errors.Is(err, ErrDecrypt) {
+				t.Fatal("expected error ErrDecrypt", sealed, err)
+			}
+		}
+	})
+}
@@ -1,8 +1,12 @@
 package tokengen
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
+	"math/big"
 )
 
 func Generate(prefix string, nPairs int) (string, error) {
@@ -12,3 +16,42 @@ func Generate(prefix string, nPairs int) (string, error) {
 	}
 	return prefix + hex.EncodeToString(bytes), nil
 }
+
+// GenerateDigits returns a random code of n digits, which can start with zeros
+func GenerateDigits(n int) (string, error) {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+	value, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", n, value), nil
+}
+
+// GenerateFrom returns a random code of n characters of the alphabet
+func GenerateFrom(alphabet string, n int) (string, error) {
+	max := big.NewInt(int64(len(alphabet)))
+	code := make([]byte, n)
+	for i := range code {
+		index, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = alphabet[index.Int64()]
+	}
+	return string(code), nil
+}
+
+// Derive returns the same token every time it is called with the same secret and nonce,
+// and a token that cannot be guessed without the secret
+func Derive(prefix, secret, nonce string, nPairs int) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(nonce))
+	return prefix + hex.EncodeToString(mac.Sum(nil)[:nPairs])
+}
+
+// Hash returns the keyed hash (HMAC-SHA256, hex encoded) under which a token is stored
+func Hash(token, pepper string) string {
+	mac := hmac.New(sha256.New, []byte(pepper))
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
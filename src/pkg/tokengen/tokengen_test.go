@@ -19,3 +19,63 @@ func TestGenerate(t *testing.T) {
 		}
 	})
 }
+
+func TestGenerateDigits(t *testing.T) {
+	t.Run("should generate a code of digits", func(t *testing.T) {
+		code, err := GenerateDigits(6)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(code) != 6 || strings.Trim(code, "0123456789") != "" {
+			t.Fatal("expected a code of 6 digits", code)
+		}
+	})
+}
+
+func TestGenerateFrom(t *testing.T) {
+	t.Run("should generate a code of the characters of the alphabet", func(t *testing.T) {
+		code, err := GenerateFrom("ABC", 8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(code) != 8 || strings.Trim(code, "ABC") != "" {
+			t.Fatal("expected a code of 8 characters of the alphabet", code)
+		}
+	})
+}
+
+func TestDerive(t *testing.T) {
+	t.Run("should derive the same token from the same secret and nonce", func(t *testing.T) {
+		token := Derive("test_", "secret", "nonce", 8)
+		if len(token) != 21 || !strings.HasPrefix(token, "test_") {
+			t.Fatal("expected token to have length 21 and prefix test", token)
+		}
+		if Derive("test_", "secret", "nonce", 8) != token {
+			t.Fatal("expected the same token")
+		}
+	})
+	t.Run("should derive different tokens from different secrets or nonces", func(t *testing.T) {
+		token := Derive("test_", "secret", "nonce", 8)
+		if Derive("test_", "other-secret", "nonce", 8) == token {
+			t.Fatal("expected another token for another secret")
+		}
+		if Derive("test_", "secret", "other-nonce", 8) == token {
+			t.Fatal("expected another token for another nonce")
+		}
+	})
+}
+
+func TestHash(t *testing.T) {
+	t.Run("should hash a token with a pepper", func(t *testing.T) {
+		hash := Hash("refresh_123", "pepper")
+		if len(hash) != 64 {
+			t.Fatal("expected hash to have length 64", len(hash))
+		}
+		if Hash("refresh_123", "pepper") != hash {
+			t.Fatal("expected the same hash")
+		}
+		if Hash("refresh_123", "other-pepper") == hash {
+			t.Fatal("expected another hash for another pepper")
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCode(t *testing.T) {
+	t.Run("should match the test vectors of RFC 6238", func(t *testing.T) {
+		key := []byte("12345678901234567890")
+		for unix, expected := range map[int64]string{
+			59:          "94287082",
+			1111111109:  "07081804",
+			1111111111:  "14050471",
+			1234567890:  "89005924",
+			2000000000:  "69279037",
+			20000000000: "65353130",
+		} {
+			if value := code(key, Step(time.Unix(unix, 0)), 8); value != expected {
+				t.Fatal("expected the code at", unix, "to be", expected, value)
+			}
+		}
+	})
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	t.Run("should accept the code of the current and adjacent periods", func(t *testing.T) {
+		for _, offset := range []time.Duration{-Period, 0, Period} {
+			value, err := Code(secret, Step(now.Add(offset)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			step, ok := Validate(secret, value, now)
+			if !ok {
+				t.Fatal("expected the code to be valid", offset)
+			}
+			if step != Step(now.Add(offset)) {
+				t.Fatal("expected the period of the code", step)
+			}
+		}
+	})
+	t.Run("should reject an old code", func(t *testing.T) {
+		value, err := Code(secret, Step(now.Add(-3*Period)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := Validate(secret, value, now); ok {
+			t.Fatal("expected the code to be rejected")
+		}
+	})
+	t.Run("should reject a malformed code", func(t *testing.T) {
+		for _, value := range []string{"", "12345", "1234567", "abcdef"} {
+			if _, ok := Validate(secret, value, now); ok {
+				t.Fatal("expected the code to be rejected", value)
+			}
+		}
+	})
+}
+
+func TestURI(t *testing.T) {
+	t.Run("should carry the secret and the issuer", func(t *testing.T) {
+		secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+		uri := URI("My App", "someone@example.com", secret)
+		if !strings.HasPrefix(uri, "otpauth://totp/My%20App:someone@example.com?") {
+			t.Fatal("expected the label to be the issuer and the account", uri)
+		}
+		if !strings.Contains(uri, "secret="+secret) || !strings.Contains(uri, "issuer=My+App") {
+			t.Fatal("expected the secret and the issuer in the query", uri)
+		}
+	})
+}
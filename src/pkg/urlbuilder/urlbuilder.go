@@ -17,3 +17,17 @@ func Build(base string, path string, queryParams map[string]string) (string, err
 	}
 	return joined, nil
 }
+
+// AddQuery adds the parameters to the query of the URL, keeping the ones it already has
+func AddQuery(rawURL string, queryParams map[string]string) (string, error) {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	query := parsedURL.Query()
+	for key, value := range queryParams {
+		query.Set(key, value)
+	}
+	parsedURL.RawQuery = query.Encode()
+	return parsedURL.String(), nil
+}
@@ -0,0 +1,143 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+var errInvalidCBOR = errors.New("invalid cbor")
+
+// maxCBORDepth bounds the nesting of the items, authenticators never go deeper than a few levels
+const maxCBORDepth = 8
+
+// decodeCBOR decodes the first item of data and returns the bytes after it. It supports the subset of
+// CBOR (RFC 8949) used by WebAuthn: integers are returned as int64, byte strings as []byte, text strings
+// as string, arrays as []any, maps as map[any]any, and simple values as bool or nil.
+// Indefinite lengths and floats are rejected, authenticators must use the canonical encoding.
+func decodeCBOR(data []byte) (any, []byte, error) {
+	return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (any, []byte, error) {
+	if depth > maxCBORDepth || len(data) == 0 {
+		return nil, nil, errInvalidCBOR
+	}
+	major := data[0] >> 5
+	argument, rest, err := decodeCBORArgument(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch major {
+	case 0:
+		if argument > math.MaxInt64 {
+			return nil, nil, errInvalidCBOR
+		}
+		return int64(argument), rest, nil
+	case 1:
+		if argument > math.MaxInt64 {
+			return nil, nil, errInvalidCBOR
+		}
+		return -1 - int64(argument), rest, nil
+	case 2, 3:
+		if argument > uint64(len(rest)) {
+			return nil, nil, errInvalidCBOR
+		}
+		value := rest[:argument]
+		if major == 3 {
+			return string(value), rest[argument:], nil
+		}
+		return append([]byte(nil), value...), rest[argument:], nil
+	case 4:
+		// Every item takes at least one byte
+		if argument > uint64(len(rest)) {
+			return nil, nil, errInvalidCBOR
+		}
+		items := make([]any, 0, argument)
+		for i := uint64(0); i < argument; i++ {
+			var item any
+			item, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, rest, nil
+	case 5:
+		if argument > uint64(len(rest))/2 {
+			return nil, nil, errInvalidCBOR
+		}
+		items := make(map[any]any, argument)
+		for i := uint64(0); i < argument; i++ {
+			var key, value any
+			key, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errInvalidCBOR
+			}
+			if _, ok := items[key]; ok {
+				return nil, nil, errInvalidCBOR
+			}
+			value, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			items[key] = value
+		}
+		return items, rest, nil
+	case 6:
+		// Tags only annotate the item that follows
+		return decodeCBORItem(rest, depth+1)
+	default:
+		switch data[0] & 0x1f {
+		case 20:
+			return false, rest, nil
+		case 21:
+			return true, rest, nil
+		case 22, 23:
+			return nil, rest, nil
+		}
+		return nil, nil, errInvalidCBOR
+	}
+}
+
+// decodeCBORArgument reads the argument of the head of an item: its value, length or number of items
+func decodeCBORArgument(data []byte) (uint64, []byte, error) {
+	additional := data[0] & 0x1f
+	data = data[1:]
+	if additional < 24 {
+		return uint64(additional), data, nil
+	}
+	size := 0
+	switch additional {
+	case 24:
+		size = 1
+	case 25:
+		size = 2
+	case 26:
+		size = 4
+	case 27:
+		size = 8
+	default:
+		return 0, nil, errInvalidCBOR
+	}
+	if len(data) < size {
+		return 0, nil, errInvalidCBOR
+	}
+	var argument uint64
+	switch size {
+	case 1:
+		argument = uint64(data[0])
+	case 2:
+		argument = uint64(binary.BigEndian.Uint16(data))
+	case 4:
+		argument = uint64(binary.BigEndian.Uint32(data))
+	case 8:
+		argument = binary.BigEndian.Uint64(data)
+	}
+	return argument, data[size:], nil
+}
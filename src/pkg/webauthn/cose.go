@@ -0,0 +1,119 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithms (RFC 9053) a credential can use, in order of preference
+const (
+	AlgorithmES256 = -7
+	AlgorithmEdDSA = -8
+	AlgorithmRS256 = -257
+)
+
+// Algorithms are the algorithms offered to authenticators when they create a credential
+var Algorithms = []int{AlgorithmES256, AlgorithmEdDSA, AlgorithmRS256}
+
+// COSE key parameters
+const (
+	coseKeyType      = 1
+	coseKeyAlgorithm = 3
+	coseKeyCurve     = -1
+	coseKeyX         = -2
+	coseKeyY         = -3
+	coseKeyModulus   = -1
+	coseKeyExponent  = -2
+
+	coseKeyTypeOKP = 1
+	coseKeyTypeEC2 = 2
+	coseKeyTypeRSA = 3
+
+	coseCurveP256    = 1
+	coseCurveEd25519 = 6
+)
+
+var ErrUnsupportedKey = errors.New("unsupported credential public key")
+
+// publicKey is the public key of a credential, read from its COSE encoding
+type publicKey struct {
+	algorithm int64
+	key       crypto.PublicKey
+}
+
+// parsePublicKey reads a COSE_Key (RFC 9052) holding an ES256, EdDSA (Ed25519) or RS256 public key
+func parsePublicKey(coseKey []byte) (publicKey, error) {
+	item, rest, err := decodeCBOR(coseKey)
+	if err != nil {
+		return publicKey{}, err
+	}
+	if len(rest) != 0 {
+		return publicKey{}, errInvalidCBOR
+	}
+	params, ok := item.(map[any]any)
+	if !ok {
+		return publicKey{}, errInvalidCBOR
+	}
+	keyType, _ := params[int64(coseKeyType)].(int64)
+	algorithm, _ := params[int64(coseKeyAlgorithm)].(int64)
+	switch {
+	case keyType == coseKeyTypeEC2 && algorithm == AlgorithmES256:
+		curve, _ := params[int64(coseKeyCurve)].(int64)
+		x, _ := params[int64(coseKeyX)].([]byte)
+		y, _ := params[int64(coseKeyY)].([]byte)
+		if curve != coseCurveP256 || len(x) != 32 || len(y) != 32 {
+			return publicKey{}, ErrUnsupportedKey
+		}
+		// Rejects the points that are not on the curve
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return publicKey{}, ErrUnsupportedKey
+		}
+		return publicKey{algorithm: algorithm, key: &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}}, nil
+	case keyType == coseKeyTypeOKP && algorithm == AlgorithmEdDSA:
+		curve, _ := params[int64(coseKeyCurve)].(int64)
+		x, _ := params[int64(coseKeyX)].([]byte)
+		if curve != coseCurveEd25519 || len(x) != ed25519.PublicKeySize {
+			return publicKey{}, ErrUnsupportedKey
+		}
+		return publicKey{algorithm: algorithm, key: ed25519.PublicKey(x)}, nil
+	case keyType == coseKeyTypeRSA && algorithm == AlgorithmRS256:
+		n, _ := params[int64(coseKeyModulus)].([]byte)
+		e, _ := params[int64(coseKeyExponent)].([]byte)
+		exponent := new(big.Int).SetBytes(e)
+		if len(n) < 256 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return publicKey{}, ErrUnsupportedKey
+		}
+		return publicKey{algorithm: algorithm, key: &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(exponent.Int64()),
+		}}, nil
+	}
+	return publicKey{}, fmt.Errorf("%w: key type %d, algorithm %d", ErrUnsupportedKey, keyType, algorithm)
+}
+
+// verify checks a signature of data made with the private key of the credential
+func (k publicKey) verify(data, signature []byte) bool {
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		return ecdsa.VerifyASN1(key, digest[:], signature)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, data, signature)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	}
+	return false
+}
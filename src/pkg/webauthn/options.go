@@ -0,0 +1,104 @@
+package webauthn
+
+import "encoding/base64"
+
+// Options of navigator.credentials.create() and navigator.credentials.get(), as JSON.
+// Binary values are base64url encoded, the browser decodes them before calling the authenticator.
+
+type RelyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey        string `json:"residentKey"`
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification"`
+}
+
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     RelyingPartyEntity     `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int                    `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int                    `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// Timeout of the ceremonies in the browser, in milliseconds
+const Timeout = 5 * 60 * 1000
+
+// CreationOptions asks for a discoverable credential, so that the user logs in without typing who they are.
+// The user ID is the handle the authenticator returns at login. The credentials already registered are excluded.
+func (rp RelyingParty) CreationOptions(name, challenge, userID, userName, displayName string, exclude []string) CreationOptions {
+	params := make([]CredentialParameter, 0, len(Algorithms))
+	for _, algorithm := range Algorithms {
+		params = append(params, CredentialParameter{Type: "public-key", Alg: algorithm})
+	}
+	excludeCredentials := make([]CredentialDescriptor, 0, len(exclude))
+	for _, id := range exclude {
+		excludeCredentials = append(excludeCredentials, CredentialDescriptor{Type: "public-key", ID: id})
+	}
+	return CreationOptions{
+		Challenge: EncodeChallenge(challenge),
+		RP:        RelyingPartyEntity{ID: rp.ID, Name: name},
+		User: UserEntity{
+			ID:          base64.RawURLEncoding.EncodeToString([]byte(userID)),
+			Name:        userName,
+			DisplayName: displayName,
+		},
+		PubKeyCredParams:   params,
+		Timeout:            Timeout,
+		ExcludeCredentials: excludeCredentials,
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:        "required",
+			RequireResidentKey: true,
+			UserVerification:   rp.userVerification(),
+		},
+		Attestation: "none",
+	}
+}
+
+// RequestOptions lets the browser offer any credential of the relying party
+func (rp RelyingParty) RequestOptions(challenge string) RequestOptions {
+	return RequestOptions{
+		Challenge:        EncodeChallenge(challenge),
+		Timeout:          Timeout,
+		RPID:             rp.ID,
+		AllowCredentials: []CredentialDescriptor{},
+		UserVerification: rp.userVerification(),
+	}
+}
+
+func (rp RelyingParty) userVerification() string {
+	if rp.RequireUserVerification {
+		return "required"
+	}
+	return "preferred"
+}
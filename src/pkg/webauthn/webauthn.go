@@ -0,0 +1,258 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"slices"
+)
+
+var (
+	ErrInvalidClientData        = errors.New("invalid client data")
+	ErrInvalidAuthenticatorData = errors.New("invalid authenticator data")
+	ErrInvalidAttestation       = errors.New("invalid attestation")
+	ErrUnsupportedAttestation   = errors.New("unsupported attestation format")
+	ErrUserNotPresent           = errors.New("user not present")
+	ErrUserNotVerified          = errors.New("user not verified")
+	ErrInvalidSignature         = errors.New("invalid signature")
+	// The signature counter went backwards: the authenticator was likely cloned
+	ErrSignCountMismatch = errors.New("sign count mismatch")
+)
+
+// Flags of the authenticator data
+const (
+	flagUserPresent            = 0x01
+	flagUserVerified           = 0x04
+	flagAttestedCredentialData = 0x40
+)
+
+// RelyingParty verifies the responses of authenticators (WebAuthn Level 2) for a website.
+// Challenges are random strings generated by the caller, sent to the browser base64url encoded.
+type RelyingParty struct {
+	// Domain the credentials are scoped to (ex: "example.com")
+	ID string
+	// Origins the browser can call the authenticator from (ex: ["https://auth.example.com"])
+	Origins []string
+	// Rejects the authenticators that did not verify the user (PIN, biometrics...), on top of their presence
+	RequireUserVerification bool
+}
+
+// Credential is a public key credential registered by an authenticator
+type Credential struct {
+	ID []byte
+	// COSE encoded, as stored
+	PublicKey []byte
+	SignCount uint32
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type authenticatorData struct {
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+	// Only set at registration
+	credentialID        []byte
+	credentialPublicKey []byte
+}
+
+// EncodeChallenge returns the challenge as sent to the browser
+func EncodeChallenge(challenge string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(challenge))
+}
+
+// ParseChallenge returns the challenge signed by the authenticator, to find the ceremony it answers
+func ParseChallenge(clientDataJSON []byte) (string, error) {
+	var data clientData
+	if err := json.Unmarshal(clientDataJSON, &data); err != nil {
+		return "", ErrInvalidClientData
+	}
+	challenge, err := base64.RawURLEncoding.DecodeString(data.Challenge)
+	if err != nil {
+		return "", ErrInvalidClientData
+	}
+	return string(challenge), nil
+}
+
+// VerifyRegistration checks the response of navigator.credentials.create() and returns the new credential.
+// Attestations "none" and "packed" are accepted, the authenticator itself is not checked against a trust anchor.
+func (rp RelyingParty) VerifyRegistration(challenge string, clientDataJSON, attestationObject []byte) (Credential, error) {
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return Credential{}, err
+	}
+	item, rest, err := decodeCBOR(attestationObject)
+	if err != nil || len(rest) != 0 {
+		return Credential{}, ErrInvalidAttestation
+	}
+	attestation, ok := item.(map[any]any)
+	if !ok {
+		return Credential{}, ErrInvalidAttestation
+	}
+	format, _ := attestation["fmt"].(string)
+	statement, _ := attestation["attStmt"].(map[any]any)
+	rawAuthData, _ := attestation["authData"].([]byte)
+	if statement == nil {
+		return Credential{}, ErrInvalidAttestation
+	}
+	authData, err := rp.verifyAuthenticatorData(rawAuthData)
+	if err != nil {
+		return Credential{}, err
+	}
+	if authData.credentialID == nil {
+		return Credential{}, ErrInvalidAuthenticatorData
+	}
+	key, err := parsePublicKey(authData.credentialPublicKey)
+	if err != nil {
+		return Credential{}, err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), rawAuthData...), clientDataHash[:]...)
+	switch format {
+	case "none":
+		if len(statement) != 0 {
+			return Credential{}, ErrInvalidAttestation
+		}
+	case "packed":
+		if err := verifyPackedAttestation(statement, key, signed); err != nil {
+			return Credential{}, err
+		}
+	default:
+		return Credential{}, ErrUnsupportedAttestation
+	}
+	return Credential{
+		ID:        authData.credentialID,
+		PublicKey: authData.credentialPublicKey,
+		SignCount: authData.signCount,
+	}, nil
+}
+
+// verifyPackedAttestation checks a self attestation, signed by the credential, or a basic attestation,
+// signed by the certificate of the authenticator model
+func verifyPackedAttestation(statement map[any]any, key publicKey, signed []byte) error {
+	algorithm, _ := statement["alg"].(int64)
+	signature, _ := statement["sig"].([]byte)
+	if signature == nil {
+		return ErrInvalidAttestation
+	}
+	chain, hasChain := statement["x5c"].([]any)
+	if !hasChain {
+		if algorithm != key.algorithm || !key.verify(signed, signature) {
+			return ErrInvalidAttestation
+		}
+		return nil
+	}
+	if len(chain) == 0 {
+		return ErrInvalidAttestation
+	}
+	der, _ := chain[0].([]byte)
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return ErrInvalidAttestation
+	}
+	var signatureAlgorithm x509.SignatureAlgorithm
+	switch algorithm {
+	case AlgorithmES256:
+		signatureAlgorithm = x509.ECDSAWithSHA256
+	case AlgorithmRS256:
+		signatureAlgorithm = x509.SHA256WithRSA
+	case AlgorithmEdDSA:
+		signatureAlgorithm = x509.PureEd25519
+	default:
+		return ErrUnsupportedAttestation
+	}
+	if err := certificate.CheckSignature(signatureAlgorithm, signed, signature); err != nil {
+		return ErrInvalidAttestation
+	}
+	return nil
+}
+
+// VerifyAssertion checks the response of navigator.credentials.get() for a registered credential,
+// and returns its new signature counter to store
+func (rp RelyingParty) VerifyAssertion(challenge string, credential Credential, clientDataJSON, rawAuthData, signature []byte) (uint32, error) {
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+	authData, err := rp.verifyAuthenticatorData(rawAuthData)
+	if err != nil {
+		return 0, err
+	}
+	key, err := parsePublicKey(credential.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), rawAuthData...), clientDataHash[:]...)
+	if !key.verify(signed, signature) {
+		return 0, ErrInvalidSignature
+	}
+	// Authenticators that do not count their signatures always return 0
+	if (authData.signCount != 0 || credential.SignCount != 0) && authData.signCount <= credential.SignCount {
+		return 0, ErrSignCountMismatch
+	}
+	return authData.signCount, nil
+}
+
+func (rp RelyingParty) verifyClientData(clientDataJSON []byte, ceremony, challenge string) error {
+	var data clientData
+	if err := json.Unmarshal(clientDataJSON, &data); err != nil {
+		return ErrInvalidClientData
+	}
+	expected := EncodeChallenge(challenge)
+	if data.Type != ceremony || subtle.ConstantTimeCompare([]byte(data.Challenge), []byte(expected)) != 1 || !slices.Contains(rp.Origins, data.Origin) {
+		return ErrInvalidClientData
+	}
+	return nil
+}
+
+// verifyAuthenticatorData parses the authenticator data and checks it is scoped to the relying party
+func (rp RelyingParty) verifyAuthenticatorData(raw []byte) (authenticatorData, error) {
+	// rpIdHash (32), flags (1), signCount (4)
+	if len(raw) < 37 {
+		return authenticatorData{}, ErrInvalidAuthenticatorData
+	}
+	data := authenticatorData{
+		rpIDHash:  raw[:32],
+		flags:     raw[32],
+		signCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(data.rpIDHash, rpIDHash[:]) {
+		return authenticatorData{}, ErrInvalidAuthenticatorData
+	}
+	if data.flags&flagUserPresent == 0 {
+		return authenticatorData{}, ErrUserNotPresent
+	}
+	if rp.RequireUserVerification && data.flags&flagUserVerified == 0 {
+		return authenticatorData{}, ErrUserNotVerified
+	}
+	if data.flags&flagAttestedCredentialData == 0 {
+		return data, nil
+	}
+	// aaguid (16), credentialIdLength (2), credentialId, credentialPublicKey, followed by extensions if any
+	rest := raw[37:]
+	if len(rest) < 18 {
+		return authenticatorData{}, ErrInvalidAuthenticatorData
+	}
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if idLength == 0 || idLength > 1023 || len(rest) < idLength {
+		return authenticatorData{}, ErrInvalidAuthenticatorData
+	}
+	data.credentialID = append([]byte(nil), rest[:idLength]...)
+	rest = rest[idLength:]
+	_, extensions, err := decodeCBOR(rest)
+	if err != nil {
+		return authenticatorData{}, ErrInvalidAuthenticatorData
+	}
+	data.credentialPublicKey = append([]byte(nil), rest[:len(rest)-len(extensions)]...)
+	return data, nil
+}
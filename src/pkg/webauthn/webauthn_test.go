@@ -0,0 +1,261 @@
+package webauthn
+
+import (
+	"aegis/pkg/webauthn/webauthntest"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestRegistration(t *testing.T) {
+	rp := RelyingParty{ID: "example.com", Origins: []string{"https://auth.example.com"}, RequireUserVerification: true}
+
+	t.Run("should return the credential of the authenticator", func(t *testing.T) {
+		authenticator, err := webauthntest.NewAuthenticator("example.com", "https://auth.example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		clientDataJSON, attestationObject, err := authenticator.Create(EncodeChallenge("challenge"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		credential, err := rp.VerifyRegistration("challenge", clientDataJSON, attestationObject)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(credential.ID, authenticator.CredentialID) {
+			t.Fatal("expected the credential ID of the authenticator", credential.ID)
+		}
+		if _, err := parsePublicKey(credential.PublicKey); err != nil {
+			t.Fatal("expected the public key to be parsable", err)
+		}
+	})
+	t.Run("should reject another challenge, origin or relying party", func(t *testing.T) {
+		for _, authenticatorRP := range []struct{ id, origin, challenge string }{
+			{"example.com", "https://auth.example.com", "other"},
+			{"example.com", "https://evil.com", "challenge"},
+			{"evil.com", "https://auth.example.com", "challenge"},
+		} {
+			authenticator, err := webauthntest.NewAuthenticator(authenticatorRP.id, authenticatorRP.origin)
+			if err != nil {
+				t.Fatal(err)
+			}
+			clientDataJSON, attestationObject, err := authenticator.Create(EncodeChallenge(authenticatorRP.challenge))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := rp.VerifyRegistration("challenge", clientDataJSON, attestationObject); err == nil {
+				t.Fatal("expected an error", authenticatorRP)
+			}
+		}
+	})
+	t.Run("should reject an authenticator that did not verify the user when required", func(t *testing.T) {
+		authenticator, err := webauthntest.NewAuthenticator("example.com", "https://auth.example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		authenticator.UserVerified = false
+		clientDataJSON, attestationObject, err := authenticator.Create(EncodeChallenge("challenge"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rp.VerifyRegistration("challenge", clientDataJSON, attestationObject); !errors.Is(err, ErrUserNotVerified) {
+			t.Fatal("expected ErrUserNotVerified", err)
+		}
+		rp := RelyingParty{ID: "example.com", Origins: []string{"https://auth.example.com"}}
+		if _, err := rp.VerifyRegistration("challenge", clientDataJSON, attestationObject); err != nil {
+			t.Fatal("expected no error when verification is not required", err)
+		}
+	})
+	t.Run("should verify a packed self attestation", func(t *testing.T) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clientDataJSON := []byte(`{"type":"webauthn.create","challenge":"` + EncodeChallenge("challenge") + `","origin":"https://auth.example.com"}`)
+		authData := testAuthData(t, key)
+		clientDataHash := sha256.Sum256(clientDataJSON)
+		digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+		signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		attestationObject := webauthntest.EncodeCBOR(map[any]any{
+			"fmt":      "packed",
+			"attStmt":  map[any]any{"alg": AlgorithmES256, "sig": signature},
+			"authData": authData,
+		})
+		if _, err := rp.VerifyRegistration("challenge", clientDataJSON, attestationObject); err != nil {
+			t.Fatal(err)
+		}
+		signature[len(signature)-1] ^= 0xff
+		attestationObject = webauthntest.EncodeCBOR(map[any]any{
+			"fmt":      "packed",
+			"attStmt":  map[any]any{"alg": AlgorithmES256, "sig": signature},
+			"authData": authData,
+		})
+		if _, err := rp.VerifyRegistration("challenge", clientDataJSON, attestationObject); !errors.Is(err, ErrInvalidAttestation) {
+			t.Fatal("expected ErrInvalidAttestation", err)
+		}
+	})
+	t.Run("should reject an unknown attestation format", func(t *testing.T) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clientDataJSON := []byte(`{"type":"webauthn.create","challenge":"` + EncodeChallenge("challenge") + `","origin":"https://auth.example.com"}`)
+		attestationObject := webauthntest.EncodeCBOR(map[any]any{"fmt": "tpm", "attStmt": map[any]any{}, "authData": testAuthData(t, key)})
+		if _, err := rp.VerifyRegistration("challenge", clientDataJSON, attestationObject); !errors.Is(err, ErrUnsupportedAttestation) {
+			t.Fatal("expected ErrUnsupportedAttestation", err)
+		}
+	})
+}
+
+func TestAssertion(t *testing.T) {
+	rp := RelyingParty{ID: "example.com", Origins: []string{"https://auth.example.com"}}
+
+	register := func(t *testing.T) (*webauthntest.Authenticator, Credential) {
+		authenticator, err := webauthntest.NewAuthenticator("example.com", "https://auth.example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		clientDataJSON, attestationObject, err := authenticator.Create(EncodeChallenge("registration"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		credential, err := rp.VerifyRegistration("registration", clientDataJSON, attestationObject)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return authenticator, credential
+	}
+
+	t.Run("should verify the signature and return the new sign count", func(t *testing.T) {
+		authenticator, credential := register(t)
+		clientDataJSON, authData, signature, err := authenticator.Get(EncodeChallenge("login"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		challenge, err := ParseChallenge(clientDataJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if challenge != "login" {
+			t.Fatal("expected the challenge to be login", challenge)
+		}
+		signCount, err := rp.VerifyAssertion("login", credential, clientDataJSON, authData, signature)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if signCount != 1 {
+			t.Fatal("expected the sign count to be 1", signCount)
+		}
+	})
+	t.Run("should reject a signature of another key", func(t *testing.T) {
+		_, credential := register(t)
+		other, _ := register(t)
+		clientDataJSON, authData, signature, err := other.Get(EncodeChallenge("login"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rp.VerifyAssertion("login", credential, clientDataJSON, authData, signature); !errors.Is(err, ErrInvalidSignature) {
+			t.Fatal("expected ErrInvalidSignature", err)
+		}
+	})
+	t.Run("should reject a sign count that did not increase", func(t *testing.T) {
+		authenticator, credential := register(t)
+		credential.SignCount = 5
+		authenticator.SignCount = 4
+		clientDataJSON, authData, signature, err := authenticator.Get(EncodeChallenge("login"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rp.VerifyAssertion("login", credential, clientDataJSON, authData, signature); !errors.Is(err, ErrSignCountMismatch) {
+			t.Fatal("expected ErrSignCountMismatch", err)
+		}
+	})
+	t.Run("should accept an authenticator that does not count", func(t *testing.T) {
+		authenticator, credential := register(t)
+		authenticator.NoSignCount = true
+		for i := 0; i < 2; i++ {
+			clientDataJSON, authData, signature, err := authenticator.Get(EncodeChallenge("login"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			signCount, err := rp.VerifyAssertion("login", credential, clientDataJSON, authData, signature)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if signCount != 0 {
+				t.Fatal("expected the sign count to stay 0", signCount)
+			}
+		}
+	})
+	t.Run("should reject a registration response", func(t *testing.T) {
+		authenticator, credential := register(t)
+		clientDataJSON, _, err := authenticator.Create(EncodeChallenge("login"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, authData, signature, err := authenticator.Get(EncodeChallenge("login"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rp.VerifyAssertion("login", credential, clientDataJSON, authData, signature); !errors.Is(err, ErrInvalidClientData) {
+			t.Fatal("expected ErrInvalidClientData", err)
+		}
+	})
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	t.Run("should decode nested items", func(t *testing.T) {
+		encoded := webauthntest.EncodeCBOR(map[any]any{"a": []any{1, -300, []byte{1, 2}}, 70000: "b"})
+		item, rest, err := decodeCBOR(append(encoded, 0xff))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(rest, []byte{0xff}) {
+			t.Fatal("expected the bytes after the item to be returned", rest)
+		}
+		decoded := item.(map[any]any)
+		array := decoded["a"].([]any)
+		if array[0] != int64(1) || array[1] != int64(-300) || !bytes.Equal(array[2].([]byte), []byte{1, 2}) {
+			t.Fatal("expected the array to be decoded", array)
+		}
+		if decoded[int64(70000)] != "b" {
+			t.Fatal("expected the integer key to be decoded", decoded)
+		}
+	})
+	t.Run("should reject truncated or oversized items", func(t *testing.T) {
+		for _, encoded := range [][]byte{
+			{},
+			{0x59, 0x01},
+			{0x5a, 0xff, 0xff, 0xff, 0xff, 0x00},
+			{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			{0x5f, 0x41, 0x00, 0xff},
+			{0xa2, 0x01, 0x01, 0x01, 0x02},
+		} {
+			if _, _, err := decodeCBOR(encoded); err == nil {
+				t.Fatal("expected an error", encoded)
+			}
+		}
+	})
+}
+
+// testAuthData returns the authenticator data of a registration of the key for example.com
+func testAuthData(t *testing.T, key *ecdsa.PrivateKey) []byte {
+	t.Helper()
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+	rpIDHash := sha256.Sum256([]byte("example.com"))
+	authData := append(rpIDHash[:], 0x01|0x04|0x40, 0, 0, 0, 0)
+	authData = append(authData, make([]byte, 16)...)
+	authData = append(authData, 0, 4, 1, 2, 3, 4)
+	return append(authData, webauthntest.EncodeCBOR(map[any]any{1: 2, 3: -7, -1: 1, -2: x, -3: y})...)
+}
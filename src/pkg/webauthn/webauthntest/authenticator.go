@@ -0,0 +1,149 @@
+// Package webauthntest provides a software authenticator, to test the WebAuthn ceremonies without a browser
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Authenticator holds one ES256 credential, and answers like a browser calling it from Origin
+type Authenticator struct {
+	RPID         string
+	Origin       string
+	CredentialID []byte
+	SignCount    uint32
+	// Set in the flags of the authenticator data when true
+	UserVerified bool
+	// Reports a counter of 0 to every call, like the authenticators that do not count their signatures
+	NoSignCount bool
+	key         *ecdsa.PrivateKey
+}
+
+func NewAuthenticator(rpID, origin string) (*Authenticator, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		return nil, err
+	}
+	return &Authenticator{RPID: rpID, Origin: origin, CredentialID: credentialID, UserVerified: true, key: key}, nil
+}
+
+// Create answers navigator.credentials.create() with the challenge of the options, as encoded in them.
+// The attestation is "none".
+func (a *Authenticator) Create(challenge string) (clientDataJSON, attestationObject []byte, err error) {
+	clientDataJSON, err = a.clientData("webauthn.create", challenge)
+	if err != nil {
+		return nil, nil, err
+	}
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	a.key.X.FillBytes(x)
+	a.key.Y.FillBytes(y)
+	publicKey := EncodeCBOR(map[any]any{1: 2, 3: -7, -1: 1, -2: x, -3: y})
+
+	authData := a.authData(0x40)
+	authData = append(authData, make([]byte, 16)...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.CredentialID)))
+	authData = append(authData, a.CredentialID...)
+	authData = append(authData, publicKey...)
+	attestationObject = EncodeCBOR(map[any]any{"fmt": "none", "attStmt": map[any]any{}, "authData": authData})
+	return clientDataJSON, attestationObject, nil
+}
+
+// Get answers navigator.credentials.get() with the challenge of the options, and increments the counter
+func (a *Authenticator) Get(challenge string) (clientDataJSON, authenticatorData, signature []byte, err error) {
+	clientDataJSON, err = a.clientData("webauthn.get", challenge)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if !a.NoSignCount {
+		a.SignCount++
+	}
+	authenticatorData = a.authData(0)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte(nil), authenticatorData...), clientDataHash[:]...))
+	signature, err = ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return clientDataJSON, authenticatorData, signature, nil
+}
+
+func (a *Authenticator) clientData(ceremony, challenge string) ([]byte, error) {
+	return json.Marshal(map[string]any{"type": ceremony, "challenge": challenge, "origin": a.Origin, "crossOrigin": false})
+}
+
+func (a *Authenticator) authData(flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.RPID))
+	flags |= 0x01
+	if a.UserVerified {
+		flags |= 0x04
+	}
+	authData := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(authData, a.SignCount)
+}
+
+// EncodeCBOR encodes integers, byte strings, text strings, arrays and maps, with the keys of maps sorted
+func EncodeCBOR(value any) []byte {
+	switch v := value.(type) {
+	case int:
+		if v < 0 {
+			return cborHead(1, uint64(-1-v))
+		}
+		return cborHead(0, uint64(v))
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+	case []any:
+		encoded := cborHead(4, uint64(len(v)))
+		for _, item := range v {
+			encoded = append(encoded, EncodeCBOR(item)...)
+		}
+		return encoded
+	case map[any]any:
+		keys := make([][]byte, 0, len(v))
+		values := map[string][]byte{}
+		for key, item := range v {
+			encodedKey := EncodeCBOR(key)
+			keys = append(keys, encodedKey)
+			values[string(encodedKey)] = EncodeCBOR(item)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if len(keys[i]) != len(keys[j]) {
+				return len(keys[i]) < len(keys[j])
+			}
+			return string(keys[i]) < string(keys[j])
+		})
+		encoded := cborHead(5, uint64(len(v)))
+		for _, key := range keys {
+			encoded = append(encoded, key...)
+			encoded = append(encoded, values[string(key)]...)
+		}
+		return encoded
+	}
+	panic(fmt.Sprintf("webauthntest: cannot encode %T", value))
+}
+
+func cborHead(major byte, argument uint64) []byte {
+	switch {
+	case argument < 24:
+		return []byte{major<<5 | byte(argument)}
+	case argument <= 0xff:
+		return []byte{major<<5 | 24, byte(argument)}
+	case argument <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(argument))
+	case argument <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(argument))
+	}
+	return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, argument)
+}